@@ -2,6 +2,11 @@
 
 set -e -x -u
 
+if [ ! -f images/sshd/websocat.sha256 ]; then
+  echo "Missing websocat checksums; run ./hack/update-websocat-checksums.sh and review the result" >&2
+  exit 1
+fi
+
 ytt -f images/sshd/ | kbld -f- | ytt -f image.yml=- -f pkg/kwt/cmd/net/ssh_flags_generated.go.txt --output-files ./tmp/
 
 mv ./tmp/ssh_flags_generated.go.txt pkg/kwt/cmd/net/ssh_flags_generated.go
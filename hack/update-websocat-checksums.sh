@@ -0,0 +1,19 @@
+#!/bin/bash
+
+set -e -x -u
+
+# Records checksums verified when building sshd image (see images/sshd/Dockerfile)
+WEBSOCAT_VERSION="${1:-1.11.0}"
+
+tmp_dir=$(mktemp -d)
+trap "rm -rf $tmp_dir" EXIT
+
+for asset in websocat.x86_64-unknown-linux-musl websocat.aarch64-unknown-linux-musl; do
+  curl -fsSLo $tmp_dir/$asset https://github.com/vi/websocat/releases/download/v$WEBSOCAT_VERSION/$asset
+done
+
+(cd $tmp_dir && sha256sum websocat.*) > images/sshd/websocat.sha256
+
+if [ "$WEBSOCAT_VERSION" != "1.11.0" ]; then
+  echo "Update WEBSOCAT_VERSION in images/sshd/Dockerfile to $WEBSOCAT_VERSION"
+fi
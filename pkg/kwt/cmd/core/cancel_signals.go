@@ -3,6 +3,7 @@ package core
 import (
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 )
 
@@ -19,3 +20,38 @@ func (CancelSignals) Watch(stopFunc func()) {
 		}
 	}()
 }
+
+// WatchTwice calls stopFunc on first signal and forceStopFunc on the second one.
+// Signals are intercepted (further ones are ignored) until returned func is called
+// so that additional signals do not kill the process while it is still shutting down.
+func (CancelSignals) WatchTwice(stopFunc, forceStopFunc func()) func() {
+	signalCh := make(chan os.Signal, 2)
+	doneCh := make(chan struct{})
+
+	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(signalCh)
+
+		var received int
+
+		for {
+			select {
+			case <-signalCh:
+				received++
+				switch received {
+				case 1:
+					go stopFunc()
+				case 2:
+					go forceStopFunc()
+				}
+			case <-doneCh:
+				return
+			}
+		}
+	}()
+
+	var doneOnce sync.Once
+
+	return func() { doneOnce.Do(func() { close(doneCh) }) }
+}
@@ -12,6 +12,10 @@ type ConfigFactory interface {
 	ConfigureContextResolver(func() (string, error))
 	RESTConfig() (*rest.Config, error)
 	DefaultNamespace() (string, error)
+	CurrentContext() (string, error)
+
+	// ForContext returns factory that uses specified context
+	ForContext(context string) ConfigFactory
 }
 
 type ConfigFactoryImpl struct {
@@ -33,6 +37,13 @@ func (f *ConfigFactoryImpl) ConfigureContextResolver(resolverFunc func() (string
 	f.contextResolverFunc = resolverFunc
 }
 
+func (f *ConfigFactoryImpl) ForContext(context string) ConfigFactory {
+	return &ConfigFactoryImpl{
+		pathResolverFunc:    f.pathResolverFunc,
+		contextResolverFunc: func() (string, error) { return context, nil },
+	}
+}
+
 func (f *ConfigFactoryImpl) RESTConfig() (*rest.Config, error) {
 	config, err := f.clientConfig()
 	if err != nil {
@@ -57,6 +68,29 @@ func (f *ConfigFactoryImpl) DefaultNamespace() (string, error) {
 	return name, err
 }
 
+func (f *ConfigFactoryImpl) CurrentContext() (string, error) {
+	context, err := f.contextResolverFunc()
+	if err != nil {
+		return "", fmt.Errorf("Resolving config context: %s", err)
+	}
+
+	if len(context) > 0 {
+		return context, nil
+	}
+
+	config, err := f.clientConfig()
+	if err != nil {
+		return "", err
+	}
+
+	rawConfig, err := config.RawConfig()
+	if err != nil {
+		return "", fmt.Errorf("Reading kubeconfig: %s", err)
+	}
+
+	return rawConfig.CurrentContext, nil
+}
+
 func (f *ConfigFactoryImpl) clientConfig() (clientcmd.ClientConfig, error) {
 	path, err := f.pathResolverFunc()
 	if err != nil {
@@ -2,6 +2,7 @@ package core
 
 import (
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/cppforlife/go-cli-ui/ui"
@@ -15,7 +16,7 @@ const (
 
 type Logger struct {
 	ui    ui.UI
-	debug bool
+	debug *int32 // shared by copies; accessed atomically
 }
 
 func NewLogger(ui ui.UI) Logger {
@@ -23,7 +24,18 @@ func NewLogger(ui ui.UI) Logger {
 }
 
 func NewLoggerWithDebug(ui ui.UI, debug bool) Logger {
-	return Logger{ui, debug}
+	logger := Logger{ui, new(int32)}
+	logger.SetDebug(debug)
+	return logger
+}
+
+// SetDebug toggles debug logging for this logger and its copies
+func (l Logger) SetDebug(debug bool) {
+	var val int32
+	if debug {
+		val = 1
+	}
+	atomic.StoreInt32(l.debug, val)
 }
 
 func (l Logger) Error(tag string, msg string, args ...interface{}) {
@@ -35,7 +47,7 @@ func (l Logger) Info(tag string, msg string, args ...interface{}) {
 }
 
 func (l Logger) Debug(tag string, msg string, args ...interface{}) {
-	if l.debug {
+	if atomic.LoadInt32(l.debug) == 1 {
 		l.ui.BeginLinef(l.msg(loggerLevelDebug, tag, msg), args...)
 	}
 }
@@ -91,12 +91,34 @@ func NewKwtCmd(o *KwtOptions, flagsFactory cmdcore.FlagsFactory) *cobra.Command
 
 	netCmd := cmdnet.NewNetCmd()
 	netCmd.AddCommand(cmdnet.NewStartCmd(cmdnet.NewStartOptions(o.depsFactory, o.configFactory, o.ui, cancelSignals), flagsFactory))
+	netCmd.AddCommand(cmdnet.NewProxyCmd(cmdnet.NewProxyOptions(o.depsFactory, o.configFactory, o.ui, cancelSignals), flagsFactory))
 	netCmd.AddCommand(cmdnet.NewCleanUpCmd(cmdnet.NewCleanUpOptions(o.depsFactory, o.configFactory, o.ui), flagsFactory))
+	netCmd.AddCommand(cmdnet.NewRotateKeysCmd(cmdnet.NewRotateKeysOptions(o.depsFactory, o.configFactory, o.ui), flagsFactory))
 	netCmd.AddCommand(cmdnet.NewForwardCmd(cmdnet.NewForwardOptions(o.depsFactory, o.ui, cancelSignals), flagsFactory))
 	netCmd.AddCommand(cmdnet.NewServicesCmd(cmdnet.NewServicesOptions(o.depsFactory, o.ui), flagsFactory))
 	netCmd.AddCommand(cmdnet.NewPodsCmd(cmdnet.NewPodsOptions(o.depsFactory, o.ui), flagsFactory))
 	netCmd.AddCommand(cmdnet.NewStartDNSCmd(cmdnet.NewStartDNSOptions(o.depsFactory, o.ui, cancelSignals), flagsFactory))
 	netCmd.AddCommand(cmdnet.NewListenCmd(cmdnet.NewListenOptions(o.depsFactory, o.configFactory, o.ui, cancelSignals), flagsFactory))
+	netCmd.AddCommand(cmdnet.NewStatusCmd(cmdnet.NewStatusOptions(o.ui), flagsFactory))
+
+	ctlCmd := cmdnet.NewCtlCmd()
+	ctlCmd.AddCommand(cmdnet.NewCtlDNSMappingsCmd(cmdnet.NewCtlDNSMappingsOptions(o.ui), flagsFactory))
+	ctlCmd.AddCommand(cmdnet.NewCtlAddDNSMappingCmd(cmdnet.NewCtlAddDNSMappingOptions(o.ui), flagsFactory))
+	ctlCmd.AddCommand(cmdnet.NewCtlRemoveDNSMappingCmd(cmdnet.NewCtlRemoveDNSMappingOptions(o.ui), flagsFactory))
+	ctlCmd.AddCommand(cmdnet.NewCtlAddSubnetCmd(cmdnet.NewCtlAddSubnetOptions(o.ui), flagsFactory))
+	ctlCmd.AddCommand(cmdnet.NewCtlRemoveSubnetCmd(cmdnet.NewCtlRemoveSubnetOptions(o.ui), flagsFactory))
+	ctlCmd.AddCommand(cmdnet.NewCtlConnsCmd(cmdnet.NewCtlConnsOptions(o.ui), flagsFactory))
+	ctlCmd.AddCommand(cmdnet.NewCtlKillConnCmd(cmdnet.NewCtlKillConnOptions(o.ui), flagsFactory))
+	ctlCmd.AddCommand(cmdnet.NewCtlFaultsCmd(cmdnet.NewCtlFaultsOptions(o.ui), flagsFactory))
+	ctlCmd.AddCommand(cmdnet.NewCtlSetFaultsCmd(cmdnet.NewCtlSetFaultsOptions(o.ui), flagsFactory))
+	ctlCmd.AddCommand(cmdnet.NewCtlDebugCmd(cmdnet.NewCtlDebugOptions(o.ui), flagsFactory))
+	netCmd.AddCommand(ctlCmd)
+
+	captureCmd := cmdnet.NewCaptureCmd()
+	captureCmd.AddCommand(cmdnet.NewCaptureLsCmd(cmdnet.NewCaptureLsOptions(o.ui), flagsFactory))
+	captureCmd.AddCommand(cmdnet.NewCaptureShowCmd(cmdnet.NewCaptureShowOptions(o.ui), flagsFactory))
+	netCmd.AddCommand(captureCmd)
+
 	cmd.AddCommand(netCmd)
 
 	regCmd := cmdreg.NewRegistryCmd()
@@ -0,0 +1,13 @@
+package net
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func NewCaptureCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "capture",
+		Short: "Inspect connections recorded by 'kwt net start --capture-dir'",
+	}
+	return cmd
+}
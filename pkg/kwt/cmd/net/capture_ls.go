@@ -0,0 +1,76 @@
+package net
+
+import (
+	"path/filepath"
+
+	cmdcore "github.com/carvel-dev/kwt/pkg/kwt/cmd/core"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/capture"
+	"github.com/cppforlife/go-cli-ui/ui"
+	uitable "github.com/cppforlife/go-cli-ui/ui/table"
+	"github.com/spf13/cobra"
+)
+
+type CaptureLsOptions struct {
+	ui ui.UI
+
+	Dir string
+}
+
+func NewCaptureLsOptions(ui ui.UI) *CaptureLsOptions {
+	return &CaptureLsOptions{ui: ui}
+}
+
+func NewCaptureLsCmd(o *CaptureLsOptions, flagsFactory cmdcore.FlagsFactory) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "ls",
+		Aliases: []string{"list"},
+		Short:   "List recorded connections",
+		Example: "kwt net capture ls -d ./captures",
+		RunE:    func(_ *cobra.Command, _ []string) error { return o.Run() },
+	}
+	cmd.Flags().StringVarP(&o.Dir, "dir", "d", "", "Capture directory (same as 'kwt net start --capture-dir')")
+	return cmd
+}
+
+func (o *CaptureLsOptions) Run() error {
+	files, err := capture.ListCaptureFiles(o.Dir)
+	if err != nil {
+		return err
+	}
+
+	table := uitable.Table{
+		Content: "captures",
+
+		Header: []uitable.Header{
+			uitable.NewHeader("File"),
+			uitable.NewHeader("Source"),
+			uitable.NewHeader("Destination"),
+			uitable.NewHeader("Started"),
+			uitable.NewHeader("Duration"),
+			uitable.NewHeader("Packets"),
+			uitable.NewHeader("Bytes out"),
+			uitable.NewHeader("Bytes in"),
+		},
+
+		SortBy: []uitable.ColumnSort{
+			{Column: 3, Asc: true},
+		},
+	}
+
+	for _, file := range files {
+		table.Rows = append(table.Rows, []uitable.Value{
+			uitable.NewValueString(filepath.Base(file.Path)),
+			uitable.NewValueString(file.Src),
+			uitable.NewValueString(file.Dst),
+			uitable.NewValueTime(file.StartedAt),
+			uitable.NewValueString(file.Duration.String()),
+			uitable.NewValueInt(file.Packets),
+			uitable.NewValueInt(file.BytesOut),
+			uitable.NewValueInt(file.BytesIn),
+		})
+	}
+
+	o.ui.PrintTable(table)
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package net
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	cmdcore "github.com/carvel-dev/kwt/pkg/kwt/cmd/core"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/capture"
+	"github.com/cppforlife/go-cli-ui/ui"
+	uitable "github.com/cppforlife/go-cli-ui/ui/table"
+	"github.com/spf13/cobra"
+)
+
+const (
+	captureShowDataPreviewLen = 60
+)
+
+type CaptureShowOptions struct {
+	ui ui.UI
+
+	Dir  string
+	File string
+	Full bool
+}
+
+func NewCaptureShowOptions(ui ui.UI) *CaptureShowOptions {
+	return &CaptureShowOptions{ui: ui}
+}
+
+func NewCaptureShowCmd(o *CaptureShowOptions, flagsFactory cmdcore.FlagsFactory) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "show",
+		Short: "Show packets of recorded connection",
+		Example: `
+  # Show packets with data previews
+  kwt net capture show -d ./captures -f 20261018T150405.123456-42.pcapng
+
+  # Show complete data of each packet
+  kwt net capture show -f ./captures/20261018T150405.123456-42.pcapng --full
+
+  # Files can also be opened with Wireshark or tcpdump
+  tcpdump -r ./captures/20261018T150405.123456-42.pcapng -A
+`,
+		RunE: func(_ *cobra.Command, _ []string) error { return o.Run() },
+	}
+	cmd.Flags().StringVarP(&o.Dir, "dir", "d", "", "Capture directory (same as 'kwt net start --capture-dir')")
+	cmd.Flags().StringVarP(&o.File, "file", "f", "", "Capture file (see 'kwt net capture ls')")
+	cmd.Flags().BoolVar(&o.Full, "full", false, "Show complete data instead of previews")
+	return cmd
+}
+
+func (o *CaptureShowOptions) Run() error {
+	if len(o.File) == 0 {
+		return fmt.Errorf("Expected non-empty capture file")
+	}
+
+	path := o.File
+	if len(o.Dir) > 0 {
+		path = filepath.Join(o.Dir, o.File)
+	}
+
+	packets, err := capture.ReadCaptureFile(path)
+	if err != nil {
+		return err
+	}
+
+	table := uitable.Table{
+		Title:   filepath.Base(path),
+		Content: "packets",
+
+		Header: []uitable.Header{
+			uitable.NewHeader("Time"),
+			uitable.NewHeader("Source"),
+			uitable.NewHeader("Destination"),
+			uitable.NewHeader("Flags"),
+			uitable.NewHeader("Bytes"),
+			uitable.NewHeader("Data"),
+		},
+	}
+
+	for _, packet := range packets {
+		table.Rows = append(table.Rows, []uitable.Value{
+			uitable.NewValueString(packet.Time.Format("15:04:05.000000")),
+			uitable.NewValueString(packet.Src.String()),
+			uitable.NewValueString(packet.Dst.String()),
+			uitable.NewValueString(packet.FlagsString()),
+			uitable.NewValueInt(len(packet.Payload)),
+			uitable.NewValueString(o.dataDesc(packet.Payload)),
+		})
+	}
+
+	o.ui.PrintTable(table)
+
+	return nil
+}
+
+func (o *CaptureShowOptions) dataDesc(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+
+	if !o.Full && len(data) > captureShowDataPreviewLen {
+		return strconv.Quote(string(data[:captureShowDataPreviewLen])) + "..."
+	}
+
+	return strconv.Quote(string(data))
+}
@@ -1,10 +1,15 @@
 package net
 
 import (
+	"fmt"
+	"syscall"
+
 	cmdcore "github.com/carvel-dev/kwt/pkg/kwt/cmd/core"
 	ctlnet "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/forwarder"
 	"github.com/cppforlife/go-cli-ui/ui"
 	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
 )
 
 type CleanUpOptions struct {
@@ -13,7 +18,12 @@ type CleanUpOptions struct {
 	ui            ui.UI
 
 	NamespaceFlags NamespaceFlags
+	NetOwnerFlags  NetOwnerFlags
 	LoggingFlags   LoggingFlags
+
+	Local  bool
+	Stale  bool
+	Legacy bool
 }
 
 func NewCleanUpOptions(
@@ -29,14 +39,35 @@ func NewCleanUpCmd(o *CleanUpOptions, flagsFactory cmdcore.FlagsFactory) *cobra.
 		Use:     "clean-up",
 		Aliases: []string{"cleanup"},
 		Short:   "Clean up network access",
-		RunE:    func(_ *cobra.Command, _ []string) error { return o.Run() },
+		Example: `
+  # Delete your networking pod, secrets and lease from the cluster
+  kwt net clean-up
+
+  # Delete networking resources of all users whose lease expired (ie 'kwt net start' is no longer running)
+  kwt net clean-up --stale
+
+  # Delete shared networking resources (eg 'kwt-net' pod) created by older kwt versions
+  kwt net clean-up --legacy
+
+  # Remove firewall rules left behind by previous 'kwt net start' runs that did not exit cleanly
+  sudo -E kwt net clean-up --local
+`,
+		RunE: func(_ *cobra.Command, _ []string) error { return o.Run() },
 	}
 	o.NamespaceFlags.Set(cmd)
+	o.NetOwnerFlags.Set(cmd)
 	o.LoggingFlags.Set(cmd)
+	cmd.Flags().BoolVar(&o.Local, "local", false, "Remove stale firewall and policy routing rules on this machine instead of cluster resources")
+	cmd.Flags().BoolVar(&o.Stale, "stale", false, "Delete networking resources of any owner whose lease expired instead of own resources")
+	cmd.Flags().BoolVar(&o.Legacy, "legacy", false, "Delete shared networking resources created by kwt versions that did not name resources per owner instead of own resources")
 	return cmd
 }
 
 func (o *CleanUpOptions) Run() error {
+	if o.Local {
+		return o.runLocal()
+	}
+
 	coreClient, err := o.depsFactory.CoreClient()
 	if err != nil {
 		return err
@@ -49,5 +80,83 @@ func (o *CleanUpOptions) Run() error {
 
 	logger := cmdcore.NewLoggerWithDebug(o.ui, o.LoggingFlags.Debug)
 
-	return ctlnet.NewKubeEntryPoint(coreClient, restConfig, o.NamespaceFlags.Name, "", logger).Delete()
+	if o.Stale {
+		return o.runStale(coreClient, logger)
+	}
+
+	if o.Legacy {
+		return o.runLegacy(coreClient, logger)
+	}
+
+	netOwner, err := o.NetOwnerFlags.Owner()
+	if err != nil {
+		return err
+	}
+
+	return ctlnet.NewKubeEntryPoint(coreClient, restConfig, o.NamespaceFlags.Name, "", "", logger).WithOwner(netOwner).Delete()
+}
+
+func (o *CleanUpOptions) runStale(coreClient kubernetes.Interface, logger ctlnet.Logger) error {
+	deleted, err := ctlnet.NewKubeNetGC(coreClient, o.NamespaceFlags.Name, logger).DeleteStale()
+
+	for _, desc := range deleted {
+		o.ui.PrintLinef("Deleted %s", desc)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(deleted) == 0 {
+		o.ui.PrintLinef("No networking resources with expired leases found")
+	}
+
+	return nil
+}
+
+func (o *CleanUpOptions) runLegacy(coreClient kubernetes.Interface, logger ctlnet.Logger) error {
+	deleted, err := ctlnet.NewKubeNetGC(coreClient, o.NamespaceFlags.Name, logger).DeleteLegacy()
+
+	for _, desc := range deleted {
+		o.ui.PrintLinef("Deleted %s", desc)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(deleted) == 0 {
+		o.ui.PrintLinef("No legacy networking resources found")
+	}
+
+	return nil
+}
+
+func (o *CleanUpOptions) runLocal() error {
+	if syscall.Geteuid() != 0 {
+		return fmt.Errorf("Command must run under sudo to change firewall settings (sudo -E kwt net clean-up --local)")
+	}
+
+	logger := cmdcore.NewLoggerWithDebug(o.ui, o.LoggingFlags.Debug)
+
+	cleaner, err := forwarder.NewFactory(forwarder.FactoryOpts{}, logger).NewStaleCleaner()
+	if err != nil {
+		return err
+	}
+
+	removed, err := cleaner.CleanUpStale()
+
+	for _, desc := range removed {
+		o.ui.PrintLinef("Removed %s", desc)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(removed) == 0 {
+		o.ui.PrintLinef("No stale firewall rules found")
+	}
+
+	return nil
 }
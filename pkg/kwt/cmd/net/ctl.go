@@ -0,0 +1,13 @@
+package net
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func NewCtlCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ctl",
+		Short: "Control running 'kwt net start'",
+	}
+	return cmd
+}
@@ -0,0 +1,112 @@
+package net
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	cmdcore "github.com/carvel-dev/kwt/pkg/kwt/cmd/core"
+	ctlnet "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"github.com/cppforlife/go-cli-ui/ui"
+	uitable "github.com/cppforlife/go-cli-ui/ui/table"
+	"github.com/spf13/cobra"
+)
+
+type CtlConnsOptions struct {
+	ui ui.UI
+
+	CtlFlags CtlFlags
+}
+
+func NewCtlConnsOptions(ui ui.UI) *CtlConnsOptions {
+	return &CtlConnsOptions{ui: ui}
+}
+
+func NewCtlConnsCmd(o *CtlConnsOptions, flagsFactory cmdcore.FlagsFactory) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "conns",
+		Aliases: []string{"conn", "connections"},
+		Short:   "List proxied connections",
+		RunE:    func(_ *cobra.Command, _ []string) error { return o.Run() },
+	}
+	o.CtlFlags.Set(cmd)
+	return cmd
+}
+
+func (o *CtlConnsOptions) Run() error {
+	conns, err := ctlnet.NewControlClient(o.CtlFlags.SocketPath).Conns()
+	if err != nil {
+		return err
+	}
+
+	table := uitable.Table{
+		Content: "connections",
+
+		Header: []uitable.Header{
+			uitable.NewHeader("ID"),
+			uitable.NewHeader("Source"),
+			uitable.NewHeader("Destination"),
+			uitable.NewHeader("Age"),
+			uitable.NewHeader("Bytes out"),
+			uitable.NewHeader("Bytes in"),
+		},
+
+		SortBy: []uitable.ColumnSort{
+			{Column: 0, Asc: true},
+		},
+	}
+
+	for _, conn := range conns {
+		table.Rows = append(table.Rows, []uitable.Value{
+			uitable.NewValueInt(int(conn.ID)),
+			uitable.NewValueString(conn.Src),
+			uitable.NewValueString(conn.Dst),
+			uitable.NewValueString(time.Since(conn.StartedAt).Round(time.Second).String()),
+			uitable.NewValueInt(int(conn.BytesOut)),
+			uitable.NewValueInt(int(conn.BytesIn)),
+		})
+	}
+
+	o.ui.PrintTable(table)
+
+	return nil
+}
+
+type CtlKillConnOptions struct {
+	ui ui.UI
+
+	CtlFlags CtlFlags
+
+	ID string
+}
+
+func NewCtlKillConnOptions(ui ui.UI) *CtlKillConnOptions {
+	return &CtlKillConnOptions{ui: ui}
+}
+
+func NewCtlKillConnCmd(o *CtlKillConnOptions, flagsFactory cmdcore.FlagsFactory) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "kill-conn",
+		Short: "Close proxied connection",
+		RunE:  func(_ *cobra.Command, _ []string) error { return o.Run() },
+	}
+	o.CtlFlags.Set(cmd)
+	cmd.Flags().StringVar(&o.ID, "id", "", "Connection ID (see 'kwt net ctl conns')")
+	return cmd
+}
+
+func (o *CtlKillConnOptions) Run() error {
+	id, err := strconv.ParseUint(o.ID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("Expected connection ID to be a number: %s", err)
+	}
+
+	err = ctlnet.NewControlClient(o.CtlFlags.SocketPath).CloseConn(id)
+	if err != nil {
+		return err
+	}
+
+	o.ui.PrintLinef("Closed connection %d", id)
+
+	return nil
+}
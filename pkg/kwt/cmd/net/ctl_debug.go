@@ -0,0 +1,53 @@
+package net
+
+import (
+	cmdcore "github.com/carvel-dev/kwt/pkg/kwt/cmd/core"
+	ctlnet "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"github.com/cppforlife/go-cli-ui/ui"
+	"github.com/spf13/cobra"
+)
+
+type CtlDebugOptions struct {
+	ui ui.UI
+
+	CtlFlags CtlFlags
+
+	Enabled bool
+}
+
+func NewCtlDebugOptions(ui ui.UI) *CtlDebugOptions {
+	return &CtlDebugOptions{ui: ui}
+}
+
+func NewCtlDebugCmd(o *CtlDebugOptions, flagsFactory cmdcore.FlagsFactory) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "debug",
+		Short: "Toggle debug logging",
+		Example: `
+  # Enable debug logging
+  kwt net ctl debug
+
+  # Disable debug logging
+  kwt net ctl debug --enabled=false
+`,
+		RunE: func(_ *cobra.Command, _ []string) error { return o.Run() },
+	}
+	o.CtlFlags.Set(cmd)
+	cmd.Flags().BoolVar(&o.Enabled, "enabled", true, "Enable debug logging")
+	return cmd
+}
+
+func (o *CtlDebugOptions) Run() error {
+	err := ctlnet.NewControlClient(o.CtlFlags.SocketPath).SetDebug(o.Enabled)
+	if err != nil {
+		return err
+	}
+
+	if o.Enabled {
+		o.ui.PrintLinef("Enabled debug logging")
+	} else {
+		o.ui.PrintLinef("Disabled debug logging")
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package net
+
+import (
+	"fmt"
+
+	cmdcore "github.com/carvel-dev/kwt/pkg/kwt/cmd/core"
+	ctlnet "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"github.com/cppforlife/go-cli-ui/ui"
+	uitable "github.com/cppforlife/go-cli-ui/ui/table"
+	"github.com/spf13/cobra"
+)
+
+type CtlDNSMappingsOptions struct {
+	ui ui.UI
+
+	CtlFlags CtlFlags
+}
+
+func NewCtlDNSMappingsOptions(ui ui.UI) *CtlDNSMappingsOptions {
+	return &CtlDNSMappingsOptions{ui: ui}
+}
+
+func NewCtlDNSMappingsCmd(o *CtlDNSMappingsOptions, flagsFactory cmdcore.FlagsFactory) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "dns-mappings",
+		Aliases: []string{"dns"},
+		Short:   "List DNS mappings",
+		RunE:    func(_ *cobra.Command, _ []string) error { return o.Run() },
+	}
+	o.CtlFlags.Set(cmd)
+	return cmd
+}
+
+func (o *CtlDNSMappingsOptions) Run() error {
+	mappings, err := ctlnet.NewControlClient(o.CtlFlags.SocketPath).DNSMappings()
+	if err != nil {
+		return err
+	}
+
+	table := uitable.Table{
+		Content: "DNS mappings",
+
+		Header: []uitable.Header{
+			uitable.NewHeader("Domain"),
+			uitable.NewHeader("Target"),
+		},
+
+		SortBy: []uitable.ColumnSort{
+			{Column: 0, Asc: true},
+		},
+	}
+
+	for _, mapping := range mappings {
+		table.Rows = append(table.Rows, []uitable.Value{
+			uitable.NewValueString(mapping.Domain),
+			uitable.NewValueString(mapping.Target),
+		})
+	}
+
+	o.ui.PrintTable(table)
+
+	return nil
+}
+
+type CtlAddDNSMappingOptions struct {
+	ui ui.UI
+
+	CtlFlags CtlFlags
+
+	Domain string
+	IPs    []string
+}
+
+func NewCtlAddDNSMappingOptions(ui ui.UI) *CtlAddDNSMappingOptions {
+	return &CtlAddDNSMappingOptions{ui: ui}
+}
+
+func NewCtlAddDNSMappingCmd(o *CtlAddDNSMappingOptions, flagsFactory cmdcore.FlagsFactory) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "add-dns-mapping",
+		Short: "Add or replace DNS mapping",
+		Example: `
+  # Redirect all example.com and its subdomains to localhost
+  kwt net ctl add-dns-mapping --domain example.com --ip 127.0.0.1
+`,
+		RunE: func(_ *cobra.Command, _ []string) error { return o.Run() },
+	}
+	o.CtlFlags.Set(cmd)
+	cmd.Flags().StringVar(&o.Domain, "domain", "", "Domain to map (includes subdomains)")
+	cmd.Flags().StringSliceVar(&o.IPs, "ip", nil, "IP to resolve domain to (can be specified multiple times)")
+	return cmd
+}
+
+func (o *CtlAddDNSMappingOptions) Run() error {
+	if len(o.Domain) == 0 {
+		return fmt.Errorf("Expected non-empty domain")
+	}
+
+	err := ctlnet.NewControlClient(o.CtlFlags.SocketPath).AddDNSMapping(o.Domain, o.IPs)
+	if err != nil {
+		return err
+	}
+
+	o.ui.PrintLinef("Added DNS mapping for '%s'", o.Domain)
+
+	return nil
+}
+
+type CtlRemoveDNSMappingOptions struct {
+	ui ui.UI
+
+	CtlFlags CtlFlags
+
+	Domain string
+}
+
+func NewCtlRemoveDNSMappingOptions(ui ui.UI) *CtlRemoveDNSMappingOptions {
+	return &CtlRemoveDNSMappingOptions{ui: ui}
+}
+
+func NewCtlRemoveDNSMappingCmd(o *CtlRemoveDNSMappingOptions, flagsFactory cmdcore.FlagsFactory) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "remove-dns-mapping",
+		Short: "Remove DNS mapping",
+		RunE:  func(_ *cobra.Command, _ []string) error { return o.Run() },
+	}
+	o.CtlFlags.Set(cmd)
+	cmd.Flags().StringVar(&o.Domain, "domain", "", "Domain to remove mapping for")
+	return cmd
+}
+
+func (o *CtlRemoveDNSMappingOptions) Run() error {
+	if len(o.Domain) == 0 {
+		return fmt.Errorf("Expected non-empty domain")
+	}
+
+	err := ctlnet.NewControlClient(o.CtlFlags.SocketPath).RemoveDNSMapping(o.Domain)
+	if err != nil {
+		return err
+	}
+
+	o.ui.PrintLinef("Removed DNS mapping for '%s'", o.Domain)
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package net
+
+import (
+	cmdcore "github.com/carvel-dev/kwt/pkg/kwt/cmd/core"
+	ctlnet "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/fault"
+	"github.com/cppforlife/go-cli-ui/ui"
+	uitable "github.com/cppforlife/go-cli-ui/ui/table"
+	"github.com/spf13/cobra"
+)
+
+type CtlFaultsOptions struct {
+	ui ui.UI
+
+	CtlFlags CtlFlags
+}
+
+func NewCtlFaultsOptions(ui ui.UI) *CtlFaultsOptions {
+	return &CtlFaultsOptions{ui: ui}
+}
+
+func NewCtlFaultsCmd(o *CtlFaultsOptions, flagsFactory cmdcore.FlagsFactory) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "faults",
+		Aliases: []string{"fault"},
+		Short:   "List fault rules applied to new connections",
+		RunE:    func(_ *cobra.Command, _ []string) error { return o.Run() },
+	}
+	o.CtlFlags.Set(cmd)
+	return cmd
+}
+
+func (o *CtlFaultsOptions) Run() error {
+	rules, err := ctlnet.NewControlClient(o.CtlFlags.SocketPath).FaultRules()
+	if err != nil {
+		return err
+	}
+
+	table := uitable.Table{
+		Content: "fault rules",
+
+		Header: []uitable.Header{
+			uitable.NewHeader("Rule"),
+		},
+	}
+
+	for _, rule := range rules {
+		table.Rows = append(table.Rows, []uitable.Value{
+			uitable.NewValueString(rule),
+		})
+	}
+
+	o.ui.PrintTable(table)
+
+	return nil
+}
+
+type CtlSetFaultsOptions struct {
+	ui ui.UI
+
+	CtlFlags CtlFlags
+
+	Rules []string
+}
+
+func NewCtlSetFaultsOptions(ui ui.UI) *CtlSetFaultsOptions {
+	return &CtlSetFaultsOptions{ui: ui}
+}
+
+func NewCtlSetFaultsCmd(o *CtlSetFaultsOptions, flagsFactory cmdcore.FlagsFactory) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set-faults",
+		Short: "Replace fault rules applied to new connections",
+		Example: `
+  # Slow down connections to redis
+  kwt net ctl set-faults --inject 'dst=redis-master.default:6379 latency=200ms jitter=50ms'
+
+  # Remove all fault rules
+  kwt net ctl set-faults
+`,
+		RunE: func(_ *cobra.Command, _ []string) error { return o.Run() },
+	}
+	o.CtlFlags.Set(cmd)
+	cmd.Flags().StringArrayVar(&o.Rules, "inject", nil, "Fault rule (can be specified multiple times; first matching rule applies)")
+	return cmd
+}
+
+func (o *CtlSetFaultsOptions) Run() error {
+	// Validate locally to give errors before talking to running process
+	_, err := fault.NewRules(o.Rules)
+	if err != nil {
+		return err
+	}
+
+	err = ctlnet.NewControlClient(o.CtlFlags.SocketPath).SetFaultRules(o.Rules)
+	if err != nil {
+		return err
+	}
+
+	if len(o.Rules) == 0 {
+		o.ui.PrintLinef("Removed all fault rules")
+	} else {
+		o.ui.PrintLinef("Set %d fault rule(s)", len(o.Rules))
+	}
+
+	return nil
+}
@@ -0,0 +1,25 @@
+package net
+
+import (
+	"os"
+	"strconv"
+
+	ctlnet "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"github.com/spf13/cobra"
+)
+
+type CtlFlags struct {
+	SocketPath string
+}
+
+func (s *CtlFlags) Set(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&s.SocketPath, "ctl-socket", ctlnet.DefaultControlSocketPath(), "Path to control socket of running 'kwt net start'")
+}
+
+// SudoOwner returns user that invoked sudo so that
+// control socket is usable without sudo
+func (s CtlFlags) SudoOwner() (int, int) {
+	uid, _ := strconv.Atoi(os.Getenv("SUDO_UID"))
+	gid, _ := strconv.Atoi(os.Getenv("SUDO_GID"))
+	return uid, gid
+}
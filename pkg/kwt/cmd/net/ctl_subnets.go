@@ -0,0 +1,87 @@
+package net
+
+import (
+	"fmt"
+	"strings"
+
+	cmdcore "github.com/carvel-dev/kwt/pkg/kwt/cmd/core"
+	ctlnet "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"github.com/cppforlife/go-cli-ui/ui"
+	"github.com/spf13/cobra"
+)
+
+type CtlAddSubnetOptions struct {
+	ui ui.UI
+
+	CtlFlags CtlFlags
+
+	Subnets []string
+}
+
+func NewCtlAddSubnetOptions(ui ui.UI) *CtlAddSubnetOptions {
+	return &CtlAddSubnetOptions{ui: ui}
+}
+
+func NewCtlAddSubnetCmd(o *CtlAddSubnetOptions, flagsFactory cmdcore.FlagsFactory) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "add-subnet",
+		Short: "Start forwarding subnet",
+		RunE:  func(_ *cobra.Command, _ []string) error { return o.Run() },
+	}
+	o.CtlFlags.Set(cmd)
+	cmd.Flags().StringSliceVarP(&o.Subnets, "subnet", "s", nil, "Subnet (can be specified multiple times)")
+	return cmd
+}
+
+func (o *CtlAddSubnetOptions) Run() error {
+	if len(o.Subnets) == 0 {
+		return fmt.Errorf("Expected at least one subnet")
+	}
+
+	err := ctlnet.NewControlClient(o.CtlFlags.SocketPath).AddSubnets(o.Subnets)
+	if err != nil {
+		return err
+	}
+
+	o.ui.PrintLinef("Forwarding subnets: %s", strings.Join(o.Subnets, ", "))
+
+	return nil
+}
+
+type CtlRemoveSubnetOptions struct {
+	ui ui.UI
+
+	CtlFlags CtlFlags
+
+	Subnets []string
+}
+
+func NewCtlRemoveSubnetOptions(ui ui.UI) *CtlRemoveSubnetOptions {
+	return &CtlRemoveSubnetOptions{ui: ui}
+}
+
+func NewCtlRemoveSubnetCmd(o *CtlRemoveSubnetOptions, flagsFactory cmdcore.FlagsFactory) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "remove-subnet",
+		Short: "Stop forwarding subnet",
+		RunE:  func(_ *cobra.Command, _ []string) error { return o.Run() },
+	}
+	o.CtlFlags.Set(cmd)
+	cmd.Flags().StringSliceVarP(&o.Subnets, "subnet", "s", nil, "Subnet (can be specified multiple times)")
+	return cmd
+}
+
+func (o *CtlRemoveSubnetOptions) Run() error {
+	if len(o.Subnets) == 0 {
+		return fmt.Errorf("Expected at least one subnet")
+	}
+
+	err := ctlnet.NewControlClient(o.CtlFlags.SocketPath).RemoveSubnets(o.Subnets)
+	if err != nil {
+		return err
+	}
+
+	o.ui.PrintLinef("Stopped forwarding subnets: %s", strings.Join(o.Subnets, ", "))
+
+	return nil
+}
@@ -21,12 +21,31 @@ type DNSServerFactory struct {
 	defaultRecursorIPs ctlnet.DNSIPs
 	coreClient         kubernetes.Interface
 	logger             cmdcore.Logger
+
+	// Additional cluster domains (eg dev.local) resolved against their clusters
+	clusterDomains map[string]kubernetes.Interface
+
+	// Answers service names instead of cluster IPs (eg in loopback mode)
+	svcIPs ctlkubedns.ServiceIPs
+
+	// Mappings changed via control socket
+	runtimeDomains *ctldns.RuntimeDomains
 }
 
 var _ ctlnet.DNSServerFactory = DNSServerFactory{}
 
 func NewDNSServerFactory(dnsFlags DNSFlags, defaultRecursorIPs ctlnet.DNSIPs, coreClient kubernetes.Interface, logger cmdcore.Logger) DNSServerFactory {
-	return DNSServerFactory{dnsFlags, defaultRecursorIPs, coreClient, logger}
+	return DNSServerFactory{dnsFlags, defaultRecursorIPs, coreClient, logger, nil, nil, ctldns.NewRuntimeDomains()}
+}
+
+func (f DNSServerFactory) WithClusterDomains(clusterDomains map[string]kubernetes.Interface) DNSServerFactory {
+	f.clusterDomains = clusterDomains
+	return f
+}
+
+func (f DNSServerFactory) WithServiceIPs(svcIPs ctlkubedns.ServiceIPs) DNSServerFactory {
+	f.svcIPs = svcIPs
+	return f
 }
 
 func (f DNSServerFactory) NewDNSServer(dstConnFactory dstconn.Factory) (ctlnet.DNSServer, error) {
@@ -43,7 +62,7 @@ func (f DNSServerFactory) NewDNSServer(dstConnFactory dstconn.Factory) (ctlnet.D
 	}
 
 	if f.dnsFlags.MDNS {
-		resolver := ctlkubedns.NewKubeDNSIPResolver(ctlkubedns.DefaultClusterDomain, f.coreClient)
+		resolver := ctlkubedns.NewKubeDNSIPResolver(ctlkubedns.DefaultClusterDomain, f.coreClient).WithServiceIPs(f.svcIPs)
 		mdnsServer := ctlmdns.NewFactory().Build(resolver, f.logger)
 		return CombinedDNSServer{server, mdnsServer}, nil
 	}
@@ -55,6 +74,53 @@ func (f DNSServerFactory) NewDNSOSCache() ctlnet.DNSOSCache {
 	return ctlnet.NewDNSOSCache(f.logger)
 }
 
+var _ ctlnet.ControlDNS = DNSServerFactory{}
+
+func (f DNSServerFactory) DNSMappings() (map[string]string, error) {
+	opts, err := f.buildServerOpts()
+	if err != nil {
+		return nil, err
+	}
+
+	domains, err := opts.DomainsMapFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]string{}
+
+	for domain, resolver := range domains {
+		result[domain] = fmt.Sprintf("%s", resolver)
+	}
+
+	return result, nil
+}
+
+func (f DNSServerFactory) AddDNSMapping(domain string, ips []net.IP) error {
+	if len(domain) == 0 || len(ips) == 0 {
+		return fmt.Errorf("Expected DNS mapping to have non-empty domain and at least one IP")
+	}
+
+	f.runtimeDomains.Add(domain, ips)
+
+	return nil
+}
+
+func (f DNSServerFactory) RemoveDNSMapping(domain string) error {
+	mappings, err := f.DNSMappings()
+	if err != nil {
+		return err
+	}
+
+	if _, found := mappings[domain]; !found {
+		return fmt.Errorf("Expected DNS mapping for domain '%s' to exist", domain)
+	}
+
+	f.runtimeDomains.Remove(domain)
+
+	return nil
+}
+
 func (f DNSServerFactory) buildServerOpts() (ctldns.BuildOpts, error) {
 	domainsMap := map[string]ctldns.IPResolver{}
 
@@ -81,8 +147,10 @@ func (f DNSServerFactory) buildServerOpts() (ctldns.BuildOpts, error) {
 	}
 
 	opts := ctldns.BuildOpts{
-		ListenAddrs:   []string{"localhost:0"},
-		RecursorAddrs: f.dnsFlags.Recursors,
+		ListenAddrs: []string{"127.0.0.1:0"},
+		// Forwarders redirect DNS traffic to IPv6 DNS servers to the same port on ::1
+		BestEffortListenAddrs: []string{"[::1]:0"},
+		RecursorAddrs:         f.dnsFlags.Recursors,
 
 		DomainsMapFunc: func() (map[string]ctldns.IPResolver, error) {
 			result, err := DomainsMapExecs{f.dnsFlags.MapExecs}.Get()
@@ -98,10 +166,17 @@ func (f DNSServerFactory) buildServerOpts() (ctldns.BuildOpts, error) {
 			// may just use /etc/resolv.conf for DNS resolution on OS X (eg dig)
 			// instead of relying on standard OS X resolution libraries
 			result[ctlkubedns.DefaultClusterDomain] =
-				ctlkubedns.NewKubeDNSIPResolver(ctlkubedns.DefaultClusterDomain, f.coreClient)
+				ctlkubedns.NewKubeDNSIPResolver(ctlkubedns.DefaultClusterDomain, f.coreClient).WithServiceIPs(f.svcIPs)
+
+			for domain, coreClient := range f.clusterDomains {
+				result[domain] = ctlkubedns.NewKubeDNSIPResolver(domain, coreClient)
+			}
+
+			f.runtimeDomains.Apply(result)
 
 			return result, nil
 		},
+		DomainsUpdateCh: f.runtimeDomains.UpdateCh(),
 	}
 
 	if len(opts.RecursorAddrs) == 0 {
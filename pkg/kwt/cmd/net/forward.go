@@ -15,7 +15,8 @@ type ForwardOptions struct {
 	ui            ui.UI
 	cancelSignals cmdcore.CancelSignals
 
-	LoggingFlags LoggingFlags
+	LoggingFlags   LoggingFlags
+	ForwarderFlags ForwarderFlags
 
 	Subnets []string
 	TCPPort int
@@ -35,6 +36,7 @@ func NewForwardCmd(o *ForwardOptions, flagsFactory cmdcore.FlagsFactory) *cobra.
 	}
 
 	o.LoggingFlags.Set(cmd)
+	o.ForwarderFlags.Set(cmd)
 
 	cmd.Flags().StringSliceVarP(&o.Subnets, "subnet", "s", nil, "Subnet (can be specified multiple times)")
 	cmd.Flags().IntVar(&o.TCPPort, "tcp-port", 8080, "TCP port destination")
@@ -46,15 +48,15 @@ func NewForwardCmd(o *ForwardOptions, flagsFactory cmdcore.FlagsFactory) *cobra.
 func (o *ForwardOptions) Run() error {
 	logger := cmdcore.NewLoggerWithDebug(o.ui, o.LoggingFlags.Debug)
 
-	forwarderFactory := forwarder.NewFactory(0, logger)
-	opts := forwarder.ForwarderOpts{DstTCPPort: o.TCPPort, DstDNSUDPPort: o.UDPPort}
+	forwarderFactory := forwarder.NewFactory(forwarder.FactoryOpts{Type: o.ForwarderFlags.Type}, logger)
+	opts := forwarder.ForwarderOpts{DstTCPPort: o.TCPPort, DstUDPPort: o.UDPPort}
 
-	forwarder, err := forwarderFactory.NewForwarder(opts)
+	fwd, err := forwarderFactory.NewForwarder(opts)
 	if err != nil {
 		return err
 	}
 
-	err = forwarder.CheckPrereqs()
+	err = fwd.CheckPrereqs()
 	if err != nil {
 		return err
 	}
@@ -64,20 +66,23 @@ func (o *ForwardOptions) Run() error {
 		return err
 	}
 
-	err = forwarder.Add(subnets, []net.IP{})
+	err = fwd.Add(subnets, []net.IP{})
 	if err != nil {
 		return err
 	}
 
 	o.ui.PrintLinef("Forwarding TCP %s -> 127.0.0.1:%d", o.Subnets, o.TCPPort)
-	o.ui.PrintLinef("Forwarding UDP %s -> 127.0.0.1:%d", o.Subnets, o.UDPPort)
+	subnets4, _ := forwarder.SplitSubnetsByFamily(subnets)
+
+	o.ui.PrintLinef("Forwarding UDP %s -> 127.0.0.1:%d (IPv6 subnets are not forwarded for UDP)",
+		ctlnet.SubnetsAsString(subnets4), o.UDPPort)
 
 	doneCh := make(chan struct{})
 
 	o.cancelSignals.Watch(func() {
 		o.ui.PrintLinef("Stopping")
 
-		err := forwarder.Reset()
+		err := fwd.Reset()
 		if err != nil {
 			logger.Error("ForwardOptions", "Failed resetting forwarder: %s", err)
 		}
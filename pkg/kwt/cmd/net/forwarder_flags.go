@@ -0,0 +1,13 @@
+package net
+
+import (
+	"github.com/spf13/cobra"
+)
+
+type ForwarderFlags struct {
+	Type string
+}
+
+func (s *ForwarderFlags) Set(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&s.Type, "forwarder", "", "Firewall forwarder to use (on Linux: iptables, nftables; guessed if not specified)")
+}
@@ -27,8 +27,10 @@ type ListenOptions struct {
 	RemoteAddr string
 	LocalAddr  string
 
-	SSHFlags     SSHFlags
-	LoggingFlags LoggingFlags
+	SSHFlags      SSHFlags
+	NetPodFlags   NetPodFlags
+	NetOwnerFlags NetOwnerFlags
+	LoggingFlags  LoggingFlags
 }
 
 func NewListenOptions(
@@ -73,6 +75,8 @@ func NewListenCmd(o *ListenOptions, flagsFactory cmdcore.FlagsFactory) *cobra.Co
 	cmd.Flags().StringVarP(&o.LocalAddr, "local", "l", "localhost:80", "Local address (example: 80, localhost:80)")
 
 	o.SSHFlags.Set(cmd)
+	o.NetPodFlags.Set(cmd)
+	o.NetOwnerFlags.Set(cmd)
 	o.LoggingFlags.Set(cmd)
 
 	return cmd
@@ -83,6 +87,11 @@ func (o *ListenOptions) Run() error {
 		return fmt.Errorf("Expected non-empty service name")
 	}
 
+	err := o.SSHFlags.Validate()
+	if err != nil {
+		return err
+	}
+
 	localAddr, err := net.ResolveTCPAddr("tcp", o.LocalAddr)
 	if err != nil {
 		return fmt.Errorf("Resolving local addr: %s", err)
@@ -110,11 +119,38 @@ func (o *ListenOptions) Run() error {
 			PrivateKeyPEM: o.SSHFlags.PrivateKey,
 		})
 	} else {
-		entryPoint = ctlnet.NewKubeEntryPoint(
-			coreClient, restConfig, o.NamespaceFlags.Name, o.SSHFlags.Image, logger)
+		transport, err := ctlnet.SelectKubeTransport(coreClient, o.NamespaceFlags.Name, o.SSHFlags.Transport, logger)
+		if err != nil {
+			return err
+		}
+
+		netPodOpts, err := o.NetPodFlags.NetPodOpts()
+		if err != nil {
+			return err
+		}
+
+		netOwner, err := o.NetOwnerFlags.Owner()
+		if err != nil {
+			return err
+		}
+
+		keyType, err := o.SSHFlags.SSHKeyType()
+		if err != nil {
+			return err
+		}
+
+		kubeEntryPoint := ctlnet.NewKubeEntryPoint(
+			coreClient, restConfig, o.NamespaceFlags.Name, o.SSHFlags.Image, transport, logger).
+			WithNetPodOpts(netPodOpts).WithOwner(netOwner).WithSSHKeyType(keyType)
+
+		if o.SSHFlags.EphemeralKeys {
+			kubeEntryPoint = kubeEntryPoint.WithEphemeralKeys()
+		}
+
+		entryPoint = kubeEntryPoint
 	}
 
-	reconnSSHClient := ctlnet.NewReconnSSHClient(entryPoint, logger)
+	reconnSSHClient := ctlnet.NewReconnSSHClient(entryPoint, logger).WithHealthOpts(o.SSHFlags.HealthOpts())
 
 	err = reconnSSHClient.Connect()
 	if err != nil {
@@ -151,6 +187,34 @@ func (o *ListenOptions) Run() error {
 	proxy := ctlnet.NewTCPProxy(resolver, dstconn.NewLocal(logger), logger)
 	startedCh := make(chan struct{})
 
+	currentContext, err := o.configFactory.CurrentContext()
+	if err != nil {
+		return err
+	}
+
+	controlServer := ctlnet.NewControlServer(ctlnet.ControlServerOpts{
+		SocketPath: ctlnet.ListenControlSocketPath(),
+
+		Conns:  proxy,
+		Logger: logger,
+		Status: ctlnet.NewSessionStatusReporter(ctlnet.SessionStatusReporterOpts{
+			Command: "listen",
+			Remotes: ctlnet.SingleRemoteStatuses{
+				Context:    currentContext,
+				EntryPoint: entryPoint,
+				SSHClient:  reconnSSHClient,
+			},
+			Conns: proxy,
+		}),
+	}, logger)
+
+	err = controlServer.Start()
+	if err != nil {
+		return err
+	}
+
+	defer controlServer.Shutdown()
+
 	go func() {
 		<-startedCh
 		logger.Info(logTag, "Forwarding %s->%s", o.RemoteAddr, o.LocalAddr)
@@ -0,0 +1,52 @@
+package net
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+
+	ctlnet "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"github.com/spf13/cobra"
+)
+
+type NetOwnerFlags struct {
+	Name string
+}
+
+func (s *NetOwnerFlags) Set(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&s.Name, "net-owner", "", "Owner used to name and label networking resources so that users sharing a namespace do not affect each other (defaults to current user)")
+}
+
+func (s *NetOwnerFlags) Owner() (ctlnet.KubeNetOwner, error) {
+	userName, err := s.currentUserName()
+	if err != nil {
+		return ctlnet.KubeNetOwner{}, err
+	}
+
+	identity := userName
+
+	hostname, err := os.Hostname()
+	if err == nil {
+		identity += "@" + hostname
+	}
+
+	if len(s.Name) > 0 {
+		return ctlnet.NewKubeNetOwner(s.Name, identity)
+	}
+
+	return ctlnet.NewKubeNetOwner(userName, identity)
+}
+
+func (s *NetOwnerFlags) currentUserName() (string, error) {
+	// Most net commands run under sudo
+	if sudoUser := os.Getenv("SUDO_USER"); len(sudoUser) > 0 {
+		return sudoUser, nil
+	}
+
+	currUser, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("Determining current user (specify --net-owner): %s", err)
+	}
+
+	return currUser.Username, nil
+}
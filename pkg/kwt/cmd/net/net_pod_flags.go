@@ -0,0 +1,65 @@
+package net
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	ctlnet "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"github.com/spf13/cobra"
+)
+
+type NetPodFlags struct {
+	Tolerations      []string
+	NodeSelector     []string
+	ServiceAccount   string
+	ImagePullSecrets []string
+	TemplatePath     string
+	Replicas         int
+}
+
+func (s *NetPodFlags) Set(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&s.Tolerations, "net-pod-toleration", nil, "Toleration for net pod in 'key[=value][:effect]' format or '*' for all taints (can be specified multiple times)")
+	cmd.Flags().StringSliceVar(&s.NodeSelector, "net-pod-node-selector", nil, "Node selector for net pod in 'key=value' format (can be specified multiple times)")
+	cmd.Flags().StringVar(&s.ServiceAccount, "net-pod-service-account", "", "Service account for net pod")
+	cmd.Flags().StringSliceVar(&s.ImagePullSecrets, "net-pod-image-pull-secret", nil, "Image pull secret for net pod (can be specified multiple times)")
+	cmd.Flags().StringVar(&s.TemplatePath, "net-pod-template", "", "Path to pod YAML merged over generated net pod (eg to set resources or security context; containers are merged by name 'kwt-net')")
+	cmd.Flags().IntVar(&s.Replicas, "net-pod-replicas", 0, "Run net pod as a deployment with given number of replicas so that connections fail over when a replica goes away (eg due to node drain)")
+}
+
+func (s *NetPodFlags) NetPodOpts() (ctlnet.KubeNetPodOpts, error) {
+	if s.Replicas < 0 {
+		return ctlnet.KubeNetPodOpts{}, fmt.Errorf("Expected net pod replicas to be >= 0")
+	}
+
+	tolerations, err := ctlnet.NewTolerations(s.Tolerations)
+	if err != nil {
+		return ctlnet.KubeNetPodOpts{}, err
+	}
+
+	nodeSelector, err := ctlnet.NewNodeSelector(s.NodeSelector)
+	if err != nil {
+		return ctlnet.KubeNetPodOpts{}, err
+	}
+
+	opts := ctlnet.KubeNetPodOpts{
+		Tolerations:      tolerations,
+		NodeSelector:     nodeSelector,
+		ServiceAccount:   s.ServiceAccount,
+		ImagePullSecrets: s.ImagePullSecrets,
+		Replicas:         s.Replicas,
+	}
+
+	if len(s.TemplatePath) > 0 {
+		templateBytes, err := ioutil.ReadFile(s.TemplatePath)
+		if err != nil {
+			return ctlnet.KubeNetPodOpts{}, fmt.Errorf("Reading net pod template: %s", err)
+		}
+
+		opts.Template, err = ctlnet.NewPodTemplate(templateBytes)
+		if err != nil {
+			return ctlnet.KubeNetPodOpts{}, err
+		}
+	}
+
+	return opts, nil
+}
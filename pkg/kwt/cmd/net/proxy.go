@@ -0,0 +1,247 @@
+package net
+
+import (
+	"fmt"
+
+	cmdcore "github.com/carvel-dev/kwt/pkg/kwt/cmd/core"
+	ctldns "github.com/carvel-dev/kwt/pkg/kwt/dns"
+	ctlnet "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
+	"github.com/cppforlife/go-cli-ui/ui"
+	"github.com/spf13/cobra"
+)
+
+type ProxyOptions struct {
+	depsFactory   cmdcore.DepsFactory
+	configFactory cmdcore.ConfigFactory
+	ui            ui.UI
+	cancelSignals cmdcore.CancelSignals
+
+	NamespaceFlags NamespaceFlags
+	DNSFlags       DNSFlags
+	LoggingFlags   LoggingFlags
+	SSHFlags       SSHFlags
+	NetPodFlags    NetPodFlags
+	NetOwnerFlags  NetOwnerFlags
+
+	SOCKSAddr   string
+	HTTPAddr    string
+	SSHPoolSize int
+}
+
+func NewProxyOptions(
+	depsFactory cmdcore.DepsFactory,
+	configFactory cmdcore.ConfigFactory,
+	ui ui.UI,
+	cancelSignals cmdcore.CancelSignals,
+) *ProxyOptions {
+	return &ProxyOptions{
+		depsFactory:   depsFactory,
+		configFactory: configFactory,
+		ui:            ui,
+		cancelSignals: cancelSignals,
+	}
+}
+
+func NewProxyCmd(o *ProxyOptions, flagsFactory cmdcore.FlagsFactory) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "proxy",
+		Aliases: []string{"p"},
+		Short:   "Sets up network access via local SOCKS5 and HTTP CONNECT proxies (does not require sudo)",
+		Example: `
+  # Start proxies on default addresses
+  kwt net proxy
+
+  # Use proxies (hosts are resolved via kwt DNS, eg svc-name.ns-name.svc.cluster.local)
+  curl --proxy socks5h://localhost:1080 http://svc-name.ns-name.svc.cluster.local
+  HTTPS_PROXY=http://localhost:3128 curl https://svc-name.ns-name.svc.cluster.local
+
+  # Start SOCKS5 proxy on a different port
+  kwt net proxy --socks-addr localhost:9050
+`,
+		RunE: func(_ *cobra.Command, _ []string) error { return o.Run() },
+	}
+
+	o.NamespaceFlags.Set(cmd)
+	o.DNSFlags.SetWithPrefix(cmd, "dns")
+	o.LoggingFlags.Set(cmd)
+	o.SSHFlags.Set(cmd)
+	o.NetPodFlags.Set(cmd)
+	o.NetOwnerFlags.Set(cmd)
+
+	cmd.Flags().StringVar(&o.SOCKSAddr, "socks-addr", "localhost:1080", "Address to serve SOCKS5 proxy on")
+	cmd.Flags().StringVar(&o.HTTPAddr, "http-addr", "localhost:3128", "Address to serve HTTP CONNECT proxy on")
+	cmd.Flags().IntVar(&o.SSHPoolSize, "ssh-pool-size", 1, "Number of parallel SSH connections to spread proxied connections across")
+
+	return cmd
+}
+
+func (o *ProxyOptions) Run() error {
+	err := o.SSHFlags.Validate()
+	if err != nil {
+		return err
+	}
+
+	coreClient, err := o.depsFactory.CoreClient()
+	if err != nil {
+		return err
+	}
+
+	restConfig, err := o.configFactory.RESTConfig()
+	if err != nil {
+		return err
+	}
+
+	logger := cmdcore.NewLoggerWithDebug(o.ui, o.LoggingFlags.Debug)
+	logTag := "ProxyOptions"
+
+	var entryPoint ctlnet.EntryPoint
+
+	if len(o.SSHFlags.PrivateKey) > 0 {
+		entryPoint = ctlnet.NewSSHEntryPoint(dstconn.SSHClientConnOpts{
+			User:          o.SSHFlags.User,
+			Host:          o.SSHFlags.Host,
+			PrivateKeyPEM: o.SSHFlags.PrivateKey,
+		})
+	} else {
+		transport, err := ctlnet.SelectKubeTransport(coreClient, o.NamespaceFlags.Name, o.SSHFlags.Transport, logger)
+		if err != nil {
+			return err
+		}
+
+		netPodOpts, err := o.NetPodFlags.NetPodOpts()
+		if err != nil {
+			return err
+		}
+
+		netOwner, err := o.NetOwnerFlags.Owner()
+		if err != nil {
+			return err
+		}
+
+		keyType, err := o.SSHFlags.SSHKeyType()
+		if err != nil {
+			return err
+		}
+
+		kubeEntryPoint := ctlnet.NewKubeEntryPoint(
+			coreClient, restConfig, o.NamespaceFlags.Name, o.SSHFlags.Image, transport, logger).
+			WithNetPodOpts(netPodOpts).WithOwner(netOwner).WithSSHKeyType(keyType)
+
+		if o.SSHFlags.EphemeralKeys {
+			kubeEntryPoint = kubeEntryPoint.WithEphemeralKeys()
+		}
+
+		entryPoint = kubeEntryPoint
+	}
+
+	reconnSSHClient := ctlnet.NewReconnSSHClientPool(entryPoint, o.SSHPoolSize, logger).
+		WithHealthOpts(o.SSHFlags.HealthOpts())
+
+	err = reconnSSHClient.Connect()
+	if err != nil {
+		return err
+	}
+
+	defer reconnSSHClient.Disconnect()
+
+	dnsIPs := ResolvConfDNSIPs{ctldns.NewResolvConf()}
+
+	dnsServer, err := NewDNSServerFactory(o.DNSFlags, dnsIPs, coreClient, logger).NewDNSServer(reconnSSHClient)
+	if err != nil {
+		return err
+	}
+
+	dnsErrCh := make(chan error, 1)
+	dnsStartedCh := make(chan struct{})
+
+	go func() { dnsErrCh <- dnsServer.Serve(dnsStartedCh) }()
+
+	select {
+	case <-dnsStartedCh:
+	case err := <-dnsErrCh:
+		return fmt.Errorf("Starting DNS server: %s", err)
+	}
+
+	defer dnsServer.Shutdown()
+
+	resolver := ctlnet.NewDNSServerHostResolver(dnsServer.UDPAddr())
+	socksProxy := ctlnet.NewSOCKSProxy(o.SOCKSAddr, resolver, reconnSSHClient, logger)
+	httpProxy := ctlnet.NewHTTPConnectProxy(o.HTTPAddr, resolver, reconnSSHClient, logger)
+
+	return serveProxies([]proxyServer{socksProxy, httpProxy}, dnsErrCh, func() {
+		o.ui.PrintLinef("export ALL_PROXY=socks5h://%s", socksProxy.Addr())
+		o.ui.PrintLinef("export HTTPS_PROXY=http://%s", httpProxy.Addr())
+
+		logger.Info(logTag, "Ready!")
+
+		o.cancelSignals.Watch(func() {
+			logger.Info(logTag, "Shutting down")
+			socksProxy.Shutdown()
+			httpProxy.Shutdown()
+		})
+	})
+}
+
+type proxyServer interface {
+	Serve(startedCh chan struct{}) error
+	Shutdown() error
+}
+
+// serveProxies returns once any of the proxies or DNS server exits.
+// All proxies are shut down (and have exited) before it returns so that
+// none of them is left listening when another one fails.
+func serveProxies(proxies []proxyServer, dnsErrCh chan error, readyFunc func()) error {
+	startedCh := make(chan struct{}, len(proxies))
+	exitCh := make(chan error, len(proxies))
+
+	for _, proxy := range proxies {
+		go func(proxy proxyServer) { exitCh <- proxy.Serve(startedCh) }(proxy)
+	}
+
+	var err error
+	var failed bool
+
+	running := len(proxies)
+
+	// Wait for every proxy to either start or fail since
+	// proxies cannot be shut down before they start listening
+	for started := 0; started < running; {
+		select {
+		case <-startedCh:
+			started++
+		case exitErr := <-exitCh:
+			running--
+			failed = true
+			if err == nil {
+				err = exitErr
+			}
+		}
+	}
+
+	if !failed {
+		readyFunc()
+
+		select {
+		case err = <-exitCh:
+			running--
+		case err = <-dnsErrCh:
+			if err == nil {
+				err = fmt.Errorf("DNS server stopped unexpectedly")
+			}
+		}
+	}
+
+	for _, proxy := range proxies {
+		proxy.Shutdown()
+	}
+
+	for ; running > 0; running-- {
+		exitErr := <-exitCh
+		if err == nil {
+			err = exitErr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,83 @@
+package net
+
+import (
+	cmdcore "github.com/carvel-dev/kwt/pkg/kwt/cmd/core"
+	ctlnet "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
+	"github.com/cppforlife/go-cli-ui/ui"
+	"github.com/spf13/cobra"
+)
+
+type RotateKeysOptions struct {
+	depsFactory   cmdcore.DepsFactory
+	configFactory cmdcore.ConfigFactory
+	ui            ui.UI
+
+	NamespaceFlags NamespaceFlags
+	NetOwnerFlags  NetOwnerFlags
+	LoggingFlags   LoggingFlags
+
+	KeyType string
+}
+
+func NewRotateKeysOptions(
+	depsFactory cmdcore.DepsFactory,
+	configFactory cmdcore.ConfigFactory,
+	ui ui.UI,
+) *RotateKeysOptions {
+	return &RotateKeysOptions{depsFactory: depsFactory, configFactory: configFactory, ui: ui}
+}
+
+func NewRotateKeysCmd(o *RotateKeysOptions, flagsFactory cmdcore.FlagsFactory) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rotate-keys",
+		Short: "Regenerate SSH keys of networking pod and restart it",
+		Example: `
+  # Regenerate client and host keys and restart networking pod (running 'kwt net start' reconnects with new keys)
+  kwt net rotate-keys
+
+  # Switch to RSA keys (uses rsa-sha2-256/512 signatures)
+  kwt net rotate-keys --ssh-key-type rsa
+`,
+		RunE: func(_ *cobra.Command, _ []string) error { return o.Run() },
+	}
+	o.NamespaceFlags.Set(cmd)
+	o.NetOwnerFlags.Set(cmd)
+	o.LoggingFlags.Set(cmd)
+	cmd.Flags().StringVar(&o.KeyType, "ssh-key-type", string(dstconn.SSHKeyTypeED25519), "Type of generated client and host keys (ed25519, ecdsa, rsa)")
+	return cmd
+}
+
+func (o *RotateKeysOptions) Run() error {
+	keyType, err := dstconn.NewSSHKeyType(o.KeyType)
+	if err != nil {
+		return err
+	}
+
+	netOwner, err := o.NetOwnerFlags.Owner()
+	if err != nil {
+		return err
+	}
+
+	coreClient, err := o.depsFactory.CoreClient()
+	if err != nil {
+		return err
+	}
+
+	restConfig, err := o.configFactory.RESTConfig()
+	if err != nil {
+		return err
+	}
+
+	logger := cmdcore.NewLoggerWithDebug(o.ui, o.LoggingFlags.Debug)
+
+	err = ctlnet.NewKubeEntryPoint(coreClient, restConfig, o.NamespaceFlags.Name, "", "", logger).
+		WithOwner(netOwner).WithSSHKeyType(keyType).RotateKeys()
+	if err != nil {
+		return err
+	}
+
+	o.ui.PrintLinef("Rotated SSH keys in namespace '%s'", o.NamespaceFlags.Name)
+
+	return nil
+}
@@ -1,6 +1,11 @@
 package net
 
 import (
+	"fmt"
+	"time"
+
+	ctlnet "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
 	"github.com/spf13/cobra"
 )
 
@@ -9,7 +14,18 @@ type SSHFlags struct {
 	Host       string
 	PrivateKey string
 
-	Image string
+	Image     string
+	Transport string
+	KeyType   string
+
+	EphemeralKeys bool
+
+	KeepAliveInterval    time.Duration
+	KeepAliveTimeout     time.Duration
+	KeepAliveMaxFailures int
+	ReconnectBackoffMin  time.Duration
+	ReconnectBackoffMax  time.Duration
+	ReconnectJitter      float64
 }
 
 func (s *SSHFlags) Set(cmd *cobra.Command) {
@@ -18,4 +34,51 @@ func (s *SSHFlags) Set(cmd *cobra.Command) {
 	cmd.Flags().StringVar(&s.PrivateKey, "ssh-private-key", "", "Private key for connecting to SSH server (PEM format)")
 
 	cmd.Flags().StringVar(&s.Image, "ssh-image", defaultSSHImage, "Image URL to use for starting OpenSSH on K8s")
+	cmd.Flags().StringVar(&s.Transport, "transport", "", "Transport for reaching OpenSSH on K8s (portforward, apiproxy) "+
+		"(if not specified, portforward is used unless RBAC forbids it)")
+	cmd.Flags().StringVar(&s.KeyType, "ssh-key-type", string(dstconn.SSHKeyTypeED25519), "Type of newly generated client and host keys for OpenSSH on K8s (ed25519, ecdsa, rsa)")
+	cmd.Flags().BoolVar(&s.EphemeralKeys, "ssh-ephemeral-keys", false, "Generate SSH keys in memory for this session instead of storing them in secrets (keys are injected into net pod via exec)")
+
+	defaults := ctlnet.DefaultSSHHealthOpts()
+
+	cmd.Flags().DurationVar(&s.KeepAliveInterval, "ssh-keepalive-interval", defaults.KeepAlive.Interval, "Interval between SSH keepalives")
+	cmd.Flags().DurationVar(&s.KeepAliveTimeout, "ssh-keepalive-timeout", defaults.KeepAlive.Timeout, "Time to wait for SSH keepalive reply before considering it failed")
+	cmd.Flags().IntVar(&s.KeepAliveMaxFailures, "ssh-keepalive-max-failures", defaults.MaxKeepAliveFailures, "Number of consecutive failed SSH keepalives before reconnecting in the background")
+	cmd.Flags().DurationVar(&s.ReconnectBackoffMin, "ssh-reconnect-backoff-min", defaults.Backoff.Initial, "Delay before retrying failed SSH reconnect (doubles with each attempt)")
+	cmd.Flags().DurationVar(&s.ReconnectBackoffMax, "ssh-reconnect-backoff-max", defaults.Backoff.Max, "Maximum delay between SSH reconnect attempts")
+	cmd.Flags().Float64Var(&s.ReconnectJitter, "ssh-reconnect-jitter", defaults.Backoff.Jitter, "Fraction of SSH reconnect delay to randomly add or subtract")
+}
+
+func (s *SSHFlags) HealthOpts() ctlnet.SSHHealthOpts {
+	opts := ctlnet.DefaultSSHHealthOpts()
+
+	opts.KeepAlive.Interval = s.KeepAliveInterval
+	opts.KeepAlive.Timeout = s.KeepAliveTimeout
+	opts.MaxKeepAliveFailures = s.KeepAliveMaxFailures
+	opts.Backoff.Initial = s.ReconnectBackoffMin
+	opts.Backoff.Max = s.ReconnectBackoffMax
+	opts.Backoff.Jitter = s.ReconnectJitter
+
+	return opts
+}
+
+func (s *SSHFlags) Validate() error {
+	if s.KeepAliveInterval <= 0 || s.KeepAliveTimeout <= 0 {
+		return fmt.Errorf("Expected --ssh-keepalive-interval and --ssh-keepalive-timeout to be positive")
+	}
+	if s.KeepAliveMaxFailures < 1 {
+		return fmt.Errorf("Expected --ssh-keepalive-max-failures to be at least 1")
+	}
+	if s.ReconnectBackoffMin <= 0 || s.ReconnectBackoffMax < s.ReconnectBackoffMin {
+		return fmt.Errorf("Expected --ssh-reconnect-backoff-min to be positive and not greater than --ssh-reconnect-backoff-max")
+	}
+	if s.ReconnectJitter < 0 || s.ReconnectJitter > 1 {
+		return fmt.Errorf("Expected --ssh-reconnect-jitter to be between 0 and 1")
+	}
+	_, err := s.SSHKeyType()
+	return err
+}
+
+func (s *SSHFlags) SSHKeyType() (dstconn.SSHKeyType, error) {
+	return dstconn.NewSSHKeyType(s.KeyType)
 }
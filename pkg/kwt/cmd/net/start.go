@@ -2,16 +2,25 @@ package net
 
 import (
 	"fmt"
+	"net"
 	"syscall"
+	"time"
 
 	cmdcore "github.com/carvel-dev/kwt/pkg/kwt/cmd/core"
 	ctldns "github.com/carvel-dev/kwt/pkg/kwt/dns"
+	ctlkubedns "github.com/carvel-dev/kwt/pkg/kwt/kubedns"
+	"github.com/carvel-dev/kwt/pkg/kwt/metrics"
 	ctlnet "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/accesslog"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/capture"
 	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/fault"
 	"github.com/carvel-dev/kwt/pkg/kwt/net/forwarder"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/kubeips"
 	"github.com/carvel-dev/kwt/pkg/kwt/setgid"
 	"github.com/cppforlife/go-cli-ui/ui"
 	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
 )
 
 type StartOptions struct {
@@ -24,9 +33,27 @@ type StartOptions struct {
 	DNSFlags       DNSFlags
 	LoggingFlags   LoggingFlags
 	SSHFlags       SSHFlags
+	NetPodFlags    NetPodFlags
+	NetOwnerFlags  NetOwnerFlags
+	ForwarderFlags ForwarderFlags
+	CtlFlags       CtlFlags
 
-	Subnets   []string
-	RemoteIPs []string
+	Contexts        []string
+	Subnets         []string
+	ExcludedSubnets []string
+	RemoteIPs       []string
+	Precise         bool
+	Namespaces      []string
+	MetricsAddr     string
+	DrainTimeout    time.Duration
+	SSHPoolSize     int
+	Loopback        bool
+	LoopbackHosts   bool
+	CaptureDir      string
+	CaptureFilters  []string
+	FaultRules      []string
+	AccessLogPath   string
+	MaxKeyAge       time.Duration
 }
 
 func NewStartOptions(
@@ -55,11 +82,41 @@ func NewStartCmd(o *StartOptions, flagsFactory cmdcore.FlagsFactory) *cobra.Comm
   # Provide predefined set of subnets to proxy
   sudo -E kwt net start --subnet 10.19.247.0/24 --subnet 10.19.248.0/24
 
+  # Do not forward office network even though it's within guessed subnets
+  sudo -E kwt net start --exclude-subnet 10.20.5.0/24
+
+  # Connect to two clusters at once (services are also resolvable via 'svc.ns.svc.staging.local')
+  sudo -E kwt net start --context staging --context dev
+
   # Redirect all example.com and its subdomains to localhost
   sudo -E kwt net start --dns-map example.com=127.0.0.1
 
   # Dynamically configure DNS mappings
   sudo -E kwt net start --dns-map-exec='knctl dns-map'
+
+  # Forward only exact pod and service IPs from two namespaces
+  sudo -E kwt net start --precise --only-namespace app1 --only-namespace app2
+
+  # Give each service in 'app1' namespace its own 127.x.y.z address without changing firewall
+  sudo -E kwt net start --loopback --only-namespace app1 --loopback-hosts
+
+  # Spread proxied connections across 4 SSH connections
+  sudo -E kwt net start --ssh-pool-size 4
+
+  # Record connections to service 'app1/redis' into pcapng files (see 'kwt net capture ls')
+  sudo -E kwt net start --capture-dir ./captures --capture-filter svc/app1/redis
+
+  # Slow down connections to redis (rules can be changed later via 'kwt net ctl set-faults')
+  sudo -E kwt net start --inject 'dst=redis-master.default:6379 latency=200ms jitter=50ms'
+
+  # Log each proxied connection with source process and destination service or pod as JSON lines
+  sudo -E kwt net start --access-log ./access.jsonl
+
+  # Rotate SSH keys (and restart net pod) if they are older than 30 days
+  sudo -E kwt net start --ssh-max-key-age 720h
+
+  # Expose Prometheus metrics on http://localhost:9090/metrics
+  sudo -E kwt net start --metrics-addr localhost:9090
 `,
 		RunE: func(_ *cobra.Command, _ []string) error { return o.Run() },
 	}
@@ -68,9 +125,27 @@ func NewStartCmd(o *StartOptions, flagsFactory cmdcore.FlagsFactory) *cobra.Comm
 	o.DNSFlags.SetWithPrefix(cmd, "dns")
 	o.LoggingFlags.Set(cmd)
 	o.SSHFlags.Set(cmd)
+	o.NetPodFlags.Set(cmd)
+	o.NetOwnerFlags.Set(cmd)
+	o.ForwarderFlags.Set(cmd)
+	o.CtlFlags.Set(cmd)
 
+	cmd.Flags().StringSliceVar(&o.Contexts, "context", nil, "Kubeconfig context to connect to, overrides --kubeconfig-context (can be specified multiple times)")
 	cmd.Flags().StringSliceVarP(&o.Subnets, "subnet", "s", nil, "Subnet, if specified subnets will not be guessed automatically (can be specified multiple times)")
+	cmd.Flags().StringSliceVar(&o.ExcludedSubnets, "exclude-subnet", nil, "Subnet to never forward, even if within forwarded subnets (can be specified multiple times)")
 	cmd.Flags().StringSliceVar(&o.RemoteIPs, "remote-ip", nil, "Additional IP to include for subnet guessing (can be specified multiple times)")
+	cmd.Flags().BoolVar(&o.Precise, "precise", false, "Forward exact pod and service IPs instead of guessed subnets")
+	cmd.Flags().StringSliceVar(&o.Namespaces, "only-namespace", nil, "Namespace to forward pod and service IPs from in precise or loopback mode (can be specified multiple times)")
+	cmd.Flags().BoolVar(&o.Loopback, "loopback", false, "Expose services on their own loopback addresses instead of changing firewall (services from --namespace if --only-namespace is not specified)")
+	cmd.Flags().BoolVar(&o.LoopbackHosts, "loopback-hosts", true, "Add loopback service names to /etc/hosts in loopback mode (system DNS resolution is not redirected to kwt DNS server in loopback mode)")
+	cmd.Flags().IntVar(&o.SSHPoolSize, "ssh-pool-size", 1, "Number of parallel SSH connections to spread proxied connections across")
+	cmd.Flags().DurationVar(&o.DrainTimeout, "drain-timeout", 10*time.Second, "Time to wait for proxied connections to finish on shutdown (Ctrl-C again to skip)")
+	cmd.Flags().StringVar(&o.CaptureDir, "capture-dir", "", "Directory to record proxied TCP connections into as pcapng files")
+	cmd.Flags().StringSliceVar(&o.CaptureFilters, "capture-filter", nil, "Capture only connections to 'svc/ns/name', 'pod/ns/name', IP or subnet (can be specified multiple times)")
+	cmd.Flags().StringArrayVar(&o.FaultRules, "inject", nil, "Fault rule such as 'dst=svc.ns:port latency=200ms jitter=50ms bandwidth=1mbit reset-after=5s refuse=10%' (can be specified multiple times; first matching rule applies)")
+	cmd.Flags().StringVar(&o.AccessLogPath, "access-log", "", "File to append JSON line per finished TCP connection to (includes source process and destination service or pod)")
+	cmd.Flags().DurationVar(&o.MaxKeyAge, "ssh-max-key-age", 0, "Rotate SSH keys of OpenSSH on K8s (and restart net pod) if they are older than given duration (example: '720h')")
+	cmd.Flags().StringVar(&o.MetricsAddr, "metrics-addr", "", "Address to serve Prometheus metrics on (example: 'localhost:9090')")
 
 	return cmd
 }
@@ -80,24 +155,368 @@ func (o *StartOptions) Run() error {
 		return fmt.Errorf("Command must run under sudo to change firewall settings (sudo -E kwt net start ...)")
 	}
 
+	if len(o.Namespaces) > 0 && !o.Precise && !o.Loopback {
+		return fmt.Errorf("Expected --only-namespace to be used together with --precise or --loopback")
+	}
+
+	if o.Loopback {
+		if o.Precise || len(o.Subnets) > 0 || len(o.ExcludedSubnets) > 0 {
+			return fmt.Errorf("Expected --loopback to not be used together with --precise, --subnet or --exclude-subnet")
+		}
+		if len(o.Contexts) > 1 {
+			return fmt.Errorf("Expected --loopback to not be used together with multiple --context")
+		}
+	}
+
+	if len(o.Subnets) > 0 && o.Precise {
+		return fmt.Errorf("Expected --subnet to not be used together with --precise")
+	}
+
+	if len(o.Contexts) > 1 {
+		if len(o.Subnets) > 0 {
+			return fmt.Errorf("Expected --subnet to not be used together with multiple --context")
+		}
+		if len(o.SSHFlags.PrivateKey) > 0 {
+			return fmt.Errorf("Expected --ssh-private-key to not be used together with multiple --context")
+		}
+	}
+
+	if len(o.CaptureFilters) > 0 && len(o.CaptureDir) == 0 {
+		return fmt.Errorf("Expected --capture-filter to be used together with --capture-dir")
+	}
+
+	err := o.SSHFlags.Validate()
+	if err != nil {
+		return err
+	}
+
+	faultRules, err := fault.NewRules(o.FaultRules)
+	if err != nil {
+		return err
+	}
+
+	logger := cmdcore.NewLoggerWithDebug(o.ui, o.LoggingFlags.Debug)
+	logTag := "StartOptions"
+
+	if o.Loopback {
+		return o.runLoopback(faultRules, logger)
+	}
+
 	gidInt, err := setgid.GidExec{}.SetProcessGID()
 	if err != nil {
 		return fmt.Errorf("Changing group id: %s", err)
 	}
 
-	coreClient, err := o.depsFactory.CoreClient()
+	excludedSubnets, err := ctlnet.NewConfiguredSubnets(o.ExcludedSubnets).Subnets()
+	if err != nil {
+		return fmt.Errorf("Parsing excluded subnets: %s", err)
+	}
+
+	if len(o.Subnets) > 0 {
+		err := o.warnAboutExcludedSubnets(ctlnet.NewConfiguredSubnets(o.Subnets), excludedSubnets, logger)
+		if err != nil {
+			return err
+		}
+	}
+
+	contexts := o.Contexts
+	if len(contexts) == 0 {
+		contexts = []string{""} // context from kubeconfig flags
+	}
+
+	var remotes []ctlnet.Remote
+	var coreClient kubernetes.Interface // of the first context
+	clusterDomains := map[string]kubernetes.Interface{}
+
+	for _, context := range contexts {
+		configFactory := o.configFactory
+		depsFactory := o.depsFactory
+
+		if len(context) > 0 {
+			configFactory = o.configFactory.ForContext(context)
+			depsFactory = cmdcore.NewDepsFactoryImpl(configFactory)
+		}
+
+		remote, remoteCoreClient, err := o.buildRemote(context, configFactory, depsFactory, excludedSubnets, logger)
+		if err != nil {
+			return err
+		}
+
+		remotes = append(remotes, remote)
+
+		if coreClient == nil {
+			coreClient = remoteCoreClient
+		}
+
+		if len(o.Contexts) > 1 {
+			domain, err := ctlkubedns.ContextClusterDomain(context)
+			if err != nil {
+				return err
+			}
+
+			if _, found := clusterDomains[domain]; found {
+				return fmt.Errorf("Expected contexts to have unique cluster domains, but '%s' is used more than once", domain)
+			}
+
+			clusterDomains[domain] = remoteCoreClient
+
+			logger.Info(logTag, "Resolving context '%s' services via 'svc-name.ns-name.svc.%s'", context, domain)
+		}
+	}
+
+	dnsIPs := ResolvConfDNSIPs{ctldns.NewResolvConf()}
+	dnsServerFactory := NewDNSServerFactory(o.DNSFlags, dnsIPs, coreClient, logger).WithClusterDomains(clusterDomains)
+	forwarderFactory := forwarder.NewFactory(forwarder.FactoryOpts{
+		ProcessGroupID:  gidInt,
+		Type:            o.ForwarderFlags.Type,
+		UseIPSets:       o.Precise,
+		ExcludedSubnets: excludedSubnets,
+	}, logger)
+	// Fault injection and capture share resolved service IPs
+	kubeIPs := kubeips.NewResolver(coreClient)
+
+	capturer, err := o.buildCapturer(kubeIPs, logger)
 	if err != nil {
 		return err
 	}
 
-	restConfig, err := o.configFactory.RESTConfig()
+	injector := fault.NewInjector(faultRules, ctlnet.NewKubeServiceHostResolver(kubeIPs), logger)
+
+	objectIndex := o.buildObjectIndex(coreClient, true, logger)
+
+	if objectIndex != nil {
+		defer objectIndex.Stop()
+	}
+
+	accessLog, err := o.buildAccessLog(objectIndex, logger)
 	if err != nil {
 		return err
 	}
 
-	logger := cmdcore.NewLoggerWithDebug(o.ui, o.LoggingFlags.Debug)
+	if accessLog != nil {
+		defer accessLog.Close()
+	}
+
+	forwardingProxy := ctlnet.NewForwardingProxy(forwarderFactory, dnsServerFactory, o.DrainTimeout, logger).
+		WithCapturer(capturer).WithInjector(injector).WithAccessLog(accessLog).
+		WithMetricsDestinations(o.buildMetricsDestinations(objectIndex))
+	remotingProxy := ctlnet.NewRemotingProxy(remotes, dnsIPs, forwardingProxy, logger)
+
+	if len(o.CtlFlags.SocketPath) > 0 {
+		ownerUID, ownerGID := o.CtlFlags.SudoOwner()
+
+		controlServer := ctlnet.NewControlServer(ctlnet.ControlServerOpts{
+			SocketPath: o.CtlFlags.SocketPath,
+			OwnerUID:   ownerUID,
+			OwnerGID:   ownerGID,
+
+			DNS:     dnsServerFactory,
+			Subnets: remotingProxy,
+			Conns:   forwardingProxy,
+			Logger:  logger,
+			Faults:  injector,
+			Status: ctlnet.NewSessionStatusReporter(ctlnet.SessionStatusReporterOpts{
+				Command:    "start",
+				Remotes:    remotingProxy,
+				Forwarding: forwardingProxy,
+				DNS:        dnsServerFactory,
+				Conns:      forwardingProxy,
+			}),
+		}, logger)
+
+		err := controlServer.Start()
+		if err != nil {
+			return err
+		}
+
+		defer controlServer.Shutdown()
+	}
+
+	if len(o.MetricsAddr) > 0 {
+		metricsServer := metrics.NewServer(o.MetricsAddr, metrics.Default, logger)
+
+		err := metricsServer.Start()
+		if err != nil {
+			return err
+		}
+
+		defer metricsServer.Shutdown()
+	}
+
+	stopWatchingSignals := o.cancelSignals.WatchTwice(func() {
+		logger.Info(logTag, "Shutting down")
+
+		err := remotingProxy.Shutdown()
+		if err != nil {
+			logger.Error(logTag, "Failed shutting proxy: %s", err)
+		}
+	}, func() {
+		logger.Info(logTag, "Forcing shut down")
+
+		err := remotingProxy.ForceShutdown()
+		if err != nil {
+			logger.Error(logTag, "Failed forcing proxy shut down: %s", err)
+		}
+	})
+
+	// Serve returns only after forwarder is reset
+	defer stopWatchingSignals()
+
+	return remotingProxy.Serve()
+}
+
+func (o *StartOptions) runLoopback(faultRules []fault.Rule, logger cmdcore.Logger) error {
 	logTag := "StartOptions"
 
+	configFactory := o.configFactory
+	depsFactory := o.depsFactory
+
+	if len(o.Contexts) > 0 {
+		configFactory = o.configFactory.ForContext(o.Contexts[0])
+		depsFactory = cmdcore.NewDepsFactoryImpl(configFactory)
+	}
+
+	remote, coreClient, err := o.buildRemote("", configFactory, depsFactory, nil, logger)
+	if err != nil {
+		return err
+	}
+
+	reconnSSHClient := ctlnet.NewReconnSSHClientPool(remote.EntryPoint, o.SSHPoolSize, logger).
+		WithHealthOpts(o.SSHFlags.HealthOpts())
+
+	err = reconnSSHClient.Connect()
+	if err != nil {
+		return err
+	}
+
+	defer reconnSSHClient.Disconnect()
+
+	namespaces := o.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{o.NamespaceFlags.Name}
+	}
+
+	opts := ctlnet.LoopbackProxyOpts{
+		Namespaces:    namespaces,
+		ClusterDomain: ctlkubedns.DefaultClusterDomain,
+	}
+
+	if o.LoopbackHosts {
+		opts.EtcHostsPath = "/etc/hosts"
+	}
+
+	kubeIPs := kubeips.NewResolver(coreClient)
+
+	opts.Capturer, err = o.buildCapturer(kubeIPs, logger)
+	if err != nil {
+		return err
+	}
+
+	opts.Injector = fault.NewInjector(faultRules, ctlnet.NewKubeServiceHostResolver(kubeIPs), logger)
+
+	// Metrics are not served in loopback mode
+	objectIndex := o.buildObjectIndex(coreClient, false, logger)
+
+	if objectIndex != nil {
+		defer objectIndex.Stop()
+	}
+
+	opts.AccessLog, err = o.buildAccessLog(objectIndex, logger)
+	if err != nil {
+		return err
+	}
+
+	if opts.AccessLog != nil {
+		defer opts.AccessLog.Close()
+	}
+
+	loopbackProxy := ctlnet.NewLoopbackProxy(coreClient, opts, logger)
+
+	dnsIPs := ResolvConfDNSIPs{ctldns.NewResolvConf()}
+	dnsServerFactory := NewDNSServerFactory(o.DNSFlags, dnsIPs, coreClient, logger).WithServiceIPs(loopbackProxy)
+
+	dnsServer, err := dnsServerFactory.NewDNSServer(reconnSSHClient)
+	if err != nil {
+		return err
+	}
+
+	dnsServerErrCh := make(chan error, 1)
+	dnsServerStartedCh := make(chan struct{})
+
+	go func() { dnsServerErrCh <- dnsServer.Serve(dnsServerStartedCh) }()
+
+	select {
+	case <-dnsServerStartedCh:
+	case err := <-dnsServerErrCh:
+		return fmt.Errorf("Starting DNS server: %s", err)
+	}
+
+	defer dnsServer.Shutdown()
+
+	if len(o.CtlFlags.SocketPath) > 0 {
+		ownerUID, ownerGID := o.CtlFlags.SudoOwner()
+
+		controlServer := ctlnet.NewControlServer(ctlnet.ControlServerOpts{
+			SocketPath: o.CtlFlags.SocketPath,
+			OwnerUID:   ownerUID,
+			OwnerGID:   ownerGID,
+
+			DNS:    dnsServerFactory,
+			Logger: logger,
+			Faults: opts.Injector,
+			Status: ctlnet.NewSessionStatusReporter(ctlnet.SessionStatusReporterOpts{
+				Command: "start --loopback",
+				Remotes: ctlnet.SingleRemoteStatuses{
+					Context:    remote.Context,
+					EntryPoint: remote.EntryPoint,
+					SSHClient:  reconnSSHClient,
+				},
+				DNS: dnsServerFactory,
+			}),
+		}, logger)
+
+		err := controlServer.Start()
+		if err != nil {
+			return err
+		}
+
+		defer controlServer.Shutdown()
+	}
+
+	// System DNS resolution is not redirected since that requires firewall changes
+	logger.Info(logTag, "Answering service names with loopback addresses via DNS server on %s", dnsServer.UDPAddr())
+
+	if !o.LoopbackHosts {
+		logger.Error(logTag, "Service names are only resolved by querying DNS server on %s directly "+
+			"since /etc/hosts is not managed (--loopback-hosts=false)", dnsServer.UDPAddr())
+	}
+
+	o.cancelSignals.Watch(func() {
+		logger.Info(logTag, "Shutting down")
+
+		err := loopbackProxy.Shutdown()
+		if err != nil {
+			logger.Error(logTag, "Failed shutting proxy: %s", err)
+		}
+	})
+
+	return loopbackProxy.Serve(reconnSSHClient)
+}
+
+func (o *StartOptions) buildRemote(context string, configFactory cmdcore.ConfigFactory,
+	depsFactory cmdcore.DepsFactory, excludedSubnets []net.IPNet,
+	logger cmdcore.Logger) (ctlnet.Remote, kubernetes.Interface, error) {
+
+	coreClient, err := depsFactory.CoreClient()
+	if err != nil {
+		return ctlnet.Remote{}, nil, err
+	}
+
+	restConfig, err := configFactory.RESTConfig()
+	if err != nil {
+		return ctlnet.Remote{}, nil, err
+	}
+
 	var entryPoint ctlnet.EntryPoint
 
 	if len(o.SSHFlags.PrivateKey) > 0 {
@@ -107,8 +526,40 @@ func (o *StartOptions) Run() error {
 			PrivateKeyPEM: o.SSHFlags.PrivateKey,
 		})
 	} else {
-		entryPoint = ctlnet.NewKubeEntryPoint(
-			coreClient, restConfig, o.NamespaceFlags.Name, o.SSHFlags.Image, logger)
+		transport, err := ctlnet.SelectKubeTransport(coreClient, o.NamespaceFlags.Name, o.SSHFlags.Transport, logger)
+		if err != nil {
+			return ctlnet.Remote{}, nil, err
+		}
+
+		netPodOpts, err := o.NetPodFlags.NetPodOpts()
+		if err != nil {
+			return ctlnet.Remote{}, nil, err
+		}
+
+		netOwner, err := o.NetOwnerFlags.Owner()
+		if err != nil {
+			return ctlnet.Remote{}, nil, err
+		}
+
+		keyType, err := o.SSHFlags.SSHKeyType()
+		if err != nil {
+			return ctlnet.Remote{}, nil, err
+		}
+
+		kubeEntryPoint := ctlnet.NewKubeEntryPoint(
+			coreClient, restConfig, o.NamespaceFlags.Name, o.SSHFlags.Image, transport, logger).
+			WithNetPodOpts(netPodOpts).WithOwner(netOwner).WithSSHKeyType(keyType)
+
+		if o.SSHFlags.EphemeralKeys {
+			kubeEntryPoint = kubeEntryPoint.WithEphemeralKeys()
+		} else if o.MaxKeyAge > 0 {
+			_, err := kubeEntryPoint.RotateKeysIfOlder(o.MaxKeyAge)
+			if err != nil {
+				return ctlnet.Remote{}, nil, err
+			}
+		}
+
+		entryPoint = kubeEntryPoint
 	}
 
 	var subnets ctlnet.Subnets
@@ -116,23 +567,113 @@ func (o *StartOptions) Run() error {
 	if len(o.Subnets) > 0 {
 		subnets = ctlnet.NewConfiguredSubnets(o.Subnets)
 	} else {
-		subnets = ctlnet.NewKubeSubnets(coreClient, o.RemoteIPs, logger)
+		dynamicClient, err := depsFactory.DynamicClient()
+		if err != nil {
+			return ctlnet.Remote{}, nil, err
+		}
+
+		subnets = ctlnet.NewKubeSubnets(dynamicClient, ctlnet.KubeSubnetsOpts{
+			AdditionalRemoteIPs: o.RemoteIPs,
+			ExcludedSubnets:     excludedSubnets,
+			Precise:             o.Precise,
+			Namespaces:          o.Namespaces,
+		}, logger)
 	}
 
-	dnsIPs := ResolvConfDNSIPs{ctldns.NewResolvConf()}
-	dnsServerFactory := NewDNSServerFactory(o.DNSFlags, dnsIPs, coreClient, logger)
-	forwarderFactory := forwarder.NewFactory(gidInt, logger)
-	forwardingProxy := ctlnet.NewForwardingProxy(forwarderFactory, dnsServerFactory, logger)
-	remotingProxy := ctlnet.NewRemotingProxy(entryPoint, subnets, dnsIPs, forwardingProxy, logger)
+	currentContext, err := configFactory.CurrentContext()
+	if err != nil {
+		return ctlnet.Remote{}, nil, err
+	}
 
-	o.cancelSignals.Watch(func() {
-		logger.Info(logTag, "Shutting down")
+	remote := ctlnet.Remote{
+		Name:        context,
+		Context:     currentContext,
+		EntryPoint:  entryPoint,
+		Subnets:     subnets,
+		SSHPoolSize: o.SSHPoolSize,
+		SSHHealth:   o.SSHFlags.HealthOpts(),
+	}
 
-		err := remotingProxy.Shutdown()
-		if err != nil {
-			logger.Error(logTag, "Failed shutting proxy: %s", err)
+	return remote, coreClient, nil
+}
+
+func (o *StartOptions) buildCapturer(kubeIPs *kubeips.Resolver, logger cmdcore.Logger) (*capture.Capturer, error) {
+	if len(o.CaptureDir) == 0 {
+		return nil, nil
+	}
+
+	filters, err := capture.NewFilters(o.CaptureFilters)
+	if err != nil {
+		return nil, err
+	}
+
+	ownerUID, ownerGID := o.CtlFlags.SudoOwner()
+
+	opts := capture.CapturerOpts{
+		Dir:      o.CaptureDir,
+		Filters:  filters,
+		OwnerUID: ownerUID,
+		OwnerGID: ownerGID,
+	}
+
+	return capture.NewCapturer(opts, capture.NewKubeTargetResolver(kubeIPs), logger)
+}
+
+// buildObjectIndex returns index only if access log or metrics attribute connections to Kubernetes objects
+func (o *StartOptions) buildObjectIndex(coreClient kubernetes.Interface,
+	withMetrics bool, logger cmdcore.Logger) *accesslog.KubeObjectIndex {
+
+	if len(o.AccessLogPath) == 0 && (!withMetrics || len(o.MetricsAddr) == 0) {
+		return nil
+	}
+
+	objectIndex := accesslog.NewKubeObjectIndex(coreClient, o.Namespaces, logger)
+	objectIndex.Start()
+
+	return objectIndex
+}
+
+func (o *StartOptions) buildMetricsDestinations(objectIndex *accesslog.KubeObjectIndex) *ctlnet.MetricsDestinations {
+	if len(o.MetricsAddr) == 0 {
+		return nil
+	}
+
+	// Keeps number of series bounded even if there are many services
+	return ctlnet.NewMetricsDestinations(objectIndex, 100)
+}
+
+func (o *StartOptions) buildAccessLog(objectIndex *accesslog.KubeObjectIndex, logger cmdcore.Logger) (*accesslog.Log, error) {
+	if len(o.AccessLogPath) == 0 {
+		return nil, nil
+	}
+
+	ownerUID, ownerGID := o.CtlFlags.SudoOwner()
+
+	opts := accesslog.LogOpts{
+		Path:     o.AccessLogPath,
+		OwnerUID: ownerUID,
+		OwnerGID: ownerGID,
+	}
+
+	return accesslog.NewLog(opts, objectIndex, accesslog.NewProcessResolver(), logger)
+}
+
+func (o *StartOptions) warnAboutExcludedSubnets(subnets ctlnet.Subnets,
+	excludedSubnets []net.IPNet, logger cmdcore.Logger) error {
+
+	configuredSubnets, err := subnets.Subnets()
+	if err != nil {
+		return err
+	}
+
+	for _, subnet := range configuredSubnets {
+		for _, excludedSubnet := range excludedSubnets {
+			if ctlnet.SubnetsOverlap(subnet, excludedSubnet) {
+				logger.Info("StartOptions", "Warning: Subnet %s overlaps excluded subnet %s, "+
+					"excluded subnet will not be forwarded", subnet.String(), excludedSubnet.String())
+			}
 		}
-	})
+	}
 
-	return remotingProxy.Serve()
+	return nil
 }
@@ -19,8 +19,9 @@ type StartDNSOptions struct {
 	ui            ui.UI
 	cancelSignals cmdcore.CancelSignals
 
-	DNSFlags     DNSFlags
-	LoggingFlags LoggingFlags
+	DNSFlags       DNSFlags
+	LoggingFlags   LoggingFlags
+	ForwarderFlags ForwarderFlags
 }
 
 func NewStartDNSOptions(depsFactory cmdcore.DepsFactory, ui ui.UI, cancelSignals cmdcore.CancelSignals) *StartDNSOptions {
@@ -43,6 +44,7 @@ func NewStartDNSCmd(o *StartDNSOptions, flagsFactory cmdcore.FlagsFactory) *cobr
 	}
 	o.DNSFlags.Set(cmd)
 	o.LoggingFlags.Set(cmd)
+	o.ForwarderFlags.Set(cmd)
 	return cmd
 }
 
@@ -66,7 +68,7 @@ func (o *StartDNSOptions) Run() error {
 
 	dnsIPs := ResolvConfDNSIPs{ctldns.NewResolvConf()}
 	dnsServerFactory := NewDNSServerFactory(o.DNSFlags, dnsIPs, coreClient, logger)
-	forwarderFactory := ctlfwd.NewFactory(gidInt, logger)
+	forwarderFactory := ctlfwd.NewFactory(ctlfwd.FactoryOpts{ProcessGroupID: gidInt, Type: o.ForwarderFlags.Type}, logger)
 
 	dnsServer, err := dnsServerFactory.NewDNSServer(nil)
 	if err != nil {
@@ -104,8 +106,10 @@ func (o *StartDNSOptions) Run() error {
 			return
 		}
 
+		// No subnets are forwarded, hence DNS TCP port is used
+		// to name forwarder rules uniquely per process
 		opts := ctlfwd.ForwarderOpts{
-			DstTCPPort:    1234,
+			DstTCPPort:    dnsTCPPort,
 			DstDNSTCPPort: dnsTCPPort,
 			DstDNSUDPPort: dnsUDPPort,
 		}
@@ -0,0 +1,179 @@
+package net
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	cmdcore "github.com/carvel-dev/kwt/pkg/kwt/cmd/core"
+	ctlnet "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"github.com/cppforlife/go-cli-ui/ui"
+	uitable "github.com/cppforlife/go-cli-ui/ui/table"
+	"github.com/spf13/cobra"
+)
+
+type StatusOptions struct {
+	ui ui.UI
+
+	SocketPath string
+}
+
+func NewStatusOptions(ui ui.UI) *StatusOptions {
+	return &StatusOptions{ui: ui}
+}
+
+func NewStatusCmd(o *StatusOptions, flagsFactory cmdcore.FlagsFactory) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show running 'kwt net start' and 'kwt net listen' sessions",
+		Example: `
+  # Show all sessions on this machine
+  kwt net status
+
+  # Show session started with custom control socket
+  kwt net status --ctl-socket /tmp/kwt-staging.sock
+
+  # Output status as JSON
+  kwt net status --json
+`,
+		RunE: func(_ *cobra.Command, _ []string) error { return o.Run() },
+	}
+	cmd.Flags().StringVar(&o.SocketPath, "ctl-socket", "", "Path to control socket of running session (defaults to finding all sessions)")
+	return cmd
+}
+
+func (o *StatusOptions) Run() error {
+	socketPaths := []string{o.SocketPath}
+
+	if len(o.SocketPath) == 0 {
+		var err error
+
+		socketPaths, err = ctlnet.ControlSocketPaths()
+		if err != nil {
+			return err
+		}
+	}
+
+	table := uitable.Table{
+		Content: "sessions",
+
+		Header: []uitable.Header{
+			uitable.NewHeader("Command"),
+			uitable.NewHeader("PID"),
+			uitable.NewHeader("Context"),
+			uitable.NewHeader("Namespace"),
+			uitable.NewHeader("Entry point"),
+			uitable.NewHeader("Net pod"),
+			uitable.NewHeader("Net pod node"),
+			uitable.NewHeader("Subnets"),
+			uitable.NewHeader("DNS IPs"),
+			uitable.NewHeader("DNS mappings"),
+			uitable.NewHeader("SSH"),
+			uitable.NewHeader("Uptime"),
+			uitable.NewHeader("Active conns"),
+			uitable.NewHeader("Control socket"),
+		},
+
+		Transpose: true,
+	}
+
+	for _, socketPath := range socketPaths {
+		status, err := ctlnet.NewControlClient(socketPath).Status()
+		if err != nil {
+			if len(o.SocketPath) > 0 {
+				return err
+			}
+			continue // socket left by process that is no longer running
+		}
+
+		table.Rows = append(table.Rows, o.statusRow(status, socketPath))
+	}
+
+	if len(table.Rows) == 0 {
+		o.ui.PrintLinef("No running 'kwt net start' or 'kwt net listen' found")
+		return nil
+	}
+
+	o.ui.PrintTable(table)
+
+	return nil
+}
+
+func (o *StatusOptions) statusRow(status ctlnet.SessionStatus, socketPath string) []uitable.Value {
+	var contexts, namespaces, entryPoints, netPods, netPodNodes, sshClients []string
+
+	for _, remote := range status.Remotes {
+		ep := remote.EntryPoint
+
+		contexts = append(contexts, remote.Context)
+		namespaces = append(namespaces, ep.Namespace)
+
+		if ep.NetReplicas > 0 {
+			netPods = append(netPods, fmt.Sprintf("%s (%d/%d ready)", ep.NetPod, ep.NetReadyReplicas, ep.NetReplicas))
+		} else {
+			netPods = append(netPods, ep.NetPod)
+		}
+		netPodNodes = append(netPodNodes, ep.NetPodNode)
+
+		switch {
+		case len(ep.Transport) > 0:
+			entryPoints = append(entryPoints, fmt.Sprintf("%s (%s)", ep.Type, ep.Transport))
+		case len(ep.Host) > 0:
+			entryPoints = append(entryPoints, fmt.Sprintf("%s (%s)", ep.Type, ep.Host))
+		default:
+			entryPoints = append(entryPoints, ep.Type)
+		}
+
+		for _, client := range remote.SSHClients {
+			sshClients = append(sshClients, o.sshClientDesc(client))
+		}
+	}
+
+	var dnsMappings []string
+
+	for _, mapping := range status.DNSMappings {
+		dnsMappings = append(dnsMappings, mapping.Domain+" -> "+mapping.Target)
+	}
+
+	return []uitable.Value{
+		uitable.NewValueString(status.Command),
+		uitable.NewValueInt(status.PID),
+		uitable.NewValueStrings(contexts),
+		uitable.NewValueStrings(namespaces),
+		uitable.NewValueStrings(entryPoints),
+		uitable.NewValueStrings(netPods),
+		uitable.NewValueStrings(netPodNodes),
+		uitable.NewValueStrings(status.Subnets),
+		uitable.NewValueStrings(status.DNSIPs),
+		uitable.NewValueStrings(dnsMappings),
+		uitable.NewValueStrings(sshClients),
+		uitable.NewValueString(time.Since(status.StartedAt).Round(time.Second).String()),
+		uitable.NewValueInt(status.ActiveConns),
+		uitable.NewValueString(socketPath),
+	}
+}
+
+func (o *StatusOptions) sshClientDesc(client ctlnet.SSHClientStatus) string {
+	if !client.Up {
+		if client.State == ctlnet.SSHClientStateReconnecting {
+			return "reconnecting"
+		}
+		return "down"
+	}
+
+	state := "up"
+	if len(client.State) > 0 {
+		state = strings.ToLower(client.State)
+	}
+
+	desc := []string{state, fmt.Sprintf("%d channels", client.ActiveChannels)}
+
+	switch {
+	case len(client.LastKeepAliveErr) > 0:
+		desc = append(desc, "keepalive failed: "+client.LastKeepAliveErr)
+	case len(client.LastKeepAliveRTT) > 0:
+		desc = append(desc, "keepalive rtt "+client.LastKeepAliveRTT)
+	}
+
+	return strings.Join(desc, ", ")
+}
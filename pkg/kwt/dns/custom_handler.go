@@ -41,29 +41,16 @@ func (d CustomHandler) ServeDNS(responseWriter dns.ResponseWriter, requestMsg *d
 		question := requestMsg.Question[0]
 
 		switch question.Qtype {
-		case dns.TypeA, dns.TypeANY:
-			ips, resolved, err := d.ipResolver.ResolveIPv4(question.Name)
-			if !resolved || err != nil {
-				msg.SetRcode(requestMsg, dns.RcodeServerFailure)
-			} else {
-				msg.SetRcode(requestMsg, dns.RcodeSuccess)
-
-				for _, ip := range ips {
-					msg.Answer = append(msg.Answer, &dns.A{
-						Hdr: dns.RR_Header{
-							Name:   question.Name,
-							Rrtype: dns.TypeA,
-							Class:  dns.ClassINET,
-							Ttl:    0, // OS X seems to have min TTL of 17s
-						},
-						A: ip,
-					})
-				}
-			}
+		case dns.TypeA:
+			d.answer(requestMsg, msg, question, d.ipResolver.ResolveIPv4)
 
 		case dns.TypeAAAA:
-			// TODO IPv6
-			msg.SetRcode(requestMsg, dns.RcodeSuccess)
+			d.answer(requestMsg, msg, question, d.ipResolver.ResolveIPv6)
+
+		case dns.TypeANY:
+			if d.answer(requestMsg, msg, question, d.ipResolver.ResolveIPv4) {
+				d.answer(requestMsg, msg, question, d.ipResolver.ResolveIPv6)
+			}
 
 		case dns.TypeMX:
 			msg.SetRcode(requestMsg, dns.RcodeSuccess)
@@ -83,3 +70,35 @@ func (d CustomHandler) ServeDNS(responseWriter dns.ResponseWriter, requestMsg *d
 		logger.Info(d.logTag, "Answering rcode=%d (%s)", msg.Rcode, time.Now().Sub(t1))
 	}
 }
+
+// answer appends resolved IPs as A or AAAA records (depending on IP family);
+// returns false if message was marked as failed
+func (d CustomHandler) answer(requestMsg, msg *dns.Msg, question dns.Question,
+	resolveFunc func(string) ([]net.IP, bool, error)) bool {
+
+	ips, resolved, err := resolveFunc(question.Name)
+	if !resolved || err != nil {
+		msg.SetRcode(requestMsg, dns.RcodeServerFailure)
+		return false
+	}
+
+	msg.SetRcode(requestMsg, dns.RcodeSuccess)
+
+	for _, ip := range ips {
+		hdr := dns.RR_Header{
+			Name:  question.Name,
+			Class: dns.ClassINET,
+			Ttl:   0, // OS X seems to have min TTL of 17s
+		}
+
+		if ip4 := ip.To4(); ip4 != nil {
+			hdr.Rrtype = dns.TypeA
+			msg.Answer = append(msg.Answer, &dns.A{Hdr: hdr, A: ip4})
+		} else {
+			hdr.Rrtype = dns.TypeAAAA
+			msg.Answer = append(msg.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	}
+
+	return true
+}
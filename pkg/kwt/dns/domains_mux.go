@@ -33,14 +33,19 @@ func (m *DomainsMux) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	m.mux.ServeDNS(w, r)
 }
 
-// UpdateContiniously and UpdateOnce are not thread safe
-func (m *DomainsMux) UpdateContiniously() {
+// UpdateContiniously and UpdateOnce are not thread safe.
+// Values received on updateCh trigger update right away.
+func (m *DomainsMux) UpdateContiniously(updateCh <-chan struct{}) {
 	for {
 		err := m.UpdateOnce()
 		if err != nil {
 			m.logger.Debug(m.logTag, "Failed updating DNS domain handlers: %s", err)
 		}
-		time.Sleep(30 * time.Second)
+
+		select {
+		case <-time.After(30 * time.Second):
+		case <-updateCh:
+		}
 	}
 }
 
@@ -61,7 +66,7 @@ func (m *DomainsMux) UpdateOnce() error {
 			m.logger.Info(m.logTag, "Registering %s->%s", domain, resolver)
 			changed = true
 		}
-		m.mux.Handle(domain, NewCustomHandler(resolver, m.logger))
+		m.mux.Handle(domain, NewMetricsHandler("custom", NewCustomHandler(resolver, m.logger)))
 	}
 
 	// Delete previously registered handlers that were not replaced
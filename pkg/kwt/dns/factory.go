@@ -7,11 +7,15 @@ import (
 type Factory struct{}
 
 type BuildOpts struct {
-	ListenAddrs   []string // include port
-	RecursorAddrs []string // include port
+	ListenAddrs []string // include port
+	// BestEffortListenAddrs are skipped if they cannot be listened on;
+	// port 0 reuses port picked for ListenAddrs
+	BestEffortListenAddrs []string
+	RecursorAddrs         []string // include port
 
 	DomainsMapFunc     DomainsMapFunc
 	DomainsChangedFunc DomainsChangedFunc
+	DomainsUpdateCh    <-chan struct{} // optional
 }
 
 func NewFactory() Factory { return Factory{} }
@@ -22,8 +26,8 @@ func (f Factory) Build(opts BuildOpts, logger Logger) (Server, error) {
 	arpaHandler := NewArpaHandler(forwardHandler, logger)
 
 	mux := dns.NewServeMux()
-	mux.Handle("arpa.", arpaHandler)
-	mux.Handle(".", forwardHandler)
+	mux.Handle("arpa.", NewMetricsHandler("arpa", arpaHandler))
+	mux.Handle(".", NewMetricsHandler("forward", forwardHandler))
 
 	domainsMux := NewDomainsMux(mux, opts.DomainsMapFunc, opts.DomainsChangedFunc, logger)
 
@@ -32,16 +36,23 @@ func (f Factory) Build(opts BuildOpts, logger Logger) (Server, error) {
 		return Server{}, err
 	}
 
-	go domainsMux.UpdateContiniously()
+	go domainsMux.UpdateContiniously(opts.DomainsUpdateCh)
 
+	servers := f.buildServers(opts.ListenAddrs, domainsMux)
+	bestEffortServers := f.buildServers(opts.BestEffortListenAddrs, domainsMux)
+
+	return NewServer(servers, logger).WithBestEffortServers(bestEffortServers), nil
+}
+
+func (Factory) buildServers(addrs []string, handler dns.Handler) []*dns.Server {
 	servers := []*dns.Server{}
 
-	for _, addr := range opts.ListenAddrs {
+	for _, addr := range addrs {
 		servers = append(servers,
-			&dns.Server{Addr: addr, Net: "tcp", Handler: domainsMux},
-			&dns.Server{Addr: addr, Net: "udp", Handler: domainsMux, UDPSize: 65535},
+			&dns.Server{Addr: addr, Net: "tcp", Handler: handler},
+			&dns.Server{Addr: addr, Net: "udp", Handler: handler, UDPSize: 65535},
 		)
 	}
 
-	return NewServer(servers, logger), nil
+	return servers
 }
@@ -70,6 +70,8 @@ func (q *FailoverRecursorPool) PerformStrategically(work func(string) error) err
 			return nil
 		}
 
+		dnsRecursorFailuresTotal.Inc(q.recursors[index].name)
+
 		failures := q.registerResult(index, true)
 		if i == 0 && failures >= FailHistoryThreshold {
 			q.shiftPreference()
@@ -83,6 +85,7 @@ func (q *FailoverRecursorPool) shiftPreference() {
 	pri := atomic.AddUint64(&q.preferredRecursorIndex, 1)
 	index := pri % uint64(len(q.recursors))
 	q.logger.Info(q.logTag, "Shifting to '%s'", q.recursors[index].name)
+	dnsRecursorFailoversTotal.Inc(q.recursors[index].name)
 }
 
 func (q *FailoverRecursorPool) registerResult(index int, wasError bool) int32 {
@@ -0,0 +1,61 @@
+package dns
+
+import (
+	"time"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/metrics"
+	"github.com/miekg/dns"
+)
+
+var (
+	dnsQueriesTotal = metrics.Default.NewCounter("kwt_dns_queries_total",
+		"Number of answered DNS queries", "handler", "rcode")
+	dnsQuerySeconds = metrics.Default.NewHistogram("kwt_dns_query_duration_seconds",
+		"Time taken to answer DNS queries", metrics.DurationBuckets, "handler")
+	dnsRecursorFailuresTotal = metrics.Default.NewCounter("kwt_dns_recursor_failures_total",
+		"Number of failed requests to recursors", "recursor")
+	dnsRecursorFailoversTotal = metrics.Default.NewCounter("kwt_dns_recursor_failovers_total",
+		"Number of times preferred recursor was changed", "recursor")
+)
+
+// MetricsHandler records number and duration of queries answered by wrapped handler
+type MetricsHandler struct {
+	name    string
+	handler dns.Handler
+}
+
+var _ dns.Handler = MetricsHandler{}
+
+func NewMetricsHandler(name string, handler dns.Handler) MetricsHandler {
+	return MetricsHandler{name, handler}
+}
+
+func (h MetricsHandler) ServeDNS(responseWriter dns.ResponseWriter, request *dns.Msg) {
+	t1 := time.Now()
+
+	recordingWriter := &rcodeRecordingWriter{ResponseWriter: responseWriter}
+
+	h.handler.ServeDNS(recordingWriter, request)
+
+	dnsQuerySeconds.ObserveSince(t1, h.name)
+
+	rcode := "none" // eg mDNS does not answer non-authoritative queries
+	if recordingWriter.written {
+		rcode = dns.RcodeToString[recordingWriter.rcode]
+	}
+
+	dnsQueriesTotal.Inc(h.name, rcode)
+}
+
+type rcodeRecordingWriter struct {
+	dns.ResponseWriter
+
+	written bool
+	rcode   int
+}
+
+func (w *rcodeRecordingWriter) WriteMsg(msg *dns.Msg) error {
+	w.written = true
+	w.rcode = msg.Rcode
+	return w.ResponseWriter.WriteMsg(msg)
+}
@@ -0,0 +1,66 @@
+package dns
+
+import (
+	"net"
+	"sync"
+)
+
+// RuntimeDomains holds domain mapping changes made while server is running.
+// Removals also apply to domains configured in other ways (eg flags).
+type RuntimeDomains struct {
+	added   map[string][]net.IP
+	removed map[string]struct{}
+	lock    sync.Mutex
+
+	updateCh chan struct{}
+}
+
+func NewRuntimeDomains() *RuntimeDomains {
+	return &RuntimeDomains{
+		added:    map[string][]net.IP{},
+		removed:  map[string]struct{}{},
+		updateCh: make(chan struct{}, 1),
+	}
+}
+
+func (d *RuntimeDomains) Add(domain string, ips []net.IP) {
+	d.lock.Lock()
+	d.added[domain] = ips
+	delete(d.removed, domain)
+	d.lock.Unlock()
+
+	d.notify()
+}
+
+func (d *RuntimeDomains) Remove(domain string) {
+	d.lock.Lock()
+	delete(d.added, domain)
+	d.removed[domain] = struct{}{}
+	d.lock.Unlock()
+
+	d.notify()
+}
+
+// Apply modifies given domains map with runtime changes
+func (d *RuntimeDomains) Apply(domains map[string]IPResolver) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for domain := range d.removed {
+		delete(domains, domain)
+	}
+
+	for domain, ips := range d.added {
+		domains[domain] = NewStaticIPsResolver(ips)
+	}
+}
+
+// UpdateCh receives a value whenever domains changed
+func (d *RuntimeDomains) UpdateCh() <-chan struct{} { return d.updateCh }
+
+func (d *RuntimeDomains) notify() {
+	select {
+	case d.updateCh <- struct{}{}:
+	default: // already notified
+	}
+}
@@ -3,6 +3,7 @@ package dns
 import (
 	"fmt"
 	"net"
+	"strconv"
 	"sync"
 
 	"github.com/miekg/dns"
@@ -10,6 +11,7 @@ import (
 
 type Server struct {
 	servers    []*dns.Server
+	bestEffort map[*dns.Server]struct{}
 	shutdownCh chan struct{}
 
 	logTag string
@@ -19,6 +21,7 @@ type Server struct {
 func NewServer(servers []*dns.Server, logger Logger) Server {
 	return Server{
 		servers:    servers,
+		bestEffort: map[*dns.Server]struct{}{},
 		shutdownCh: make(chan struct{}),
 
 		logTag: "dns.Server",
@@ -26,25 +29,63 @@ func NewServer(servers []*dns.Server, logger Logger) Server {
 	}
 }
 
-func (s Server) Serve(startedCh chan struct{}) error {
-	errCh := make(chan error)
+// WithBestEffortServers adds servers that are skipped if they cannot listen
+// (eg IPv6 loopback address is not available)
+func (s Server) WithBestEffortServers(servers []*dns.Server) Server {
+	s.servers = append(append([]*dns.Server{}, s.servers...), servers...)
+	s.bestEffort = map[*dns.Server]struct{}{}
+	for _, srv := range servers {
+		s.bestEffort[srv] = struct{}{}
+	}
+	return s
+}
 
+func (s Server) Serve(startedCh chan struct{}) error {
 	// Cannot use (*dns.Server).ListenAndServer unfortunately
 	err := s.listen()
 	if err != nil {
 		return err
 	}
 
-	startedCh <- struct{}{}
+	servers := s.listeningServers()
+	errCh := make(chan error, len(servers))
 
-	s.logger.Info(s.logTag, "Started DNS server on %s (TCP) and %s (UDP)", s.TCPAddr(), s.UDPAddr())
+	// Servers cannot be shut down until they are marked as started
+	// which happens right before NotifyStartedFunc is called
+	startedWg := &sync.WaitGroup{}
+	startedWg.Add(len(servers))
+
+	for _, srv := range servers {
+		notifyFunc := srv.NotifyStartedFunc
+		srv.NotifyStartedFunc = func() {
+			if notifyFunc != nil {
+				notifyFunc()
+			}
+			startedWg.Done()
+		}
 
-	for _, srv := range s.servers {
 		go func(srv *dns.Server) {
 			errCh <- srv.ActivateAndServe()
 		}(srv)
 	}
 
+	allStartedCh := make(chan struct{})
+
+	go func() {
+		startedWg.Wait()
+		close(allStartedCh)
+	}()
+
+	select {
+	case <-allStartedCh:
+	case err := <-errCh:
+		return fmt.Errorf("Serving: %s", err)
+	}
+
+	startedCh <- struct{}{}
+
+	s.logger.Info(s.logTag, "Started DNS server on %s (TCP) and %s (UDP)", s.TCPAddr(), s.UDPAddr())
+
 	select {
 	case err := <-errCh:
 		return fmt.Errorf("Serving: %s", err)
@@ -83,12 +124,13 @@ func (s Server) Shutdown() error {
 }
 
 func (s Server) shutdown() error {
-	errCh := make(chan error, len(s.servers))
+	servers := s.listeningServers()
+	errCh := make(chan error, len(servers))
 
 	wg := &sync.WaitGroup{}
-	wg.Add(len(s.servers))
+	wg.Add(len(servers))
 
-	for _, server := range s.servers {
+	for _, server := range servers {
 		go func(server *dns.Server) {
 			errCh <- server.Shutdown()
 			wg.Done()
@@ -107,8 +149,22 @@ func (s Server) shutdown() error {
 	return nil
 }
 
+func (s Server) listeningServers() []*dns.Server {
+	var result []*dns.Server
+	for _, srv := range s.servers {
+		if srv.Listener != nil || srv.PacketConn != nil {
+			result = append(result, srv)
+		}
+	}
+	return result
+}
+
+// listen makes servers with port 0 on the same network share the port
+// picked for the first of them so that forwarders can redirect
+// both IPv4 and IPv6 DNS traffic to a single port
 func (s Server) listen() error {
-	// Adapted from https://github.com/miekg/dns/blob/e875a31a5cfbcd646131e625f24818cbae228913/server.go#L407
+	sharedPorts := map[string]int{}
+
 	for _, srv := range s.servers {
 		if srv.Listener != nil || srv.PacketConn != nil {
 			continue
@@ -118,37 +174,63 @@ func (s Server) listen() error {
 		if addr == "" {
 			addr = ":domain"
 		}
-		if srv.UDPSize == 0 {
-			srv.UDPSize = dns.MinMsgSize
-		}
 
-		switch srv.Net {
-		case "tcp", "tcp4", "tcp6":
-			a, err := net.ResolveTCPAddr(srv.Net, addr)
-			if err != nil {
-				return err
+		host, port, err := net.SplitHostPort(addr)
+		if err == nil && port == "0" {
+			if sharedPort, found := sharedPorts[srv.Net]; found {
+				addr = net.JoinHostPort(host, strconv.Itoa(sharedPort))
 			}
-			l, err := net.ListenTCP(srv.Net, a)
-			if err != nil {
-				return err
-			}
-			srv.Listener = l
+		}
 
-		case "udp", "udp4", "udp6":
-			a, err := net.ResolveUDPAddr(srv.Net, addr)
-			if err != nil {
-				return err
-			}
-			l, err := net.ListenUDP(srv.Net, a)
-			if err != nil {
-				return err
+		listenedPort, err := s.listenOne(srv, addr)
+		if err != nil {
+			if _, found := s.bestEffort[srv]; found {
+				s.logger.Info(s.logTag, "Skipping DNS server on %s (%s): %s", addr, srv.Net, err)
+				continue
 			}
-			srv.PacketConn = l
+			return err
+		}
 
-		default:
-			return fmt.Errorf("Unknown net '%s'", srv.Net)
+		if _, found := sharedPorts[srv.Net]; !found {
+			sharedPorts[srv.Net] = listenedPort
 		}
 	}
 
 	return nil
 }
+
+func (s Server) listenOne(srv *dns.Server, addr string) (int, error) {
+	// Adapted from https://github.com/miekg/dns/blob/e875a31a5cfbcd646131e625f24818cbae228913/server.go#L407
+	if srv.UDPSize == 0 {
+		srv.UDPSize = dns.MinMsgSize
+	}
+
+	switch srv.Net {
+	case "tcp", "tcp4", "tcp6":
+		a, err := net.ResolveTCPAddr(srv.Net, addr)
+		if err != nil {
+			return 0, err
+		}
+		l, err := net.ListenTCP(srv.Net, a)
+		if err != nil {
+			return 0, err
+		}
+		srv.Listener = l
+		return l.Addr().(*net.TCPAddr).Port, nil
+
+	case "udp", "udp4", "udp6":
+		a, err := net.ResolveUDPAddr(srv.Net, addr)
+		if err != nil {
+			return 0, err
+		}
+		l, err := net.ListenUDP(srv.Net, a)
+		if err != nil {
+			return 0, err
+		}
+		srv.PacketConn = l
+		return l.LocalAddr().(*net.UDPAddr).Port, nil
+
+	default:
+		return 0, fmt.Errorf("Unknown net '%s'", srv.Net)
+	}
+}
@@ -0,0 +1,66 @@
+package dns_test
+
+import (
+	"net"
+	"testing"
+
+	. "github.com/carvel-dev/kwt/pkg/kwt/dns"
+	"github.com/carvel-dev/kwt/pkg/kwt/logtest"
+	"github.com/miekg/dns"
+)
+
+func TestServerSharesPortWithBestEffortServers(t *testing.T) {
+	ipv6Lis, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback is not available: %s", err)
+	}
+	ipv6Lis.Close()
+
+	handler := dns.NewServeMux()
+
+	server := NewServer([]*dns.Server{
+		&dns.Server{Addr: "127.0.0.1:0", Net: "tcp", Handler: handler},
+		&dns.Server{Addr: "127.0.0.1:0", Net: "udp", Handler: handler},
+	}, logtest.NoopLogger{})
+
+	ipv6Servers := []*dns.Server{
+		&dns.Server{Addr: "[::1]:0", Net: "tcp", Handler: handler},
+		&dns.Server{Addr: "[::1]:0", Net: "udp", Handler: handler},
+		&dns.Server{Addr: "[::1]:0", Net: "tcp", Handler: handler}, // port is already taken
+	}
+
+	server = server.WithBestEffortServers(ipv6Servers)
+
+	startedCh := make(chan struct{})
+	errCh := make(chan error, 1)
+
+	go func() { errCh <- server.Serve(startedCh) }()
+
+	select {
+	case <-startedCh:
+	case err := <-errCh:
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	tcpPort := server.TCPAddr().(*net.TCPAddr).Port
+	udpPort := server.UDPAddr().(*net.UDPAddr).Port
+
+	if ipv6Servers[0].Listener.Addr().(*net.TCPAddr).Port != tcpPort {
+		t.Fatalf("Expected IPv6 TCP server to use IPv4 TCP server port %d", tcpPort)
+	}
+
+	if ipv6Servers[1].PacketConn.LocalAddr().(*net.UDPAddr).Port != udpPort {
+		t.Fatalf("Expected IPv6 UDP server to use IPv4 UDP server port %d", udpPort)
+	}
+
+	if ipv6Servers[2].Listener != nil {
+		t.Fatalf("Expected best effort server that failed to listen to be skipped")
+	}
+
+	server.Shutdown()
+
+	err = <-errCh
+	if err != nil {
+		t.Fatalf("Expected no err on shutdown: %s", err)
+	}
+}
@@ -24,9 +24,21 @@ func (r StaticIPsResolver) String() string {
 }
 
 func (r StaticIPsResolver) ResolveIPv4(question string) ([]net.IP, bool, error) {
-	return r.ips, true, nil
+	var result []net.IP
+	for _, ip := range r.ips {
+		if ip.To4() != nil {
+			result = append(result, ip)
+		}
+	}
+	return result, true, nil
 }
 
 func (r StaticIPsResolver) ResolveIPv6(question string) ([]net.IP, bool, error) {
-	return nil, true, nil
+	var result []net.IP
+	for _, ip := range r.ips {
+		if ip.To4() == nil {
+			result = append(result, ip)
+		}
+	}
+	return result, true, nil
 }
@@ -3,22 +3,55 @@ package kubedns
 import (
 	"fmt"
 	"net"
+	"regexp"
 	"strings"
 
 	ctldns "github.com/carvel-dev/kwt/pkg/kwt/dns"
 	ctlmdns "github.com/carvel-dev/kwt/pkg/kwt/mdns"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/kubeips"
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/client-go/kubernetes"
 )
 
 const DefaultClusterDomain = "cluster." + ctlmdns.Domain
 
+var nonDNSLabelCharsRegexp = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// ContextClusterDomain returns cluster domain specific to kubeconfig context
+// (eg 'gke_proj_us-east1_dev' becomes 'gke-proj-us-east1-dev.local').
+// Contexts that map to DefaultClusterDomain (eg 'cluster') are rejected
+// since that domain always resolves against the first context.
+func ContextClusterDomain(context string) (string, error) {
+	label := nonDNSLabelCharsRegexp.ReplaceAllString(strings.ToLower(context), "-")
+	label = strings.Trim(label, "-")
+
+	if len(label) == 0 {
+		return "", fmt.Errorf("Expected context '%s' to include at least one letter or digit to be used as cluster domain", context)
+	}
+
+	domain := label + "." + ctlmdns.Domain
+
+	if domain == DefaultClusterDomain {
+		return "", fmt.Errorf("Expected context '%s' cluster domain to not be '%s' since it's reserved "+
+			"for the first context (rename context, eg via 'kubectl config rename-context')", context, domain)
+	}
+
+	return domain, nil
+}
+
+// ServiceIPs provides IPs to answer with instead of service cluster IPs
+// (eg loopback addresses that forward to services)
+type ServiceIPs interface {
+	ServiceIP(namespace, name string) (net.IP, bool)
+}
+
 type KubeDNSIPResolver struct {
 	clusterSuffix string // eg .cluster.local.
 	svcSuffix     string // eg .svc
 	podSuffix     string // eg .pod
 	coreClient    kubernetes.Interface
+	svcIPs        ServiceIPs
 }
 
 var _ ctldns.IPResolver = KubeDNSIPResolver{}
@@ -39,9 +72,46 @@ func NewKubeDNSIPResolver(suffix string, coreClient kubernetes.Interface) KubeDN
 	}
 }
 
+func (r KubeDNSIPResolver) WithServiceIPs(svcIPs ServiceIPs) KubeDNSIPResolver {
+	r.svcIPs = svcIPs
+	return r
+}
+
 func (r KubeDNSIPResolver) String() string { return "kube-dns" }
 
 func (r KubeDNSIPResolver) ResolveIPv4(question string) ([]net.IP, bool, error) {
+	ips, resolved, err := r.resolve(question)
+	if err != nil {
+		return nil, resolved, err
+	}
+
+	var result []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			result = append(result, ip)
+		}
+	}
+
+	return result, resolved, nil
+}
+
+func (r KubeDNSIPResolver) ResolveIPv6(question string) ([]net.IP, bool, error) {
+	ips, resolved, err := r.resolve(question)
+	if err != nil {
+		return nil, resolved, err
+	}
+
+	var result []net.IP
+	for _, ip := range ips {
+		if ip.To4() == nil {
+			result = append(result, ip)
+		}
+	}
+
+	return result, resolved, nil
+}
+
+func (r KubeDNSIPResolver) resolve(question string) ([]net.IP, bool, error) {
 	if !strings.HasSuffix(question, r.clusterSuffix) {
 		return nil, false, nil
 	}
@@ -55,6 +125,7 @@ func (r KubeDNSIPResolver) ResolveIPv4(question string) ([]net.IP, bool, error)
 		return ips, true, err
 
 	// 1-2-3-4.default.pod.cluster.local -> 1.2.3.4
+	// fd00-10-80--5.default.pod.cluster.local -> fd00:10:80::5
 	case strings.HasSuffix(question, r.podSuffix):
 		ips, err := r.podIP(question)
 		return ips, true, err
@@ -64,23 +135,6 @@ func (r KubeDNSIPResolver) ResolveIPv4(question string) ([]net.IP, bool, error)
 	}
 }
 
-func (r KubeDNSIPResolver) ResolveIPv6(question string) ([]net.IP, bool, error) {
-	if !strings.HasSuffix(question, r.clusterSuffix) {
-		return nil, false, nil
-	}
-
-	switch {
-	case strings.HasSuffix(question, r.svcSuffix):
-		return nil, true, nil // no IPs
-
-	case strings.HasSuffix(question, r.podSuffix):
-		return nil, true, nil // no IPs
-
-	default:
-		return nil, true, fmt.Errorf("Could not determine Kubernetes DNS question format")
-	}
-}
-
 func (r KubeDNSIPResolver) svcIP(question string) ([]net.IP, error) {
 	rest := strings.TrimSuffix(question, r.svcSuffix)
 
@@ -89,22 +143,30 @@ func (r KubeDNSIPResolver) svcIP(question string) ([]net.IP, error) {
 		return nil, fmt.Errorf("Expected service address to be in particular format")
 	}
 
-	svc, err := r.coreClient.CoreV1().Services(pieces[1]).Get(pieces[0], metav1.GetOptions{})
+	if r.svcIPs != nil {
+		if ip, found := r.svcIPs.ServiceIP(pieces[1], pieces[0]); found {
+			return []net.IP{ip}, nil
+		}
+	}
+
+	svc, err := kubeips.GetObject(r.coreClient, "services", pieces[1], pieces[0])
 	if err != nil {
 		return nil, fmt.Errorf("Getting service: %s", err)
 	}
 
-	if len(svc.Spec.ClusterIP) == 0 {
+	clusterIP, _, _ := unstructured.NestedString(svc, "spec", "clusterIP")
+	if len(clusterIP) == 0 {
 		// TODO which pods to pick up (readiness? etc?)
 		return nil, nil
 	}
 
-	ip := net.ParseIP(svc.Spec.ClusterIP)
-	if ip == nil {
+	// Includes cluster IPs of both families for dual-stack services
+	ips := kubeips.ServiceClusterIPs(svc)
+	if len(ips) == 0 {
 		return nil, fmt.Errorf("Expected service cluster IP address to be valid")
 	}
 
-	return []net.IP{ip}, nil
+	return ips, nil
 }
 
 func (r KubeDNSIPResolver) podIP(question string) ([]net.IP, error) {
@@ -116,6 +178,9 @@ func (r KubeDNSIPResolver) podIP(question string) ([]net.IP, error) {
 	}
 
 	ip := net.ParseIP(strings.Replace(pieces[0], "-", ".", -1))
+	if ip == nil {
+		ip = net.ParseIP(strings.Replace(pieces[0], "-", ":", -1))
+	}
 	if ip == nil {
 		return nil, fmt.Errorf("Expected pod address to be in IP address format")
 	}
@@ -130,7 +195,7 @@ func (r KubeDNSIPResolver) ServiceInternalDNSAddress(service corev1.Service) str
 
 func (r KubeDNSIPResolver) PodInternalDNSAddress(pod corev1.Pod) string {
 	if len(pod.Status.PodIP) > 0 {
-		dashedIP := strings.Replace(pod.Status.PodIP, ".", "-", -1)
+		dashedIP := strings.NewReplacer(".", "-", ":", "-").Replace(pod.Status.PodIP)
 		addr := fmt.Sprintf("%s.%s%s", dashedIP, pod.Namespace, r.podSuffix)
 		return strings.TrimSuffix(addr, ".")
 	}
@@ -0,0 +1,40 @@
+package kubedns_test
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/carvel-dev/kwt/pkg/kwt/kubedns"
+	"github.com/carvel-dev/kwt/pkg/kwt/kubetest"
+)
+
+func TestKubeDNSIPResolverDualStackService(t *testing.T) {
+	api, coreClient, closeFunc := kubetest.NewFakeAPI(t)
+	defer closeFunc()
+
+	api.Set("/api/v1/namespaces/ns/services/ds", `{"spec":{"clusterIP":"10.0.0.1","clusterIPs":["10.0.0.1","fd00::1"]}}`)
+	api.Set("/api/v1/namespaces/ns/services/v4", `{"spec":{"clusterIP":"10.0.0.2"}}`)
+
+	resolver := NewKubeDNSIPResolver("cluster.local", coreClient)
+
+	examples := []struct {
+		question string
+		ipv4     string
+		ipv6     string
+	}{
+		{"ds.ns.svc.cluster.local.", "[10.0.0.1]", "[fd00::1]"},
+		{"v4.ns.svc.cluster.local.", "[10.0.0.2]", "[]"},
+	}
+
+	for _, ex := range examples {
+		ips, resolved, err := resolver.ResolveIPv4(ex.question)
+		if err != nil || !resolved || fmt.Sprintf("%s", ips) != ex.ipv4 {
+			t.Fatalf("Expected '%s' IPv4 IPs to be %s, but was %s (resolved: %t, err: %v)", ex.question, ex.ipv4, ips, resolved, err)
+		}
+
+		ips, resolved, err = resolver.ResolveIPv6(ex.question)
+		if err != nil || !resolved || fmt.Sprintf("%s", ips) != ex.ipv6 {
+			t.Fatalf("Expected '%s' IPv6 IPs to be %s, but was %s (resolved: %t, err: %v)", ex.question, ex.ipv6, ips, resolved, err)
+		}
+	}
+}
@@ -0,0 +1,478 @@
+package kubetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	// Kinds are filled in so that watch events and lists can be decoded without type hints
+	resourceKinds = map[string]string{
+		"deployments":          "Deployment",
+		"endpoints":            "Endpoints",
+		"leases":               "Lease",
+		"namespaces":           "Namespace",
+		"poddisruptionbudgets": "PodDisruptionBudget",
+		"pods":                 "Pod",
+		"secrets":              "Secret",
+		"services":             "Service",
+	}
+)
+
+type watchEvent struct {
+	Type   string                 `json:"type"`
+	Object map[string]interface{} `json:"object"`
+}
+
+// FakeAPI stores objects as raw JSON keyed by request path
+// (eg /api/v1/namespaces/ns/pods/name) and serves them to Kubernetes clients.
+// Lists and watches are supported for namespaced and all namespaces paths.
+type FakeAPI struct {
+	// ErrFunc returns HTTP status code to fail request with (0 to not fail)
+	ErrFunc func(method, path string) int
+
+	objs     map[string]map[string]interface{}
+	requests []string // eg 'PUT /api/v1/namespaces/ns/secrets/name'
+	watches  map[string][]chan watchEvent
+	version  int
+	expired  bool
+	lock     sync.Mutex
+
+	config *rest.Config
+}
+
+func NewFakeAPI(t *testing.T) (*FakeAPI, kubernetes.Interface, func()) {
+	api := &FakeAPI{
+		objs:    map[string]map[string]interface{}{},
+		watches: map[string][]chan watchEvent{},
+	}
+
+	server := httptest.NewServer(api)
+
+	// Client side rate limiting would slow down tests making many requests
+	api.config = &rest.Config{Host: server.URL, QPS: 1000, Burst: 1000}
+
+	coreClient, err := kubernetes.NewForConfig(api.config)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	closeFunc := func() {
+		api.closeWatches()
+		server.Close()
+	}
+
+	return api, coreClient, closeFunc
+}
+
+// Config is used to build other clients (eg dynamic client)
+func (a *FakeAPI) Config() *rest.Config { return rest.CopyConfig(a.config) }
+
+func (a *FakeAPI) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	path := req.URL.Path
+
+	a.lock.Lock()
+	a.requests = append(a.requests, req.Method+" "+path)
+	errFunc := a.ErrFunc
+	a.lock.Unlock()
+
+	// Called without lock so that it can inspect objects
+	if errFunc != nil {
+		if code := errFunc(req.Method, path); code != 0 {
+			a.status(w, code)
+			return
+		}
+	}
+
+	if req.Method == "GET" && req.URL.Query().Get("watch") == "true" {
+		a.watch(w, req)
+		return
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	switch req.Method {
+	case "GET":
+		if len(parsePath(path).name) == 0 {
+			a.list(w, path, req.URL.Query().Get("labelSelector"))
+			return
+		}
+		if obj, found := a.objs[path]; found {
+			json.NewEncoder(w).Encode(obj)
+			return
+		}
+		a.status(w, http.StatusNotFound)
+
+	case "POST", "PUT":
+		var obj map[string]interface{}
+		body, _ := ioutil.ReadAll(req.Body)
+		json.Unmarshal(body, &obj)
+		if req.Method == "POST" {
+			path += "/" + obj["metadata"].(map[string]interface{})["name"].(string)
+		}
+		json.NewEncoder(w).Encode(a.set(path, obj))
+
+	case "PATCH": // only merge patches
+		obj, found := a.objs[path]
+		if !found {
+			a.status(w, http.StatusNotFound)
+			return
+		}
+		var patch map[string]interface{}
+		json.NewDecoder(req.Body).Decode(&patch)
+		merge(obj, patch)
+		json.NewEncoder(w).Encode(a.set(path, obj))
+
+	case "DELETE":
+		if !a.delete(path) {
+			a.status(w, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"kind": "Status", "apiVersion": "v1", "status": "Success"})
+	}
+}
+
+// Set adds or replaces object (typed, map or JSON string) at path
+func (a *FakeAPI) Set(path string, obj interface{}) {
+	var bs []byte
+
+	if str, ok := obj.(string); ok {
+		bs = []byte(str)
+	} else {
+		bs, _ = json.Marshal(obj)
+	}
+
+	var objMap map[string]interface{}
+
+	err := json.Unmarshal(bs, &objMap)
+	if err != nil {
+		panic(fmt.Sprintf("Unmarshaling fake object: %s", err))
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.set(path, objMap)
+}
+
+// Update changes object in place; returns false if object is not found
+func (a *FakeAPI) Update(path string, updateFunc func(obj map[string]interface{})) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	obj, found := a.objs[path]
+	if found {
+		updateFunc(obj)
+		a.set(path, obj)
+	}
+	return found
+}
+
+func (a *FakeAPI) Delete(path string) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.delete(path)
+}
+
+// Get returns copy of object (nil if not found)
+func (a *FakeAPI) Get(path string) map[string]interface{} {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	obj, found := a.objs[path]
+	if !found {
+		return nil
+	}
+
+	var objCopy map[string]interface{}
+	bs, _ := json.Marshal(obj)
+	json.Unmarshal(bs, &objCopy)
+
+	return objCopy
+}
+
+// Decode unmarshals object into typed object
+func (a *FakeAPI) Decode(t *testing.T, path string, into interface{}) {
+	obj := a.Get(path)
+	if obj == nil {
+		t.Fatalf("Expected object '%s' to exist", path)
+	}
+
+	bs, _ := json.Marshal(obj)
+
+	err := json.Unmarshal(bs, into)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+}
+
+func (a *FakeAPI) Has(path string) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	_, found := a.objs[path]
+	return found
+}
+
+// Paths returns sorted paths of objects with given prefix
+func (a *FakeAPI) Paths(prefix string) []string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	var result []string
+	for path := range a.objs {
+		if strings.HasPrefix(path, prefix) {
+			result = append(result, path)
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// Requests returns requests made so far and forgets them
+func (a *FakeAPI) Requests() []string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	requests := a.requests
+	a.requests = nil
+
+	return requests
+}
+
+// DeleteWithoutEvent makes informers miss deletion so that they
+// only find out about it when relisting (via tombstone).
+// Watches are answered with 410 Gone until Unexpire is called.
+func (a *FakeAPI) DeleteWithoutEvent(path string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.version++
+	delete(a.objs, path)
+
+	a.expired = true
+	a.closeWatchesLocked()
+}
+
+func (a *FakeAPI) Unexpire() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.expired = false
+}
+
+func (a *FakeAPI) set(path string, obj map[string]interface{}) map[string]interface{} {
+	a.version++
+
+	if _, found := obj["metadata"].(map[string]interface{}); !found {
+		obj["metadata"] = map[string]interface{}{}
+	}
+	obj["metadata"].(map[string]interface{})["resourceVersion"] = fmt.Sprintf("%d", a.version)
+
+	objPath := parsePath(path)
+
+	if kind, found := resourceKinds[objPath.resource]; found {
+		if _, found := obj["kind"]; !found {
+			obj["kind"] = kind
+			obj["apiVersion"] = objPath.apiVersion()
+		}
+	}
+
+	eventType := "MODIFIED"
+	if _, found := a.objs[path]; !found {
+		eventType = "ADDED"
+	}
+
+	a.objs[path] = obj
+	a.notify(path, eventType, obj)
+
+	return obj
+}
+
+func (a *FakeAPI) delete(path string) bool {
+	obj, found := a.objs[path]
+	if found {
+		a.version++
+		delete(a.objs, path)
+		a.notify(path, "DELETED", obj)
+	}
+	return found
+}
+
+func (a *FakeAPI) list(w http.ResponseWriter, path, selector string) {
+	listPath := parsePath(path)
+
+	items := []interface{}{}
+
+	for objPath, obj := range a.objs {
+		if listPath.includes(parsePath(objPath)) && matches(obj, selector) {
+			items = append(items, obj)
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"kind":       resourceKinds[listPath.resource] + "List",
+		"apiVersion": listPath.apiVersion(),
+		"metadata":   map[string]interface{}{"resourceVersion": fmt.Sprintf("%d", a.version)},
+		"items":      items,
+	})
+}
+
+func (a *FakeAPI) watch(w http.ResponseWriter, req *http.Request) {
+	a.lock.Lock()
+	if a.expired {
+		a.lock.Unlock()
+		a.status(w, http.StatusGone)
+		return
+	}
+	eventsCh := make(chan watchEvent, 100)
+	a.watches[req.URL.Path] = append(a.watches[req.URL.Path], eventsCh)
+	a.lock.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	for {
+		select {
+		case event, ok := <-eventsCh:
+			if !ok {
+				return
+			}
+			json.NewEncoder(w).Encode(event)
+			w.(http.Flusher).Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+func (a *FakeAPI) notify(path, eventType string, obj map[string]interface{}) {
+	objPath := parsePath(path)
+
+	// Copy so that later in place changes are not seen by watchers
+	var objCopy map[string]interface{}
+	bs, _ := json.Marshal(obj)
+	json.Unmarshal(bs, &objCopy)
+
+	for watchPath, watches := range a.watches {
+		if parsePath(watchPath).includes(objPath) {
+			for _, eventsCh := range watches {
+				eventsCh <- watchEvent{eventType, objCopy}
+			}
+		}
+	}
+}
+
+func (a *FakeAPI) closeWatches() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.closeWatchesLocked()
+}
+
+func (a *FakeAPI) closeWatchesLocked() {
+	for _, watches := range a.watches {
+		for _, eventsCh := range watches {
+			close(eventsCh)
+		}
+	}
+
+	a.watches = map[string][]chan watchEvent{}
+}
+
+func (a *FakeAPI) status(w http.ResponseWriter, code int) {
+	reasons := map[int]string{
+		http.StatusForbidden: "Forbidden",
+		http.StatusNotFound:  "NotFound",
+		http.StatusConflict:  "Conflict",
+		http.StatusGone:      "Expired",
+	}
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"kind": "Status", "apiVersion": "v1", "status": "Failure", "reason": reasons[code], "code": code})
+}
+
+func merge(obj, patch map[string]interface{}) {
+	for k, v := range patch {
+		patchMap, isPatchMap := v.(map[string]interface{})
+		objMap, isObjMap := obj[k].(map[string]interface{})
+		if isPatchMap && isObjMap {
+			merge(objMap, patchMap)
+		} else {
+			obj[k] = v
+		}
+	}
+}
+
+// matches supports 'key' and 'key=value' selectors
+func matches(obj map[string]interface{}, selector string) bool {
+	if len(selector) == 0 {
+		return true
+	}
+	meta, _ := obj["metadata"].(map[string]interface{})
+	labels, _ := meta["labels"].(map[string]interface{})
+	pieces := strings.SplitN(selector, "=", 2)
+	val, found := labels[pieces[0]]
+	return found && (len(pieces) == 1 || val == pieces[1])
+}
+
+type apiPath struct {
+	group     string // eg api/v1 or apis/apps/v1
+	namespace string
+	resource  string
+	name      string
+}
+
+func parsePath(path string) apiPath {
+	pieces := strings.Split(strings.Trim(path, "/"), "/")
+
+	groupLen := 3
+	if pieces[0] == "api" {
+		groupLen = 2
+	}
+	if len(pieces) < groupLen {
+		return apiPath{group: strings.Join(pieces, "/")}
+	}
+
+	result := apiPath{group: strings.Join(pieces[:groupLen], "/")}
+	pieces = pieces[groupLen:]
+
+	if len(pieces) > 2 && pieces[0] == "namespaces" {
+		result.namespace = pieces[1]
+		pieces = pieces[2:]
+	}
+	if len(pieces) > 0 {
+		result.resource = pieces[0]
+	}
+	if len(pieces) > 1 {
+		result.name = pieces[1]
+	}
+
+	return result
+}
+
+func (p apiPath) apiVersion() string {
+	return strings.TrimPrefix(strings.TrimPrefix(p.group, "api/"), "apis/")
+}
+
+// includes checks if object path is within list path
+func (p apiPath) includes(objPath apiPath) bool {
+	return p.group == objPath.group && p.resource == objPath.resource &&
+		(len(p.namespace) == 0 || p.namespace == objPath.namespace) && len(objPath.name) > 0
+}
@@ -0,0 +1,23 @@
+package logtest
+
+import (
+	"fmt"
+)
+
+// NoopLogger satisfies Logger interfaces of kwt packages in tests
+type NoopLogger struct{}
+
+func (NoopLogger) Error(tag, msg string, args ...interface{}) {}
+func (NoopLogger) Info(tag, msg string, args ...interface{})  {}
+func (NoopLogger) Debug(tag, msg string, args ...interface{}) {}
+
+// HookLogger passes formatted messages of all levels to HookFunc
+type HookLogger struct {
+	HookFunc func(msg string)
+}
+
+func (l HookLogger) Error(tag, msg string, args ...interface{}) { l.log(msg, args) }
+func (l HookLogger) Info(tag, msg string, args ...interface{})  { l.log(msg, args) }
+func (l HookLogger) Debug(tag, msg string, args ...interface{}) { l.log(msg, args) }
+
+func (l HookLogger) log(msg string, args []interface{}) { l.HookFunc(fmt.Sprintf(msg, args...)) }
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Default is used by components that expose metrics
+var Default = NewRegistry()
+
+// Registry keeps track of metrics and serves them
+// in Prometheus exposition format
+type Registry struct {
+	registry *prometheus.Registry
+	handler  http.Handler
+}
+
+var _ http.Handler = &Registry{}
+
+func NewRegistry() *Registry {
+	registry := prometheus.NewRegistry()
+	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	return &Registry{registry, handler}
+}
+
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+	vec := prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labelNames)
+	r.registry.MustRegister(vec)
+	return &Counter{vec}
+}
+
+func (r *Registry) NewGauge(name, help string, labelNames ...string) *Gauge {
+	vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labelNames)
+	r.registry.MustRegister(vec)
+	return &Gauge{vec}
+}
+
+// NewHistogram expects buckets to be sorted in increasing order
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	vec := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets}, labelNames)
+	r.registry.MustRegister(vec)
+	return &Histogram{vec}
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.handler.ServeHTTP(w, req)
+}
@@ -0,0 +1,69 @@
+package metrics_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/carvel-dev/kwt/pkg/kwt/metrics"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+
+	counter := registry.NewCounter("test_total", "Test counter", "reason")
+	gauge := registry.NewGauge("test_active", "Test gauge")
+	histogram := registry.NewHistogram("test_seconds", "Test histogram", []float64{0.1, 1})
+
+	counter.Inc("timeout")
+	counter.Add(2, "timeout")
+	counter.Inc(`"quoted"`)
+
+	gauge.Inc()
+	gauge.Inc()
+	gauge.Dec()
+
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+	histogram.Observe(5)
+
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("Expected text exposition format: %s", recorder.Header().Get("Content-Type"))
+	}
+
+	expectedOutput := `# HELP test_active Test gauge
+# TYPE test_active gauge
+test_active 1
+# HELP test_seconds Test histogram
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 1
+test_seconds_bucket{le="1"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 5.55
+test_seconds_count 3
+# HELP test_total Test counter
+# TYPE test_total counter
+test_total{reason="\"quoted\""} 1
+test_total{reason="timeout"} 3
+`
+
+	if recorder.Body.String() != expectedOutput {
+		t.Fatalf("Expected output to match: actual %s", recorder.Body.String())
+	}
+}
+
+func TestRegistryDuplicateMetric(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounter("test_total", "Test counter")
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Expected registering duplicate metric to panic")
+		}
+	}()
+
+	registry.NewCounter("test_total", "Test counter")
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+)
+
+type Server struct {
+	addr     string
+	registry *Registry
+	listener net.Listener
+
+	logTag string
+	logger Logger
+}
+
+func NewServer(addr string, registry *Registry, logger Logger) *Server {
+	return &Server{addr: addr, registry: registry, logTag: "metrics.Server", logger: logger}
+}
+
+// Start returns once server is listening; requests are served in the background
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("Listening for metrics: %s", err)
+	}
+
+	s.listener = listener
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.registry)
+
+	go func() {
+		err := http.Serve(listener, mux)
+		if err != nil {
+			s.logger.Debug(s.logTag, "Stopped serving: %s", err)
+		}
+	}()
+
+	s.logger.Info(s.logTag, "Serving metrics on http://%s/metrics", listener.Addr())
+
+	return nil
+}
+
+func (s *Server) Shutdown() error {
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return nil
+}
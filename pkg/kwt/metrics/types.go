@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// DurationBuckets cover typical network and DNS latencies (in seconds)
+	DurationBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+)
+
+// Label values are expected to have low cardinality
+// (eg never include IPs or ports) since each combination is kept forever
+
+type Counter struct {
+	vec *prometheus.CounterVec
+}
+
+func (c *Counter) Inc(labelValues ...string) { c.Add(1, labelValues...) }
+
+func (c *Counter) Add(val float64, labelValues ...string) {
+	c.vec.WithLabelValues(labelValues...).Add(val)
+}
+
+type Gauge struct {
+	vec *prometheus.GaugeVec
+}
+
+func (g *Gauge) Inc(labelValues ...string) { g.Add(1, labelValues...) }
+func (g *Gauge) Dec(labelValues ...string) { g.Add(-1, labelValues...) }
+
+func (g *Gauge) Add(val float64, labelValues ...string) {
+	g.vec.WithLabelValues(labelValues...).Add(val)
+}
+
+func (g *Gauge) Set(val float64, labelValues ...string) {
+	g.vec.WithLabelValues(labelValues...).Set(val)
+}
+
+type Histogram struct {
+	vec *prometheus.HistogramVec
+}
+
+func (h *Histogram) Observe(val float64, labelValues ...string) {
+	h.vec.WithLabelValues(labelValues...).Observe(val)
+}
+
+func (h *Histogram) ObserveSince(t time.Time, labelValues ...string) {
+	h.Observe(time.Now().Sub(t).Seconds(), labelValues...)
+}
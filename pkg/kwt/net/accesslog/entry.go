@@ -0,0 +1,39 @@
+package accesslog
+
+import (
+	"time"
+)
+
+const (
+	CloseReasonEOF          = "eof"           // one of the sides finished connection
+	CloseReasonDialFailed   = "dial_failed"   // destination could not be reached
+	CloseReasonRefused      = "refused"       // refused by fault rule
+	CloseReasonReset        = "reset"         // reset by fault rule
+	CloseReasonClosed       = "closed"        // closed via 'kwt net ctl close'
+	CloseReasonDrainTimeout = "drain_timeout" // still open when shutdown drain timeout was reached
+	CloseReasonForced       = "forced"        // still open when shutdown was forced
+)
+
+// Entry is a single line of access log describing finished connection;
+// bytes are counted from the perspective of destination
+type Entry struct {
+	Time time.Time `json:"time"`
+	ID   uint64    `json:"id,omitempty"`
+
+	Src     string `json:"src"`
+	SrcPID  int    `json:"src_pid,omitempty"`
+	SrcComm string `json:"src_comm,omitempty"`
+
+	Dst          string `json:"dst"`
+	DstKind      string `json:"dst_kind,omitempty"`
+	DstNamespace string `json:"dst_namespace,omitempty"`
+	DstName      string `json:"dst_name,omitempty"`
+
+	DialSeconds     float64 `json:"dial_seconds"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	BytesOut        uint64  `json:"bytes_out"`
+	BytesIn         uint64  `json:"bytes_in"`
+
+	CloseReason string `json:"close_reason"`
+	Error       string `json:"error,omitempty"`
+}
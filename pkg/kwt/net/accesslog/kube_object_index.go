@@ -0,0 +1,189 @@
+package accesslog
+
+import (
+	"net"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// KubeObjectIndex keeps services and pods keyed by their IPs up to date via
+// informers so that connections can be attributed without API calls
+type KubeObjectIndex struct {
+	coreClient kubernetes.Interface
+	namespaces []string
+
+	svcs    map[string]Object // keyed by IP
+	pods    map[string]Object // keyed by IP
+	objIPs  map[Object][]string
+	objLock sync.RWMutex
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+
+	logTag string
+	logger Logger
+}
+
+var _ ObjectResolver = &KubeObjectIndex{}
+
+func NewKubeObjectIndex(coreClient kubernetes.Interface, namespaces []string, logger Logger) *KubeObjectIndex {
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	return &KubeObjectIndex{
+		coreClient: coreClient,
+		namespaces: namespaces,
+
+		svcs:   map[string]Object{},
+		pods:   map[string]Object{},
+		objIPs: map[Object][]string{},
+
+		stopCh: make(chan struct{}),
+
+		logTag: "accesslog.KubeObjectIndex",
+		logger: logger,
+	}
+}
+
+// Start fills index in the background; lookups miss until informers sync
+func (i *KubeObjectIndex) Start() {
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { i.update(obj) },
+		UpdateFunc: func(_, obj interface{}) { i.update(obj) },
+		DeleteFunc: func(obj interface{}) { i.delete(obj) },
+	}
+
+	restClient := i.coreClient.CoreV1().RESTClient()
+
+	var hasSyncedFuncs []cache.InformerSynced
+
+	for _, ns := range i.namespaces {
+		_, svcController := cache.NewInformer(
+			cache.NewListWatchFromClient(restClient, "services", ns, fields.Everything()),
+			&corev1.Service{}, 0, handler)
+
+		_, podController := cache.NewInformer(
+			cache.NewListWatchFromClient(restClient, "pods", ns, fields.Everything()),
+			&corev1.Pod{}, 0, handler)
+
+		go svcController.Run(i.stopCh)
+		go podController.Run(i.stopCh)
+
+		hasSyncedFuncs = append(hasSyncedFuncs, svcController.HasSynced, podController.HasSynced)
+	}
+
+	go func() {
+		if cache.WaitForCacheSync(i.stopCh, hasSyncedFuncs...) {
+			i.logger.Debug(i.logTag, "Synced services and pods")
+		}
+	}()
+}
+
+// Stop may be called more than once since index can be shared (eg with metrics)
+func (i *KubeObjectIndex) Stop() {
+	i.stopOnce.Do(func() { close(i.stopCh) })
+}
+
+// Object prefers services over pods
+func (i *KubeObjectIndex) Object(ip net.IP) (Object, bool) {
+	i.objLock.RLock()
+	defer i.objLock.RUnlock()
+
+	if obj, found := i.svcs[ip.String()]; found {
+		return obj, true
+	}
+
+	obj, found := i.pods[ip.String()]
+	return obj, found
+}
+
+func (i *KubeObjectIndex) update(obj interface{}) {
+	switch typedObj := obj.(type) {
+	case *corev1.Service:
+		key := Object{Kind: "svc", Namespace: typedObj.Namespace, Name: typedObj.Name}
+		i.set(i.svcs, key, kubeServiceIPs(typedObj))
+
+	case *corev1.Pod:
+		key := Object{Kind: "pod", Namespace: typedObj.Namespace, Name: typedObj.Name}
+
+		// Host network pods share node IP and finished pods may give up their IP
+		finished := typedObj.Status.Phase == corev1.PodSucceeded || typedObj.Status.Phase == corev1.PodFailed
+		if typedObj.Spec.HostNetwork || finished {
+			i.set(i.pods, key, nil)
+		} else {
+			i.set(i.pods, key, kubePodIPs(typedObj))
+		}
+	}
+}
+
+func (i *KubeObjectIndex) delete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	switch typedObj := obj.(type) {
+	case *corev1.Service:
+		i.set(i.svcs, Object{Kind: "svc", Namespace: typedObj.Namespace, Name: typedObj.Name}, nil)
+	case *corev1.Pod:
+		i.set(i.pods, Object{Kind: "pod", Namespace: typedObj.Namespace, Name: typedObj.Name}, nil)
+	}
+}
+
+// set replaces object's previous IPs with given ones
+func (i *KubeObjectIndex) set(objs map[string]Object, obj Object, ips []net.IP) {
+	i.objLock.Lock()
+	defer i.objLock.Unlock()
+
+	// Previous IPs may have been already taken over by another object
+	for _, prevIP := range i.objIPs[obj] {
+		if objs[prevIP] == obj {
+			delete(objs, prevIP)
+		}
+	}
+
+	delete(i.objIPs, obj)
+
+	for _, ip := range ips {
+		objs[ip.String()] = obj
+		i.objIPs[obj] = append(i.objIPs[obj], ip.String())
+	}
+}
+
+// kubeServiceIPs only includes primary cluster IP since vendored API types
+// predate dual-stack services (Spec.ClusterIPs); connections to the secondary
+// IP family cluster IP of dual-stack services are not attributed
+func kubeServiceIPs(svc *corev1.Service) []net.IP {
+	return parseIPs([]string{svc.Spec.ClusterIP}) // ClusterIP can be "None"
+}
+
+// kubePodIPs only includes primary pod IP since vendored API types
+// predate dual-stack pods (Status.PodIPs); connections to the secondary
+// IP family pod IP of dual-stack pods are not attributed
+func kubePodIPs(pod *corev1.Pod) []net.IP {
+	return parseIPs([]string{pod.Status.PodIP})
+}
+
+func parseIPs(strs []string) []net.IP {
+	var result []net.IP
+	seen := map[string]struct{}{}
+
+	for _, str := range strs {
+		ip := net.ParseIP(str)
+		if ip == nil {
+			continue
+		}
+		if _, found := seen[ip.String()]; found {
+			continue
+		}
+		seen[ip.String()] = struct{}{}
+		result = append(result, ip)
+	}
+
+	return result
+}
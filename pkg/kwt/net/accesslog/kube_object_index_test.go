@@ -0,0 +1,156 @@
+package accesslog_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/kubetest"
+	"github.com/carvel-dev/kwt/pkg/kwt/logtest"
+	. "github.com/carvel-dev/kwt/pkg/kwt/net/accesslog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// fakeObjectPath returns path under which object is served by fake API
+func fakeObjectPath(obj runtime.Object) string {
+	switch typedObj := obj.(type) {
+	case *corev1.Service:
+		return "/api/v1/namespaces/" + typedObj.Namespace + "/services/" + typedObj.Name
+	case *corev1.Pod:
+		return "/api/v1/namespaces/" + typedObj.Namespace + "/pods/" + typedObj.Name
+	default:
+		panic(fmt.Sprintf("Unknown object %T", obj))
+	}
+}
+
+func fakeService(name, clusterIP string) *corev1.Service {
+	return &corev1.Service{
+		TypeMeta:   metav1.TypeMeta{Kind: "Service", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns"},
+		Spec:       corev1.ServiceSpec{ClusterIP: clusterIP},
+	}
+}
+
+func fakePod(name, podIP string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns"},
+		Status:     corev1.PodStatus{Phase: phase, PodIP: podIP},
+	}
+}
+
+func TestKubeObjectIndex(t *testing.T) {
+	api, coreClient, closeFunc := kubetest.NewFakeAPI(t)
+	defer closeFunc()
+
+	setObj := func(obj runtime.Object) { api.Set(fakeObjectPath(obj), obj) }
+
+	// Present before informers start so that they are found by initial list
+	setObj(fakeService("redis", "10.0.0.1"))
+
+	index := NewKubeObjectIndex(coreClient, nil, logtest.NoopLogger{})
+	index.Start()
+
+	defer index.Stop()
+
+	svc := func(name string) string { return "svc/ns/" + name }
+	pod := func(name string) string { return "pod/ns/" + name }
+
+	examples := []struct {
+		desc    string
+		apply   func()
+		objects map[string]string // keyed by IP; empty means not found
+	}{
+		{
+			desc:    "listed service",
+			objects: map[string]string{"10.0.0.1": svc("redis")},
+		},
+		{
+			desc: "added objects",
+			apply: func() {
+				setObj(fakeService("headless", "None"))
+				setObj(fakePod("app", "10.1.0.1", corev1.PodRunning))
+				setObj(fakePod("pending", "", corev1.PodPending))
+			},
+			objects: map[string]string{"10.0.0.1": svc("redis"), "10.1.0.1": pod("app")},
+		},
+		{
+			desc: "changed pod IP",
+			apply: func() {
+				setObj(fakePod("app", "10.1.0.2", corev1.PodRunning))
+			},
+			objects: map[string]string{"10.1.0.1": "", "10.1.0.2": pod("app")},
+		},
+		{
+			desc: "pod IP taken over by another pod before update of the previous one",
+			apply: func() {
+				setObj(fakePod("next", "10.1.0.2", corev1.PodRunning))
+				setObj(fakePod("app", "10.1.0.2", corev1.PodSucceeded))
+			},
+			objects: map[string]string{"10.1.0.2": pod("next")},
+		},
+		{
+			desc: "finished pod",
+			apply: func() {
+				setObj(fakePod("next", "10.1.0.2", corev1.PodFailed))
+			},
+			objects: map[string]string{"10.1.0.2": ""},
+		},
+		{
+			desc: "service preferred over pod with the same IP",
+			apply: func() {
+				setObj(fakePod("shared", "10.0.0.1", corev1.PodRunning))
+			},
+			objects: map[string]string{"10.0.0.1": svc("redis")},
+		},
+		{
+			desc: "deleted service",
+			apply: func() {
+				api.Delete(fakeObjectPath(fakeService("redis", "10.0.0.1")))
+			},
+			objects: map[string]string{"10.0.0.1": pod("shared")},
+		},
+		{
+			desc: "pod deleted while watch was down (tombstone)",
+			apply: func() {
+				api.DeleteWithoutEvent(fakeObjectPath(fakePod("shared", "10.0.0.1", corev1.PodRunning)))
+				time.Sleep(100 * time.Millisecond) // let informers see expired watches
+				api.Unexpire()
+			},
+			objects: map[string]string{"10.0.0.1": ""},
+		},
+	}
+
+	for _, ex := range examples {
+		if ex.apply != nil {
+			ex.apply()
+		}
+
+		for ipStr, expectedObj := range ex.objects {
+			var obj Object
+			var found bool
+
+			for i := 0; i < 100; i++ {
+				obj, found = index.Object(net.ParseIP(ipStr))
+				if (found && expectedObj == obj.Kind+"/"+obj.Namespace+"/"+obj.Name) || (!found && len(expectedObj) == 0) {
+					break
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+
+			if len(expectedObj) == 0 {
+				if found {
+					t.Fatalf("[%s] Expected IP '%s' to not be found, but was %#v", ex.desc, ipStr, obj)
+				}
+				continue
+			}
+
+			if !found || expectedObj != obj.Kind+"/"+obj.Namespace+"/"+obj.Name {
+				t.Fatalf("[%s] Expected IP '%s' to be %s, but was %#v (found: %t)", ex.desc, ipStr, expectedObj, obj, found)
+			}
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+type ObjectResolver interface {
+	Object(net.IP) (Object, bool)
+}
+
+type ProcessResolver interface {
+	// Process finds local process that owns socket with given local address
+	Process(src *net.TCPAddr) (Process, bool)
+}
+
+// Object is a Kubernetes service or pod that owns destination IP
+type Object struct {
+	Kind      string // svc or pod
+	Namespace string
+	Name      string
+}
+
+type Process struct {
+	PID  int
+	Comm string
+}
+
+type LogOpts struct {
+	Path string
+
+	// Log file is owned by given user so that it's readable without sudo
+	OwnerUID, OwnerGID int
+}
+
+// Log appends one JSON line per finished connection
+type Log struct {
+	objects   ObjectResolver
+	processes ProcessResolver
+
+	file     *os.File
+	enc      *json.Encoder
+	fileLock sync.Mutex
+
+	logTag string
+	logger Logger
+}
+
+func NewLog(opts LogOpts, objects ObjectResolver, processes ProcessResolver, logger Logger) (*Log, error) {
+	file, err := os.OpenFile(opts.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Opening access log: %s", err)
+	}
+
+	if opts.OwnerUID > 0 {
+		err = file.Chown(opts.OwnerUID, opts.OwnerGID)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("Changing access log owner: %s", err)
+		}
+	}
+
+	log := &Log{
+		objects:   objects,
+		processes: processes,
+
+		file: file,
+		enc:  json.NewEncoder(file),
+
+		logTag: "accesslog.Log",
+		logger: logger,
+	}
+
+	return log, nil
+}
+
+// Conn collects details about connection until it's finished
+type Conn struct {
+	entry      Entry
+	acceptedAt time.Time
+
+	process       Process
+	processFound  bool
+	processDoneCh chan struct{}
+}
+
+type ConnResult struct {
+	DialedAt    time.Time // zero if destination was not dialed
+	BytesOut    uint64
+	BytesIn     uint64
+	CloseReason string
+	Err         error
+}
+
+// Start looks up source process right away since its socket
+// may be gone by the time connection is finished
+func (l *Log) Start(id uint64, src, dst *net.TCPAddr, acceptedAt time.Time) *Conn {
+	conn := &Conn{
+		entry: Entry{
+			ID:  id,
+			Src: src.String(),
+			Dst: dst.String(),
+		},
+		acceptedAt:    acceptedAt,
+		processDoneCh: make(chan struct{}),
+	}
+
+	go func() {
+		conn.process, conn.processFound = l.processes.Process(src)
+		close(conn.processDoneCh)
+	}()
+
+	if object, found := l.objects.Object(dst.IP); found {
+		conn.entry.DstKind = object.Kind
+		conn.entry.DstNamespace = object.Namespace
+		conn.entry.DstName = object.Name
+	}
+
+	return conn
+}
+
+func (l *Log) Finish(conn *Conn, result ConnResult) {
+	now := time.Now()
+	entry := conn.entry
+
+	entry.Time = now
+	entry.BytesOut = result.BytesOut
+	entry.BytesIn = result.BytesIn
+	entry.CloseReason = result.CloseReason
+
+	if result.DialedAt.IsZero() {
+		entry.DialSeconds = now.Sub(conn.acceptedAt).Seconds()
+	} else {
+		entry.DialSeconds = result.DialedAt.Sub(conn.acceptedAt).Seconds()
+		entry.DurationSeconds = now.Sub(result.DialedAt).Seconds()
+	}
+
+	if result.Err != nil {
+		entry.Error = result.Err.Error()
+	}
+
+	<-conn.processDoneCh
+
+	if conn.processFound {
+		entry.SrcPID = conn.process.PID
+		entry.SrcComm = conn.process.Comm
+	}
+
+	l.fileLock.Lock()
+	defer l.fileLock.Unlock()
+
+	err := l.enc.Encode(entry)
+	if err != nil {
+		l.logger.Error(l.logTag, "Failed writing access log entry: %s", err)
+	}
+}
+
+// Close also stops object resolver if it's running in the background
+func (l *Log) Close() error {
+	if stopper, ok := l.objects.(interface{ Stop() }); ok {
+		stopper.Stop()
+	}
+
+	l.fileLock.Lock()
+	defer l.fileLock.Unlock()
+
+	return l.file.Close()
+}
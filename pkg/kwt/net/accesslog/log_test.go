@@ -0,0 +1,129 @@
+package accesslog_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/logtest"
+	. "github.com/carvel-dev/kwt/pkg/kwt/net/accesslog"
+)
+
+type fakeObjectResolver map[string]Object
+
+func (r fakeObjectResolver) Object(ip net.IP) (Object, bool) {
+	obj, found := r[ip.String()]
+	return obj, found
+}
+
+func TestLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kwt-access-log")
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	objects := fakeObjectResolver{"10.0.0.5": Object{Kind: "svc", Namespace: "app1", Name: "redis"}}
+
+	log, err := NewLog(LogOpts{Path: filepath.Join(dir, "access.jsonl")}, objects, NewProcessResolver(), logtest.NoopLogger{})
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	// Source socket owned by this process so that it can be found in /proc
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer listener.Close()
+
+	srcConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer srcConn.Close()
+
+	src := srcConn.LocalAddr().(*net.TCPAddr)
+	acceptedAt := time.Now().Add(-2 * time.Second)
+
+	conn := log.Start(1, src, &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 6379}, acceptedAt)
+	log.Finish(conn, ConnResult{
+		DialedAt:    acceptedAt.Add(time.Second),
+		BytesOut:    10,
+		BytesIn:     20,
+		CloseReason: CloseReasonEOF,
+	})
+
+	conn = log.Start(2, src, &net.TCPAddr{IP: net.ParseIP("10.0.0.6"), Port: 80}, acceptedAt)
+	log.Finish(conn, ConnResult{CloseReason: CloseReasonDialFailed, Err: fmt.Errorf("connection refused")})
+
+	err = log.Close()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	entries := readEntries(t, filepath.Join(dir, "access.jsonl"))
+
+	if len(entries) != 2 {
+		t.Fatalf("Expected two entries, but was %#v", entries)
+	}
+
+	entry := entries[0]
+
+	if entry.ID != 1 || entry.Src != src.String() || entry.Dst != "10.0.0.5:6379" ||
+		entry.DstKind != "svc" || entry.DstNamespace != "app1" || entry.DstName != "redis" ||
+		entry.BytesOut != 10 || entry.BytesIn != 20 || entry.CloseReason != "eof" {
+		t.Fatalf("Expected entry to match, but was %#v", entry)
+	}
+
+	if entry.DialSeconds < 0.9 || entry.DialSeconds > 1.1 || entry.DurationSeconds < 0.9 {
+		t.Fatalf("Expected entry timings to match, but was %#v", entry)
+	}
+
+	if runtime.GOOS == "linux" && (entry.SrcPID != os.Getpid() || len(entry.SrcComm) == 0) {
+		t.Fatalf("Expected entry source process to be found, but was %#v", entry)
+	}
+
+	entry = entries[1]
+
+	if entry.Dst != "10.0.0.6:80" || len(entry.DstKind) > 0 || entry.DurationSeconds != 0 ||
+		entry.DialSeconds < 1.9 || entry.CloseReason != "dial_failed" || entry.Error != "connection refused" {
+		t.Fatalf("Expected failed entry to match, but was %#v", entry)
+	}
+}
+
+func readEntries(t *testing.T, path string) []Entry {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer file.Close()
+
+	var entries []Entry
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		var entry Entry
+
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			t.Fatalf("Expected no err: %s", err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
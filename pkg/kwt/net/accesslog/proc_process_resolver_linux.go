@@ -0,0 +1,128 @@
+//go:build linux
+// +build linux
+
+package accesslog
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ProcProcessResolver finds socket inode in /proc/net/tcp{,6}
+// and then process that has file descriptor pointing to that inode
+type ProcProcessResolver struct {
+	procPath string
+}
+
+var _ ProcessResolver = ProcProcessResolver{}
+
+func NewProcessResolver() ProcessResolver { return ProcProcessResolver{"/proc"} }
+
+func (r ProcProcessResolver) Process(src *net.TCPAddr) (Process, bool) {
+	var inode string
+
+	for _, name := range []string{"tcp", "tcp6"} {
+		inode = r.socketInode(filepath.Join(r.procPath, "net", name), src)
+		if len(inode) > 0 {
+			break
+		}
+	}
+
+	if len(inode) == 0 {
+		return Process{}, false
+	}
+
+	pid, found := r.socketPID(inode)
+	if !found {
+		return Process{}, false
+	}
+
+	comm, err := ioutil.ReadFile(filepath.Join(r.procPath, strconv.Itoa(pid), "comm"))
+	if err != nil {
+		return Process{PID: pid}, true
+	}
+
+	return Process{PID: pid, Comm: strings.TrimSpace(string(comm))}, true
+}
+
+// socketInode returns inode of socket with given local address, eg
+// "  1: 0100007F:15B3 0100007F:0050 01 00000000:00000000 00:00000000 00000000  1000  0 12345 ..."
+func (r ProcProcessResolver) socketInode(path string, local *net.TCPAddr) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // skip header
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+
+		ip, port, ok := r.parseAddr(fields[1])
+		if ok && port == local.Port && ip.Equal(local.IP) && fields[9] != "0" {
+			return fields[9]
+		}
+	}
+
+	return ""
+}
+
+// parseAddr parses hex encoded address where IP is stored
+// as a sequence of 32-bit words in host (little endian) byte order
+func (ProcProcessResolver) parseAddr(addr string) (net.IP, int, bool) {
+	pieces := strings.Split(addr, ":")
+	if len(pieces) != 2 {
+		return nil, 0, false
+	}
+
+	ipBytes, err := hex.DecodeString(pieces[0])
+	if err != nil || (len(ipBytes) != net.IPv4len && len(ipBytes) != net.IPv6len) {
+		return nil, 0, false
+	}
+
+	for i := 0; i < len(ipBytes); i += 4 {
+		ipBytes[i], ipBytes[i+1], ipBytes[i+2], ipBytes[i+3] = ipBytes[i+3], ipBytes[i+2], ipBytes[i+1], ipBytes[i]
+	}
+
+	port, err := strconv.ParseUint(pieces[1], 16, 16)
+	if err != nil {
+		return nil, 0, false
+	}
+
+	return net.IP(ipBytes), int(port), true
+}
+
+func (r ProcProcessResolver) socketPID(inode string) (int, bool) {
+	fdPaths, err := filepath.Glob(filepath.Join(r.procPath, "[0-9]*", "fd", "*"))
+	if err != nil {
+		return 0, false
+	}
+
+	link := "socket:[" + inode + "]"
+
+	for _, fdPath := range fdPaths {
+		target, err := os.Readlink(fdPath)
+		if err != nil || target != link {
+			continue // process may have exited or is not accessible
+		}
+
+		pid, err := strconv.Atoi(filepath.Base(filepath.Dir(filepath.Dir(fdPath))))
+		if err == nil {
+			return pid, true
+		}
+	}
+
+	return 0, false
+}
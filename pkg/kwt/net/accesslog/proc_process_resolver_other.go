@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package accesslog
+
+import (
+	"net"
+)
+
+// Source processes are only looked up on Linux via /proc
+type noopProcessResolver struct{}
+
+var _ ProcessResolver = noopProcessResolver{}
+
+func NewProcessResolver() ProcessResolver { return noopProcessResolver{} }
+
+func (noopProcessResolver) Process(*net.TCPAddr) (Process, bool) { return Process{}, false }
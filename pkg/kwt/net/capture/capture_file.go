@@ -0,0 +1,112 @@
+package capture
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type CapturedPacket struct {
+	Time time.Time
+	TCPPacket
+}
+
+// CaptureFile summarizes single capture file
+type CaptureFile struct {
+	Path      string
+	Src       string
+	Dst       string
+	StartedAt time.Time
+	Duration  time.Duration
+	Packets   int
+	BytesOut  int
+	BytesIn   int
+}
+
+func ListCaptureFiles(dir string) ([]CaptureFile, error) {
+	fileInfos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Reading capture directory: %s", err)
+	}
+
+	var result []CaptureFile
+
+	for _, fileInfo := range fileInfos {
+		if fileInfo.IsDir() || !strings.HasSuffix(fileInfo.Name(), FileExt) {
+			continue
+		}
+
+		file, err := NewCaptureFile(filepath.Join(dir, fileInfo.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, file)
+	}
+
+	return result, nil
+}
+
+func NewCaptureFile(path string) (CaptureFile, error) {
+	packets, err := ReadCaptureFile(path)
+	if err != nil {
+		return CaptureFile{}, err
+	}
+
+	file := CaptureFile{Path: path, Packets: len(packets)}
+
+	if len(packets) > 0 {
+		first := packets[0]
+
+		file.Src = first.Src.String()
+		file.Dst = first.Dst.String()
+		file.StartedAt = first.Time
+		file.Duration = packets[len(packets)-1].Time.Sub(first.Time)
+
+		for _, packet := range packets {
+			if packet.Src.String() == file.Src {
+				file.BytesOut += len(packet.Payload)
+			} else {
+				file.BytesIn += len(packet.Payload)
+			}
+		}
+	}
+
+	return file, nil
+}
+
+// ReadCaptureFile tolerates truncated file since
+// captures of active connections are still being written
+func ReadCaptureFile(path string) ([]CapturedPacket, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Opening capture file: %s", err)
+	}
+
+	defer file.Close()
+
+	reader := NewPcapngReader(file)
+
+	var result []CapturedPacket
+
+	for {
+		ts, data, err := reader.ReadPacket()
+		if err != nil {
+			if err == io.EOF || len(result) > 0 {
+				return result, nil
+			}
+			return nil, fmt.Errorf("Reading capture file '%s': %s", path, err)
+		}
+
+		packet, err := ParseTCPPacket(data)
+		if err != nil {
+			return nil, fmt.Errorf("Reading capture file '%s': %s", path, err)
+		}
+
+		result = append(result, CapturedPacket{ts, packet})
+	}
+}
@@ -0,0 +1,106 @@
+package capture
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	FileExt = ".pcapng"
+)
+
+type TargetResolver interface {
+	IPs(Filter) ([]net.IP, error)
+}
+
+type CapturerOpts struct {
+	Dir     string
+	Filters []Filter // all connections are captured if empty
+
+	// Capture files are owned by given user so that they are readable without sudo
+	OwnerUID, OwnerGID int
+}
+
+// Capturer records connections matching filters into separate files
+type Capturer struct {
+	opts     CapturerOpts
+	resolver TargetResolver
+
+	logTag string
+	logger Logger
+}
+
+func NewCapturer(opts CapturerOpts, resolver TargetResolver, logger Logger) (*Capturer, error) {
+	err := os.MkdirAll(opts.Dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("Creating capture directory: %s", err)
+	}
+
+	if opts.OwnerUID > 0 {
+		err = os.Chown(opts.Dir, opts.OwnerUID, opts.OwnerGID)
+		if err != nil {
+			return nil, fmt.Errorf("Changing capture directory owner: %s", err)
+		}
+	}
+
+	return &Capturer{opts, resolver, "Capturer", logger}, nil
+}
+
+// Start returns nil if connection should not be captured
+func (c *Capturer) Start(id uint64, src, dst *net.TCPAddr) *ConnCapture {
+	if !c.matches(dst.IP) {
+		return nil
+	}
+
+	name := fmt.Sprintf("%s-%d%s", time.Now().UTC().Format("20060102T150405.000000"), id, FileExt)
+	path := filepath.Join(c.opts.Dir, name)
+
+	capture, err := NewConnCapture(path, src, dst)
+	if err != nil {
+		c.logger.Error(c.logTag, "Failed starting capture of %s->%s: %s", src, dst, err)
+		return nil
+	}
+
+	if c.opts.OwnerUID > 0 {
+		err = os.Chown(path, c.opts.OwnerUID, c.opts.OwnerGID)
+		if err != nil {
+			c.logger.Error(c.logTag, "Failed changing capture file owner: %s", err)
+		}
+	}
+
+	c.logger.Info(c.logTag, "Capturing %s->%s into '%s'", src, dst, path)
+
+	return capture
+}
+
+func (c *Capturer) matches(ip net.IP) bool {
+	if len(c.opts.Filters) == 0 {
+		return true
+	}
+
+	for _, filter := range c.opts.Filters {
+		if filter.Kind == FilterKindSubnet {
+			if filter.Subnet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		ips, err := c.resolver.IPs(filter)
+		if err != nil {
+			c.logger.Error(c.logTag, "Failed resolving capture filter '%s': %s", filter, err)
+			continue
+		}
+
+		for _, filterIP := range ips {
+			if filterIP.Equal(ip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
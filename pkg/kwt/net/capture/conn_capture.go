@@ -0,0 +1,160 @@
+package capture
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+type Direction int
+
+const (
+	DirectionOut Direction = iota // from source to destination
+	DirectionIn
+)
+
+// ConnCapture records a proxied TCP stream as synthesized packets:
+// handshake, data segments for each direction and FINs when directions end
+type ConnCapture struct {
+	path   string
+	file   *os.File
+	writer *PcapngWriter
+
+	src, dst      *net.TCPAddr
+	seqOut, seqIn uint32
+	finOut, finIn bool
+	closed        bool
+	err           error
+	lock          sync.Mutex
+}
+
+func NewConnCapture(path string, src, dst *net.TCPAddr) (*ConnCapture, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Creating capture file: %s", err)
+	}
+
+	writer, err := NewPcapngWriter(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	c := &ConnCapture{path: path, file: file, writer: writer, src: src, dst: dst}
+
+	now := time.Now()
+
+	c.writePacket(now, DirectionOut, TCPFlagSYN, nil)
+	c.seqOut++
+	c.writePacket(now, DirectionIn, TCPFlagSYN|TCPFlagACK, nil)
+	c.seqIn++
+	c.writePacket(now, DirectionOut, TCPFlagACK, nil)
+
+	if c.err != nil {
+		file.Close()
+		return nil, c.err
+	}
+
+	return c, nil
+}
+
+func (c *ConnCapture) Path() string { return c.path }
+
+func (c *ConnCapture) Data(dir Direction, data []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed {
+		return
+	}
+
+	now := time.Now()
+
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > tcpMaxPayloadLen {
+			chunk = chunk[:tcpMaxPayloadLen]
+		}
+
+		c.writePacket(now, dir, TCPFlagPSH|TCPFlagACK, chunk)
+
+		if dir == DirectionOut {
+			c.seqOut += uint32(len(chunk))
+		} else {
+			c.seqIn += uint32(len(chunk))
+		}
+
+		data = data[len(chunk):]
+	}
+}
+
+// Fin records that given direction will not carry more data
+func (c *ConnCapture) Fin(dir Direction) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.fin(dir)
+}
+
+func (c *ConnCapture) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	c.fin(DirectionOut)
+	c.fin(DirectionIn)
+	c.closed = true
+
+	err := c.file.Close()
+	if err != nil {
+		return fmt.Errorf("Closing capture file: %s", err)
+	}
+
+	return c.err
+}
+
+func (c *ConnCapture) fin(dir Direction) {
+	if c.closed {
+		return
+	}
+
+	now := time.Now()
+
+	switch dir {
+	case DirectionOut:
+		if !c.finOut {
+			c.writePacket(now, dir, TCPFlagFIN|TCPFlagACK, nil)
+			c.seqOut++
+			c.finOut = true
+		}
+	case DirectionIn:
+		if !c.finIn {
+			c.writePacket(now, dir, TCPFlagFIN|TCPFlagACK, nil)
+			c.seqIn++
+			c.finIn = true
+		}
+	}
+}
+
+func (c *ConnCapture) writePacket(ts time.Time, dir Direction, flags uint8, payload []byte) {
+	if c.err != nil {
+		return // stop recording after first failure
+	}
+
+	packet := TCPPacket{Src: c.src, Dst: c.dst, Seq: c.seqOut, Ack: c.seqIn, Flags: flags, Payload: payload}
+
+	if dir == DirectionIn {
+		packet = TCPPacket{Src: c.dst, Dst: c.src, Seq: c.seqIn, Ack: c.seqOut, Flags: flags, Payload: payload}
+	}
+
+	if flags&TCPFlagACK == 0 {
+		packet.Ack = 0
+	}
+
+	c.err = c.writer.WritePacket(ts, packet.Marshal())
+}
@@ -0,0 +1,122 @@
+package capture_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/carvel-dev/kwt/pkg/kwt/net/capture"
+)
+
+func TestConnCapture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kwt-capture")
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	src := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 51234}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 80}
+
+	capture, err := NewConnCapture(filepath.Join(dir, "conn"+FileExt), src, dst)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	capture.Data(DirectionOut, []byte("GET / HTTP/1.1\r\n\r\n"))
+	capture.Data(DirectionIn, []byte("HTTP/1.1 200 OK\r\n\r\n"))
+	capture.Fin(DirectionOut)
+
+	err = capture.Close()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	packets, err := ReadCaptureFile(capture.Path())
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	expected := []struct {
+		src, flags, payload string
+		seq, ack            uint32
+	}{
+		{"127.0.0.1:51234", "SYN", "", 0, 0},
+		{"10.0.0.5:80", "SYN,ACK", "", 0, 1},
+		{"127.0.0.1:51234", "ACK", "", 1, 1},
+		{"127.0.0.1:51234", "PSH,ACK", "GET / HTTP/1.1\r\n\r\n", 1, 1},
+		{"10.0.0.5:80", "PSH,ACK", "HTTP/1.1 200 OK\r\n\r\n", 1, 19},
+		{"127.0.0.1:51234", "FIN,ACK", "", 19, 20},
+		{"10.0.0.5:80", "FIN,ACK", "", 20, 20},
+	}
+
+	if len(packets) != len(expected) {
+		t.Fatalf("Expected %d packets, but was %d", len(expected), len(packets))
+	}
+
+	for i, packet := range packets {
+		exp := expected[i]
+		if packet.Src.String() != exp.src || packet.FlagsString() != exp.flags ||
+			string(packet.Payload) != exp.payload || packet.Seq != exp.seq || packet.Ack != exp.ack {
+			t.Fatalf("Expected packet %d to match %#v, but was %s %s %q seq=%d ack=%d",
+				i, exp, packet.Src, packet.FlagsString(), packet.Payload, packet.Seq, packet.Ack)
+		}
+	}
+
+	files, err := ListCaptureFiles(dir)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	if len(files) != 1 || files[0].Src != "127.0.0.1:51234" || files[0].Dst != "10.0.0.5:80" ||
+		files[0].BytesOut != 18 || files[0].BytesIn != 19 {
+		t.Fatalf("Expected capture file summary: %#v", files)
+	}
+}
+
+func TestTCPPacketIPv6(t *testing.T) {
+	packet := TCPPacket{
+		Src:     &net.TCPAddr{IP: net.ParseIP("fd00::1"), Port: 1234},
+		Dst:     &net.TCPAddr{IP: net.ParseIP("fd00::2"), Port: 443},
+		Seq:     5,
+		Flags:   TCPFlagPSH | TCPFlagACK,
+		Payload: []byte("data"),
+	}
+
+	parsed, err := ParseTCPPacket(packet.Marshal())
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	if parsed.Src.String() != "[fd00::1]:1234" || parsed.Dst.String() != "[fd00::2]:443" ||
+		parsed.Seq != 5 || string(parsed.Payload) != "data" {
+		t.Fatalf("Expected parsed packet to match: %#v", parsed)
+	}
+}
+
+func TestNewFilter(t *testing.T) {
+	valid := map[string]string{
+		"svc/app1/redis": "svc/app1/redis",
+		"pod/app1/web-0": "pod/app1/web-0",
+		"10.0.0.5":       "10.0.0.5/32",
+		"10.0.0.0/16":    "10.0.0.0/16",
+		"fd00::1":        "fd00::1/128",
+	}
+
+	for in, out := range valid {
+		filter, err := NewFilter(in)
+		if err != nil || filter.String() != out {
+			t.Fatalf("Expected filter '%s' to be '%s', but was '%s' (err: %s)", in, out, filter, err)
+		}
+	}
+
+	for _, in := range []string{"svc/app1", "svc//redis", "deploy/app1/web", "not-ip"} {
+		_, err := NewFilter(in)
+		if err == nil {
+			t.Fatalf("Expected filter '%s' to be invalid", in)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package capture
+
+import (
+	"io"
+	"net"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
+)
+
+// ConnCopier tees both directions of copied connection into capture
+type ConnCopier struct {
+	copier  dstconn.ConnCopier
+	capture *ConnCapture
+
+	logTag string
+	logger Logger
+}
+
+var _ dstconn.ConnCopier = ConnCopier{}
+
+func NewConnCopier(copier dstconn.ConnCopier, capture *ConnCapture, logger Logger) ConnCopier {
+	return ConnCopier{copier, capture, "capture.ConnCopier", logger}
+}
+
+func (c ConnCopier) CopyAndClose(dstConn, srcConn net.Conn) {
+	c.copier.CopyAndClose(dstConn, &capturingConn{Conn: srcConn, capture: c.capture})
+
+	err := c.capture.Close()
+	if err != nil {
+		c.logger.Error(c.logTag, "Failed recording capture '%s': %s", c.capture.Path(), err)
+	}
+}
+
+// capturingConn wraps source side of proxied connection: data read
+// from it goes to destination and data written to it comes from destination
+type capturingConn struct {
+	net.Conn
+	capture *ConnCapture
+}
+
+func (c *capturingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.capture.Data(DirectionOut, b[:n])
+	}
+	if err == io.EOF {
+		c.capture.Fin(DirectionOut)
+	}
+	return n, err
+}
+
+func (c *capturingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.capture.Data(DirectionIn, b[:n])
+	}
+	return n, err
+}
+
+func (c *capturingConn) CloseWrite() error {
+	c.capture.Fin(DirectionIn)
+
+	if closer, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return closer.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package capture
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	FilterKindService = "svc"
+	FilterKindPod     = "pod"
+	FilterKindSubnet  = "subnet"
+)
+
+// Filter selects connections by destination: service (svc/ns/name),
+// pod (pod/ns/name), IP or subnet
+type Filter struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Subnet    net.IPNet
+}
+
+func NewFilters(filterStrs []string) ([]Filter, error) {
+	var result []Filter
+
+	for _, filterStr := range filterStrs {
+		filter, err := NewFilter(filterStr)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, filter)
+	}
+
+	return result, nil
+}
+
+func NewFilter(filterStr string) (Filter, error) {
+	pieces := strings.Split(filterStr, "/")
+
+	if len(pieces) == 3 && (pieces[0] == FilterKindService || pieces[0] == FilterKindPod) {
+		if len(pieces[1]) == 0 || len(pieces[2]) == 0 {
+			return Filter{}, fmt.Errorf("Expected capture filter '%s' to specify namespace and name", filterStr)
+		}
+		return Filter{Kind: pieces[0], Namespace: pieces[1], Name: pieces[2]}, nil
+	}
+
+	if ip := net.ParseIP(filterStr); ip != nil {
+		bits := 128
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 32
+		}
+		return Filter{Kind: FilterKindSubnet, Subnet: net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}}, nil
+	}
+
+	_, subnet, err := net.ParseCIDR(filterStr)
+	if err == nil {
+		return Filter{Kind: FilterKindSubnet, Subnet: *subnet}, nil
+	}
+
+	return Filter{}, fmt.Errorf("Expected capture filter '%s' to be 'svc/ns/name', 'pod/ns/name', IP or subnet", filterStr)
+}
+
+func (f Filter) String() string {
+	if f.Kind == FilterKindSubnet {
+		return f.Subnet.String()
+	}
+	return f.Kind + "/" + f.Namespace + "/" + f.Name
+}
@@ -0,0 +1,30 @@
+package capture
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/net/kubeips"
+)
+
+// KubeTargetResolver finds IPs of services (including their endpoints) and pods
+type KubeTargetResolver struct {
+	ips *kubeips.Resolver
+}
+
+var _ TargetResolver = KubeTargetResolver{}
+
+func NewKubeTargetResolver(ips *kubeips.Resolver) KubeTargetResolver {
+	return KubeTargetResolver{ips}
+}
+
+func (r KubeTargetResolver) IPs(filter Filter) ([]net.IP, error) {
+	switch filter.Kind {
+	case FilterKindService:
+		return r.ips.ServiceIPs(filter.Namespace, filter.Name)
+	case FilterKindPod:
+		return r.ips.PodIPs(filter.Namespace, filter.Name)
+	default:
+		return nil, fmt.Errorf("Unknown capture filter kind '%s'", filter.Kind)
+	}
+}
@@ -0,0 +1,7 @@
+package capture
+
+type Logger interface {
+	Error(tag, msg string, args ...interface{})
+	Info(tag, msg string, args ...interface{})
+	Debug(tag, msg string, args ...interface{})
+}
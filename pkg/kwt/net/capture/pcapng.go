@@ -0,0 +1,146 @@
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Minimal pcapng support: one section with one interface
+// carrying raw IP packets (https://www.ietf.org/archive/id/draft-tuexen-opsawg-pcapng-05.html)
+const (
+	pcapngSHBType        = 0x0A0D0D0A
+	pcapngIDBType        = 0x00000001
+	pcapngEPBType        = 0x00000006
+	pcapngByteOrderMagic = 0x1A2B3C4D
+	pcapngLinkTypeRaw    = 101
+)
+
+var pcapngEndian = binary.LittleEndian
+
+type PcapngWriter struct {
+	w io.Writer
+}
+
+func NewPcapngWriter(w io.Writer) (*PcapngWriter, error) {
+	writer := &PcapngWriter{w}
+
+	shb := make([]byte, 16)
+	pcapngEndian.PutUint32(shb[0:], pcapngByteOrderMagic)
+	pcapngEndian.PutUint16(shb[4:], 1)                  // major version
+	pcapngEndian.PutUint16(shb[6:], 0)                  // minor version
+	pcapngEndian.PutUint64(shb[8:], 0xFFFFFFFFFFFFFFFF) // unspecified section length
+
+	err := writer.writeBlock(pcapngSHBType, shb)
+	if err != nil {
+		return nil, err
+	}
+
+	idb := make([]byte, 8)
+	pcapngEndian.PutUint16(idb[0:], pcapngLinkTypeRaw)
+	pcapngEndian.PutUint32(idb[4:], 0) // no snap length limit
+
+	err = writer.writeBlock(pcapngIDBType, idb)
+	if err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+// WritePacket records packet with timestamp in microseconds (default resolution)
+func (w *PcapngWriter) WritePacket(ts time.Time, packet []byte) error {
+	micros := uint64(ts.UnixNano() / int64(time.Microsecond))
+
+	epb := make([]byte, 20+pcapngPaddedLen(len(packet)))
+	pcapngEndian.PutUint32(epb[0:], 0) // interface ID
+	pcapngEndian.PutUint32(epb[4:], uint32(micros>>32))
+	pcapngEndian.PutUint32(epb[8:], uint32(micros))
+	pcapngEndian.PutUint32(epb[12:], uint32(len(packet)))
+	pcapngEndian.PutUint32(epb[16:], uint32(len(packet)))
+	copy(epb[20:], packet)
+
+	return w.writeBlock(pcapngEPBType, epb)
+}
+
+func (w *PcapngWriter) writeBlock(blockType uint32, body []byte) error {
+	totalLen := uint32(12 + len(body))
+
+	block := make([]byte, totalLen)
+	pcapngEndian.PutUint32(block[0:], blockType)
+	pcapngEndian.PutUint32(block[4:], totalLen)
+	copy(block[8:], body)
+	pcapngEndian.PutUint32(block[totalLen-4:], totalLen)
+
+	_, err := w.w.Write(block)
+	if err != nil {
+		return fmt.Errorf("Writing capture block: %s", err)
+	}
+
+	return nil
+}
+
+// PcapngReader reads packets written by PcapngWriter;
+// blocks other than enhanced packet blocks are skipped
+type PcapngReader struct {
+	r io.Reader
+}
+
+func NewPcapngReader(r io.Reader) *PcapngReader {
+	return &PcapngReader{r}
+}
+
+// ReadPacket returns io.EOF when there are no more packets
+func (r *PcapngReader) ReadPacket() (time.Time, []byte, error) {
+	for {
+		header := make([]byte, 8)
+
+		_, err := io.ReadFull(r.r, header)
+		if err != nil {
+			if err == io.EOF {
+				return time.Time{}, nil, io.EOF
+			}
+			return time.Time{}, nil, fmt.Errorf("Reading capture block header: %s", err)
+		}
+
+		blockType := pcapngEndian.Uint32(header[0:])
+		totalLen := pcapngEndian.Uint32(header[4:])
+
+		if totalLen < 12 || totalLen%4 != 0 {
+			return time.Time{}, nil, fmt.Errorf("Expected capture block length to be valid, but was %d", totalLen)
+		}
+
+		rest := make([]byte, totalLen-8)
+
+		_, err = io.ReadFull(r.r, rest)
+		if err != nil {
+			return time.Time{}, nil, fmt.Errorf("Reading capture block: %s", err)
+		}
+
+		if blockType == pcapngSHBType && pcapngEndian.Uint32(rest[0:]) != pcapngByteOrderMagic {
+			return time.Time{}, nil, fmt.Errorf("Expected capture to be little endian pcapng")
+		}
+
+		if blockType != pcapngEPBType {
+			continue
+		}
+
+		if len(rest) < 24 {
+			return time.Time{}, nil, fmt.Errorf("Expected enhanced packet block to be at least 32 bytes")
+		}
+
+		micros := uint64(pcapngEndian.Uint32(rest[4:]))<<32 | uint64(pcapngEndian.Uint32(rest[8:]))
+		capturedLen := pcapngEndian.Uint32(rest[12:])
+
+		if int(capturedLen) > len(rest)-24 {
+			return time.Time{}, nil, fmt.Errorf("Expected captured packet length to fit into block")
+		}
+
+		ts := time.Unix(0, int64(micros)*int64(time.Microsecond))
+
+		return ts, rest[20 : 20+capturedLen], nil
+	}
+}
+
+func pcapngPaddedLen(l int) int { return (l + 3) &^ 3 }
@@ -0,0 +1,186 @@
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	TCPFlagFIN uint8 = 0x01
+	TCPFlagSYN uint8 = 0x02
+	TCPFlagRST uint8 = 0x04
+	TCPFlagPSH uint8 = 0x08
+	TCPFlagACK uint8 = 0x10
+
+	tcpHeaderLen  = 20
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	ipProtoTCP    = 6
+
+	// Keeps IPv4 total length within 16 bits
+	tcpMaxPayloadLen = 65535 - ipv4HeaderLen - tcpHeaderLen
+)
+
+// TCPPacket is a synthesized TCP segment; proxied streams do not
+// carry original packets so only addresses, flags and data are meaningful
+type TCPPacket struct {
+	Src     *net.TCPAddr
+	Dst     *net.TCPAddr
+	Seq     uint32
+	Ack     uint32
+	Flags   uint8
+	Payload []byte
+}
+
+func (p TCPPacket) FlagsString() string {
+	names := []struct {
+		flag uint8
+		name string
+	}{
+		{TCPFlagSYN, "SYN"},
+		{TCPFlagFIN, "FIN"},
+		{TCPFlagRST, "RST"},
+		{TCPFlagPSH, "PSH"},
+		{TCPFlagACK, "ACK"},
+	}
+
+	var result []string
+
+	for _, n := range names {
+		if p.Flags&n.flag != 0 {
+			result = append(result, n.name)
+		}
+	}
+
+	return strings.Join(result, ",")
+}
+
+func (p TCPPacket) Marshal() []byte {
+	tcp := make([]byte, tcpHeaderLen+len(p.Payload))
+	binary.BigEndian.PutUint16(tcp[0:], uint16(p.Src.Port))
+	binary.BigEndian.PutUint16(tcp[2:], uint16(p.Dst.Port))
+	binary.BigEndian.PutUint32(tcp[4:], p.Seq)
+	binary.BigEndian.PutUint32(tcp[8:], p.Ack)
+	tcp[12] = (tcpHeaderLen / 4) << 4
+	tcp[13] = p.Flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535) // window
+	copy(tcp[tcpHeaderLen:], p.Payload)
+
+	srcIP4, dstIP4 := p.Src.IP.To4(), p.Dst.IP.To4()
+
+	if srcIP4 != nil && dstIP4 != nil {
+		pseudo := make([]byte, 12)
+		copy(pseudo[0:], srcIP4)
+		copy(pseudo[4:], dstIP4)
+		pseudo[9] = ipProtoTCP
+		binary.BigEndian.PutUint16(pseudo[10:], uint16(len(tcp)))
+		binary.BigEndian.PutUint16(tcp[16:], checksum(pseudo, tcp))
+
+		ip := make([]byte, ipv4HeaderLen, ipv4HeaderLen+len(tcp))
+		ip[0] = 0x45 // version 4, header length 5 words
+		binary.BigEndian.PutUint16(ip[2:], uint16(ipv4HeaderLen+len(tcp)))
+		ip[8] = 64 // TTL
+		ip[9] = ipProtoTCP
+		copy(ip[12:], srcIP4)
+		copy(ip[16:], dstIP4)
+		binary.BigEndian.PutUint16(ip[10:], checksum(ip))
+
+		return append(ip, tcp...)
+	}
+
+	srcIP16, dstIP16 := p.Src.IP.To16(), p.Dst.IP.To16()
+
+	pseudo := make([]byte, 40)
+	copy(pseudo[0:], srcIP16)
+	copy(pseudo[16:], dstIP16)
+	binary.BigEndian.PutUint32(pseudo[32:], uint32(len(tcp)))
+	pseudo[39] = ipProtoTCP
+	binary.BigEndian.PutUint16(tcp[16:], checksum(pseudo, tcp))
+
+	ip := make([]byte, ipv6HeaderLen, ipv6HeaderLen+len(tcp))
+	ip[0] = 0x60 // version 6
+	binary.BigEndian.PutUint16(ip[4:], uint16(len(tcp)))
+	ip[6] = ipProtoTCP
+	ip[7] = 64 // hop limit
+	copy(ip[8:], srcIP16)
+	copy(ip[24:], dstIP16)
+
+	return append(ip, tcp...)
+}
+
+func ParseTCPPacket(packet []byte) (TCPPacket, error) {
+	if len(packet) == 0 {
+		return TCPPacket{}, fmt.Errorf("Expected packet to be non-empty")
+	}
+
+	var srcIP, dstIP net.IP
+	var tcp []byte
+
+	switch packet[0] >> 4 {
+	case 4:
+		headerLen := int(packet[0]&0x0f) * 4
+		if len(packet) < headerLen || headerLen < ipv4HeaderLen || packet[9] != ipProtoTCP {
+			return TCPPacket{}, fmt.Errorf("Expected packet to be IPv4 TCP packet")
+		}
+		srcIP, dstIP = net.IP(packet[12:16]), net.IP(packet[16:20])
+		tcp = packet[headerLen:]
+
+	case 6:
+		if len(packet) < ipv6HeaderLen || packet[6] != ipProtoTCP {
+			return TCPPacket{}, fmt.Errorf("Expected packet to be IPv6 TCP packet")
+		}
+		srcIP, dstIP = net.IP(packet[8:24]), net.IP(packet[24:40])
+		tcp = packet[ipv6HeaderLen:]
+
+	default:
+		return TCPPacket{}, fmt.Errorf("Expected packet to be IPv4 or IPv6 packet")
+	}
+
+	if len(tcp) < tcpHeaderLen {
+		return TCPPacket{}, fmt.Errorf("Expected packet to include TCP header")
+	}
+
+	dataOffset := int(tcp[12]>>4) * 4
+	if dataOffset < tcpHeaderLen || dataOffset > len(tcp) {
+		return TCPPacket{}, fmt.Errorf("Expected TCP header to have valid data offset")
+	}
+
+	return TCPPacket{
+		Src:     &net.TCPAddr{IP: srcIP, Port: int(binary.BigEndian.Uint16(tcp[0:]))},
+		Dst:     &net.TCPAddr{IP: dstIP, Port: int(binary.BigEndian.Uint16(tcp[2:]))},
+		Seq:     binary.BigEndian.Uint32(tcp[4:]),
+		Ack:     binary.BigEndian.Uint32(tcp[8:]),
+		Flags:   tcp[13],
+		Payload: tcp[dataOffset:],
+	}, nil
+}
+
+// checksum is the Internet checksum (RFC 1071) over concatenated parts
+func checksum(parts ...[]byte) uint16 {
+	var sum uint32
+	var odd bool
+	var last byte
+
+	for _, part := range parts {
+		for _, b := range part {
+			if odd {
+				sum += uint32(last)<<8 | uint32(b)
+			} else {
+				last = b
+			}
+			odd = !odd
+		}
+	}
+
+	if odd {
+		sum += uint32(last) << 8
+	}
+
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+
+	return ^uint16(sum)
+}
@@ -8,6 +8,8 @@ type ConfiguredSubnets struct {
 	subnets []string
 }
 
+var _ Subnets = ConfiguredSubnets{}
+
 func NewConfiguredSubnets(subnets []string) ConfiguredSubnets {
 	return ConfiguredSubnets{subnets}
 }
@@ -25,3 +27,8 @@ func (s ConfiguredSubnets) Subnets() ([]net.IPNet, error) {
 
 	return result, nil
 }
+
+func (s ConfiguredSubnets) Watch(_ func([]net.IPNet), doneCh chan struct{}) error {
+	<-doneCh // configured subnets never change
+	return nil
+}
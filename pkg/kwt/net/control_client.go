@@ -0,0 +1,130 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ControlClient talks to ControlServer of a running networking session
+type ControlClient struct {
+	socketPath string
+	client     *http.Client
+}
+
+func NewControlClient(socketPath string) ControlClient {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+
+	return ControlClient{socketPath, client}
+}
+
+func (c ControlClient) DNSMappings() ([]ControlDNSMapping, error) {
+	var result []ControlDNSMapping
+	err := c.request(http.MethodGet, "/dns-mappings", nil, &result)
+	return result, err
+}
+
+func (c ControlClient) AddDNSMapping(domain string, ips []string) error {
+	return c.request(http.MethodPost, "/dns-mappings", ControlDNSMapping{Domain: domain, IPs: ips}, nil)
+}
+
+func (c ControlClient) RemoveDNSMapping(domain string) error {
+	return c.request(http.MethodDelete, "/dns-mappings?domain="+url.QueryEscape(domain), nil, nil)
+}
+
+func (c ControlClient) AddSubnets(subnets []string) error {
+	return c.request(http.MethodPost, "/subnets", ControlSubnetsRequest{subnets}, nil)
+}
+
+func (c ControlClient) RemoveSubnets(subnets []string) error {
+	return c.request(http.MethodDelete, "/subnets", ControlSubnetsRequest{subnets}, nil)
+}
+
+func (c ControlClient) Conns() ([]ProxiedConn, error) {
+	var result []ProxiedConn
+	err := c.request(http.MethodGet, "/conns", nil, &result)
+	return result, err
+}
+
+func (c ControlClient) CloseConn(id uint64) error {
+	return c.request(http.MethodDelete, "/conns?id="+strconv.FormatUint(id, 10), nil, nil)
+}
+
+func (c ControlClient) FaultRules() ([]string, error) {
+	var result []string
+	err := c.request(http.MethodGet, "/faults", nil, &result)
+	return result, err
+}
+
+func (c ControlClient) SetFaultRules(rules []string) error {
+	return c.request(http.MethodPut, "/faults", ControlFaultsRequest{rules}, nil)
+}
+
+func (c ControlClient) SetDebug(enabled bool) error {
+	return c.request(http.MethodPut, "/debug", ControlDebugRequest{enabled}, nil)
+}
+
+func (c ControlClient) Status() (SessionStatus, error) {
+	var result SessionStatus
+	err := c.request(http.MethodGet, "/status", nil, &result)
+	return result, err
+}
+
+func (c ControlClient) request(method, path string, reqVal, respVal interface{}) error {
+	var body bytes.Buffer
+
+	if reqVal != nil {
+		err := json.NewEncoder(&body).Encode(reqVal)
+		if err != nil {
+			return fmt.Errorf("Marshaling request: %s", err)
+		}
+	}
+
+	// Host is ignored since requests are made over Unix socket
+	req, err := http.NewRequest(method, "http://kwt"+path, &body)
+	if err != nil {
+		return fmt.Errorf("Building request: %s", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Connecting to control socket '%s' (is 'kwt net start' running?): %s", c.socketPath, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp controlErrorResponse
+
+		err := json.NewDecoder(resp.Body).Decode(&errResp)
+		if err != nil {
+			return fmt.Errorf("Request failed with status %d", resp.StatusCode)
+		}
+
+		return fmt.Errorf("%s", errResp.Error)
+	}
+
+	if respVal != nil {
+		err := json.NewDecoder(resp.Body).Decode(respVal)
+		if err != nil {
+			return fmt.Errorf("Unmarshaling response: %s", err)
+		}
+	}
+
+	return nil
+}
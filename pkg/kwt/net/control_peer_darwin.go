@@ -0,0 +1,39 @@
+//go:build darwin
+// +build darwin
+
+package net
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// controlPeerUID returns uid of connected process via LOCAL_PEERCRED
+func controlPeerUID(conn net.Conn) (int, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return -1, fmt.Errorf("Expected unix connection")
+	}
+
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+
+	var cred *unix.Xucred
+	var credErr error
+
+	err = rawConn.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	})
+	if err != nil {
+		return -1, err
+	}
+	if credErr != nil {
+		return -1, credErr
+	}
+
+	return int(cred.Uid), nil
+}
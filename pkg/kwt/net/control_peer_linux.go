@@ -0,0 +1,39 @@
+//go:build linux
+// +build linux
+
+package net
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// controlPeerUID returns uid of connected process via SO_PEERCRED
+func controlPeerUID(conn net.Conn) (int, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return -1, fmt.Errorf("Expected unix connection")
+	}
+
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+
+	var cred *unix.Ucred
+	var credErr error
+
+	err = rawConn.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return -1, err
+	}
+	if credErr != nil {
+		return -1, credErr
+	}
+
+	return int(cred.Uid), nil
+}
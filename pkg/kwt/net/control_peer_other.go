@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package net
+
+import (
+	"net"
+)
+
+// controlPeerUID is unknown (-1) hence only socket permissions apply
+func controlPeerUID(net.Conn) (int, error) { return -1, nil }
@@ -0,0 +1,575 @@
+package net
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+type ControlDNS interface {
+	DNSMappings() (map[string]string, error)
+	AddDNSMapping(domain string, ips []net.IP) error
+	RemoveDNSMapping(domain string) error
+}
+
+type ControlSubnets interface {
+	AddSubnets([]net.IPNet) error
+	RemoveSubnets([]net.IPNet) error
+}
+
+type ControlConns interface {
+	Conns() []ProxiedConn
+	CloseConn(id uint64) bool
+}
+
+type ControlLogger interface {
+	SetDebug(bool)
+}
+
+type ControlFaults interface {
+	FaultRules() []string
+	SetFaultRules([]string) error
+}
+
+type ControlStatus interface {
+	Status() (SessionStatus, error)
+}
+
+type ControlServerOpts struct {
+	SocketPath string
+
+	// Socket is owned by given user so that commands do not need sudo
+	OwnerUID, OwnerGID int
+
+	DNS     ControlDNS
+	Subnets ControlSubnets
+	Conns   ControlConns
+	Logger  ControlLogger
+	Faults  ControlFaults
+	Status  ControlStatus
+}
+
+type ControlDNSMapping struct {
+	Domain string   `json:"domain"`
+	Target string   `json:"target,omitempty"`
+	IPs    []string `json:"ips,omitempty"`
+}
+
+type ControlSubnetsRequest struct {
+	Subnets []string `json:"subnets"`
+}
+
+type ControlFaultsRequest struct {
+	Rules []string `json:"rules"`
+}
+
+type ControlDebugRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+type controlErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// ControlServer exposes HTTP API on a Unix socket
+// for changing settings of running networking session
+type ControlServer struct {
+	opts     ControlServerOpts
+	listener net.Listener
+	server   *http.Server
+
+	logTag string
+	logger Logger
+}
+
+const (
+	// controlSocketRootDir holds sockets of sessions started with sudo.
+	// It's only writable by root but is not 0700 so that socket owners
+	// can reach their sockets without sudo (sockets themselves are 0600).
+	controlSocketRootDir = "/var/run/kwt"
+)
+
+func DefaultControlSocketPath() string {
+	return filepath.Join(controlSocketRootDir, "kwt-net.sock")
+}
+
+// ListenControlSocketPath is unique per process since
+// multiple 'kwt net listen' may run at the same time
+func ListenControlSocketPath() string {
+	return filepath.Join(controlSocketDir(), fmt.Sprintf("kwt-net-listen-%d.sock", os.Getpid()))
+}
+
+// ControlSocketPaths returns control sockets found at default locations;
+// some of them may be left by processes that are no longer running
+func ControlSocketPaths() ([]string, error) {
+	var result []string
+
+	for _, dir := range []string{controlSocketRootDir, controlSocketUserDir()} {
+		paths, err := filepath.Glob(filepath.Join(dir, "kwt-net*.sock"))
+		if err != nil {
+			return nil, fmt.Errorf("Finding control sockets: %s", err)
+		}
+		result = append(result, paths...)
+	}
+
+	return result, nil
+}
+
+// controlSocketDir falls back to per user directory
+// for processes that do not run under sudo
+func controlSocketDir() string {
+	if os.Geteuid() == 0 {
+		return controlSocketRootDir
+	}
+	return controlSocketUserDir()
+}
+
+func controlSocketUserDir() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("kwt-%d", os.Getuid()))
+}
+
+func NewControlServer(opts ControlServerOpts, logger Logger) *ControlServer {
+	return &ControlServer{opts: opts, logTag: "ControlServer", logger: logger}
+}
+
+func (s *ControlServer) Start() error {
+	err := s.prepareSocketDir()
+	if err != nil {
+		return err
+	}
+
+	err = s.removeStaleSocket()
+	if err != nil {
+		return err
+	}
+
+	listener, err := s.listen()
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dns-mappings", s.handleDNSMappings)
+	mux.HandleFunc("/subnets", s.handleSubnets)
+	mux.HandleFunc("/conns", s.handleConns)
+	mux.HandleFunc("/debug", s.handleDebug)
+	mux.HandleFunc("/faults", s.handleFaults)
+	mux.HandleFunc("/status", s.handleStatus)
+
+	s.listener = controlListener{listener, s.allowedUIDs(), s.logTag, s.logger}
+	s.server = &http.Server{Handler: mux}
+
+	go func() {
+		err := s.server.Serve(s.listener)
+		if err != nil && err != http.ErrServerClosed {
+			s.logger.Error(s.logTag, "Failed serving control socket: %s", err)
+		}
+	}()
+
+	s.logger.Info(s.logTag, "Listening for control commands on %s", s.opts.SocketPath)
+
+	return nil
+}
+
+func (s *ControlServer) Shutdown() error {
+	if s.server != nil {
+		s.server.Close()
+		os.Remove(s.opts.SocketPath)
+	}
+	return nil
+}
+
+// listen binds socket in a private directory and only then moves it
+// into its place so that it's never reachable with default permissions
+func (s *ControlServer) listen() (net.Listener, error) {
+	tmpDir, err := ioutil.TempDir(filepath.Dir(s.opts.SocketPath), ".kwt-net-")
+	if err != nil {
+		return nil, fmt.Errorf("Creating control socket directory: %s", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	tmpPath := filepath.Join(tmpDir, "ctl.sock")
+
+	listener, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, fmt.Errorf("Listening on control socket: %s", err)
+	}
+
+	// Socket is removed on shutdown since it's moved
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	err = os.Chmod(tmpPath, 0600)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("Changing control socket permissions: %s", err)
+	}
+
+	if s.opts.OwnerUID > 0 {
+		err = os.Chown(tmpPath, s.opts.OwnerUID, s.opts.OwnerGID)
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("Changing control socket owner: %s", err)
+		}
+	}
+
+	err = os.Rename(tmpPath, s.opts.SocketPath)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("Moving control socket: %s", err)
+	}
+
+	return listener, nil
+}
+
+// prepareSocketDir makes sure that default directories
+// are not writable by anyone else but their owner
+func (s *ControlServer) prepareSocketDir() error {
+	dir := filepath.Dir(s.opts.SocketPath)
+
+	var mode os.FileMode
+
+	switch dir {
+	case controlSocketRootDir:
+		mode = 0755
+	case controlSocketUserDir():
+		mode = 0700
+	default:
+		return nil // custom location is managed by the user
+	}
+
+	err := os.MkdirAll(dir, mode)
+	if err != nil {
+		return fmt.Errorf("Creating control socket directory: %s", err)
+	}
+
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("Checking control socket directory: %s", err)
+	}
+
+	if !fi.IsDir() || fileOwnerUID(fi) != os.Geteuid() {
+		return fmt.Errorf("Expected control socket directory '%s' to be a directory owned by uid %d", dir, os.Geteuid())
+	}
+
+	err = os.Chmod(dir, mode)
+	if err != nil {
+		return fmt.Errorf("Changing control socket directory permissions: %s", err)
+	}
+
+	return nil
+}
+
+// removeStaleSocket removes socket left by a previous run
+// unless another run is still using it or it was not created by kwt
+func (s *ControlServer) removeStaleSocket() error {
+	fi, err := os.Lstat(s.opts.SocketPath)
+	if err != nil {
+		return nil
+	}
+
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("Expected '%s' to be a control socket", s.opts.SocketPath)
+	}
+
+	if !s.allowedUIDs()[fileOwnerUID(fi)] {
+		return fmt.Errorf("Expected control socket '%s' to be owned by current user (owned by uid %d)",
+			s.opts.SocketPath, fileOwnerUID(fi))
+	}
+
+	conn, err := net.DialTimeout("unix", s.opts.SocketPath, 1*time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("Expected control socket '%s' to not be used by another kwt process", s.opts.SocketPath)
+	}
+
+	err = os.Remove(s.opts.SocketPath)
+	if err != nil {
+		return fmt.Errorf("Removing stale control socket: %s", err)
+	}
+
+	return nil
+}
+
+// allowedUIDs includes root, current user and socket owner
+func (s *ControlServer) allowedUIDs() map[int]bool {
+	uids := map[int]bool{0: true, os.Geteuid(): true}
+	if s.opts.OwnerUID > 0 {
+		uids[s.opts.OwnerUID] = true
+	}
+	return uids
+}
+
+func fileOwnerUID(fi os.FileInfo) int {
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Uid)
+	}
+	return -1
+}
+
+// controlListener rejects connections from other users
+// even if socket permissions were changed
+type controlListener struct {
+	net.Listener
+	allowedUIDs map[int]bool
+
+	logTag string
+	logger Logger
+}
+
+func (l controlListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		uid, err := controlPeerUID(conn)
+		if err != nil {
+			l.logger.Error(l.logTag, "Failed checking control socket peer: %s", err)
+			conn.Close()
+			continue
+		}
+
+		if uid >= 0 && !l.allowedUIDs[uid] {
+			l.logger.Error(l.logTag, "Rejecting control socket connection from uid %d", uid)
+			conn.Close()
+			continue
+		}
+
+		return conn, nil
+	}
+}
+
+func (s *ControlServer) handleDNSMappings(w http.ResponseWriter, r *http.Request) {
+	if s.opts.DNS == nil {
+		s.writeErr(w, http.StatusNotImplemented, fmt.Errorf("Expected DNS to be controllable"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		mappings, err := s.opts.DNS.DNSMappings()
+		if err != nil {
+			s.writeErr(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		var result []ControlDNSMapping
+
+		for domain, target := range mappings {
+			result = append(result, ControlDNSMapping{Domain: domain, Target: target})
+		}
+
+		s.writeJSON(w, result)
+
+	case http.MethodPost:
+		var mapping ControlDNSMapping
+
+		err := json.NewDecoder(r.Body).Decode(&mapping)
+		if err != nil {
+			s.writeErr(w, http.StatusBadRequest, fmt.Errorf("Unmarshaling DNS mapping: %s", err))
+			return
+		}
+
+		var ips []net.IP
+
+		for _, ipStr := range mapping.IPs {
+			ip := net.ParseIP(ipStr)
+			if ip == nil {
+				s.writeErr(w, http.StatusBadRequest, fmt.Errorf("Expected DNS mapping to have valid IP '%s'", ipStr))
+				return
+			}
+			ips = append(ips, ip)
+		}
+
+		err = s.opts.DNS.AddDNSMapping(mapping.Domain, ips)
+		if err != nil {
+			s.writeErr(w, http.StatusBadRequest, err)
+			return
+		}
+
+		s.logger.Info(s.logTag, "Added DNS mapping %s->%v", mapping.Domain, mapping.IPs)
+		s.writeJSON(w, nil)
+
+	case http.MethodDelete:
+		domain := r.URL.Query().Get("domain")
+
+		err := s.opts.DNS.RemoveDNSMapping(domain)
+		if err != nil {
+			s.writeErr(w, http.StatusBadRequest, err)
+			return
+		}
+
+		s.logger.Info(s.logTag, "Removed DNS mapping %s", domain)
+		s.writeJSON(w, nil)
+
+	default:
+		s.writeErr(w, http.StatusMethodNotAllowed, fmt.Errorf("Unsupported method '%s'", r.Method))
+	}
+}
+
+func (s *ControlServer) handleSubnets(w http.ResponseWriter, r *http.Request) {
+	if s.opts.Subnets == nil {
+		s.writeErr(w, http.StatusNotImplemented, fmt.Errorf("Expected subnets to be controllable"))
+		return
+	}
+
+	var req ControlSubnetsRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		s.writeErr(w, http.StatusBadRequest, fmt.Errorf("Unmarshaling subnets: %s", err))
+		return
+	}
+
+	subnets, err := NewConfiguredSubnets(req.Subnets).Subnets()
+	if err != nil {
+		s.writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		err = s.opts.Subnets.AddSubnets(subnets)
+	case http.MethodDelete:
+		err = s.opts.Subnets.RemoveSubnets(subnets)
+	default:
+		err = fmt.Errorf("Unsupported method '%s'", r.Method)
+	}
+
+	if err != nil {
+		s.writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.writeJSON(w, nil)
+}
+
+func (s *ControlServer) handleConns(w http.ResponseWriter, r *http.Request) {
+	if s.opts.Conns == nil {
+		s.writeErr(w, http.StatusNotImplemented, fmt.Errorf("Expected connections to be controllable"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.writeJSON(w, s.opts.Conns.Conns())
+
+	case http.MethodDelete:
+		id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			s.writeErr(w, http.StatusBadRequest, fmt.Errorf("Parsing connection ID: %s", err))
+			return
+		}
+
+		if !s.opts.Conns.CloseConn(id) {
+			s.writeErr(w, http.StatusNotFound, fmt.Errorf("Expected connection %d to exist", id))
+			return
+		}
+
+		s.writeJSON(w, nil)
+
+	default:
+		s.writeErr(w, http.StatusMethodNotAllowed, fmt.Errorf("Unsupported method '%s'", r.Method))
+	}
+}
+
+func (s *ControlServer) handleDebug(w http.ResponseWriter, r *http.Request) {
+	if s.opts.Logger == nil {
+		s.writeErr(w, http.StatusNotImplemented, fmt.Errorf("Expected logger to be controllable"))
+		return
+	}
+
+	if r.Method != http.MethodPut {
+		s.writeErr(w, http.StatusMethodNotAllowed, fmt.Errorf("Unsupported method '%s'", r.Method))
+		return
+	}
+
+	var req ControlDebugRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		s.writeErr(w, http.StatusBadRequest, fmt.Errorf("Unmarshaling debug request: %s", err))
+		return
+	}
+
+	s.opts.Logger.SetDebug(req.Enabled)
+	s.logger.Info(s.logTag, "Set debug logging to %t", req.Enabled)
+
+	s.writeJSON(w, nil)
+}
+
+func (s *ControlServer) handleFaults(w http.ResponseWriter, r *http.Request) {
+	if s.opts.Faults == nil {
+		s.writeErr(w, http.StatusNotImplemented, fmt.Errorf("Expected faults to be controllable"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.writeJSON(w, s.opts.Faults.FaultRules())
+
+	case http.MethodPut:
+		var req ControlFaultsRequest
+
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			s.writeErr(w, http.StatusBadRequest, fmt.Errorf("Unmarshaling fault rules: %s", err))
+			return
+		}
+
+		err = s.opts.Faults.SetFaultRules(req.Rules)
+		if err != nil {
+			s.writeErr(w, http.StatusBadRequest, err)
+			return
+		}
+
+		s.writeJSON(w, nil)
+
+	default:
+		s.writeErr(w, http.StatusMethodNotAllowed, fmt.Errorf("Unsupported method '%s'", r.Method))
+	}
+}
+
+func (s *ControlServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if s.opts.Status == nil {
+		s.writeErr(w, http.StatusNotImplemented, fmt.Errorf("Expected status to be reportable"))
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		s.writeErr(w, http.StatusMethodNotAllowed, fmt.Errorf("Unsupported method '%s'", r.Method))
+		return
+	}
+
+	status, err := s.opts.Status.Status()
+	if err != nil {
+		s.writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.writeJSON(w, status)
+}
+
+func (s *ControlServer) writeJSON(w http.ResponseWriter, val interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(val)
+	if err != nil {
+		s.logger.Error(s.logTag, "Failed writing response: %s", err)
+	}
+}
+
+func (s *ControlServer) writeErr(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(controlErrorResponse{err.Error()})
+}
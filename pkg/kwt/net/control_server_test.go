@@ -0,0 +1,240 @@
+package net_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/logtest"
+	. "github.com/carvel-dev/kwt/pkg/kwt/net"
+)
+
+type fakeControlDNS struct {
+	mappings map[string]string
+}
+
+func (d *fakeControlDNS) DNSMappings() (map[string]string, error) { return d.mappings, nil }
+
+func (d *fakeControlDNS) AddDNSMapping(domain string, ips []net.IP) error {
+	d.mappings[domain] = fmt.Sprintf("%s", ips)
+	return nil
+}
+
+func (d *fakeControlDNS) RemoveDNSMapping(domain string) error {
+	if _, found := d.mappings[domain]; !found {
+		return fmt.Errorf("not found")
+	}
+	delete(d.mappings, domain)
+	return nil
+}
+
+type fakeControlSubnets struct {
+	added []net.IPNet
+}
+
+func (s *fakeControlSubnets) AddSubnets(subnets []net.IPNet) error {
+	s.added = append(s.added, subnets...)
+	return nil
+}
+
+func (s *fakeControlSubnets) RemoveSubnets([]net.IPNet) error { return nil }
+
+type fakeControlConns struct {
+	closedID uint64
+}
+
+func (c *fakeControlConns) Conns() []ProxiedConn {
+	return []ProxiedConn{{ID: 5, Src: "127.0.0.1:1234", Dst: "10.0.0.1:80", BytesOut: 10}}
+}
+
+func (c *fakeControlConns) CloseConn(id uint64) bool {
+	c.closedID = id
+	return id == 5
+}
+
+func (c *fakeControlConns) ActiveConns() int { return 1 }
+
+type fakeStatusForwarding struct{}
+
+func (fakeStatusForwarding) ForwardedSubnets() []net.IPNet {
+	_, subnet, _ := net.ParseCIDR("10.0.0.0/16")
+	return []net.IPNet{*subnet}
+}
+
+func (fakeStatusForwarding) InterceptedDNSIPs() []net.IP { return []net.IP{net.ParseIP("8.8.8.8")} }
+
+type fakeControlLogger struct {
+	debug bool
+}
+
+func (l *fakeControlLogger) SetDebug(debug bool) { l.debug = debug }
+
+func TestControlServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kwt-ctl")
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	socketPath := filepath.Join(dir, "kwt-net.sock")
+
+	dns := &fakeControlDNS{map[string]string{}}
+	subnets := &fakeControlSubnets{}
+	conns := &fakeControlConns{}
+	logger := &fakeControlLogger{}
+
+	server := NewControlServer(ControlServerOpts{
+		SocketPath: socketPath,
+		DNS:        dns,
+		Subnets:    subnets,
+		Conns:      conns,
+		Logger:     logger,
+		Status: NewSessionStatusReporter(SessionStatusReporterOpts{
+			Command:    "start",
+			Forwarding: fakeStatusForwarding{},
+			DNS:        dns,
+			Conns:      conns,
+		}),
+	}, logtest.NoopLogger{})
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer server.Shutdown()
+
+	client := NewControlClient(socketPath)
+
+	err = client.AddDNSMapping("example.com", []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	mappings, err := client.DNSMappings()
+	if err != nil || len(mappings) != 1 || mappings[0].Domain != "example.com" {
+		t.Fatalf("Expected added DNS mapping: %#v (err: %s)", mappings, err)
+	}
+
+	err = client.RemoveDNSMapping("other.com")
+	if err == nil || err.Error() != "not found" {
+		t.Fatalf("Expected removal err: %s", err)
+	}
+
+	err = client.AddDNSMapping("example.com", []string{"not-ip"})
+	if err == nil || err.Error() != "Expected DNS mapping to have valid IP 'not-ip'" {
+		t.Fatalf("Expected invalid IP err: %s", err)
+	}
+
+	err = client.AddSubnets([]string{"10.0.0.0/16"})
+	if err != nil || SubnetsAsString(subnets.added) != "10.0.0.0/16" {
+		t.Fatalf("Expected added subnet: %s (err: %s)", SubnetsAsString(subnets.added), err)
+	}
+
+	listedConns, err := client.Conns()
+	if err != nil || len(listedConns) != 1 || listedConns[0].ID != 5 || listedConns[0].BytesOut != 10 {
+		t.Fatalf("Expected listed conns: %#v (err: %s)", listedConns, err)
+	}
+
+	err = client.CloseConn(5)
+	if err != nil || conns.closedID != 5 {
+		t.Fatalf("Expected conn to be closed: %s", err)
+	}
+
+	err = client.CloseConn(6)
+	if err == nil || err.Error() != "Expected connection 6 to exist" {
+		t.Fatalf("Expected missing conn err: %s", err)
+	}
+
+	err = client.SetDebug(true)
+	if err != nil || !logger.debug {
+		t.Fatalf("Expected debug to be enabled: %s", err)
+	}
+
+	status, err := client.Status()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	if status.Command != "start" || status.PID != os.Getpid() || status.ActiveConns != 1 {
+		t.Fatalf("Expected status to describe session: %#v", status)
+	}
+
+	if len(status.Subnets) != 1 || status.Subnets[0] != "10.0.0.0/16" ||
+		len(status.DNSIPs) != 1 || status.DNSIPs[0] != "8.8.8.8" {
+		t.Fatalf("Expected status to include subnets and DNS IPs: %#v", status)
+	}
+
+	if len(status.DNSMappings) != 1 || status.DNSMappings[0].Domain != "example.com" {
+		t.Fatalf("Expected status to include DNS mappings: %#v", status.DNSMappings)
+	}
+
+	err = NewControlServer(ControlServerOpts{SocketPath: socketPath}, logtest.NoopLogger{}).Start()
+	if err == nil {
+		t.Fatalf("Expected err when socket is in use")
+	}
+}
+
+func TestControlServerSocketFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kwt-ctl")
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	socketPath := filepath.Join(dir, "kwt-net.sock")
+
+	err = ioutil.WriteFile(socketPath, []byte("data"), 0600)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	err = NewControlServer(ControlServerOpts{SocketPath: socketPath}, logtest.NoopLogger{}).Start()
+	if err == nil || err.Error() != "Expected '"+socketPath+"' to be a control socket" {
+		t.Fatalf("Expected err for non-socket file: %v", err)
+	}
+
+	if _, err := os.Stat(socketPath); err != nil {
+		t.Fatalf("Expected non-socket file to be kept: %s", err)
+	}
+
+	os.Remove(socketPath)
+
+	server := NewControlServer(ControlServerOpts{SocketPath: socketPath}, logtest.NoopLogger{})
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	fi, err := os.Lstat(socketPath)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0600 {
+		t.Fatalf("Expected socket to be only accessible by its owner: %s", fi.Mode())
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Expected only socket to be left in directory: %#v (err: %v)", entries, err)
+	}
+
+	// Connections from current user pass peer credentials check
+	_, err = NewControlClient(socketPath).Status()
+	if err == nil || err.Error() != "Expected status to be reportable" {
+		t.Fatalf("Expected err from control server: %v", err)
+	}
+
+	server.Shutdown()
+
+	if _, err := os.Lstat(socketPath); !os.IsNotExist(err) {
+		t.Fatalf("Expected socket to be removed on shutdown: %v", err)
+	}
+}
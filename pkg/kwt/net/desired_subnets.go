@@ -0,0 +1,207 @@
+package net
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// DesiredSubnets is the single source of truth for forwarded subnets:
+// subnets watched per route (eg guessed from cluster) combined with
+// subnets added or removed via control socket. Each change updates
+// routing first (so that overlapping routes are rejected) and then
+// passes all desired subnets to updateFunc (eg forwarding proxy).
+//
+// Subnets removed via control socket stay removed even if they are
+// watched again until they are added back via control socket.
+type DesiredSubnets struct {
+	routingFactory *RoutingDstConnFactory
+	updateFunc     func([]net.IPNet) error
+
+	routeNames []string
+	watched    map[string][]net.IPNet
+	ctlAdded   map[string][]net.IPNet
+	ctlRemoved []net.IPNet
+	lock       sync.Mutex
+}
+
+var _ ControlSubnets = &DesiredSubnets{}
+
+func NewDesiredSubnets(routingFactory *RoutingDstConnFactory, updateFunc func([]net.IPNet) error) *DesiredSubnets {
+	return &DesiredSubnets{
+		routingFactory: routingFactory,
+		updateFunc:     updateFunc,
+
+		routeNames: routingFactory.RouteNames(),
+		watched:    routingFactory.RouteSubnets(),
+		ctlAdded:   map[string][]net.IPNet{},
+	}
+}
+
+// UpdateWatched replaces watched subnets of given route
+func (s *DesiredSubnets) UpdateWatched(name string, subnets []net.IPNet) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	prevWatched, prevCtlAdded, prevCtlRemoved := s.snapshot()
+
+	s.watched[name] = subnets
+
+	return s.apply(prevWatched, prevCtlAdded, prevCtlRemoved)
+}
+
+// AddSubnets forwards subnets via route whose subnets overlap
+// given subnet, otherwise via first route
+func (s *DesiredSubnets) AddSubnets(subnets []net.IPNet) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	prevWatched, prevCtlAdded, prevCtlRemoved := s.snapshot()
+
+	for _, subnet := range subnets {
+		s.ctlRemoved = subnetsWithout(s.ctlRemoved, subnet)
+
+		_, watched := s.routeWith(s.watched, subnet)
+		_, added := s.routeWith(s.ctlAdded, subnet)
+
+		if watched || added {
+			continue // already forwarded unless it was removed
+		}
+
+		name := s.routeNames[0]
+
+		for _, routeName := range s.routeNames {
+			if subnetsOverlapAny(s.routeSubnets(routeName), subnet) {
+				name = routeName
+				break
+			}
+		}
+
+		s.ctlAdded[name] = append(s.ctlAdded[name], subnet)
+	}
+
+	return s.apply(prevWatched, prevCtlAdded, prevCtlRemoved)
+}
+
+// RemoveSubnets stops forwarding subnets that were watched or added
+func (s *DesiredSubnets) RemoveSubnets(subnets []net.IPNet) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	prevWatched, prevCtlAdded, prevCtlRemoved := s.snapshot()
+
+	for _, subnet := range subnets {
+		var forwarded bool
+
+		if name, added := s.routeWith(s.ctlAdded, subnet); added {
+			s.ctlAdded[name] = subnetsWithout(s.ctlAdded[name], subnet)
+			forwarded = true
+		}
+
+		if _, watched := s.routeWith(s.watched, subnet); watched {
+			if !subnetsContain(s.ctlRemoved, subnet) {
+				s.ctlRemoved = append(s.ctlRemoved, subnet)
+				forwarded = true
+			}
+		}
+
+		if !forwarded {
+			s.watched, s.ctlAdded, s.ctlRemoved = prevWatched, prevCtlAdded, prevCtlRemoved
+			return fmt.Errorf("Expected subnet '%s' to be forwarded", subnet.String())
+		}
+	}
+
+	return s.apply(prevWatched, prevCtlAdded, prevCtlRemoved)
+}
+
+// apply reverts to given previous state if routing rejects subnets;
+// subnets that fail to be forwarded stay desired so that they are retried
+func (s *DesiredSubnets) apply(prevWatched, prevCtlAdded map[string][]net.IPNet, prevCtlRemoved []net.IPNet) error {
+	allRouteSubnets := map[string][]net.IPNet{}
+
+	for _, name := range s.routeNames {
+		allRouteSubnets[name] = s.routeSubnets(name)
+	}
+
+	err := s.routingFactory.UpdateAllSubnets(allRouteSubnets)
+	if err != nil {
+		s.watched, s.ctlAdded, s.ctlRemoved = prevWatched, prevCtlAdded, prevCtlRemoved
+		return err
+	}
+
+	err = s.updateFunc(s.routingFactory.Subnets())
+	if err != nil {
+		return fmt.Errorf("Forwarding subnets: %s", err)
+	}
+
+	return nil
+}
+
+func (s *DesiredSubnets) routeSubnets(name string) []net.IPNet {
+	var result []net.IPNet
+
+	for _, subnet := range s.watched[name] {
+		if !subnetsContain(s.ctlRemoved, subnet) {
+			result = append(result, subnet)
+		}
+	}
+
+	for _, subnet := range s.ctlAdded[name] {
+		if !subnetsContain(result, subnet) {
+			result = append(result, subnet)
+		}
+	}
+
+	return result
+}
+
+func (s *DesiredSubnets) routeWith(subnetsByRoute map[string][]net.IPNet, subnet net.IPNet) (string, bool) {
+	for _, name := range s.routeNames {
+		if subnetsContain(subnetsByRoute[name], subnet) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (s *DesiredSubnets) snapshot() (map[string][]net.IPNet, map[string][]net.IPNet, []net.IPNet) {
+	watched := map[string][]net.IPNet{}
+	for name, subnets := range s.watched {
+		watched[name] = append([]net.IPNet{}, subnets...)
+	}
+
+	ctlAdded := map[string][]net.IPNet{}
+	for name, subnets := range s.ctlAdded {
+		ctlAdded[name] = append([]net.IPNet{}, subnets...)
+	}
+
+	return watched, ctlAdded, append([]net.IPNet{}, s.ctlRemoved...)
+}
+
+func subnetsContain(subnets []net.IPNet, subnet net.IPNet) bool {
+	for _, existingSubnet := range subnets {
+		if maskedSubnetStr(existingSubnet) == maskedSubnetStr(subnet) {
+			return true
+		}
+	}
+	return false
+}
+
+func subnetsWithout(subnets []net.IPNet, subnet net.IPNet) []net.IPNet {
+	var result []net.IPNet
+	for _, existingSubnet := range subnets {
+		if maskedSubnetStr(existingSubnet) != maskedSubnetStr(subnet) {
+			result = append(result, existingSubnet)
+		}
+	}
+	return result
+}
+
+func subnetsOverlapAny(subnets []net.IPNet, subnet net.IPNet) bool {
+	for _, existingSubnet := range subnets {
+		if SubnetsOverlap(existingSubnet, subnet) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package net_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/logtest"
+	. "github.com/carvel-dev/kwt/pkg/kwt/net"
+)
+
+func newDesiredSubnets(t *testing.T, routes []RoutingDstConnRoute) (*DesiredSubnets, *RoutingDstConnFactory, *fakeIntervalForwarder) {
+	routingFactory, err := NewRoutingDstConnFactory(routes)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	fwd := &fakeIntervalForwarder{}
+	updater := NewSubnetsUpdater(fwd, logtest.NoopLogger{})
+
+	err = updater.Add(routingFactory.Subnets(), nil)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	updateFunc := func(subnets []net.IPNet) error {
+		_, _, err := updater.Update(subnets)
+		return err
+	}
+
+	return NewDesiredSubnets(routingFactory, updateFunc), routingFactory, fwd
+}
+
+func TestDesiredSubnetsCtlChangesKeptAcrossWatchedChanges(t *testing.T) {
+	subnets, _, fwd := newDesiredSubnets(t, []RoutingDstConnRoute{
+		{Subnets: parseSubnets(t, "10.0.0.0/16", "10.1.0.0/16")},
+	})
+
+	expectForwarded := func(desc, expected string) {
+		if SubnetsAsString(fwd.subnets) != expected {
+			t.Fatalf("[%s] Expected forwarded subnets '%s', but was '%s'", desc, expected, SubnetsAsString(fwd.subnets))
+		}
+	}
+
+	err := subnets.AddSubnets(parseSubnets(t, "10.20.0.0/16"))
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	err = subnets.RemoveSubnets(parseSubnets(t, "10.1.0.0/16"))
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	expectForwarded("ctl changes", "10.0.0.0/16, 10.20.0.0/16")
+
+	err = subnets.UpdateWatched("", parseSubnets(t, "10.0.0.0/16", "10.1.0.0/16", "10.2.0.0/16"))
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	// Added subnet is not dropped and removed subnet is not brought back
+	expectForwarded("watched changes", "10.0.0.0/16, 10.20.0.0/16, 10.2.0.0/16")
+
+	// Adding already forwarded subnet does not duplicate it
+	err = subnets.AddSubnets(parseSubnets(t, "10.2.0.0/16"))
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	err = subnets.AddSubnets(parseSubnets(t, "10.1.0.0/16"))
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	expectForwarded("removed subnet added back", "10.0.0.0/16, 10.20.0.0/16, 10.2.0.0/16, 10.1.0.0/16")
+
+	err = subnets.UpdateWatched("", parseSubnets(t, "10.0.0.0/16"))
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	expectForwarded("watched subnets removed", "10.0.0.0/16, 10.20.0.0/16")
+
+	err = subnets.RemoveSubnets(parseSubnets(t, "10.30.0.0/16"))
+	if err == nil || err.Error() != "Expected subnet '10.30.0.0/16' to be forwarded" {
+		t.Fatalf("Expected err removing not forwarded subnet: %v", err)
+	}
+}
+
+func TestDesiredSubnetsCtlChangesRouted(t *testing.T) {
+	subnets, routingFactory, fwd := newDesiredSubnets(t, []RoutingDstConnRoute{
+		{Name: "staging", Subnets: parseSubnets(t, "10.0.0.0/16"), Factory: fakeDstConnFactory{"staging"}},
+		{Name: "dev", Subnets: parseSubnets(t, "10.1.0.0/16"), Factory: fakeDstConnFactory{"dev"}},
+	})
+
+	err := subnets.AddSubnets(parseSubnets(t, "10.20.0.0/16", "10.1.5.0/24"))
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	// Subnet within route's subnets goes to that route, others go to first route
+	_, err = routingFactory.NewConn(net.ParseIP("10.20.0.1"), 80)
+	if err == nil || err.Error() != "dialed staging" {
+		t.Fatalf("Expected to dial staging: %s", err)
+	}
+
+	err = subnets.UpdateWatched("dev", parseSubnets(t, "10.2.0.0/16"))
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	_, err = routingFactory.NewConn(net.ParseIP("10.1.5.1"), 80)
+	if err == nil || err.Error() != "dialed dev" {
+		t.Fatalf("Expected to dial dev: %s", err)
+	}
+
+	if SubnetsAsString(fwd.subnets) != "10.0.0.0/16, 10.20.0.0/16, 10.2.0.0/16, 10.1.5.0/24" {
+		t.Fatalf("Expected forwarded subnets, but was '%s'", SubnetsAsString(fwd.subnets))
+	}
+
+	err = subnets.UpdateWatched("dev", parseSubnets(t, "10.2.0.0/16", "10.20.0.0/24"))
+	if err == nil {
+		t.Fatalf("Expected overlap err")
+	}
+
+	// Rejected watched subnets do not replace previous ones
+	_, err = routingFactory.NewConn(net.ParseIP("10.2.0.1"), 80)
+	if err == nil || err.Error() != "dialed dev" {
+		t.Fatalf("Expected to dial dev: %s", err)
+	}
+}
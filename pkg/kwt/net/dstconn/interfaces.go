@@ -8,6 +8,10 @@ type Factory interface {
 	NewConn(net.IP, int) (net.Conn, error)
 	NewConnCopier(logTag string) ConnCopier
 
+	// NewPacketConn returns connection that preserves datagram boundaries:
+	// each Write sends one datagram and each Read returns one datagram
+	NewPacketConn(net.IP, int) (net.Conn, error)
+
 	NewListener() (net.Listener, error)
 }
 
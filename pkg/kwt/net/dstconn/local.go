@@ -22,6 +22,10 @@ func (l Local) NewConnCopier(logTag string) ConnCopier {
 	return NewSSHConnCopier(logTag, l.logger) // TODO plain one?
 }
 
+func (l Local) NewPacketConn(ip net.IP, port int) (net.Conn, error) {
+	return net.DialUDP("udp", nil, &net.UDPAddr{IP: ip, Port: port})
+}
+
 func (l Local) NewListener() (net.Listener, error) {
 	panic("Not implemented")
 }
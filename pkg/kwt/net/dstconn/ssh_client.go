@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sync"
 	"time"
 
 	gossh "golang.org/x/crypto/ssh"
@@ -15,12 +16,34 @@ type SSHClient struct {
 	client     *gossh.Client
 	shutdownCh chan struct{}
 
+	keepAliveOpts     SSHKeepAliveOpts
+	keepAliveFunc     func(SSHKeepAlive)
+	lastKeepAlive     SSHKeepAlive
+	lastKeepAliveLock sync.RWMutex
+
 	logTag string
 	logger Logger
 }
 
 var _ Factory = &SSHClient{}
 
+// SSHKeepAlive records result of the most recent keepalive request
+type SSHKeepAlive struct {
+	At       time.Time
+	RTT      time.Duration
+	Err      error
+	Failures int // consecutive failed keepalives including this one
+}
+
+type SSHKeepAliveOpts struct {
+	Interval time.Duration
+	Timeout  time.Duration // keepalive without reply in time is considered failed
+}
+
+func DefaultSSHKeepAliveOpts() SSHKeepAliveOpts {
+	return SSHKeepAliveOpts{Interval: 3 * time.Second, Timeout: 5 * time.Second}
+}
+
 type SSHClientConnOpts struct {
 	User             string
 	Host             string
@@ -30,14 +53,23 @@ type SSHClientConnOpts struct {
 
 func NewSSHClient(connOpts SSHClientConnOpts, logger Logger) *SSHClient {
 	return &SSHClient{
-		connOpts:   connOpts,
-		shutdownCh: make(chan struct{}),
+		connOpts:      connOpts,
+		shutdownCh:    make(chan struct{}),
+		keepAliveOpts: DefaultSSHKeepAliveOpts(),
 
 		logTag: "SSHClient",
 		logger: logger,
 	}
 }
 
+// WithKeepAlive configures keepalive requests; keepAliveFunc is called
+// after each keepalive so that unresponsive connection can be replaced
+func (c *SSHClient) WithKeepAlive(opts SSHKeepAliveOpts, keepAliveFunc func(SSHKeepAlive)) *SSHClient {
+	c.keepAliveOpts = opts
+	c.keepAliveFunc = keepAliveFunc
+	return c
+}
+
 func (c *SSHClient) Connect() error {
 	signer, err := gossh.ParsePrivateKey([]byte(c.connOpts.PrivateKeyPEM))
 	if err != nil {
@@ -53,7 +85,7 @@ func (c *SSHClient) Connect() error {
 		User:              c.connOpts.User,
 		Auth:              []gossh.AuthMethod{gossh.PublicKeys(signer)},
 		HostKeyCallback:   gossh.FixedHostKey(hostPublicKey),
-		HostKeyAlgorithms: []string{gossh.KeyAlgoRSA},
+		HostKeyAlgorithms: SSHHostKeyAlgorithms(hostPublicKey),
 	}
 
 	client, err := gossh.Dial("tcp", c.connOpts.Host, sshConfig)
@@ -94,6 +126,21 @@ func (c *SSHClient) NewConnCopier(proxyDesc string) ConnCopier {
 	return NewSSHConnCopier(proxyDesc, c.logger)
 }
 
+func (c *SSHClient) NewPacketConn(ip net.IP, port int) (net.Conn, error) {
+	sess, err := c.client.NewSession()
+	if err != nil {
+		return nil, c.detectConnBrokenErr(err)
+	}
+
+	conn, err := NewSSHPacketConn(sess, &net.UDPAddr{IP: ip, Port: port})
+	if err != nil {
+		sess.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
 func (c *SSHClient) NewListener() (net.Listener, error) {
 	// sshd must be configured with `GatewayPorts yes/clientspecified`
 	// otherwise it will be always be listening on loopback
@@ -112,18 +159,76 @@ func (c *SSHClient) detectConnBrokenErr(err error) error {
 	return err
 }
 
+// LastKeepAlive returns zero value if no keepalive was sent yet
+func (c *SSHClient) LastKeepAlive() SSHKeepAlive {
+	c.lastKeepAliveLock.RLock()
+	defer c.lastKeepAliveLock.RUnlock()
+
+	return c.lastKeepAlive
+}
+
 func (c *SSHClient) keepAlive() {
-	ticker := time.NewTicker(3 * time.Second)
+	ticker := time.NewTicker(c.keepAliveOpts.Interval)
 	defer ticker.Stop()
 
+	var failures int
+
 	for {
 		select {
 		case <-ticker.C:
-			b, data, err := c.client.SendRequest("keepalive@openssh.com", true, nil)
-			c.logger.Debug(c.logTag, "Sending keepalive: %t %v %s", b, data, err)
+			startedAt := time.Now()
+			err := c.sendKeepAlive()
+			rtt := time.Since(startedAt)
+
+			select {
+			case <-c.shutdownCh:
+				return
+			default:
+			}
+
+			if err != nil {
+				failures++
+			} else {
+				failures = 0
+			}
+
+			c.logger.Debug(c.logTag, "Sending keepalive: %v (rtt: %s, failures: %d)", err, rtt, failures)
+
+			keepAlive := SSHKeepAlive{At: startedAt, RTT: rtt, Err: err, Failures: failures}
+
+			c.lastKeepAliveLock.Lock()
+			c.lastKeepAlive = keepAlive
+			c.lastKeepAliveLock.Unlock()
+
+			if c.keepAliveFunc != nil {
+				c.keepAliveFunc(keepAlive)
+			}
 
 		case <-c.shutdownCh:
 			return
 		}
 	}
 }
+
+// sendKeepAlive does not wait for reply beyond timeout since
+// requests over dead connection (eg after laptop sleep) may hang for minutes
+func (c *SSHClient) sendKeepAlive() error {
+	errCh := make(chan error, 1)
+
+	go func() {
+		_, _, err := c.client.SendRequest("keepalive@openssh.com", true, nil)
+		errCh <- err
+	}()
+
+	timer := time.NewTimer(c.keepAliveOpts.Timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("Timed out waiting for keepalive reply after %s", c.keepAliveOpts.Timeout)
+	case <-c.shutdownCh:
+		return nil
+	}
+}
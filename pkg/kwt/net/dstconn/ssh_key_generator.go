@@ -1,11 +1,16 @@
 package dstconn
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/binary"
 	"encoding/pem"
 	"fmt"
+	"io"
 
 	"golang.org/x/crypto/ssh"
 )
@@ -15,21 +20,85 @@ const (
 	sshKeyGeneratorHeaderPrivateKey = "RSA PRIVATE KEY"
 )
 
-type SSHKeyGenerator struct{}
+type SSHKeyType string
+
+const (
+	SSHKeyTypeRSA     SSHKeyType = "rsa"
+	SSHKeyTypeED25519 SSHKeyType = "ed25519"
+	SSHKeyTypeECDSA   SSHKeyType = "ecdsa"
+)
+
+func NewSSHKeyType(val string) (SSHKeyType, error) {
+	switch keyType := SSHKeyType(val); keyType {
+	case SSHKeyTypeRSA, SSHKeyTypeED25519, SSHKeyTypeECDSA:
+		return keyType, nil
+	default:
+		return "", fmt.Errorf("Expected SSH key type '%s' to be rsa, ed25519 or ecdsa", val)
+	}
+}
+
+type SSHKeyGenerator struct {
+	keyType SSHKeyType
+}
 
 type SSHKey struct {
+	Type       SSHKeyType
 	PrivateKey string
 	PublicKey  string
 }
 
 func NewSSHKeyGenerator() SSHKeyGenerator {
-	return SSHKeyGenerator{}
+	return SSHKeyGenerator{keyType: SSHKeyTypeRSA}
+}
+
+func (g SSHKeyGenerator) WithKeyType(keyType SSHKeyType) SSHKeyGenerator {
+	if len(keyType) > 0 {
+		g.keyType = keyType
+	}
+	return g
 }
 
 func (g SSHKeyGenerator) Generate() (SSHKey, error) {
-	priv, pub, err := g.generateRSAKeyPair()
+	var pub interface{}
+	var privPEM string
+	var err error
+
+	switch g.keyType {
+	case SSHKeyTypeRSA:
+		var priv *rsa.PrivateKey
+		priv, err = rsa.GenerateKey(rand.Reader, sshKeyGeneratorKeyBits)
+		if err == nil {
+			pub = priv.Public()
+			privPEM = g.encodePEM(x509.MarshalPKCS1PrivateKey(priv), sshKeyGeneratorHeaderPrivateKey)
+		}
+
+	case SSHKeyTypeECDSA:
+		var priv *ecdsa.PrivateKey
+		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err == nil {
+			pub = priv.Public()
+			var privBytes []byte
+			privBytes, err = x509.MarshalECPrivateKey(priv)
+			privPEM = g.encodePEM(privBytes, "EC PRIVATE KEY")
+		}
+
+	case SSHKeyTypeED25519:
+		var edPub ed25519.PublicKey
+		var edPriv ed25519.PrivateKey
+		edPub, edPriv, err = ed25519.GenerateKey(rand.Reader)
+		if err == nil {
+			pub = edPub
+			var privBytes []byte
+			privBytes, err = g.marshalOpenSSHED25519(edPub, edPriv)
+			privPEM = g.encodePEM(privBytes, "OPENSSH PRIVATE KEY")
+		}
+
+	default:
+		return SSHKey{}, fmt.Errorf("Unknown SSH key type '%s'", g.keyType)
+	}
+
 	if err != nil {
-		return SSHKey{}, fmt.Errorf("Generating RSA key pair: %s", err)
+		return SSHKey{}, fmt.Errorf("Generating %s key pair: %s", g.keyType, err)
 	}
 
 	sshPubKey, err := ssh.NewPublicKey(pub)
@@ -38,7 +107,8 @@ func (g SSHKeyGenerator) Generate() (SSHKey, error) {
 	}
 
 	key := SSHKey{
-		PrivateKey: g.privateKeyToPEM(priv),
+		Type:       g.keyType,
+		PrivateKey: privPEM,
 		PublicKey:  string(ssh.MarshalAuthorizedKey(sshPubKey)),
 	}
 
@@ -53,15 +123,53 @@ func (g SSHKeyGenerator) encodePEM(keyBytes []byte, keyType string) string {
 	return string(pem.EncodeToMemory(block))
 }
 
-func (g SSHKeyGenerator) generateRSAKeyPair() (*rsa.PrivateKey, *rsa.PublicKey, error) {
-	private, err := rsa.GenerateKey(rand.Reader, sshKeyGeneratorKeyBits)
+// marshalOpenSSHED25519 produces unencrypted 'openssh-key-v1' key
+// since OpenSSH does not read ed25519 keys in PKCS8 format
+func (g SSHKeyGenerator) marshalOpenSSHED25519(pub ed25519.PublicKey, priv ed25519.PrivateKey) ([]byte, error) {
+	pubKeyBlob := ssh.Marshal(struct {
+		KeyType string
+		Pub     []byte
+	}{ssh.KeyAlgoED25519, pub})
+
+	var checkBytes [4]byte
+
+	_, err := io.ReadFull(rand.Reader, checkBytes[:])
 	if err != nil {
-		return nil, nil, err
+		return nil, fmt.Errorf("Generating check bytes: %s", err)
 	}
-	public := private.Public().(*rsa.PublicKey)
-	return private, public, nil
+	check := binary.BigEndian.Uint32(checkBytes[:])
+
+	privKeyBlock := ssh.Marshal(struct {
+		Check1  uint32
+		Check2  uint32
+		KeyType string
+		Pub     []byte
+		Priv    []byte
+		Comment string
+	}{check, check, ssh.KeyAlgoED25519, pub, priv, ""})
+
+	// Pad to cipher block size (8 for 'none')
+	for i := 1; len(privKeyBlock)%8 != 0; i++ {
+		privKeyBlock = append(privKeyBlock, byte(i))
+	}
+
+	keyBytes := ssh.Marshal(struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}{"none", "none", "", 1, pubKeyBlob, privKeyBlock})
+
+	return append([]byte("openssh-key-v1\x00"), keyBytes...), nil
 }
 
-func (g SSHKeyGenerator) privateKeyToPEM(privateKey *rsa.PrivateKey) string {
-	return g.encodePEM(x509.MarshalPKCS1PrivateKey(privateKey), sshKeyGeneratorHeaderPrivateKey)
+// SSHHostKeyAlgorithms lists algorithms that can be used with given host key;
+// RSA keys prefer SHA-2 signatures since newer OpenSSH rejects 'ssh-rsa' (SHA-1)
+func SSHHostKeyAlgorithms(hostKey ssh.PublicKey) []string {
+	if hostKey.Type() == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{hostKey.Type()}
 }
@@ -0,0 +1,150 @@
+package dstconn_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/logtest"
+	. "github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHKeyGeneratorConnect(t *testing.T) {
+	for _, keyType := range []SSHKeyType{SSHKeyTypeED25519, SSHKeyTypeECDSA, SSHKeyTypeRSA} {
+		clientKey, err := NewSSHKeyGenerator().WithKeyType(keyType).Generate()
+		if err != nil {
+			t.Fatalf("Expected no err: %s", err)
+		}
+
+		hostKey, err := NewSSHKeyGenerator().WithKeyType(keyType).Generate()
+		if err != nil {
+			t.Fatalf("Expected no err: %s", err)
+		}
+
+		if hostKey.Type != keyType || !strings.HasPrefix(hostKey.PublicKey, map[SSHKeyType]string{
+			SSHKeyTypeED25519: "ssh-ed25519 ", SSHKeyTypeECDSA: "ecdsa-sha2-nistp256 ", SSHKeyTypeRSA: "ssh-rsa "}[keyType]) {
+			t.Fatalf("Expected %s public key, but was '%s'", keyType, hostKey.PublicKey)
+		}
+
+		addr := startSSHServer(t, clientKey, hostKey)
+
+		client := NewSSHClient(SSHClientConnOpts{
+			User:             "tom",
+			Host:             addr,
+			PrivateKeyPEM:    clientKey.PrivateKey,
+			HostPublicKeyAuf: hostKey.PublicKey,
+		}, logtest.NoopLogger{})
+
+		err = client.Connect()
+		if err != nil {
+			t.Fatalf("Expected %s keys to connect: %s", keyType, err)
+		}
+
+		client.Disconnect()
+
+		verifyWithOpenSSH(t, hostKey)
+	}
+}
+
+func TestNewSSHKeyType(t *testing.T) {
+	for _, val := range []string{"rsa", "ed25519", "ecdsa"} {
+		_, err := NewSSHKeyType(val)
+		if err != nil {
+			t.Fatalf("Expected key type '%s' to be valid: %s", val, err)
+		}
+	}
+
+	_, err := NewSSHKeyType("dsa")
+	if err == nil {
+		t.Fatalf("Expected key type 'dsa' to be invalid")
+	}
+}
+
+// startSSHServer only accepts given client key and presents given host key
+func startSSHServer(t *testing.T, clientKey, hostKey SSHKey) string {
+	hostSigner, err := ssh.ParsePrivateKey([]byte(hostKey.PrivateKey))
+	if err != nil {
+		t.Fatalf("Expected host private key to parse: %s", err)
+	}
+
+	authorizedKey, err := ParsePublicKey(clientKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Expected client public key to parse: %s", err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(authorizedKey.Marshal()) {
+				return nil, fmt.Errorf("Unknown client key")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for newChan := range chans {
+					newChan.Reject(ssh.Prohibited, "")
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// verifyWithOpenSSH makes sure that sshd would be able to read host key
+func verifyWithOpenSSH(t *testing.T, key SSHKey) {
+	keygenPath, err := exec.LookPath("ssh-keygen")
+	if err != nil {
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "kwt-ssh-key")
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	keyPath := filepath.Join(dir, "key")
+
+	err = ioutil.WriteFile(keyPath, []byte(key.PrivateKey), 0600)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	out, err := exec.Command(keygenPath, "-y", "-f", keyPath).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Expected OpenSSH to read %s private key: %s (%s)", key.Type, err, out)
+	}
+
+	if strings.TrimSpace(string(out)) != strings.TrimSpace(key.PublicKey) {
+		t.Fatalf("Expected OpenSSH derived public key '%s' to match '%s'", out, key.PublicKey)
+	}
+}
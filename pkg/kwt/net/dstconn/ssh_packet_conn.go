@@ -0,0 +1,156 @@
+package dstconn
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const sshPacketConnMaxSize = 65535
+
+// SSH does not provide a way to forward datagrams, hence
+// remote end of the tunnel runs a small relay that sends
+// length prefixed (2 bytes, big endian) frames read from stdin
+// as UDP datagrams and frames received datagrams back to stdout.
+// Perl is used since it's always present on Debian based images (perl-base).
+var sshPacketRelayScript = strings.Join([]string{
+	`use IO::Socket::INET; use IO::Select;`,
+	`my $s = IO::Socket::INET->new(Proto => "udp", PeerAddr => $ARGV[0], PeerPort => $ARGV[1]) or die "socket: $!";`,
+	`binmode STDIN; binmode STDOUT;`,
+	`my $sel = IO::Select->new(\*STDIN, $s); my $buf = "";`,
+	`while (1) { for my $fh ($sel->can_read) {`,
+	`if ($fh == $s) { my $d; defined($s->recv($d, 65535)) or next; syswrite(STDOUT, pack("n", length($d)) . $d); next; }`,
+	`sysread(STDIN, $buf, 65536, length($buf)) or exit 0;`,
+	`while (length($buf) >= 2) { my $l = unpack("n", $buf); last if length($buf) < 2 + $l;`,
+	`$s->send(substr($buf, 2, $l)); substr($buf, 0, 2 + $l) = ""; }`,
+	`} }`,
+}, " ")
+
+type SSHPacketConn struct {
+	relay      io.ReadWriteCloser
+	frames     *bufio.Reader
+	remoteAddr *net.UDPAddr
+
+	writeLock sync.Mutex
+	readLock  sync.Mutex
+}
+
+var _ net.Conn = &SSHPacketConn{}
+
+func NewSSHPacketConn(sess *gossh.Session, remoteAddr *net.UDPAddr) (*SSHPacketConn, error) {
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("Opening relay stdin: %s", err)
+	}
+
+	stdout, err := sess.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("Opening relay stdout: %s", err)
+	}
+
+	err = sess.Start(SSHPacketRelayCmd(remoteAddr))
+	if err != nil {
+		return nil, fmt.Errorf("Starting UDP relay: %s", err)
+	}
+
+	return NewSSHPacketConnWithRelay(sshSessionRelay{stdin, stdout, sess}, remoteAddr), nil
+}
+
+// NewSSHPacketConnWithRelay exchanges frames with already started relay
+func NewSSHPacketConnWithRelay(relay io.ReadWriteCloser, remoteAddr *net.UDPAddr) *SSHPacketConn {
+	return &SSHPacketConn{
+		relay:      relay,
+		frames:     bufio.NewReaderSize(relay, sshPacketConnMaxSize+2),
+		remoteAddr: remoteAddr,
+	}
+}
+
+// SSHPacketRelayCmd returns shell command that starts relay sending datagrams to remoteAddr
+func SSHPacketRelayCmd(remoteAddr *net.UDPAddr) string {
+	return fmt.Sprintf("exec perl -e '%s' %s %d", sshPacketRelayScript, remoteAddr.IP.String(), remoteAddr.Port)
+}
+
+// Read returns single datagram; if b is too small, rest of the datagram is discarded
+func (c *SSHPacketConn) Read(b []byte) (int, error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	var header [2]byte
+
+	_, err := io.ReadFull(c.frames, header[:])
+	if err != nil {
+		return 0, err
+	}
+
+	frame := make([]byte, binary.BigEndian.Uint16(header[:]))
+
+	_, err = io.ReadFull(c.frames, frame)
+	if err != nil {
+		return 0, err
+	}
+
+	return copy(b, frame), nil
+}
+
+// Write sends b as a single datagram
+func (c *SSHPacketConn) Write(b []byte) (int, error) {
+	if len(b) > sshPacketConnMaxSize {
+		return 0, fmt.Errorf("Expected datagram to be at most %d bytes but was %d", sshPacketConnMaxSize, len(b))
+	}
+
+	frame := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(frame, uint16(len(b)))
+	copy(frame[2:], b)
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	_, err := c.relay.Write(frame)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (c *SSHPacketConn) Close() error { return c.relay.Close() }
+
+func (c *SSHPacketConn) LocalAddr() net.Addr  { return &net.UDPAddr{} }
+func (c *SSHPacketConn) RemoteAddr() net.Addr { return c.remoteAddr }
+
+func (c *SSHPacketConn) SetDeadline(t time.Time) error      { return c.deadlineErr() }
+func (c *SSHPacketConn) SetReadDeadline(t time.Time) error  { return c.deadlineErr() }
+func (c *SSHPacketConn) SetWriteDeadline(t time.Time) error { return c.deadlineErr() }
+
+func (c *SSHPacketConn) deadlineErr() error {
+	return errors.New("SSHPacketConn: deadline not supported")
+}
+
+// sshSessionRelay exchanges frames with relay started in SSH session
+type sshSessionRelay struct {
+	stdin  io.WriteCloser
+	stdout io.Reader
+	sess   *gossh.Session
+}
+
+func (r sshSessionRelay) Read(b []byte) (int, error)  { return r.stdout.Read(b) }
+func (r sshSessionRelay) Write(b []byte) (int, error) { return r.stdin.Write(b) }
+
+func (r sshSessionRelay) Close() error {
+	r.stdin.Close()
+
+	err := r.sess.Close()
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	return nil
+}
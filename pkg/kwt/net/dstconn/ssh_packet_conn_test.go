@@ -0,0 +1,163 @@
+package dstconn_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os/exec"
+	"testing"
+	"time"
+
+	. "github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
+)
+
+var testUDPAddr = &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 53}
+
+func TestSSHPacketConnFraming(t *testing.T) {
+	connSide, relaySide := net.Pipe()
+
+	conn := NewSSHPacketConnWithRelay(connSide, testUDPAddr)
+	defer conn.Close()
+
+	// Each datagram is prefixed with 2 byte big endian length
+	go func() {
+		conn.Write(bytes.Repeat([]byte("a"), 300))
+		conn.Write([]byte{})
+	}()
+
+	for _, expectedLen := range []int{300, 0} {
+		var header [2]byte
+
+		_, err := io.ReadFull(relaySide, header[:])
+		if err != nil {
+			t.Fatalf("Expected no err: %s", err)
+		}
+
+		if int(binary.BigEndian.Uint16(header[:])) != expectedLen {
+			t.Fatalf("Expected frame length %d, but was %v", expectedLen, header)
+		}
+
+		_, err = io.ReadFull(relaySide, make([]byte, expectedLen))
+		if err != nil {
+			t.Fatalf("Expected no err: %s", err)
+		}
+	}
+
+	_, err := conn.Write(make([]byte, 65536))
+	if err == nil {
+		t.Fatalf("Expected datagram larger than frame to be rejected")
+	}
+
+	// Frames may be split or coalesced by the stream
+	go relaySide.Write([]byte{0, 3, 'o', 'n', 'e', 0, 5, 't', 'w', 'o'})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		relaySide.Write([]byte{'-', '2', 0, 5, 't', 'h', 'r', 'e', 'e'})
+	}()
+
+	buf := make([]byte, 100)
+
+	for _, expected := range []string{"one", "two-2", "thr"} {
+		if expected == "thr" {
+			buf = buf[:3] // rest of the datagram is discarded
+		}
+
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("Expected no err: %s", err)
+		}
+
+		if string(buf[:n]) != expected {
+			t.Fatalf("Expected datagram '%s', but was '%s'", expected, buf[:n])
+		}
+	}
+
+	go relaySide.Write([]byte{0, 4, 'f', 'o', 'u', 'r'})
+
+	n, err := conn.Read(make([]byte, 100))
+	if err != nil || n != 4 {
+		t.Fatalf("Expected next datagram to be read from frame boundary: %d, %v", n, err)
+	}
+}
+
+// execRelay runs relay locally the same way it's run in net pod
+type execRelay struct {
+	io.WriteCloser
+	io.Reader
+	cmd *exec.Cmd
+}
+
+func (r execRelay) Close() error {
+	r.WriteCloser.Close()
+	return r.cmd.Wait()
+}
+
+func TestSSHPacketConnRelay(t *testing.T) {
+	if _, err := exec.LookPath("perl"); err != nil {
+		t.Skip("Relay requires perl")
+	}
+
+	echoConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer echoConn.Close()
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := echoConn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			echoConn.WriteToUDP(buf[:n], addr)
+		}
+	}()
+
+	cmd := exec.Command("/bin/sh", "-c", SSHPacketRelayCmd(echoConn.LocalAddr().(*net.UDPAddr)))
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	conn := NewSSHPacketConnWithRelay(execRelay{stdin, stdout, cmd}, testUDPAddr)
+
+	// Sizes cover single and two byte lengths, and frames larger than pipe buffers
+	for _, size := range []int{1, 255, 256, 1400, 60000} {
+		datagram := bytes.Repeat([]byte{byte(size)}, size)
+
+		_, err := conn.Write(datagram)
+		if err != nil {
+			t.Fatalf("Expected no err: %s", err)
+		}
+
+		buf := make([]byte, 65535)
+
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("Expected no err: %s", err)
+		}
+
+		if !bytes.Equal(buf[:n], datagram) {
+			t.Fatalf("Expected echoed datagram of %d bytes, but was %d bytes", size, n)
+		}
+	}
+
+	err = conn.Close()
+	if err != nil {
+		t.Fatalf("Expected relay to exit once its stdin is closed: %s", err)
+	}
+}
@@ -0,0 +1,98 @@
+package net
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	etcHostsBlockBegin = "# BEGIN kwt net (managed, do not edit)"
+	etcHostsBlockEnd   = "# END kwt net"
+)
+
+type EtcHostsEntry struct {
+	IP        string
+	Hostnames []string
+}
+
+// EtcHostsBlock manages a block of entries in hosts file
+// leaving other contents of the file untouched
+type EtcHostsBlock struct {
+	path string
+}
+
+func NewEtcHostsBlock(path string) EtcHostsBlock {
+	return EtcHostsBlock{path}
+}
+
+func (b EtcHostsBlock) Write(entries []EtcHostsEntry) error {
+	stat, err := os.Stat(b.path)
+	if err != nil {
+		return fmt.Errorf("Checking hosts file: %s", err)
+	}
+
+	content, err := ioutil.ReadFile(b.path)
+	if err != nil {
+		return fmt.Errorf("Reading hosts file: %s", err)
+	}
+
+	newContent := ReplaceEtcHostsBlock(string(content), entries)
+
+	if newContent == string(content) {
+		return nil
+	}
+
+	// Write in place instead of renaming since hosts file may be bind mounted
+	err = ioutil.WriteFile(b.path, []byte(newContent), stat.Mode())
+	if err != nil {
+		return fmt.Errorf("Writing hosts file: %s", err)
+	}
+
+	return nil
+}
+
+func (b EtcHostsBlock) Remove() error { return b.Write(nil) }
+
+// ReplaceEtcHostsBlock removes previous block (if any) and
+// appends new block unless there are no entries
+func ReplaceEtcHostsBlock(content string, entries []EtcHostsEntry) string {
+	var lines []string
+	var inBlock bool
+
+	for _, line := range strings.Split(content, "\n") {
+		switch {
+		case line == etcHostsBlockBegin:
+			inBlock = true
+		case line == etcHostsBlockEnd:
+			inBlock = false
+		case !inBlock:
+			lines = append(lines, line)
+		}
+	}
+
+	result := strings.TrimRight(strings.Join(lines, "\n"), "\n")
+
+	if len(entries) == 0 {
+		return result + "\n"
+	}
+
+	entries = append([]EtcHostsEntry{}, entries...)
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(entries[i].IP), net.ParseIP(entries[j].IP)) < 0
+	})
+
+	blockLines := []string{etcHostsBlockBegin}
+
+	for _, entry := range entries {
+		blockLines = append(blockLines, entry.IP+" "+strings.Join(entry.Hostnames, " "))
+	}
+
+	blockLines = append(blockLines, etcHostsBlockEnd)
+
+	return result + "\n\n" + strings.Join(blockLines, "\n") + "\n"
+}
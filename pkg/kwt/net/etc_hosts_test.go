@@ -0,0 +1,51 @@
+package net_test
+
+import (
+	"testing"
+
+	. "github.com/carvel-dev/kwt/pkg/kwt/net"
+)
+
+func TestReplaceEtcHostsBlock(t *testing.T) {
+	content := "127.0.0.1 localhost\n::1 localhost\n"
+
+	result := ReplaceEtcHostsBlock(content, []EtcHostsEntry{
+		{IP: "127.1.0.10", Hostnames: []string{"svc2.ns.svc.cluster.local", "svc2.ns"}},
+		{IP: "127.1.0.2", Hostnames: []string{"svc1.ns.svc.cluster.local", "svc1.ns"}},
+	})
+
+	expected := `127.0.0.1 localhost
+::1 localhost
+
+# BEGIN kwt net (managed, do not edit)
+127.1.0.2 svc1.ns.svc.cluster.local svc1.ns
+127.1.0.10 svc2.ns.svc.cluster.local svc2.ns
+# END kwt net
+`
+
+	if result != expected {
+		t.Fatalf("Expected block to be added: %s", result)
+	}
+
+	result = ReplaceEtcHostsBlock(result, []EtcHostsEntry{
+		{IP: "127.1.0.2", Hostnames: []string{"svc1.ns"}},
+	})
+
+	expected = `127.0.0.1 localhost
+::1 localhost
+
+# BEGIN kwt net (managed, do not edit)
+127.1.0.2 svc1.ns
+# END kwt net
+`
+
+	if result != expected {
+		t.Fatalf("Expected block to be replaced: %s", result)
+	}
+
+	result = ReplaceEtcHostsBlock(result, nil)
+
+	if result != content {
+		t.Fatalf("Expected block to be removed: %s", result)
+	}
+}
@@ -0,0 +1,101 @@
+package fault
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Conn wraps source side of proxied connection delaying and throttling
+// data read from it (going to destination) and written to it (coming from destination)
+type Conn struct {
+	net.Conn
+
+	rule     Rule
+	injector *Injector
+
+	outLimiter *bandwidthLimiter
+	inLimiter  *bandwidthLimiter
+}
+
+func NewConn(conn net.Conn, rule Rule, injector *Injector) *Conn {
+	return &Conn{
+		Conn:       conn,
+		rule:       rule,
+		injector:   injector,
+		outLimiter: newBandwidthLimiter(rule.Bandwidth),
+		inLimiter:  newBandwidthLimiter(rule.Bandwidth),
+	}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.delay()
+		c.outLimiter.Wait(n)
+	}
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	if len(b) > 0 {
+		c.delay()
+		c.inLimiter.Wait(len(b))
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *Conn) CloseWrite() error {
+	if closer, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return closer.CloseWrite()
+	}
+	return nil
+}
+
+func (c *Conn) delay() {
+	if latency := c.injector.Latency(c.rule); latency > 0 {
+		time.Sleep(latency)
+	}
+}
+
+// Reset closes connection so that peer sees RST instead of FIN if possible
+func Reset(conn net.Conn) error {
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+	return conn.Close()
+}
+
+type bandwidthLimiter struct {
+	bytesPerSec int64
+	next        time.Time
+	lock        sync.Mutex
+}
+
+func newBandwidthLimiter(bytesPerSec int64) *bandwidthLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return &bandwidthLimiter{bytesPerSec: bytesPerSec}
+}
+
+// Wait blocks until n bytes fit into bandwidth
+func (l *bandwidthLimiter) Wait(n int) {
+	if l == nil {
+		return
+	}
+
+	l.lock.Lock()
+
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.bytesPerSec))
+	until := l.next
+
+	l.lock.Unlock()
+
+	time.Sleep(time.Until(until))
+}
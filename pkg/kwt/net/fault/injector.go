@@ -0,0 +1,144 @@
+package fault
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+type HostResolver interface {
+	LookupIP(host string) ([]net.IP, error)
+}
+
+// Injector picks fault rule for new connections;
+// rules can be replaced while connections are being proxied
+type Injector struct {
+	resolver HostResolver
+
+	rules     []Rule
+	rulesLock sync.RWMutex
+
+	rand     *rand.Rand
+	randLock sync.Mutex
+
+	logTag string
+	logger Logger
+}
+
+func NewInjector(rules []Rule, resolver HostResolver, logger Logger) *Injector {
+	return &Injector{
+		resolver: resolver,
+		rules:    rules,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+
+		logTag: "fault.Injector",
+		logger: logger,
+	}
+}
+
+func (i *Injector) Rules() []Rule {
+	i.rulesLock.RLock()
+	defer i.rulesLock.RUnlock()
+
+	return append([]Rule{}, i.rules...)
+}
+
+func (i *Injector) SetRules(rules []Rule) {
+	i.rulesLock.Lock()
+	i.rules = rules
+	i.rulesLock.Unlock()
+
+	if len(rules) == 0 {
+		i.logger.Info(i.logTag, "Cleared fault rules")
+	}
+	for _, rule := range rules {
+		i.logger.Info(i.logTag, "Injecting faults: %s", rule)
+	}
+}
+
+func (i *Injector) FaultRules() []string {
+	var result []string
+	for _, rule := range i.Rules() {
+		result = append(result, rule.String())
+	}
+	return result
+}
+
+// SetFaultRules replaces all rules unless some of them are invalid
+func (i *Injector) SetFaultRules(ruleStrs []string) error {
+	rules, err := NewRules(ruleStrs)
+	if err != nil {
+		return err
+	}
+
+	i.SetRules(rules)
+
+	return nil
+}
+
+// Match returns first rule matching destination
+func (i *Injector) Match(ip net.IP, port int) (Rule, bool) {
+	for _, rule := range i.Rules() {
+		if rule.DstPort > 0 && rule.DstPort != port {
+			continue
+		}
+		if i.matchesHost(rule, ip) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Refuse decides whether connection should be refused based on rule's percentage
+func (i *Injector) Refuse(rule Rule) bool {
+	if rule.Refuse == 0 {
+		return false
+	}
+
+	i.randLock.Lock()
+	defer i.randLock.Unlock()
+
+	return i.rand.Intn(100) < rule.Refuse
+}
+
+// Latency returns latency with jitter applied
+func (i *Injector) Latency(rule Rule) time.Duration {
+	if rule.Jitter == 0 {
+		return rule.Latency
+	}
+
+	i.randLock.Lock()
+	jitter := time.Duration(i.rand.Int63n(int64(2*rule.Jitter)+1)) - rule.Jitter
+	i.randLock.Unlock()
+
+	if rule.Latency+jitter < 0 {
+		return 0
+	}
+
+	return rule.Latency + jitter
+}
+
+func (i *Injector) matchesHost(rule Rule, ip net.IP) bool {
+	if len(rule.DstHost) == 0 {
+		return true
+	}
+
+	if subnet := rule.DstSubnet(); subnet != nil {
+		return subnet.Contains(ip)
+	}
+
+	ips, err := i.resolver.LookupIP(rule.DstHost)
+	if err != nil {
+		i.logger.Debug(i.logTag, "Failed resolving fault rule destination '%s': %s", rule.DstHost, err)
+		return false
+	}
+
+	for _, hostIP := range ips {
+		if hostIP.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,209 @@
+package fault
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rule describes faults applied to connections matching its destination,
+// eg 'dst=redis-master.default:6379 latency=200ms jitter=50ms'
+type Rule struct {
+	DstHost string // IP, subnet, host name or empty for any destination
+	DstPort int    // 0 for any port
+
+	Latency    time.Duration // added to data in each direction
+	Jitter     time.Duration // latency varies randomly by up to jitter
+	Bandwidth  int64         // bytes per second in each direction; 0 is unlimited
+	ResetAfter time.Duration // connection is reset once it's open for this long
+	Refuse     int           // percentage of connections to refuse
+
+	dstSubnet *net.IPNet
+}
+
+func NewRules(ruleStrs []string) ([]Rule, error) {
+	var result []Rule
+
+	for _, ruleStr := range ruleStrs {
+		rule, err := NewRule(ruleStr)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, rule)
+	}
+
+	return result, nil
+}
+
+func NewRule(ruleStr string) (Rule, error) {
+	var rule Rule
+	var hasFault bool
+
+	for _, piece := range strings.Fields(ruleStr) {
+		kv := strings.SplitN(piece, "=", 2)
+		if len(kv) != 2 || len(kv[1]) == 0 {
+			return Rule{}, fmt.Errorf("Expected fault rule '%s' to have 'key=value' pieces, but found '%s'", ruleStr, piece)
+		}
+
+		var err error
+
+		switch kv[0] {
+		case "dst":
+			err = rule.parseDst(kv[1])
+		case "latency":
+			rule.Latency, err = rule.parseDuration(kv[1])
+			hasFault = true
+		case "jitter":
+			rule.Jitter, err = rule.parseDuration(kv[1])
+			hasFault = true
+		case "bandwidth":
+			rule.Bandwidth, err = ParseBandwidth(kv[1])
+			hasFault = true
+		case "reset-after":
+			rule.ResetAfter, err = rule.parseDuration(kv[1])
+			hasFault = true
+		case "refuse":
+			rule.Refuse, err = rule.parsePercent(kv[1])
+			hasFault = true
+		default:
+			err = fmt.Errorf("Unknown key '%s'", kv[0])
+		}
+
+		if err != nil {
+			return Rule{}, fmt.Errorf("Parsing fault rule '%s': %s", ruleStr, err)
+		}
+	}
+
+	if !hasFault {
+		return Rule{}, fmt.Errorf("Expected fault rule '%s' to specify at least one fault "+
+			"(latency, jitter, bandwidth, reset-after or refuse)", ruleStr)
+	}
+
+	return rule, nil
+}
+
+func (r Rule) String() string {
+	var pieces []string
+
+	if len(r.DstHost) > 0 || r.DstPort > 0 {
+		dst := r.DstHost
+		if len(dst) == 0 {
+			dst = "*"
+		}
+		if r.DstPort > 0 {
+			dst = net.JoinHostPort(dst, strconv.Itoa(r.DstPort))
+		}
+		pieces = append(pieces, "dst="+dst)
+	}
+	if r.Latency > 0 {
+		pieces = append(pieces, "latency="+r.Latency.String())
+	}
+	if r.Jitter > 0 {
+		pieces = append(pieces, "jitter="+r.Jitter.String())
+	}
+	if r.Bandwidth > 0 {
+		pieces = append(pieces, "bandwidth="+strconv.FormatInt(r.Bandwidth, 10)+"bps")
+	}
+	if r.ResetAfter > 0 {
+		pieces = append(pieces, "reset-after="+r.ResetAfter.String())
+	}
+	if r.Refuse > 0 {
+		pieces = append(pieces, "refuse="+strconv.Itoa(r.Refuse)+"%")
+	}
+
+	return strings.Join(pieces, " ")
+}
+
+// DstSubnet returns nil if destination is not an IP or subnet
+func (r Rule) DstSubnet() *net.IPNet { return r.dstSubnet }
+
+func (r *Rule) parseDst(dst string) error {
+	host := dst
+
+	if h, portStr, err := net.SplitHostPort(dst); err == nil {
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("Expected destination port '%s' to be between 1 and 65535", portStr)
+		}
+		host = h
+		r.DstPort = port
+	}
+
+	if host == "*" {
+		host = ""
+	}
+
+	r.DstHost = host
+
+	if ip := net.ParseIP(host); ip != nil {
+		bits := 128
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 32
+		}
+		r.dstSubnet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		return nil
+	}
+
+	if strings.Contains(host, "/") {
+		_, subnet, err := net.ParseCIDR(host)
+		if err != nil {
+			return fmt.Errorf("Parsing destination subnet: %s", err)
+		}
+		r.dstSubnet = subnet
+	}
+
+	return nil
+}
+
+func (*Rule) parseDuration(val string) (time.Duration, error) {
+	dur, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, err
+	}
+	if dur < 0 {
+		return 0, fmt.Errorf("Expected duration '%s' to not be negative", val)
+	}
+	return dur, nil
+}
+
+func (*Rule) parsePercent(val string) (int, error) {
+	percent, err := strconv.Atoi(strings.TrimSuffix(val, "%"))
+	if err != nil || percent < 0 || percent > 100 {
+		return 0, fmt.Errorf("Expected percentage '%s' to be between 0%% and 100%%", val)
+	}
+	return percent, nil
+}
+
+// ParseBandwidth follows tc conventions: 'bit' suffixes are
+// bits per second and 'bps' suffixes are bytes per second
+func ParseBandwidth(val string) (int64, error) {
+	units := []struct {
+		suffix string
+		mult   float64 // bytes per second
+	}{
+		{"gbit", 1e9 / 8}, {"mbit", 1e6 / 8}, {"kbit", 1e3 / 8}, {"bit", 1.0 / 8},
+		{"gbps", 1e9}, {"mbps", 1e6}, {"kbps", 1e3}, {"bps", 1},
+	}
+
+	lowerVal := strings.ToLower(val)
+
+	for _, unit := range units {
+		if strings.HasSuffix(lowerVal, unit.suffix) {
+			num, err := strconv.ParseFloat(strings.TrimSuffix(lowerVal, unit.suffix), 64)
+			if err != nil || num <= 0 {
+				break
+			}
+			result := int64(num * unit.mult)
+			if result < 1 {
+				result = 1
+			}
+			return result, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Expected bandwidth '%s' to be a positive number with unit "+
+		"(bit, kbit, mbit, gbit, bps, kbps, mbps or gbps)", val)
+}
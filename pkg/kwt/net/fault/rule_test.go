@@ -0,0 +1,133 @@
+package fault_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/logtest"
+	. "github.com/carvel-dev/kwt/pkg/kwt/net/fault"
+)
+
+type fakeHostResolver map[string][]net.IP
+
+func (r fakeHostResolver) LookupIP(host string) ([]net.IP, error) { return r[host], nil }
+
+func TestNewRule(t *testing.T) {
+	valid := map[string]string{
+		"dst=redis-master.default:6379 latency=200ms jitter=50ms": "dst=redis-master.default:6379 latency=200ms jitter=50ms",
+		"dst=10.0.0.0/8 bandwidth=1mbit":                          "dst=10.0.0.0/8 bandwidth=125000bps",
+		"dst=[fd00::1]:80 reset-after=5s":                         "dst=[fd00::1]:80 reset-after=5s",
+		"dst=*:443 refuse=10%":                                    "dst=*:443 refuse=10%",
+		"bandwidth=2kbps":                                         "bandwidth=2000bps",
+	}
+
+	for in, out := range valid {
+		rule, err := NewRule(in)
+		if err != nil {
+			t.Fatalf("Expected rule '%s' to be valid: %s", in, err)
+		}
+		if rule.String() != out {
+			t.Fatalf("Expected rule '%s' to be '%s', but was '%s'", in, out, rule)
+		}
+	}
+
+	invalid := []string{
+		"dst=redis-master.default:6379",
+		"latency=fast",
+		"latency=-1s",
+		"refuse=150%",
+		"bandwidth=10",
+		"dst=redis:0 latency=1s",
+		"color=red",
+		"latency",
+	}
+
+	for _, in := range invalid {
+		_, err := NewRule(in)
+		if err == nil {
+			t.Fatalf("Expected rule '%s' to be invalid", in)
+		}
+	}
+}
+
+func TestInjectorMatch(t *testing.T) {
+	rules, err := NewRules([]string{
+		"dst=redis-master.default:6379 latency=1s",
+		"dst=10.0.0.0/8 refuse=100%",
+	})
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	resolver := fakeHostResolver{"redis-master.default": []net.IP{net.ParseIP("10.0.0.5")}}
+	injector := NewInjector(rules, resolver, logtest.NoopLogger{})
+
+	rule, found := injector.Match(net.ParseIP("10.0.0.5"), 6379)
+	if !found || rule.Latency != time.Second {
+		t.Fatalf("Expected first rule to match: %s", rule)
+	}
+
+	rule, found = injector.Match(net.ParseIP("10.0.0.5"), 80)
+	if !found || rule.Refuse != 100 || !injector.Refuse(rule) {
+		t.Fatalf("Expected second rule to match: %s", rule)
+	}
+
+	_, found = injector.Match(net.ParseIP("192.168.0.1"), 6379)
+	if found {
+		t.Fatalf("Expected no rule to match")
+	}
+
+	err = injector.SetFaultRules([]string{"latency=5ms"})
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	rule, found = injector.Match(net.ParseIP("192.168.0.1"), 6379)
+	if !found || rule.Latency != 5*time.Millisecond {
+		t.Fatalf("Expected replaced rule to match: %s", rule)
+	}
+
+	err = injector.SetFaultRules([]string{"latency=5ms", "invalid"})
+	if err == nil || len(injector.FaultRules()) != 1 {
+		t.Fatalf("Expected invalid rules to not replace existing rules: %s", err)
+	}
+}
+
+func TestConnBandwidth(t *testing.T) {
+	rule, err := NewRule("bandwidth=10kbps")
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	srcConn, peerConn := net.Pipe()
+
+	defer srcConn.Close()
+	defer peerConn.Close()
+
+	conn := NewConn(srcConn, rule, NewInjector(nil, nil, logtest.NoopLogger{}))
+
+	go func() {
+		buf := make([]byte, 1000)
+		for {
+			_, err := peerConn.Read(buf)
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	startedAt := time.Now()
+
+	for i := 0; i < 3; i++ {
+		_, err := conn.Write(make([]byte, 1000))
+		if err != nil {
+			t.Fatalf("Expected no err: %s", err)
+		}
+	}
+
+	// 3000 bytes at 10000 bytes per second
+	if elapsed := time.Since(startedAt); elapsed < 250*time.Millisecond {
+		t.Fatalf("Expected writes to be throttled, but took %s", elapsed)
+	}
+}
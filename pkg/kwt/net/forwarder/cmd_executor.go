@@ -26,7 +26,7 @@ func (e OsCmdExecutor) CombinedOutput(cmdName string, args []string, stdin io.Re
 	cmdDesc := cmdName + " " + strings.Join(args, " ")
 	e.logger.Debug(e.logTag, "Running '%s'", cmdDesc)
 
-	cmd := exec.Command("iptables", append([]string{"-w"}, args...)...)
+	cmd := exec.Command(cmdName, args...)
 	cmd.Stdin = stdin
 
 	out, err := cmd.CombinedOutput()
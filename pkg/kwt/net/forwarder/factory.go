@@ -2,25 +2,44 @@ package forwarder
 
 import (
 	"fmt"
+	"net"
 	"runtime"
 )
 
 const (
 	osDarwin = "darwin"
 	osLinux  = "linux"
+
+	TypeIptables = "iptables"
+	TypeNftables = "nftables"
+	TypePfctl    = "pfctl"
 )
 
 type Factory struct {
-	processGroupID int
-	logger         Logger
+	opts   FactoryOpts
+	exec   CmdExecutor
+	logger Logger
+}
+
+type FactoryOpts struct {
+	ProcessGroupID  int
+	Type            string // empty means auto-select
+	UseIPSets       bool   // only affects iptables; other forwarders always use sets
+	ExcludedSubnets []net.IPNet
 }
 
-func NewFactory(processGroupID int, logger Logger) Factory {
-	return Factory{processGroupID, logger}
+func NewFactory(opts FactoryOpts, logger Logger) Factory {
+	return Factory{opts, NewOsCmdExecutor(logger), logger}
+}
+
+func (f Factory) WithCmdExecutor(exec CmdExecutor) Factory {
+	f.exec = exec
+	return f
 }
 
 type ForwarderOpts struct {
 	DstTCPPort    int
+	DstUDPPort    int
 	DstDNSTCPPort int
 	DstDNSUDPPort int
 }
@@ -30,20 +49,52 @@ func (f Factory) NewForwarder(opts ForwarderOpts) (Forwarder, error) {
 
 	switch os {
 	case osLinux:
+		forwarderType, err := f.linuxForwarderType()
+		if err != nil {
+			return nil, err
+		}
+
+		if forwarderType == TypeNftables {
+			opts := NftablesOpts{
+				DstTCPPort:     opts.DstTCPPort,
+				DstUDPPort:     opts.DstUDPPort,
+				DstDNSTCPPort:  opts.DstDNSTCPPort,
+				DstDNSUDPPort:  opts.DstDNSUDPPort,
+				ProcessGroupID: f.opts.ProcessGroupID,
+
+				ExcludedSubnets: f.opts.ExcludedSubnets,
+				OwnersDir:       DefaultOwnersDir,
+			}
+			return NewNftables(opts, f.exec, f.logger), nil
+		}
+
 		opts := IptablesOpts{
 			DstTCPPort:     opts.DstTCPPort,
+			DstUDPPort:     opts.DstUDPPort,
 			DstDNSTCPPort:  opts.DstDNSTCPPort,
 			DstDNSUDPPort:  opts.DstDNSUDPPort,
-			ProcessGroupID: f.processGroupID,
+			ProcessGroupID: f.opts.ProcessGroupID,
+			UseIPSet:       f.opts.UseIPSets,
+
+			ExcludedSubnets: f.opts.ExcludedSubnets,
+			OwnersDir:       DefaultOwnersDir,
 		}
-		return NewIptables(opts, NewOsCmdExecutor(f.logger), f.logger), nil
+		return NewIptables(opts, f.exec, f.logger), nil
 
 	case osDarwin:
+		if len(f.opts.Type) > 0 && f.opts.Type != TypePfctl {
+			return nil, fmt.Errorf("Forwarder '%s' is not supported on OS '%s'", f.opts.Type, os)
+		}
+
 		opts := PfctlOpts{
 			DstTCPPort:     opts.DstTCPPort,
+			DstUDPPort:     opts.DstUDPPort,
 			DstDNSTCPPort:  opts.DstDNSTCPPort,
 			DstDNSUDPPort:  opts.DstDNSUDPPort,
-			ProcessGroupID: f.processGroupID,
+			ProcessGroupID: f.opts.ProcessGroupID,
+
+			ExcludedSubnets: f.opts.ExcludedSubnets,
+			OwnersDir:       DefaultOwnersDir,
 		}
 		return NewPfctl(opts, f.logger), nil
 
@@ -52,6 +103,61 @@ func (f Factory) NewForwarder(opts ForwarderOpts) (Forwarder, error) {
 	}
 }
 
+// NewStaleCleaner covers all usable forwarders regardless of selected type
+// since previous runs may have used a different one
+func (f Factory) NewStaleCleaner() (StaleCleaner, error) {
+	os := runtime.GOOS
+
+	switch os {
+	case osLinux:
+		var cleaners MultiStaleCleaner
+
+		iptables := NewIptables(IptablesOpts{OwnersDir: DefaultOwnersDir}, f.exec, f.logger)
+		if iptables.CheckPrereqs() == nil {
+			cleaners = append(cleaners, iptables)
+		}
+
+		nftables := NewNftables(NftablesOpts{OwnersDir: DefaultOwnersDir}, f.exec, f.logger)
+		if nftables.CheckPrereqs() == nil {
+			cleaners = append(cleaners, nftables)
+		}
+
+		// Runs last since forwarders above remove rules they know about
+		cleaners = append(cleaners, NewIPRules(IPRulesOpts{OwnersDir: DefaultOwnersDir}, f.exec, f.logger))
+
+		return cleaners, nil
+
+	case osDarwin:
+		return NewPfctl(PfctlOpts{OwnersDir: DefaultOwnersDir}, f.logger), nil
+
+	default:
+		return nil, fmt.Errorf("OS '%s' is not supported for connection forwarding", os)
+	}
+}
+
+func (f Factory) linuxForwarderType() (string, error) {
+	switch f.opts.Type {
+	case TypeIptables, TypeNftables:
+		return f.opts.Type, nil
+
+	case "":
+		// Prefer nftables when it's usable since iptables
+		// may be missing or be backed by nft in odd states
+		err := NewNftables(NftablesOpts{}, f.exec, f.logger).CheckPrereqs()
+		if err == nil {
+			return TypeNftables, nil
+		}
+
+		f.logger.Debug("Factory", "Falling back to iptables: nft is not usable: %s", err)
+
+		return TypeIptables, nil
+
+	default:
+		return "", fmt.Errorf("Forwarder '%s' is not supported on OS '%s' (expected '%s' or '%s')",
+			f.opts.Type, osLinux, TypeIptables, TypeNftables)
+	}
+}
+
 func (f Factory) NewOriginalDstResolver() (OriginalDstResolver, error) {
 	os := runtime.GOOS
 
@@ -66,3 +172,18 @@ func (f Factory) NewOriginalDstResolver() (OriginalDstResolver, error) {
 		return nil, fmt.Errorf("OS '%s' is not supported for original destination resolution", os)
 	}
 }
+
+func (f Factory) NewOriginalDstPacketResolver() (OriginalDstPacketResolver, error) {
+	os := runtime.GOOS
+
+	switch os {
+	case osLinux:
+		return LinuxOriginalDstPacketResolver{}, nil
+
+	case osDarwin:
+		return NewPfctlResolver(f.logger)
+
+	default:
+		return nil, fmt.Errorf("OS '%s' is not supported for original destination resolution", os)
+	}
+}
@@ -0,0 +1,45 @@
+package forwarder_test
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/logtest"
+	. "github.com/carvel-dev/kwt/pkg/kwt/net/forwarder"
+)
+
+func TestFactoryNewForwarderAutoSelect(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Forwarder auto selection only applies to linux")
+	}
+
+	exec := &FakeCmdExecutor{}
+	factory := NewFactory(FactoryOpts{}, logtest.NoopLogger{}).WithCmdExecutor(exec)
+
+	fwd, err := factory.NewForwarder(ForwarderOpts{DstTCPPort: 123})
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	if _, ok := fwd.(Nftables); !ok {
+		t.Fatalf("Expected nftables to be selected when nft is usable, but was %T", fwd)
+	}
+
+	if !reflect.DeepEqual(exec.Cmds, [][]string{[]string{"nft", "list", "tables"}}) {
+		t.Fatalf("Expected nft check to go through cmd executor: actual %#v", exec.Cmds)
+	}
+
+	exec = &FakeCmdExecutor{Errs: map[string]error{"nft list tables": fmt.Errorf("nft-err")}}
+	factory = NewFactory(FactoryOpts{}, logtest.NoopLogger{}).WithCmdExecutor(exec)
+
+	fwd, err = factory.NewForwarder(ForwarderOpts{DstTCPPort: 123})
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	if _, ok := fwd.(*Iptables); !ok {
+		t.Fatalf("Expected iptables to be selected when nft is not usable, but was %T", fwd)
+	}
+}
@@ -7,9 +7,27 @@ import (
 type Forwarder interface {
 	CheckPrereqs() error
 	Add([]net.IPNet, []net.IP) error
+	// AddSubnets and RemoveSubnets are used after Add to change forwarded
+	// subnets without touching other rules (established connections are kept)
+	AddSubnets([]net.IPNet) error
+	RemoveSubnets([]net.IPNet) error
 	Reset() error
 }
 
 type OriginalDstResolver interface {
 	GetOrigIPPort(net.Conn) (net.IP, int, error)
 }
+
+type OriginalDstPacketResolver interface {
+	ListenUDP(*net.UDPAddr) (*net.UDPConn, error)
+	// ReadFromUDP returns source address and original destination of a single datagram
+	ReadFromUDP(*net.UDPConn, []byte) (int, *net.UDPAddr, net.IP, int, error)
+	// NewReplyConn returns connection that sends datagrams
+	// as if they were coming from original destination
+	NewReplyConn(lisConn *net.UDPConn, origIP net.IP, origPort int) (PacketReplyConn, error)
+}
+
+type PacketReplyConn interface {
+	WriteToUDP([]byte, *net.UDPAddr) (int, error)
+	Close() error
+}
@@ -0,0 +1,33 @@
+package forwarder
+
+import (
+	"net"
+)
+
+func SplitSubnetsByFamily(subnets []net.IPNet) ([]net.IPNet, []net.IPNet) {
+	var subnets4, subnets6 []net.IPNet
+
+	for _, subnet := range subnets {
+		if subnet.IP.To4() != nil {
+			subnets4 = append(subnets4, subnet)
+		} else {
+			subnets6 = append(subnets6, subnet)
+		}
+	}
+
+	return subnets4, subnets6
+}
+
+func SplitIPsByFamily(ips []net.IP) ([]net.IP, []net.IP) {
+	var ips4, ips6 []net.IP
+
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			ips4 = append(ips4, ip)
+		} else {
+			ips6 = append(ips6, ip)
+		}
+	}
+
+	return ips4, ips6
+}
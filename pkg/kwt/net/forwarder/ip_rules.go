@@ -0,0 +1,114 @@
+package forwarder
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// IPRules removes policy routing state added for UDP (TPROXY) forwarding.
+// Rules and routes are not part of iptables chains or nftables tables
+// hence they may be left behind even after those were removed.
+// Since other tools use the same TPROXY recipe, only marks
+// recorded by kwt (see ruleOwners) are considered.
+type IPRules struct {
+	opts IPRulesOpts
+	exec CmdExecutor
+
+	logTag string
+	logger Logger
+}
+
+var _ StaleCleaner = IPRules{}
+
+type IPRulesOpts struct {
+	// OwnersDir is where forwarders record owners of their rules
+	OwnersDir string
+}
+
+func NewIPRules(opts IPRulesOpts, exec CmdExecutor, logger Logger) IPRules {
+	return IPRules{opts, exec, "IPRules", logger}
+}
+
+var (
+	// Piece of example output: '32765:	from all fwmark 0x7e lookup 126'
+	ipRuleRegexp = regexp.MustCompile(`fwmark 0x([0-9a-f]+) lookup (\d+)`)
+	// Piece of example output: 'local default dev lo table 126 scope host'
+	ipRouteRegexp = regexp.MustCompile(`(?m)^local (?:default|0\.0\.0\.0/0) dev lo table (\d+)`)
+)
+
+func (r IPRules) CleanUpStale() ([]string, error) {
+	owners := ruleOwners{r.opts.OwnersDir}
+
+	var staleMarks []int
+
+	for _, name := range owners.Names(udpRulesNamePrefix) {
+		mark, err := strconv.Atoi(strings.TrimPrefix(name, udpRulesNamePrefix))
+		if err != nil || !owners.Exited(name) {
+			continue
+		}
+		staleMarks = append(staleMarks, mark)
+	}
+
+	if len(staleMarks) == 0 {
+		return nil, nil
+	}
+
+	rulesOut, err := r.exec.CombinedOutput("ip", []string{"rule", "show"}, nil)
+	if err != nil {
+		return nil, nil // ip may not be installed
+	}
+
+	routesOut, err := r.exec.CombinedOutput("ip", []string{"route", "show", "table", "all"}, nil)
+	if err != nil {
+		return nil, nil
+	}
+
+	// kwt uses UDP port as both fwmark and routing table
+	presentMarks := map[int]struct{}{}
+
+	for _, match := range ipRuleRegexp.FindAllStringSubmatch(string(rulesOut), -1) {
+		mark, err := strconv.ParseInt(match[1], 16, 32)
+		if err != nil || strconv.Itoa(int(mark)) != match[2] {
+			continue
+		}
+		presentMarks[int(mark)] = struct{}{}
+	}
+
+	for _, match := range ipRouteRegexp.FindAllStringSubmatch(string(routesOut), -1) {
+		mark, err := strconv.Atoi(match[1])
+		if err == nil {
+			presentMarks[mark] = struct{}{}
+		}
+	}
+
+	var removed []string
+
+	for _, mark := range staleMarks {
+		name := udpRulesName(mark)
+
+		if _, found := presentMarks[mark]; !found {
+			owners.Forget([]string{name})
+			continue
+		}
+
+		markStr := strconv.Itoa(mark)
+
+		r.logger.Debug(r.logTag, "Removing stale ip rule and route for mark %s", markStr)
+
+		// Continue removing even if some of the steps fail since state may be partial
+		for _, cmd := range [][]string{
+			[]string{"rule", "del", "fwmark", markStr, "lookup", markStr},
+			[]string{"route", "del", "local", "0.0.0.0/0", "dev", "lo", "table", markStr},
+		} {
+			r.exec.CombinedOutput("ip", cmd, nil)
+		}
+
+		owners.Forget([]string{name})
+
+		removed = append(removed, fmt.Sprintf("ip rule and route for mark %s", markStr))
+	}
+
+	return removed, nil
+}
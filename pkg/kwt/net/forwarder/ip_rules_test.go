@@ -0,0 +1,101 @@
+package forwarder_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/logtest"
+	. "github.com/carvel-dev/kwt/pkg/kwt/net/forwarder"
+)
+
+func TestIPRulesCleanUpStale(t *testing.T) {
+	ownersDir, err := ioutil.TempDir("", "kwt-forwarder-owners")
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer os.RemoveAll(ownersDir)
+
+	// Marks are ports which are not listening
+	ports := unusedTCPPorts(t, 4)
+	liveMark, staleMark, goneMark, foreignMark := ports[0], ports[1], ports[2], ports[3]
+
+	owners := map[string]int{
+		liveMark:  os.Getpid(),
+		staleMark: exitedProcessPID(t),
+		goneMark:  exitedProcessPID(t),
+	}
+
+	for mark, pid := range owners {
+		err := ioutil.WriteFile(filepath.Join(ownersDir, "kwt-udp-"+mark), []byte(strconv.Itoa(pid)), 0600)
+		if err != nil {
+			t.Fatalf("Expected no err: %s", err)
+		}
+	}
+
+	hexMark := func(mark string) string {
+		markInt, _ := strconv.Atoi(mark)
+		return fmt.Sprintf("%x", markInt)
+	}
+
+	// Rules for foreign mark are added by another tool using the same TPROXY recipe
+	exec := &FakeCmdExecutor{
+		Outputs: map[string]string{
+			"ip rule show": fmt.Sprintf("0:	from all lookup local\n"+
+				"32764:	from all fwmark 0x%s lookup %s\n"+
+				"32765:	from all fwmark 0x%s lookup %s\n"+
+				"32766:	from all fwmark 0x%s lookup %s\n", hexMark(liveMark), liveMark,
+				hexMark(staleMark), staleMark, hexMark(foreignMark), foreignMark),
+			"ip route show table all": fmt.Sprintf("local default dev lo table %s scope host\n"+
+				"local default dev lo table %s scope host\n"+
+				"local default dev lo table %s scope host\n", liveMark, staleMark, foreignMark),
+		},
+	}
+
+	removed, err := NewIPRules(IPRulesOpts{OwnersDir: ownersDir}, exec, logtest.NoopLogger{}).CleanUpStale()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	if !reflect.DeepEqual(removed, []string{"ip rule and route for mark " + staleMark}) {
+		t.Fatalf("Expected removed items to match: actual %#v", removed)
+	}
+
+	expectedCmds := [][]string{
+		[]string{"ip", "rule", "show"},
+		[]string{"ip", "route", "show", "table", "all"},
+		[]string{"ip", "rule", "del", "fwmark", staleMark, "lookup", staleMark},
+		[]string{"ip", "route", "del", "local", "0.0.0.0/0", "dev", "lo", "table", staleMark},
+	}
+
+	if !reflect.DeepEqual(exec.Cmds, expectedCmds) {
+		t.Fatalf("Expected CleanUpStale cmds to match: actual %#v", exec.Cmds)
+	}
+
+	for mark, expectedExists := range map[string]bool{liveMark: true, staleMark: false, goneMark: false} {
+		_, err := os.Stat(filepath.Join(ownersDir, "kwt-udp-"+mark))
+		if (err == nil) != expectedExists {
+			t.Fatalf("Expected owner of mark %s to exist (%t): %v", mark, expectedExists, err)
+		}
+	}
+}
+
+func TestIPRulesCleanUpStaleWithoutOwners(t *testing.T) {
+	exec := &FakeCmdExecutor{
+		Outputs: map[string]string{
+			"ip rule show":            "32766:	from all fwmark 0x64 lookup 100\n",
+			"ip route show table all": "local default dev lo table 100 scope host\n",
+		},
+	}
+
+	// Rules that kwt cannot prove it added are never removed
+	removed, err := NewIPRules(IPRulesOpts{}, exec, logtest.NoopLogger{}).CleanUpStale()
+	if err != nil || len(removed) != 0 || len(exec.Cmds) != 0 {
+		t.Fatalf("Expected nothing to be removed: %#v, %v (cmds: %#v)", removed, err, exec.Cmds)
+	}
+}
@@ -3,12 +3,18 @@ package forwarder
 import (
 	"fmt"
 	"net"
+	"regexp"
 	"strconv"
+	"strings"
 )
 
 type Iptables struct {
-	opts   IptablesOpts
-	chains []chain
+	opts      IptablesOpts
+	chains    []chain
+	udpChains []chain
+
+	// IPv6 chains are only created when there are IPv6 subnets or DNS IPs
+	ipv6Added bool
 
 	exec CmdExecutor
 
@@ -16,13 +22,24 @@ type Iptables struct {
 	logger Logger
 }
 
-var _ Forwarder = Iptables{}
+var _ Forwarder = &Iptables{}
 
 type IptablesOpts struct {
 	DstTCPPort     int
+	DstUDPPort     int // UDP forwarding is disabled if 0
 	DstDNSTCPPort  int
 	DstDNSUDPPort  int
 	ProcessGroupID int
+
+	// UseIPSet keeps subnets in an ipset instead of a rule per subnet
+	UseIPSet bool
+
+	// ExcludedSubnets are not forwarded even if they are within forwarded subnets
+	ExcludedSubnets []net.IPNet
+
+	// OwnersDir is used to record this process as owner of added rules
+	// so that they are not considered stale while it's running
+	OwnersDir string
 }
 
 type chain struct {
@@ -31,7 +48,36 @@ type chain struct {
 	GroupCheck []string
 }
 
-func NewIptables(opts IptablesOpts, exec CmdExecutor, logger Logger) Iptables {
+type iptablesFamily struct {
+	Bin         string
+	TTLCheck    []string
+	HostMask    string
+	Loopback    string
+	IPSetFamily string
+	IPSetSuffix string
+}
+
+var (
+	iptablesIPv4 = iptablesFamily{
+		Bin:      "iptables",
+		TTLCheck: []string{"-m", "ttl", "!", "--ttl", "42"},
+		HostMask: "/32",
+		Loopback: "127.0.0.0/8",
+
+		IPSetFamily: "inet",
+	}
+	iptablesIPv6 = iptablesFamily{
+		Bin:      "ip6tables",
+		TTLCheck: []string{"-m", "hl", "!", "--hl-eq", "42"},
+		HostMask: "/128",
+		Loopback: "::1/128",
+
+		IPSetFamily: "inet6",
+		IPSetSuffix: "-6",
+	}
+)
+
+func NewIptables(opts IptablesOpts, exec CmdExecutor, logger Logger) *Iptables {
 	name := fmt.Sprintf("kwt-tcp-%d", opts.DstTCPPort)
 	chains := []chain{
 		{
@@ -44,11 +90,32 @@ func NewIptables(opts IptablesOpts, exec CmdExecutor, logger Logger) Iptables {
 		},
 	}
 
-	return Iptables{opts, chains, exec, "Iptables", logger}
+	udpName := fmt.Sprintf("kwt-udp-%d", opts.DstUDPPort)
+	udpChains := []chain{
+		{
+			Name:       udpName + "-output",
+			Type:       "OUTPUT",
+			GroupCheck: []string{"-m", "owner", "!", "--gid-owner", strconv.Itoa(opts.ProcessGroupID)}},
+		{
+			Name: udpName + "-prerouting",
+			Type: "PREROUTING",
+		},
+	}
+
+	return &Iptables{
+		opts:      opts,
+		chains:    chains,
+		udpChains: udpChains,
+
+		exec: exec,
+
+		logTag: "Iptables",
+		logger: logger,
+	}
 }
 
-func (i Iptables) CheckPrereqs() error {
-	out, err := i.runCmd([]string{"-L", "-t", "nat", "-n"})
+func (i *Iptables) CheckPrereqs() error {
+	out, err := i.runCmd(iptablesIPv4, []string{"-L", "-t", "nat", "-n"})
 	if err != nil {
 		return fmt.Errorf("Checking 'iptables' can run successfully: %s (output: %s)", err, out)
 	}
@@ -56,7 +123,50 @@ func (i Iptables) CheckPrereqs() error {
 	return nil
 }
 
-func (i Iptables) Add(subnets []net.IPNet, dnsIPs []net.IP) error {
+func (i *Iptables) Add(subnets []net.IPNet, dnsIPs []net.IP) error {
+	subnets4, subnets6 := SplitSubnetsByFamily(subnets)
+	dnsIPs4, dnsIPs6 := SplitIPsByFamily(dnsIPs)
+
+	// Recorded before adding rules so that they are never considered stale
+	err := i.owners().Record(rulesNames(i.opts.DstTCPPort, i.opts.DstUDPPort))
+	if err != nil {
+		return err
+	}
+
+	err = i.addNAT(iptablesIPv4, subnets4, dnsIPs4)
+	if err != nil {
+		return err
+	}
+
+	if len(subnets6) > 0 || len(dnsIPs6) > 0 {
+		i.ipv6Added = true
+
+		err := i.addNAT(iptablesIPv6, subnets6, dnsIPs6)
+		if err != nil {
+			return err
+		}
+	}
+
+	// UDP is not forwarded for IPv6 subnets since UDP relay in net pod is IPv4 only
+	if i.opts.DstUDPPort > 0 {
+		return i.addUDP(subnets4)
+	}
+
+	return nil
+}
+
+func (i *Iptables) addNAT(family iptablesFamily, subnets []net.IPNet, dnsIPs []net.IP) error {
+	if i.opts.UseIPSet {
+		err := i.restoreIPSet(i.createIPSetLines(family, subnets))
+		if err != nil {
+			return err
+		}
+	}
+
+	return i.runCmds(family, i.natCmds(family, subnets, dnsIPs))
+}
+
+func (i *Iptables) natCmds(family iptablesFamily, subnets []net.IPNet, dnsIPs []net.IP) [][]string {
 	cmds := [][]string{}
 
 	for _, chain := range i.chains {
@@ -66,41 +176,258 @@ func (i Iptables) Add(subnets []net.IPNet, dnsIPs []net.IP) error {
 			[]string{"-t", "nat", "-I", chain.Type, "1", "-j", chain.Name},
 		}...)
 
-		for _, subnet := range subnets {
-			cmds = append(cmds, append([]string{
+		for _, subnet := range i.excludedSubnets(family) {
+			cmds = append(cmds, []string{
 				"-t", "nat", "-A", chain.Name,
-				"-j", "REDIRECT", "--dest", subnet.String(), "-p", "tcp",
-				"--to-ports", strconv.Itoa(i.opts.DstTCPPort),
-				"-m", "ttl", "!", "--ttl", "42",
-			}, chain.GroupCheck...))
+				"-j", "RETURN", "--dest", subnet.String(), "-p", "tcp",
+			})
+		}
+
+		for _, dstMatch := range i.dstMatches(family, subnets) {
+			cmds = append(cmds, append([]string{"-t", "nat", "-A", chain.Name},
+				i.subnetRule(family, chain, dstMatch)...))
 		}
 
 		for _, ip := range dnsIPs {
-			cmds = append(cmds, append([]string{
+			cmds = append(cmds, append(append([]string{
 				"-t", "nat", "-A", chain.Name,
-				"-j", "REDIRECT", "--dest", ip.String() + "/32", "-p", "tcp",
+				"-j", "REDIRECT", "--dest", ip.String() + family.HostMask, "-p", "tcp",
 				"--dport", "53", "--to-ports", strconv.Itoa(i.opts.DstDNSTCPPort),
-				"-m", "ttl", "!", "--ttl", "42",
-			}, chain.GroupCheck...))
+			}, family.TTLCheck...), chain.GroupCheck...))
 
-			cmds = append(cmds, append([]string{
+			cmds = append(cmds, append(append([]string{
 				"-t", "nat", "-A", chain.Name,
-				"-j", "REDIRECT", "--dest", ip.String() + "/32", "-p", "udp",
+				"-j", "REDIRECT", "--dest", ip.String() + family.HostMask, "-p", "udp",
 				"--dport", "53", "--to-ports", strconv.Itoa(i.opts.DstDNSUDPPort),
-				"-m", "ttl", "!", "--ttl", "42",
-			}, chain.GroupCheck...))
+			}, family.TTLCheck...), chain.GroupCheck...))
 		}
 
 		cmds = append(cmds, []string{
 			"-t", "nat", "-A", chain.Name,
-			"-j", "RETURN", "--dest", "127.0.0.0/8", "-p", "tcp",
+			"-j", "RETURN", "--dest", family.Loopback, "-p", "tcp",
 		})
 	}
 
-	return i.runCmds(cmds)
+	return cmds
+}
+
+func (i *Iptables) excludedSubnets(family iptablesFamily) []net.IPNet {
+	subnets4, subnets6 := SplitSubnetsByFamily(i.opts.ExcludedSubnets)
+	if family.Bin == iptablesIPv6.Bin {
+		return subnets6
+	}
+	return subnets4
+}
+
+// dstMatches returns a rule match per subnet, or a single
+// set match when subnets are kept in an ipset
+func (i *Iptables) dstMatches(family iptablesFamily, subnets []net.IPNet) [][]string {
+	if i.opts.UseIPSet {
+		return [][]string{{"-m", "set", "--match-set", i.ipSetName(family), "dst"}}
+	}
+
+	var matches [][]string
+	for _, subnet := range subnets {
+		matches = append(matches, []string{"--dest", subnet.String()})
+	}
+	return matches
+}
+
+func (i *Iptables) subnetRule(family iptablesFamily, chain chain, dstMatch []string) []string {
+	rule := append(append([]string{"-j", "REDIRECT"}, dstMatch...),
+		"-p", "tcp", "--to-ports", strconv.Itoa(i.opts.DstTCPPort))
+
+	return append(append(rule, family.TTLCheck...), chain.GroupCheck...)
+}
+
+func (i *Iptables) AddSubnets(subnets []net.IPNet) error {
+	subnets4, subnets6 := SplitSubnetsByFamily(subnets)
+
+	err := i.updateSubnets(iptablesIPv4, "add", subnets4)
+	if err != nil {
+		return err
+	}
+
+	if len(subnets6) > 0 {
+		if i.ipv6Added {
+			err = i.updateSubnets(iptablesIPv6, "add", subnets6)
+		} else {
+			i.ipv6Added = true
+			err = i.addNAT(iptablesIPv6, subnets6, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// UDP rules match the same ipset, hence do not need updating
+	if i.opts.DstUDPPort > 0 && !i.opts.UseIPSet {
+		return i.runCmds(iptablesIPv4, i.udpSubnetCmds("-A", subnets4))
+	}
+
+	return nil
+}
+
+func (i *Iptables) RemoveSubnets(subnets []net.IPNet) error {
+	subnets4, subnets6 := SplitSubnetsByFamily(subnets)
+
+	err := i.updateSubnets(iptablesIPv4, "del", subnets4)
+	if err != nil {
+		return err
+	}
+
+	if i.ipv6Added {
+		err := i.updateSubnets(iptablesIPv6, "del", subnets6)
+		if err != nil {
+			return err
+		}
+	}
+
+	if i.opts.DstUDPPort > 0 && !i.opts.UseIPSet {
+		return i.runCmds(iptablesIPv4, i.udpSubnetCmds("-D", subnets4))
+	}
+
+	return nil
+}
+
+// updateSubnets either adds or deletes (action: add, del) subnets
+func (i *Iptables) updateSubnets(family iptablesFamily, action string, subnets []net.IPNet) error {
+	if len(subnets) == 0 {
+		return nil
+	}
+
+	if i.opts.UseIPSet {
+		return i.restoreIPSet(i.ipSetLines(family, action, subnets))
+	}
+
+	ruleAction := map[string]string{"add": "-A", "del": "-D"}[action]
+
+	return i.runCmds(family, i.subnetCmds(family, ruleAction, subnets))
+}
+
+func (i *Iptables) subnetCmds(family iptablesFamily, action string, subnets []net.IPNet) [][]string {
+	cmds := [][]string{}
+
+	for _, chain := range i.chains {
+		for _, subnet := range subnets {
+			cmds = append(cmds, append([]string{"-t", "nat", action, chain.Name},
+				i.subnetRule(family, chain, []string{"--dest", subnet.String()})...))
+		}
+	}
+
+	return cmds
+}
+
+func (i *Iptables) ipSetName(family iptablesFamily) string {
+	return fmt.Sprintf("kwt-tcp-%d%s", i.opts.DstTCPPort, family.IPSetSuffix)
+}
+
+func (i *Iptables) createIPSetLines(family iptablesFamily, subnets []net.IPNet) []string {
+	name := i.ipSetName(family)
+
+	lines := []string{
+		fmt.Sprintf("create %s hash:net family %s", name, family.IPSetFamily),
+		fmt.Sprintf("flush %s", name),
+	}
+
+	return append(lines, i.ipSetLines(family, "add", subnets)...)
+}
+
+func (i *Iptables) ipSetLines(family iptablesFamily, action string, subnets []net.IPNet) []string {
+	var lines []string
+	for _, subnet := range subnets {
+		lines = append(lines, fmt.Sprintf("%s %s %s", action, i.ipSetName(family), subnet.String()))
+	}
+	return lines
+}
+
+// restoreIPSet applies all changes with a single ipset invocation
+// since there may be thousands of entries
+func (i *Iptables) restoreIPSet(lines []string) error {
+	stdin := strings.NewReader(strings.Join(lines, "\n") + "\n")
+
+	out, err := i.exec.CombinedOutput("ipset", []string{"-exist", "restore"}, stdin)
+	if err != nil {
+		return fmt.Errorf("Updating ipset: %s (output: %s)", err, out)
+	}
+
+	return nil
+}
+
+// addUDP intercepts UDP traffic via TPROXY since REDIRECT-ed datagrams
+// do not keep track of their original destination. Locally originated
+// datagrams are marked in OUTPUT so that they are routed via loopback
+// and become subject to TPROXY rule in PREROUTING.
+func (i *Iptables) addUDP(subnets []net.IPNet) error {
+	mark := strconv.Itoa(i.opts.DstUDPPort)
+
+	cmds := [][]string{
+		[]string{"rule", "add", "fwmark", mark, "lookup", mark},
+		[]string{"route", "add", "local", "0.0.0.0/0", "dev", "lo", "table", mark},
+	}
+
+	err := i.runIPCmds(cmds)
+	if err != nil {
+		return err
+	}
+
+	cmds = [][]string{}
+
+	for _, chain := range i.udpChains {
+		cmds = append(cmds, [][]string{
+			[]string{"-t", "mangle", "-N", chain.Name},
+			[]string{"-t", "mangle", "-F", chain.Name},
+			[]string{"-t", "mangle", "-I", chain.Type, "1", "-j", chain.Name},
+		}...)
+
+		for _, subnet := range i.excludedSubnets(iptablesIPv4) {
+			cmds = append(cmds, []string{
+				"-t", "mangle", "-A", chain.Name,
+				"-j", "RETURN", "--dest", subnet.String(), "-p", "udp",
+			})
+		}
+
+		for _, dstMatch := range i.dstMatches(iptablesIPv4, subnets) {
+			cmds = append(cmds, append([]string{"-t", "mangle", "-A", chain.Name},
+				i.udpSubnetRule(chain, dstMatch)...))
+		}
+	}
+
+	return i.runCmds(iptablesIPv4, cmds)
 }
 
-func (i Iptables) Reset() error {
+func (i *Iptables) udpSubnetRule(chain chain, dstMatch []string) []string {
+	mark := strconv.Itoa(i.opts.DstUDPPort)
+
+	var rule []string
+
+	switch chain.Type {
+	case "OUTPUT":
+		rule = []string{"-j", "MARK", "--set-mark", mark}
+	case "PREROUTING":
+		rule = []string{"-j", "TPROXY", "--on-ip", "127.0.0.1",
+			"--on-port", strconv.Itoa(i.opts.DstUDPPort), "--tproxy-mark", mark}
+	}
+
+	rule = append(append(rule, dstMatch...), "-p", "udp", "-m", "ttl", "!", "--ttl", "42")
+
+	return append(rule, chain.GroupCheck...)
+}
+
+func (i *Iptables) udpSubnetCmds(action string, subnets []net.IPNet) [][]string {
+	cmds := [][]string{}
+
+	for _, chain := range i.udpChains {
+		for _, subnet := range subnets {
+			cmds = append(cmds, append([]string{"-t", "mangle", action, chain.Name},
+				i.udpSubnetRule(chain, []string{"--dest", subnet.String()})...))
+		}
+	}
+
+	return cmds
+}
+
+func (i *Iptables) Reset() error {
 	cmds := [][]string{}
 
 	for _, chain := range i.chains {
@@ -111,12 +438,72 @@ func (i Iptables) Reset() error {
 		}...)
 	}
 
-	return i.runCmds(cmds)
+	err := i.runCmds(iptablesIPv4, cmds)
+	if err != nil {
+		return err
+	}
+
+	ipSetFamilies := []iptablesFamily{iptablesIPv4}
+
+	if i.ipv6Added {
+		err := i.runCmds(iptablesIPv6, cmds)
+		if err != nil {
+			return err
+		}
+
+		i.ipv6Added = false
+		ipSetFamilies = append(ipSetFamilies, iptablesIPv6)
+	}
+
+	if i.opts.DstUDPPort > 0 {
+		err := i.resetUDP()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Sets can only be destroyed once no rules reference them
+	if i.opts.UseIPSet {
+		for _, family := range ipSetFamilies {
+			_, err := i.exec.CombinedOutput("ipset", []string{"destroy", i.ipSetName(family)}, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	i.owners().Forget(rulesNames(i.opts.DstTCPPort, i.opts.DstUDPPort))
+
+	return nil
+}
+
+func (i *Iptables) resetUDP() error {
+	cmds := [][]string{}
+
+	for _, chain := range i.udpChains {
+		cmds = append(cmds, [][]string{
+			[]string{"-t", "mangle", "-D", chain.Type, "-j", chain.Name},
+			[]string{"-t", "mangle", "-F", chain.Name},
+			[]string{"-t", "mangle", "-X", chain.Name},
+		}...)
+	}
+
+	err := i.runCmds(iptablesIPv4, cmds)
+	if err != nil {
+		return err
+	}
+
+	mark := strconv.Itoa(i.opts.DstUDPPort)
+
+	return i.runIPCmds([][]string{
+		[]string{"rule", "del", "fwmark", mark, "lookup", mark},
+		[]string{"route", "del", "local", "0.0.0.0/0", "dev", "lo", "table", mark},
+	})
 }
 
-func (i Iptables) runCmds(cmds [][]string) error {
+func (i *Iptables) runIPCmds(cmds [][]string) error {
 	for _, cmd := range cmds {
-		_, err := i.runCmd(cmd)
+		_, err := i.exec.CombinedOutput("ip", cmd, nil)
 		if err != nil {
 			return err
 		}
@@ -124,6 +511,165 @@ func (i Iptables) runCmds(cmds [][]string) error {
 	return nil
 }
 
-func (i Iptables) runCmd(cmd []string) ([]byte, error) {
-	return i.exec.CombinedOutput("iptables", append([]string{"-w"}, cmd...), nil)
+func (i *Iptables) runCmds(family iptablesFamily, cmds [][]string) error {
+	for _, cmd := range cmds {
+		_, err := i.runCmd(family, cmd)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *Iptables) runCmd(family iptablesFamily, cmd []string) ([]byte, error) {
+	return i.exec.CombinedOutput(family.Bin, append([]string{"-w"}, cmd...), nil)
+}
+
+var (
+	// Piece of example output: '-N kwt-tcp-43123-output'
+	iptablesTCPChainRegexp = regexp.MustCompile(`(?m)^-N (kwt-tcp-(\d+)-(output|prerouting))$`)
+	iptablesUDPChainRegexp = regexp.MustCompile(`(?m)^-N (kwt-udp-(\d+)-(output|prerouting))$`)
+
+	// Piece of example output: 'kwt-tcp-43123-6'
+	ipSetRegexp = regexp.MustCompile(`(?m)^(kwt-tcp-(\d+)(-6)?)$`)
+)
+
+var _ StaleCleaner = &Iptables{}
+
+func (i *Iptables) CleanUpStale() ([]string, error) {
+	var removed []string
+
+	// Owners are forgotten once all of their rules are removed
+	staleNames := map[string]struct{}{}
+	defer func() {
+		for name := range staleNames {
+			i.owners().Forget([]string{name})
+		}
+	}()
+
+	tcpLive := i.rulesLiveFunc(tcpRulesName, tcpPortListening, staleNames)
+	udpLive := i.rulesLiveFunc(udpRulesName, udpPortListening, staleNames)
+
+	for _, family := range []iptablesFamily{iptablesIPv4, iptablesIPv6} {
+		out, err := i.runCmd(family, []string{"-t", "nat", "-S"})
+		if err != nil {
+			if family.Bin == iptablesIPv6.Bin {
+				continue // IPv6 support may not be available
+			}
+			return nil, err
+		}
+
+		removed = append(removed, i.removeStaleChains(
+			family, "nat", iptablesTCPChainRegexp, string(out), tcpLive)...)
+	}
+
+	out, err := i.runCmd(iptablesIPv4, []string{"-t", "mangle", "-S"})
+	if err != nil {
+		return removed, err
+	}
+
+	udpRemoved := i.removeStaleChains(
+		iptablesIPv4, "mangle", iptablesUDPChainRegexp, string(out), udpLive)
+
+	removedMarks := map[string]struct{}{}
+
+	for _, match := range iptablesUDPChainRegexp.FindAllStringSubmatch(string(out), -1) {
+		mark := match[2]
+		if _, found := removedMarks[mark]; found {
+			continue
+		}
+
+		port, _ := strconv.Atoi(mark)
+		if udpLive(port) {
+			continue
+		}
+
+		removedMarks[mark] = struct{}{}
+
+		i.runIPCmdsIgnoringErrs([][]string{
+			[]string{"rule", "del", "fwmark", mark, "lookup", mark},
+			[]string{"route", "del", "local", "0.0.0.0/0", "dev", "lo", "table", mark},
+		})
+	}
+
+	removed = append(removed, udpRemoved...)
+
+	// Sets are removed last since stale chains may still reference them
+	return append(removed, i.removeStaleIPSets(tcpLive)...), nil
+}
+
+func (i *Iptables) owners() ruleOwners { return ruleOwners{i.opts.OwnersDir} }
+
+// rulesLiveFunc keeps track of names of stale rules
+func (i *Iptables) rulesLiveFunc(nameFunc func(int) string,
+	listeningFunc func(int) bool, staleNames map[string]struct{}) func(int) bool {
+
+	return func(port int) bool {
+		name := nameFunc(port)
+		if i.owners().Live(name, port, listeningFunc) {
+			return true
+		}
+		staleNames[name] = struct{}{}
+		return false
+	}
+}
+
+func (i *Iptables) removeStaleIPSets(liveFunc func(int) bool) []string {
+	out, err := i.exec.CombinedOutput("ipset", []string{"list", "-n"}, nil)
+	if err != nil {
+		return nil // ipset may not be installed
+	}
+
+	var removed []string
+
+	for _, match := range ipSetRegexp.FindAllStringSubmatch(string(out), -1) {
+		port, err := strconv.Atoi(match[2])
+		if err != nil || liveFunc(port) {
+			continue
+		}
+
+		_, err = i.exec.CombinedOutput("ipset", []string{"destroy", match[1]}, nil)
+		if err == nil {
+			removed = append(removed, fmt.Sprintf("ipset %s", match[1]))
+		}
+	}
+
+	return removed
+}
+
+func (i *Iptables) removeStaleChains(family iptablesFamily, table string,
+	chainRegexp *regexp.Regexp, rules string, liveFunc func(int) bool) []string {
+
+	var removed []string
+
+	for _, match := range chainRegexp.FindAllStringSubmatch(rules, -1) {
+		name := match[1]
+		chainType := strings.ToUpper(match[3])
+
+		port, err := strconv.Atoi(match[2])
+		if err != nil || liveFunc(port) {
+			continue
+		}
+
+		i.logger.Debug(i.logTag, "Removing stale %s %s chain %s", family.Bin, table, name)
+
+		// Continue removing even if some of the steps fail since state may be partial
+		for _, cmd := range [][]string{
+			[]string{"-t", table, "-D", chainType, "-j", name},
+			[]string{"-t", table, "-F", name},
+			[]string{"-t", table, "-X", name},
+		} {
+			i.runCmd(family, cmd)
+		}
+
+		removed = append(removed, fmt.Sprintf("%s %s chain %s", family.Bin, table, name))
+	}
+
+	return removed
+}
+
+func (i *Iptables) runIPCmdsIgnoringErrs(cmds [][]string) {
+	for _, cmd := range cmds {
+		i.exec.CombinedOutput("ip", cmd, nil)
+	}
 }
@@ -2,22 +2,41 @@ package forwarder_test
 
 import (
 	"io"
+	"io/ioutil"
 	"net"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/carvel-dev/kwt/pkg/kwt/logtest"
 	. "github.com/carvel-dev/kwt/pkg/kwt/net/forwarder"
 )
 
 type FakeCmdExecutor struct {
-	Cmds [][]string
+	Cmds   [][]string
+	Stdins []string
+
+	// Keyed by command and its arguments joined with spaces
+	Outputs map[string]string
+	Errs    map[string]error
 }
 
 var _ CmdExecutor = &FakeCmdExecutor{}
 
 func (e *FakeCmdExecutor) CombinedOutput(cmdName string, args []string, stdin io.Reader) ([]byte, error) {
 	e.Cmds = append(e.Cmds, append([]string{cmdName}, args...))
-	return nil, nil
+
+	var stdinStr string
+	if stdin != nil {
+		bs, _ := ioutil.ReadAll(stdin)
+		stdinStr = string(bs)
+	}
+	e.Stdins = append(e.Stdins, stdinStr)
+
+	cmdDesc := strings.Join(append([]string{cmdName}, args...), " ")
+
+	return []byte(e.Outputs[cmdDesc]), e.Errs[cmdDesc]
 }
 
 func TestIptables(t *testing.T) {
@@ -90,3 +109,426 @@ func TestIptables(t *testing.T) {
 		t.Fatalf("Expected Reset cmds to match: actual %#v", exec.Cmds)
 	}
 }
+
+func TestIptablesUDP(t *testing.T) {
+	exec := &FakeCmdExecutor{}
+	opts := IptablesOpts{
+		DstTCPPort:     123,
+		DstUDPPort:     126,
+		DstDNSTCPPort:  200,
+		DstDNSUDPPort:  124,
+		ProcessGroupID: 125,
+	}
+	iptables := NewIptables(opts, exec, nil)
+
+	_, ipNet1, _ := net.ParseCIDR("10.0.0.0/24")
+
+	err := iptables.Add([]net.IPNet{*ipNet1}, nil)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	expectedCmds := [][]string{
+		[]string{"ip", "rule", "add", "fwmark", "126", "lookup", "126"},
+		[]string{"ip", "route", "add", "local", "0.0.0.0/0", "dev", "lo", "table", "126"},
+
+		[]string{"iptables", "-w", "-t", "mangle", "-N", "kwt-udp-126-output"},
+		[]string{"iptables", "-w", "-t", "mangle", "-F", "kwt-udp-126-output"},
+		[]string{"iptables", "-w", "-t", "mangle", "-I", "OUTPUT", "1", "-j", "kwt-udp-126-output"},
+		[]string{"iptables", "-w", "-t", "mangle", "-A", "kwt-udp-126-output", "-j", "MARK", "--set-mark", "126", "--dest", "10.0.0.0/24", "-p", "udp", "-m", "ttl", "!", "--ttl", "42", "-m", "owner", "!", "--gid-owner", "125"},
+
+		[]string{"iptables", "-w", "-t", "mangle", "-N", "kwt-udp-126-prerouting"},
+		[]string{"iptables", "-w", "-t", "mangle", "-F", "kwt-udp-126-prerouting"},
+		[]string{"iptables", "-w", "-t", "mangle", "-I", "PREROUTING", "1", "-j", "kwt-udp-126-prerouting"},
+		[]string{"iptables", "-w", "-t", "mangle", "-A", "kwt-udp-126-prerouting", "-j", "TPROXY", "--on-ip", "127.0.0.1", "--on-port", "126", "--tproxy-mark", "126", "--dest", "10.0.0.0/24", "-p", "udp", "-m", "ttl", "!", "--ttl", "42"},
+	}
+
+	// Skip over TCP related commands that are covered by TestIptables
+	if len(exec.Cmds) < len(expectedCmds) {
+		t.Fatalf("Expected Add to run UDP cmds: actual %#v", exec.Cmds)
+	}
+
+	actualCmds := exec.Cmds[len(exec.Cmds)-len(expectedCmds):]
+
+	if !reflect.DeepEqual(actualCmds, expectedCmds) {
+		t.Fatalf("Expected Add cmds to match: actual %#v", actualCmds)
+	}
+
+	exec.Cmds = nil
+
+	err = iptables.Reset()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	expectedCmds = [][]string{
+		[]string{"iptables", "-w", "-t", "mangle", "-D", "OUTPUT", "-j", "kwt-udp-126-output"},
+		[]string{"iptables", "-w", "-t", "mangle", "-F", "kwt-udp-126-output"},
+		[]string{"iptables", "-w", "-t", "mangle", "-X", "kwt-udp-126-output"},
+
+		[]string{"iptables", "-w", "-t", "mangle", "-D", "PREROUTING", "-j", "kwt-udp-126-prerouting"},
+		[]string{"iptables", "-w", "-t", "mangle", "-F", "kwt-udp-126-prerouting"},
+		[]string{"iptables", "-w", "-t", "mangle", "-X", "kwt-udp-126-prerouting"},
+
+		[]string{"ip", "rule", "del", "fwmark", "126", "lookup", "126"},
+		[]string{"ip", "route", "del", "local", "0.0.0.0/0", "dev", "lo", "table", "126"},
+	}
+
+	actualCmds = exec.Cmds[len(exec.Cmds)-len(expectedCmds):]
+
+	if !reflect.DeepEqual(actualCmds, expectedCmds) {
+		t.Fatalf("Expected Reset cmds to match: actual %#v", actualCmds)
+	}
+}
+
+func TestIptablesIPv6(t *testing.T) {
+	exec := &FakeCmdExecutor{}
+	opts := IptablesOpts{
+		DstTCPPort:     123,
+		DstDNSTCPPort:  200,
+		DstDNSUDPPort:  124,
+		ProcessGroupID: 125,
+	}
+	iptables := NewIptables(opts, exec, nil)
+
+	_, ipNet1, _ := net.ParseCIDR("10.0.0.0/24")
+	_, ipNet2, _ := net.ParseCIDR("fd00:10:96::/112")
+
+	ip1 := net.ParseIP("fd00:10:96::a")
+
+	err := iptables.Add([]net.IPNet{*ipNet1, *ipNet2}, []net.IP{ip1})
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	expectedCmds := [][]string{
+		[]string{"ip6tables", "-w", "-t", "nat", "-N", "kwt-tcp-123-output"},
+		[]string{"ip6tables", "-w", "-t", "nat", "-F", "kwt-tcp-123-output"},
+		[]string{"ip6tables", "-w", "-t", "nat", "-I", "OUTPUT", "1", "-j", "kwt-tcp-123-output"},
+		[]string{"ip6tables", "-w", "-t", "nat", "-A", "kwt-tcp-123-output", "-j", "REDIRECT", "--dest", "fd00:10:96::/112", "-p", "tcp", "--to-ports", "123", "-m", "hl", "!", "--hl-eq", "42", "-m", "owner", "!", "--gid-owner", "125"},
+		[]string{"ip6tables", "-w", "-t", "nat", "-A", "kwt-tcp-123-output", "-j", "REDIRECT", "--dest", "fd00:10:96::a/128", "-p", "tcp", "--dport", "53", "--to-ports", "200", "-m", "hl", "!", "--hl-eq", "42", "-m", "owner", "!", "--gid-owner", "125"},
+		[]string{"ip6tables", "-w", "-t", "nat", "-A", "kwt-tcp-123-output", "-j", "REDIRECT", "--dest", "fd00:10:96::a/128", "-p", "udp", "--dport", "53", "--to-ports", "124", "-m", "hl", "!", "--hl-eq", "42", "-m", "owner", "!", "--gid-owner", "125"},
+		[]string{"ip6tables", "-w", "-t", "nat", "-A", "kwt-tcp-123-output", "-j", "RETURN", "--dest", "::1/128", "-p", "tcp"},
+
+		[]string{"ip6tables", "-w", "-t", "nat", "-N", "kwt-tcp-123-prerouting"},
+		[]string{"ip6tables", "-w", "-t", "nat", "-F", "kwt-tcp-123-prerouting"},
+		[]string{"ip6tables", "-w", "-t", "nat", "-I", "PREROUTING", "1", "-j", "kwt-tcp-123-prerouting"},
+		[]string{"ip6tables", "-w", "-t", "nat", "-A", "kwt-tcp-123-prerouting", "-j", "REDIRECT", "--dest", "fd00:10:96::/112", "-p", "tcp", "--to-ports", "123", "-m", "hl", "!", "--hl-eq", "42"},
+		[]string{"ip6tables", "-w", "-t", "nat", "-A", "kwt-tcp-123-prerouting", "-j", "REDIRECT", "--dest", "fd00:10:96::a/128", "-p", "tcp", "--dport", "53", "--to-ports", "200", "-m", "hl", "!", "--hl-eq", "42"},
+		[]string{"ip6tables", "-w", "-t", "nat", "-A", "kwt-tcp-123-prerouting", "-j", "REDIRECT", "--dest", "fd00:10:96::a/128", "-p", "udp", "--dport", "53", "--to-ports", "124", "-m", "hl", "!", "--hl-eq", "42"},
+		[]string{"ip6tables", "-w", "-t", "nat", "-A", "kwt-tcp-123-prerouting", "-j", "RETURN", "--dest", "::1/128", "-p", "tcp"},
+	}
+
+	// Skip over IPv4 commands that are covered by TestIptables
+	if len(exec.Cmds) < len(expectedCmds) {
+		t.Fatalf("Expected Add to run IPv6 cmds: actual %#v", exec.Cmds)
+	}
+
+	actualCmds := exec.Cmds[len(exec.Cmds)-len(expectedCmds):]
+
+	if !reflect.DeepEqual(actualCmds, expectedCmds) {
+		t.Fatalf("Expected Add cmds to match: actual %#v", actualCmds)
+	}
+
+	exec.Cmds = nil
+
+	err = iptables.Reset()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	expectedCmds = [][]string{
+		[]string{"ip6tables", "-w", "-t", "nat", "-D", "OUTPUT", "-j", "kwt-tcp-123-output"},
+		[]string{"ip6tables", "-w", "-t", "nat", "-F", "kwt-tcp-123-output"},
+		[]string{"ip6tables", "-w", "-t", "nat", "-X", "kwt-tcp-123-output"},
+		[]string{"ip6tables", "-w", "-t", "nat", "-D", "PREROUTING", "-j", "kwt-tcp-123-prerouting"},
+		[]string{"ip6tables", "-w", "-t", "nat", "-F", "kwt-tcp-123-prerouting"},
+		[]string{"ip6tables", "-w", "-t", "nat", "-X", "kwt-tcp-123-prerouting"},
+	}
+
+	actualCmds = exec.Cmds[len(exec.Cmds)-len(expectedCmds):]
+
+	if !reflect.DeepEqual(actualCmds, expectedCmds) {
+		t.Fatalf("Expected Reset cmds to match: actual %#v", actualCmds)
+	}
+}
+
+func TestIptablesUpdateSubnets(t *testing.T) {
+	exec := &FakeCmdExecutor{}
+	opts := IptablesOpts{
+		DstTCPPort:     123,
+		DstDNSTCPPort:  200,
+		DstDNSUDPPort:  124,
+		ProcessGroupID: 125,
+	}
+	iptables := NewIptables(opts, exec, nil)
+
+	_, ipNet1, _ := net.ParseCIDR("10.0.0.0/24")
+
+	err := iptables.Add([]net.IPNet{*ipNet1}, nil)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	exec.Cmds = nil
+
+	_, ipNet2, _ := net.ParseCIDR("192.0.0.0/24")
+
+	err = iptables.AddSubnets([]net.IPNet{*ipNet2})
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	expectedCmds := [][]string{
+		[]string{"iptables", "-w", "-t", "nat", "-A", "kwt-tcp-123-output", "-j", "REDIRECT", "--dest", "192.0.0.0/24", "-p", "tcp", "--to-ports", "123", "-m", "ttl", "!", "--ttl", "42", "-m", "owner", "!", "--gid-owner", "125"},
+		[]string{"iptables", "-w", "-t", "nat", "-A", "kwt-tcp-123-prerouting", "-j", "REDIRECT", "--dest", "192.0.0.0/24", "-p", "tcp", "--to-ports", "123", "-m", "ttl", "!", "--ttl", "42"},
+	}
+
+	if !reflect.DeepEqual(exec.Cmds, expectedCmds) {
+		t.Fatalf("Expected AddSubnets cmds to match: actual %#v", exec.Cmds)
+	}
+
+	exec.Cmds = nil
+
+	err = iptables.RemoveSubnets([]net.IPNet{*ipNet1})
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	expectedCmds = [][]string{
+		[]string{"iptables", "-w", "-t", "nat", "-D", "kwt-tcp-123-output", "-j", "REDIRECT", "--dest", "10.0.0.0/24", "-p", "tcp", "--to-ports", "123", "-m", "ttl", "!", "--ttl", "42", "-m", "owner", "!", "--gid-owner", "125"},
+		[]string{"iptables", "-w", "-t", "nat", "-D", "kwt-tcp-123-prerouting", "-j", "REDIRECT", "--dest", "10.0.0.0/24", "-p", "tcp", "--to-ports", "123", "-m", "ttl", "!", "--ttl", "42"},
+	}
+
+	if !reflect.DeepEqual(exec.Cmds, expectedCmds) {
+		t.Fatalf("Expected RemoveSubnets cmds to match: actual %#v", exec.Cmds)
+	}
+}
+
+func TestIptablesExcludedSubnets(t *testing.T) {
+	exec := &FakeCmdExecutor{}
+
+	_, excludedNet1, _ := net.ParseCIDR("10.0.5.0/24")
+	_, excludedNet2, _ := net.ParseCIDR("fd00:10:96::/120")
+
+	opts := IptablesOpts{
+		DstTCPPort:      123,
+		DstUDPPort:      126,
+		DstDNSTCPPort:   200,
+		DstDNSUDPPort:   124,
+		ProcessGroupID:  125,
+		ExcludedSubnets: []net.IPNet{*excludedNet1, *excludedNet2},
+	}
+	iptables := NewIptables(opts, exec, nil)
+
+	_, ipNet1, _ := net.ParseCIDR("10.0.0.0/16")
+
+	err := iptables.Add([]net.IPNet{*ipNet1}, nil)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	expectedCmds := [][]string{
+		[]string{"iptables", "-w", "-t", "nat", "-N", "kwt-tcp-123-output"},
+		[]string{"iptables", "-w", "-t", "nat", "-F", "kwt-tcp-123-output"},
+		[]string{"iptables", "-w", "-t", "nat", "-I", "OUTPUT", "1", "-j", "kwt-tcp-123-output"},
+		[]string{"iptables", "-w", "-t", "nat", "-A", "kwt-tcp-123-output", "-j", "RETURN", "--dest", "10.0.5.0/24", "-p", "tcp"},
+		[]string{"iptables", "-w", "-t", "nat", "-A", "kwt-tcp-123-output", "-j", "REDIRECT", "--dest", "10.0.0.0/16", "-p", "tcp", "--to-ports", "123", "-m", "ttl", "!", "--ttl", "42", "-m", "owner", "!", "--gid-owner", "125"},
+		[]string{"iptables", "-w", "-t", "nat", "-A", "kwt-tcp-123-output", "-j", "RETURN", "--dest", "127.0.0.0/8", "-p", "tcp"},
+
+		[]string{"iptables", "-w", "-t", "nat", "-N", "kwt-tcp-123-prerouting"},
+		[]string{"iptables", "-w", "-t", "nat", "-F", "kwt-tcp-123-prerouting"},
+		[]string{"iptables", "-w", "-t", "nat", "-I", "PREROUTING", "1", "-j", "kwt-tcp-123-prerouting"},
+		[]string{"iptables", "-w", "-t", "nat", "-A", "kwt-tcp-123-prerouting", "-j", "RETURN", "--dest", "10.0.5.0/24", "-p", "tcp"},
+		[]string{"iptables", "-w", "-t", "nat", "-A", "kwt-tcp-123-prerouting", "-j", "REDIRECT", "--dest", "10.0.0.0/16", "-p", "tcp", "--to-ports", "123", "-m", "ttl", "!", "--ttl", "42"},
+		[]string{"iptables", "-w", "-t", "nat", "-A", "kwt-tcp-123-prerouting", "-j", "RETURN", "--dest", "127.0.0.0/8", "-p", "tcp"},
+
+		[]string{"ip", "rule", "add", "fwmark", "126", "lookup", "126"},
+		[]string{"ip", "route", "add", "local", "0.0.0.0/0", "dev", "lo", "table", "126"},
+
+		[]string{"iptables", "-w", "-t", "mangle", "-N", "kwt-udp-126-output"},
+		[]string{"iptables", "-w", "-t", "mangle", "-F", "kwt-udp-126-output"},
+		[]string{"iptables", "-w", "-t", "mangle", "-I", "OUTPUT", "1", "-j", "kwt-udp-126-output"},
+		[]string{"iptables", "-w", "-t", "mangle", "-A", "kwt-udp-126-output", "-j", "RETURN", "--dest", "10.0.5.0/24", "-p", "udp"},
+		[]string{"iptables", "-w", "-t", "mangle", "-A", "kwt-udp-126-output", "-j", "MARK", "--set-mark", "126", "--dest", "10.0.0.0/16", "-p", "udp", "-m", "ttl", "!", "--ttl", "42", "-m", "owner", "!", "--gid-owner", "125"},
+
+		[]string{"iptables", "-w", "-t", "mangle", "-N", "kwt-udp-126-prerouting"},
+		[]string{"iptables", "-w", "-t", "mangle", "-F", "kwt-udp-126-prerouting"},
+		[]string{"iptables", "-w", "-t", "mangle", "-I", "PREROUTING", "1", "-j", "kwt-udp-126-prerouting"},
+		[]string{"iptables", "-w", "-t", "mangle", "-A", "kwt-udp-126-prerouting", "-j", "RETURN", "--dest", "10.0.5.0/24", "-p", "udp"},
+		[]string{"iptables", "-w", "-t", "mangle", "-A", "kwt-udp-126-prerouting", "-j", "TPROXY", "--on-ip", "127.0.0.1", "--on-port", "126", "--tproxy-mark", "126", "--dest", "10.0.0.0/16", "-p", "udp", "-m", "ttl", "!", "--ttl", "42"},
+	}
+
+	if !reflect.DeepEqual(exec.Cmds, expectedCmds) {
+		t.Fatalf("Expected Add cmds to match: actual %#v", exec.Cmds)
+	}
+}
+
+func TestIptablesIPSet(t *testing.T) {
+	exec := &FakeCmdExecutor{}
+	opts := IptablesOpts{
+		DstTCPPort:     123,
+		DstUDPPort:     126,
+		DstDNSTCPPort:  200,
+		DstDNSUDPPort:  124,
+		ProcessGroupID: 125,
+		UseIPSet:       true,
+	}
+	iptables := NewIptables(opts, exec, nil)
+
+	_, ipNet1, _ := net.ParseCIDR("10.0.0.1/32")
+	_, ipNet2, _ := net.ParseCIDR("10.0.0.2/32")
+
+	err := iptables.Add([]net.IPNet{*ipNet1, *ipNet2}, nil)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	expectedCmds := [][]string{
+		[]string{"ipset", "-exist", "restore"},
+		[]string{"iptables", "-w", "-t", "nat", "-N", "kwt-tcp-123-output"},
+		[]string{"iptables", "-w", "-t", "nat", "-F", "kwt-tcp-123-output"},
+		[]string{"iptables", "-w", "-t", "nat", "-I", "OUTPUT", "1", "-j", "kwt-tcp-123-output"},
+		[]string{"iptables", "-w", "-t", "nat", "-A", "kwt-tcp-123-output", "-j", "REDIRECT", "-m", "set", "--match-set", "kwt-tcp-123", "dst", "-p", "tcp", "--to-ports", "123", "-m", "ttl", "!", "--ttl", "42", "-m", "owner", "!", "--gid-owner", "125"},
+		[]string{"iptables", "-w", "-t", "nat", "-A", "kwt-tcp-123-output", "-j", "RETURN", "--dest", "127.0.0.0/8", "-p", "tcp"},
+
+		[]string{"iptables", "-w", "-t", "nat", "-N", "kwt-tcp-123-prerouting"},
+		[]string{"iptables", "-w", "-t", "nat", "-F", "kwt-tcp-123-prerouting"},
+		[]string{"iptables", "-w", "-t", "nat", "-I", "PREROUTING", "1", "-j", "kwt-tcp-123-prerouting"},
+		[]string{"iptables", "-w", "-t", "nat", "-A", "kwt-tcp-123-prerouting", "-j", "REDIRECT", "-m", "set", "--match-set", "kwt-tcp-123", "dst", "-p", "tcp", "--to-ports", "123", "-m", "ttl", "!", "--ttl", "42"},
+		[]string{"iptables", "-w", "-t", "nat", "-A", "kwt-tcp-123-prerouting", "-j", "RETURN", "--dest", "127.0.0.0/8", "-p", "tcp"},
+
+		[]string{"ip", "rule", "add", "fwmark", "126", "lookup", "126"},
+		[]string{"ip", "route", "add", "local", "0.0.0.0/0", "dev", "lo", "table", "126"},
+
+		[]string{"iptables", "-w", "-t", "mangle", "-N", "kwt-udp-126-output"},
+		[]string{"iptables", "-w", "-t", "mangle", "-F", "kwt-udp-126-output"},
+		[]string{"iptables", "-w", "-t", "mangle", "-I", "OUTPUT", "1", "-j", "kwt-udp-126-output"},
+		[]string{"iptables", "-w", "-t", "mangle", "-A", "kwt-udp-126-output", "-j", "MARK", "--set-mark", "126", "-m", "set", "--match-set", "kwt-tcp-123", "dst", "-p", "udp", "-m", "ttl", "!", "--ttl", "42", "-m", "owner", "!", "--gid-owner", "125"},
+
+		[]string{"iptables", "-w", "-t", "mangle", "-N", "kwt-udp-126-prerouting"},
+		[]string{"iptables", "-w", "-t", "mangle", "-F", "kwt-udp-126-prerouting"},
+		[]string{"iptables", "-w", "-t", "mangle", "-I", "PREROUTING", "1", "-j", "kwt-udp-126-prerouting"},
+		[]string{"iptables", "-w", "-t", "mangle", "-A", "kwt-udp-126-prerouting", "-j", "TPROXY", "--on-ip", "127.0.0.1", "--on-port", "126", "--tproxy-mark", "126", "-m", "set", "--match-set", "kwt-tcp-123", "dst", "-p", "udp", "-m", "ttl", "!", "--ttl", "42"},
+	}
+
+	if !reflect.DeepEqual(exec.Cmds, expectedCmds) {
+		t.Fatalf("Expected Add cmds to match: actual %#v", exec.Cmds)
+	}
+
+	expectedStdin := "create kwt-tcp-123 hash:net family inet\nflush kwt-tcp-123\nadd kwt-tcp-123 10.0.0.1/32\nadd kwt-tcp-123 10.0.0.2/32\n"
+
+	if exec.Stdins[0] != expectedStdin {
+		t.Fatalf("Expected ipset stdin to match: actual %s", exec.Stdins[0])
+	}
+
+	exec.Cmds = nil
+	exec.Stdins = nil
+
+	_, ipNet3, _ := net.ParseCIDR("10.0.0.3/32")
+
+	err = iptables.AddSubnets([]net.IPNet{*ipNet3})
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	err = iptables.RemoveSubnets([]net.IPNet{*ipNet1})
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	expectedCmds = [][]string{
+		[]string{"ipset", "-exist", "restore"},
+		[]string{"ipset", "-exist", "restore"},
+	}
+
+	if !reflect.DeepEqual(exec.Cmds, expectedCmds) {
+		t.Fatalf("Expected update cmds to match: actual %#v", exec.Cmds)
+	}
+
+	expectedStdins := []string{"add kwt-tcp-123 10.0.0.3/32\n", "del kwt-tcp-123 10.0.0.1/32\n"}
+
+	if !reflect.DeepEqual(exec.Stdins, expectedStdins) {
+		t.Fatalf("Expected update stdins to match: actual %#v", exec.Stdins)
+	}
+
+	exec.Cmds = nil
+
+	err = iptables.Reset()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	lastCmd := exec.Cmds[len(exec.Cmds)-1]
+
+	if !reflect.DeepEqual(lastCmd, []string{"ipset", "destroy", "kwt-tcp-123"}) {
+		t.Fatalf("Expected set to be destroyed last: actual %#v", lastCmd)
+	}
+}
+
+func TestIptablesCleanUpStale(t *testing.T) {
+	liveLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer liveLis.Close()
+
+	staleLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	staleLis.Close()
+
+	livePort := strconv.Itoa(liveLis.Addr().(*net.TCPAddr).Port)
+	stalePort := strconv.Itoa(staleLis.Addr().(*net.TCPAddr).Port)
+
+	exec := &FakeCmdExecutor{
+		Outputs: map[string]string{
+			"iptables -w -t nat -S": strings.Join([]string{
+				"-P OUTPUT ACCEPT",
+				"-N kwt-tcp-" + livePort + "-output",
+				"-N kwt-tcp-" + stalePort + "-output",
+				"-N kwt-tcp-" + stalePort + "-prerouting",
+				"-A OUTPUT -j kwt-tcp-" + stalePort + "-output",
+			}, "\n"),
+			"ipset list -n": "kwt-tcp-" + livePort + "\nkwt-tcp-" + stalePort + "-6\n",
+		},
+	}
+
+	iptables := NewIptables(IptablesOpts{}, exec, logtest.NoopLogger{})
+
+	removed, err := iptables.CleanUpStale()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	expectedRemoved := []string{
+		"iptables nat chain kwt-tcp-" + stalePort + "-output",
+		"iptables nat chain kwt-tcp-" + stalePort + "-prerouting",
+		"ipset kwt-tcp-" + stalePort + "-6",
+	}
+
+	if !reflect.DeepEqual(removed, expectedRemoved) {
+		t.Fatalf("Expected removed items to match: actual %#v", removed)
+	}
+
+	expectedCmds := [][]string{
+		[]string{"iptables", "-w", "-t", "nat", "-S"},
+		[]string{"iptables", "-w", "-t", "nat", "-D", "OUTPUT", "-j", "kwt-tcp-" + stalePort + "-output"},
+		[]string{"iptables", "-w", "-t", "nat", "-F", "kwt-tcp-" + stalePort + "-output"},
+		[]string{"iptables", "-w", "-t", "nat", "-X", "kwt-tcp-" + stalePort + "-output"},
+		[]string{"iptables", "-w", "-t", "nat", "-D", "PREROUTING", "-j", "kwt-tcp-" + stalePort + "-prerouting"},
+		[]string{"iptables", "-w", "-t", "nat", "-F", "kwt-tcp-" + stalePort + "-prerouting"},
+		[]string{"iptables", "-w", "-t", "nat", "-X", "kwt-tcp-" + stalePort + "-prerouting"},
+		[]string{"ip6tables", "-w", "-t", "nat", "-S"},
+		[]string{"iptables", "-w", "-t", "mangle", "-S"},
+		[]string{"ipset", "list", "-n"},
+		[]string{"ipset", "destroy", "kwt-tcp-" + stalePort + "-6"},
+	}
+
+	if !reflect.DeepEqual(exec.Cmds, expectedCmds) {
+		t.Fatalf("Expected CleanUpStale cmds to match: actual %#v", exec.Cmds)
+	}
+}
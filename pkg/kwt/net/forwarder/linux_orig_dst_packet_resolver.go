@@ -0,0 +1,108 @@
+package forwarder
+
+// Based on https://github.com/LiamHaworth/go-tproxy/blob/master/tproxy_udp.go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	ipTransparent     = 19 // IP_TRANSPARENT
+	ipRecvOrigDstAddr = 20 // IP_RECVORIGDSTADDR
+)
+
+// LinuxOriginalDstPacketResolver works with datagrams intercepted via TPROXY
+type LinuxOriginalDstPacketResolver struct{}
+
+var _ OriginalDstPacketResolver = LinuxOriginalDstPacketResolver{}
+
+func (r LinuxOriginalDstPacketResolver) ListenUDP(addr *net.UDPAddr) (*net.UDPConn, error) {
+	conn, err := r.listenTransparentUDP(addr, ipRecvOrigDstAddr)
+	if err != nil {
+		return nil, fmt.Errorf("Listening on transparent UDP socket: %s", err)
+	}
+
+	return conn, nil
+}
+
+func (r LinuxOriginalDstPacketResolver) ReadFromUDP(conn *net.UDPConn, b []byte) (int, *net.UDPAddr, net.IP, int, error) {
+	oob := make([]byte, 1024)
+
+	n, oobn, _, srcAddr, err := conn.ReadMsgUDP(b, oob)
+	if err != nil {
+		return 0, nil, nil, 0, err
+	}
+
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return 0, nil, nil, 0, fmt.Errorf("Parsing socket control message: %s", err)
+	}
+
+	for _, msg := range msgs {
+		if msg.Header.Level == solIP && msg.Header.Type == ipRecvOrigDstAddr {
+			var addr sockaddr
+
+			if len(msg.Data) < int(unsafe.Sizeof(addr)) {
+				return 0, nil, nil, 0, errors.New("unexpected original destination size")
+			}
+
+			copy((*[unsafe.Sizeof(addr)]byte)(unsafe.Pointer(&addr))[:], msg.Data)
+
+			if addr.family != syscall.AF_INET {
+				return 0, nil, nil, 0, errors.New("unrecognized address family")
+			}
+
+			ip := net.IP(append([]byte{}, addr.data[2:6]...))
+			port := int(addr.data[0])<<8 + int(addr.data[1])
+
+			return n, srcAddr, ip, port, nil
+		}
+	}
+
+	return 0, nil, nil, 0, errors.New("original destination not found")
+}
+
+func (r LinuxOriginalDstPacketResolver) NewReplyConn(_ *net.UDPConn, origIP net.IP, origPort int) (PacketReplyConn, error) {
+	// Binding to non-local address is allowed for transparent sockets
+	conn, err := r.listenTransparentUDP(&net.UDPAddr{IP: origIP, Port: origPort}, 0)
+	if err != nil {
+		return nil, fmt.Errorf("Binding reply UDP socket: %s", err)
+	}
+
+	return conn, nil
+}
+
+func (LinuxOriginalDstPacketResolver) listenTransparentUDP(addr *net.UDPAddr, extraOpt int) (*net.UDPConn, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var optErr error
+
+			err := c.Control(func(fd uintptr) {
+				optErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+				if optErr == nil {
+					optErr = syscall.SetsockoptInt(int(fd), solIP, ipTransparent, 1)
+				}
+				if optErr == nil && extraOpt != 0 {
+					optErr = syscall.SetsockoptInt(int(fd), solIP, extraOpt, 1)
+				}
+			})
+			if err != nil {
+				return err
+			}
+
+			return optErr
+		},
+	}
+
+	conn, err := lc.ListenPacket(context.Background(), "udp4", addr.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return conn.(*net.UDPConn), nil
+}
@@ -19,6 +19,15 @@ type sockaddr struct {
 	data   [14]byte
 }
 
+// sockaddr6 matches sockaddr_in6 layout
+type sockaddr6 struct {
+	family   uint16
+	port     [2]byte
+	flowinfo uint32
+	addr     [16]byte
+	scopeID  uint32
+}
+
 func (r LinuxOriginalDstResolver) GetOrigIPPort(conn net.Conn) (net.IP, int, error) {
 	tcpConn, ok := (conn).(*net.TCPConn)
 	if !ok {
@@ -42,7 +51,23 @@ func (r LinuxOriginalDstResolver) GetOrigIPPort(conn net.Conn) (net.IP, int, err
 	defer file.Close()
 	fd := file.Fd()
 
-	const soOriginalDst = 80
+	const soOriginalDst = 80 // same value for IP6T_SO_ORIGINAL_DST
+
+	if tcpAddr, ok := tcpConn.LocalAddr().(*net.TCPAddr); ok && tcpAddr.IP.To4() == nil {
+		var addr sockaddr6
+		size := uint32(unsafe.Sizeof(addr))
+
+		err = r.getsockopt(int(fd), solIPv6, soOriginalDst, uintptr(unsafe.Pointer(&addr)), &size)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if addr.family != syscall.AF_INET6 {
+			return nil, 0, errors.New("unrecognized address family")
+		}
+
+		return net.IP(append([]byte{}, addr.addr[:]...)), int(addr.port[0])<<8 + int(addr.port[1]), nil
+	}
 
 	var addr sockaddr
 	size := uint32(unsafe.Sizeof(addr))
@@ -7,4 +7,7 @@ import (
 	"syscall"
 )
 
-const solIP = syscall.SOL_IP
+const (
+	solIP   = syscall.SOL_IP
+	solIPv6 = syscall.SOL_IPV6
+)
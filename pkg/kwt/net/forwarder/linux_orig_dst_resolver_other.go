@@ -4,4 +4,7 @@
 package forwarder
 
 // Define placeholder const so that LinuxOriginalDstResolver can be compiled on non-linux systems
-const solIP = 0
+const (
+	solIP   = 0
+	solIPv6 = 41
+)
@@ -37,6 +37,20 @@ func (f *Locking) Add(subnets []net.IPNet, dnsIPs []net.IP) error {
 	return f.forwarder.Add(subnets, dnsIPs)
 }
 
+func (f *Locking) AddSubnets(subnets []net.IPNet) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.forwarder.AddSubnets(subnets)
+}
+
+func (f *Locking) RemoveSubnets(subnets []net.IPNet) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.forwarder.RemoveSubnets(subnets)
+}
+
 func (f *Locking) Reset() error {
 	f.lock.Lock()
 	defer f.lock.Unlock()
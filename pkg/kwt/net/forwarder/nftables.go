@@ -0,0 +1,344 @@
+package forwarder
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Nftables keeps all of its rules in a single table
+// so that removing that table reverts all changes
+type Nftables struct {
+	opts  NftablesOpts
+	table string
+
+	exec CmdExecutor
+
+	logTag string
+	logger Logger
+}
+
+var _ Forwarder = Nftables{}
+
+type NftablesOpts struct {
+	DstTCPPort     int
+	DstUDPPort     int // UDP forwarding is disabled if 0
+	DstDNSTCPPort  int
+	DstDNSUDPPort  int
+	ProcessGroupID int
+
+	// ExcludedSubnets are not forwarded even if they are within forwarded subnets
+	ExcludedSubnets []net.IPNet
+
+	// OwnersDir is used to record this process as owner of added rules
+	// so that they are not considered stale while it's running
+	OwnersDir string
+}
+
+func NewNftables(opts NftablesOpts, exec CmdExecutor, logger Logger) Nftables {
+	return Nftables{opts, fmt.Sprintf("kwt-tcp-%d", opts.DstTCPPort), exec, "Nftables", logger}
+}
+
+func (n Nftables) CheckPrereqs() error {
+	out, err := n.exec.CombinedOutput("nft", []string{"list", "tables"}, nil)
+	if err != nil {
+		return fmt.Errorf("Checking 'nft' can run successfully: %s (output: %s)", err, out)
+	}
+
+	return nil
+}
+
+func (n Nftables) Add(subnets []net.IPNet, dnsIPs []net.IP) error {
+	// Declaring and deleting table first makes Add idempotent;
+	// whole file is applied atomically by nft
+	rules := `
+table inet |table| {}
+delete table inet |table|
+table inet |table| {
+	set forward_subnets {
+		type ipv4_addr
+		flags interval
+		|subnets|
+	}
+
+	set forward_subnets6 {
+		type ipv6_addr
+		flags interval
+		|subnets6|
+	}
+
+	set excluded_subnets {
+		type ipv4_addr
+		flags interval
+		|excludedsubnets|
+	}
+
+	set excluded_subnets6 {
+		type ipv6_addr
+		flags interval
+		|excludedsubnets6|
+	}
+
+	set dns_servers {
+		type ipv4_addr
+		|dnsservers|
+	}
+
+	set dns_servers6 {
+		type ipv6_addr
+		|dnsservers6|
+	}
+
+	chain output {
+		type nat hook output priority -100; policy accept;
+		ip ttl 42 return
+		ip6 hoplimit 42 return
+		meta skgid |gid| return
+		ip daddr @excluded_subnets return
+		ip6 daddr @excluded_subnets6 return
+		ip daddr @forward_subnets meta l4proto tcp redirect to :|tcpport|
+		ip6 daddr @forward_subnets6 meta l4proto tcp redirect to :|tcpport|
+		ip daddr @dns_servers tcp dport 53 redirect to :|dnstcpport|
+		ip daddr @dns_servers udp dport 53 redirect to :|dnsudpport|
+		ip6 daddr @dns_servers6 tcp dport 53 redirect to :|dnstcpport|
+		ip6 daddr @dns_servers6 udp dport 53 redirect to :|dnsudpport|
+	}
+
+	chain prerouting {
+		type nat hook prerouting priority -100; policy accept;
+		ip ttl 42 return
+		ip6 hoplimit 42 return
+		ip daddr @excluded_subnets return
+		ip6 daddr @excluded_subnets6 return
+		ip daddr @forward_subnets meta l4proto tcp redirect to :|tcpport|
+		ip6 daddr @forward_subnets6 meta l4proto tcp redirect to :|tcpport|
+		ip daddr @dns_servers tcp dport 53 redirect to :|dnstcpport|
+		ip daddr @dns_servers udp dport 53 redirect to :|dnsudpport|
+		ip6 daddr @dns_servers6 tcp dport 53 redirect to :|dnstcpport|
+		ip6 daddr @dns_servers6 udp dport 53 redirect to :|dnsudpport|
+	}
+|udpchains|}
+`
+
+	var udpChains string
+
+	if n.opts.DstUDPPort > 0 {
+		// See Iptables.addUDP for explanation; only IPv4 subnets are intercepted
+		udpChains = `
+	chain udp_output {
+		type route hook output priority -150; policy accept;
+		ip ttl 42 return
+		meta skgid |gid| return
+		ip daddr @excluded_subnets return
+		ip daddr @forward_subnets meta l4proto udp meta mark set |udpport|
+	}
+
+	chain udp_prerouting {
+		type filter hook prerouting priority -150; policy accept;
+		ip ttl 42 return
+		ip daddr @excluded_subnets return
+		ip daddr @forward_subnets meta l4proto udp tproxy ip to 127.0.0.1:|udpport| meta mark set |udpport|
+	}
+`
+	}
+
+	subnets4, subnets6 := SplitSubnetsByFamily(subnets)
+	excludedSubnets4, excludedSubnets6 := SplitSubnetsByFamily(n.opts.ExcludedSubnets)
+	dnsIPs4, dnsIPs6 := SplitIPsByFamily(dnsIPs)
+
+	rules = strings.Replace(rules, "|udpchains|", udpChains, -1)
+	rules = strings.Replace(rules, "|table|", n.table, -1)
+	rules = strings.Replace(rules, "|subnets|", n.setElements(n.subnetStrs(subnets4)), -1)
+	rules = strings.Replace(rules, "|subnets6|", n.setElements(n.subnetStrs(subnets6)), -1)
+	rules = strings.Replace(rules, "|excludedsubnets|", n.setElements(n.subnetStrs(excludedSubnets4)), -1)
+	rules = strings.Replace(rules, "|excludedsubnets6|", n.setElements(n.subnetStrs(excludedSubnets6)), -1)
+	rules = strings.Replace(rules, "|dnsservers|", n.setElements(n.ipStrs(dnsIPs4)), -1)
+	rules = strings.Replace(rules, "|dnsservers6|", n.setElements(n.ipStrs(dnsIPs6)), -1)
+	rules = strings.Replace(rules, "|gid|", strconv.Itoa(n.opts.ProcessGroupID), -1)
+	rules = strings.Replace(rules, "|tcpport|", strconv.Itoa(n.opts.DstTCPPort), -1)
+	rules = strings.Replace(rules, "|udpport|", strconv.Itoa(n.opts.DstUDPPort), -1)
+	rules = strings.Replace(rules, "|dnstcpport|", strconv.Itoa(n.opts.DstDNSTCPPort), -1)
+	rules = strings.Replace(rules, "|dnsudpport|", strconv.Itoa(n.opts.DstDNSUDPPort), -1)
+
+	n.logger.Debug(n.logTag, "Will run nft with following rules: %s", rules)
+
+	// Recorded before adding rules so that they are never considered stale
+	err := n.owners().Record(rulesNames(n.opts.DstTCPPort, n.opts.DstUDPPort))
+	if err != nil {
+		return err
+	}
+
+	_, err = n.exec.CombinedOutput("nft", []string{"-f", "-"}, strings.NewReader(rules))
+	if err != nil {
+		return err
+	}
+
+	if n.opts.DstUDPPort > 0 {
+		mark := strconv.Itoa(n.opts.DstUDPPort)
+
+		return n.runIPCmds([][]string{
+			[]string{"rule", "add", "fwmark", mark, "lookup", mark},
+			[]string{"route", "add", "local", "0.0.0.0/0", "dev", "lo", "table", mark},
+		})
+	}
+
+	return nil
+}
+
+// AddSubnets fails if subnets overlap forwarded subnets since
+// interval sets reject overlapping elements (callers collapse them)
+func (n Nftables) AddSubnets(subnets []net.IPNet) error {
+	return n.updateSubnets("add", subnets)
+}
+
+func (n Nftables) RemoveSubnets(subnets []net.IPNet) error {
+	return n.updateSubnets("delete", subnets)
+}
+
+func (n Nftables) updateSubnets(action string, subnets []net.IPNet) error {
+	subnets4, subnets6 := SplitSubnetsByFamily(subnets)
+
+	sets := []struct {
+		Name    string
+		Subnets []net.IPNet
+	}{
+		{"forward_subnets", subnets4},
+		{"forward_subnets6", subnets6},
+	}
+
+	for _, set := range sets {
+		if len(set.Subnets) == 0 {
+			continue
+		}
+
+		elements := "{ " + strings.Join(n.subnetStrs(set.Subnets), ", ") + " }"
+
+		_, err := n.exec.CombinedOutput("nft", []string{action, "element", "inet", n.table, set.Name, elements}, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (n Nftables) Reset() error {
+	_, err := n.exec.CombinedOutput("nft", []string{"delete", "table", "inet", n.table}, nil)
+	if err != nil {
+		return err
+	}
+
+	if n.opts.DstUDPPort > 0 {
+		mark := strconv.Itoa(n.opts.DstUDPPort)
+
+		err := n.runIPCmds([][]string{
+			[]string{"rule", "del", "fwmark", mark, "lookup", mark},
+			[]string{"route", "del", "local", "0.0.0.0/0", "dev", "lo", "table", mark},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	n.owners().Forget(rulesNames(n.opts.DstTCPPort, n.opts.DstUDPPort))
+
+	return nil
+}
+
+func (n Nftables) owners() ruleOwners { return ruleOwners{n.opts.OwnersDir} }
+
+// subnetStrs clears host bits since nft refuses intervals with them set
+func (Nftables) subnetStrs(subnets []net.IPNet) []string {
+	var result []string
+	for _, subnet := range subnets {
+		masked := net.IPNet{IP: subnet.IP.Mask(subnet.Mask), Mask: subnet.Mask}
+		result = append(result, masked.String())
+	}
+	return result
+}
+
+func (Nftables) ipStrs(ips []net.IP) []string {
+	var result []string
+	for _, ip := range ips {
+		result = append(result, ip.String())
+	}
+	return result
+}
+
+func (Nftables) setElements(elements []string) string {
+	if len(elements) == 0 {
+		return "" // nft does not accept empty element list
+	}
+	return "elements = { " + strings.Join(elements, ", ") + " }"
+}
+
+func (n Nftables) runIPCmds(cmds [][]string) error {
+	for _, cmd := range cmds {
+		_, err := n.exec.CombinedOutput("ip", cmd, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	// Piece of example output: 'table inet kwt-tcp-43123'
+	nftablesTableRegexp  = regexp.MustCompile(`(?m)^table (ip|inet) (kwt-tcp-(\d+))$`)
+	nftablesTProxyRegexp = regexp.MustCompile(`tproxy (?:ip )?to 127\.0\.0\.1:(\d+)`)
+)
+
+var _ StaleCleaner = Nftables{}
+
+func (n Nftables) CleanUpStale() ([]string, error) {
+	out, err := n.exec.CombinedOutput("nft", []string{"list", "tables"}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+
+	for _, match := range nftablesTableRegexp.FindAllStringSubmatch(string(out), -1) {
+		family, name := match[1], match[2]
+
+		port, err := strconv.Atoi(match[3])
+		if err != nil || n.owners().Live(name, port, tcpPortListening) {
+			continue
+		}
+
+		n.logger.Debug(n.logTag, "Removing stale table %s %s", family, name)
+
+		var udpMarks []string
+
+		tableOut, err := n.exec.CombinedOutput("nft", []string{"list", "table", family, name}, nil)
+		if err == nil {
+			for _, tproxyMatch := range nftablesTProxyRegexp.FindAllStringSubmatch(string(tableOut), -1) {
+				udpMarks = append(udpMarks, tproxyMatch[1])
+			}
+		}
+
+		_, err = n.exec.CombinedOutput("nft", []string{"delete", "table", family, name}, nil)
+		if err != nil {
+			return removed, err
+		}
+
+		// Routing rules are not part of the table
+		for _, mark := range udpMarks {
+			for _, cmd := range [][]string{
+				[]string{"rule", "del", "fwmark", mark, "lookup", mark},
+				[]string{"route", "del", "local", "0.0.0.0/0", "dev", "lo", "table", mark},
+			} {
+				n.exec.CombinedOutput("ip", cmd, nil)
+			}
+			n.owners().Forget([]string{"kwt-udp-" + mark})
+		}
+
+		n.owners().Forget([]string{name})
+
+		removed = append(removed, fmt.Sprintf("nftables table %s %s", family, name))
+	}
+
+	return removed, nil
+}
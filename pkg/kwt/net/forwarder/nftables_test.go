@@ -0,0 +1,158 @@
+package forwarder_test
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/logtest"
+	. "github.com/carvel-dev/kwt/pkg/kwt/net/forwarder"
+)
+
+func TestNftables(t *testing.T) {
+	exec := &FakeCmdExecutor{}
+	opts := NftablesOpts{
+		DstTCPPort:     123,
+		DstDNSTCPPort:  200,
+		DstDNSUDPPort:  124,
+		ProcessGroupID: 125,
+	}
+
+	_, excludedNet, _ := net.ParseCIDR("10.0.0.128/25")
+	opts.ExcludedSubnets = []net.IPNet{*excludedNet}
+
+	nftables := NewNftables(opts, exec, logtest.NoopLogger{})
+
+	_, ipNet1, _ := net.ParseCIDR("10.0.0.0/24")
+	_, ipNet2, _ := net.ParseCIDR("192.0.0.0/24")
+	ipNet3 := net.IPNet{IP: net.ParseIP("fd00:10:96::a"), Mask: net.CIDRMask(112, 128)} // with host bits
+
+	ip1 := net.ParseIP("1.1.1.1")
+	ip2 := net.ParseIP("2.2.2.2")
+	ip3 := net.ParseIP("fd00:10:96::a")
+
+	err := nftables.Add([]net.IPNet{*ipNet1, *ipNet2, ipNet3}, []net.IP{ip1, ip2, ip3})
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	expectedCmds := [][]string{
+		[]string{"nft", "-f", "-"},
+	}
+
+	if !reflect.DeepEqual(exec.Cmds, expectedCmds) {
+		t.Fatalf("Expected Add cmds to match: actual %#v", exec.Cmds)
+	}
+
+	expectedRules := `
+table inet kwt-tcp-123 {}
+delete table inet kwt-tcp-123
+table inet kwt-tcp-123 {
+	set forward_subnets {
+		type ipv4_addr
+		flags interval
+		elements = { 10.0.0.0/24, 192.0.0.0/24 }
+	}
+
+	set forward_subnets6 {
+		type ipv6_addr
+		flags interval
+		elements = { fd00:10:96::/112 }
+	}
+
+	set excluded_subnets {
+		type ipv4_addr
+		flags interval
+		elements = { 10.0.0.128/25 }
+	}
+
+	set excluded_subnets6 {
+		type ipv6_addr
+		flags interval
+		
+	}
+
+	set dns_servers {
+		type ipv4_addr
+		elements = { 1.1.1.1, 2.2.2.2 }
+	}
+
+	set dns_servers6 {
+		type ipv6_addr
+		elements = { fd00:10:96::a }
+	}
+
+	chain output {
+		type nat hook output priority -100; policy accept;
+		ip ttl 42 return
+		ip6 hoplimit 42 return
+		meta skgid 125 return
+		ip daddr @excluded_subnets return
+		ip6 daddr @excluded_subnets6 return
+		ip daddr @forward_subnets meta l4proto tcp redirect to :123
+		ip6 daddr @forward_subnets6 meta l4proto tcp redirect to :123
+		ip daddr @dns_servers tcp dport 53 redirect to :200
+		ip daddr @dns_servers udp dport 53 redirect to :124
+		ip6 daddr @dns_servers6 tcp dport 53 redirect to :200
+		ip6 daddr @dns_servers6 udp dport 53 redirect to :124
+	}
+
+	chain prerouting {
+		type nat hook prerouting priority -100; policy accept;
+		ip ttl 42 return
+		ip6 hoplimit 42 return
+		ip daddr @excluded_subnets return
+		ip6 daddr @excluded_subnets6 return
+		ip daddr @forward_subnets meta l4proto tcp redirect to :123
+		ip6 daddr @forward_subnets6 meta l4proto tcp redirect to :123
+		ip daddr @dns_servers tcp dport 53 redirect to :200
+		ip daddr @dns_servers udp dport 53 redirect to :124
+		ip6 daddr @dns_servers6 tcp dport 53 redirect to :200
+		ip6 daddr @dns_servers6 udp dport 53 redirect to :124
+	}
+}
+`
+
+	if exec.Stdins[0] != expectedRules {
+		t.Fatalf("Expected Add rules to match: actual %s", exec.Stdins[0])
+	}
+
+	exec.Cmds = nil
+
+	_, ipNet4, _ := net.ParseCIDR("172.16.0.0/16")
+
+	err = nftables.AddSubnets([]net.IPNet{*ipNet4})
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	err = nftables.RemoveSubnets([]net.IPNet{*ipNet1, ipNet3})
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	expectedCmds = [][]string{
+		[]string{"nft", "add", "element", "inet", "kwt-tcp-123", "forward_subnets", "{ 172.16.0.0/16 }"},
+		[]string{"nft", "delete", "element", "inet", "kwt-tcp-123", "forward_subnets", "{ 10.0.0.0/24 }"},
+		[]string{"nft", "delete", "element", "inet", "kwt-tcp-123", "forward_subnets6", "{ fd00:10:96::/112 }"},
+	}
+
+	if !reflect.DeepEqual(exec.Cmds, expectedCmds) {
+		t.Fatalf("Expected AddSubnets/RemoveSubnets cmds to match: actual %#v", exec.Cmds)
+	}
+
+	exec.Cmds = nil
+
+	err = nftables.Reset()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	expectedCmds = [][]string{
+		[]string{"nft", "delete", "table", "inet", "kwt-tcp-123"},
+	}
+
+	if !reflect.DeepEqual(exec.Cmds, expectedCmds) {
+		t.Fatalf("Expected Reset cmds to match: actual %#v", exec.Cmds)
+	}
+}
@@ -29,11 +29,19 @@ var _ Forwarder = &Pfctl{}
 
 type PfctlOpts struct {
 	DstTCPPort    int
+	DstUDPPort    int // UDP forwarding is disabled if 0
 	DstDNSTCPPort int
 	DstDNSUDPPort int
 
 	// 0 is root, so root produced traffic wont go through proxy by default
 	ProcessGroupID int
+
+	// ExcludedSubnets are not forwarded even if they are within forwarded subnets
+	ExcludedSubnets []net.IPNet
+
+	// OwnersDir is used to record this process as owner of added rules
+	// so that they are not considered stale while it's running
+	OwnersDir string
 }
 
 func NewPfctl(opts PfctlOpts, logger Logger) *Pfctl {
@@ -59,7 +67,14 @@ func (f *Pfctl) Add(subnets []net.IPNet, dnsIPs []net.IP) error {
 	// TODO check if disabled?
 	// TODO local loopback
 
-	err := f.addAnchorIfNotExists()
+	// Recorded before adding rules so that they are never considered stale;
+	// UDP rules are part of the same anchor
+	err := f.owners().Record([]string{f.name})
+	if err != nil {
+		return err
+	}
+
+	err = f.addAnchorIfNotExists()
 	if err != nil {
 		return err
 	}
@@ -82,6 +97,29 @@ func (f *Pfctl) Add(subnets []net.IPNet, dnsIPs []net.IP) error {
 	return nil
 }
 
+func (f *Pfctl) AddSubnets(subnets []net.IPNet) error {
+	return f.updateSubnets("add", subnets)
+}
+
+func (f *Pfctl) RemoveSubnets(subnets []net.IPNet) error {
+	return f.updateSubnets("delete", subnets)
+}
+
+func (f *Pfctl) updateSubnets(action string, subnets []net.IPNet) error {
+	subnets4, _ := SplitSubnetsByFamily(subnets)
+	if len(subnets4) == 0 {
+		return nil
+	}
+
+	args := []string{"-a", f.name, "-t", "forward_subnets", "-T", action}
+	for _, subnet := range subnets4 {
+		args = append(args, subnet.String())
+	}
+
+	_, err := f.run(args, nil)
+	return err
+}
+
 func (f *Pfctl) Reset() error {
 	_, err := f.run([]string{"-a", f.name, "-F", "all"}, nil)
 	if err != nil {
@@ -102,9 +140,13 @@ func (f *Pfctl) Reset() error {
 		f.enableToken = ""
 	}
 
+	f.owners().Forget([]string{f.name})
+
 	return nil
 }
 
+func (f *Pfctl) owners() ruleOwners { return ruleOwners{f.opts.OwnersDir} }
+
 func (f *Pfctl) addAnchorIfNotExists() error {
 	output, err := f.run([]string{"-s", "all"}, nil)
 	if err != nil {
@@ -154,12 +196,27 @@ table <dns_servers> {|dnsservers|}
 rdr pass on lo0 inet proto tcp to <forward_subnets> -> 127.0.0.1 port |tcpport|
 rdr pass on lo0 inet proto tcp to <dns_servers> port 53 -> 127.0.0.1 port |dnstcpport|
 rdr pass on lo0 inet proto udp to <dns_servers> port 53 -> 127.0.0.1 port |dnsudpport|
+|udprdr|
 pass out route-to lo0 inet proto tcp to <forward_subnets> keep state |excludegroup|
 pass out route-to lo0 inet proto tcp to <dns_servers> port 53 keep state |excludegroup|
 pass out route-to lo0 inet proto udp to <dns_servers> port 53 keep state |excludegroup|
+|udppass|
 `)
 
+	// Translation rules must precede filtering rules
+	var udpRdrRule, udpPassRule string
+
+	if f.opts.DstUDPPort > 0 {
+		udpRdrRule = "rdr pass on lo0 inet proto udp to <forward_subnets> -> 127.0.0.1 port |udpport|"
+		udpPassRule = "pass out route-to lo0 inet proto udp to <forward_subnets> keep state |excludegroup|"
+	}
+
+	// Negated table entries win over broader forwarded subnets
+	// since pf tables use longest prefix match
 	subnetsStrs := []string{"!127.0.0.1/32"}
+	for _, subnet := range f.opts.ExcludedSubnets {
+		subnetsStrs = append(subnetsStrs, "!"+subnet.String())
+	}
 	for _, subnet := range subnets {
 		subnetsStrs = append(subnetsStrs, subnet.String())
 	}
@@ -170,6 +227,9 @@ pass out route-to lo0 inet proto udp to <dns_servers> port 53 keep state |exclud
 	}
 
 	rules = strings.Replace(rules, "|tcpport|", strconv.Itoa(f.opts.DstTCPPort), -1)
+	rules = strings.Replace(rules, "|udprdr|", udpRdrRule, -1)
+	rules = strings.Replace(rules, "|udppass|", udpPassRule, -1)
+	rules = strings.Replace(rules, "|udpport|", strconv.Itoa(f.opts.DstUDPPort), -1)
 	rules = strings.Replace(rules, "|dnstcpport|", strconv.Itoa(f.opts.DstDNSTCPPort), -1)
 	rules = strings.Replace(rules, "|dnsudpport|", strconv.Itoa(f.opts.DstDNSUDPPort), -1)
 	rules = strings.Replace(rules, "|subnets|", strings.Join(subnetsStrs, ","), -1)
@@ -201,3 +261,44 @@ func (f *Pfctl) run(args []string, stdin io.Reader) (string, error) {
 
 	return string(output), nil
 }
+
+// Piece of example output: 'rdr-anchor "kwt-tcp-43123" all'
+var pfctlAnchorRegexp = regexp.MustCompile(`anchor "(kwt-tcp-(\d+))"`)
+
+var _ StaleCleaner = &Pfctl{}
+
+func (f *Pfctl) CleanUpStale() ([]string, error) {
+	output, err := f.run([]string{"-s", "all"}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	seen := map[string]struct{}{}
+
+	for _, match := range pfctlAnchorRegexp.FindAllStringSubmatch(output, -1) {
+		name := match[1]
+		if _, found := seen[name]; found {
+			continue
+		}
+
+		seen[name] = struct{}{}
+
+		port, err := strconv.Atoi(match[2])
+		if err != nil || f.owners().Live(name, port, tcpPortListening) {
+			continue
+		}
+
+		f.logger.Debug(f.logTag, "Removing stale anchor %s", name)
+
+		// Enable token of the previous process is lost, hence pf stays enabled
+		err = NewPfctl(PfctlOpts{DstTCPPort: port, OwnersDir: f.opts.OwnersDir}, f.logger).Reset()
+		if err != nil {
+			return removed, err
+		}
+
+		removed = append(removed, fmt.Sprintf("pfctl anchor %s", name))
+	}
+
+	return removed, nil
+}
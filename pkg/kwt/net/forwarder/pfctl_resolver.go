@@ -16,6 +16,7 @@ type PfctlResolver struct {
 }
 
 var _ OriginalDstResolver = PfctlResolver{}
+var _ OriginalDstPacketResolver = PfctlResolver{}
 
 func NewPfctlResolver(logger Logger) (PfctlResolver, error) {
 	pfctl, err := pf.NewPfctl()
@@ -57,11 +58,46 @@ func (r PfctlResolver) GetOrigIPPort(conn net.Conn) (net.IP, int, error) {
 	return r.GetOrigIPPortWithOpts(opts)
 }
 
+func (r PfctlResolver) ListenUDP(addr *net.UDPAddr) (*net.UDPConn, error) {
+	return net.ListenUDP("udp", addr)
+}
+
+func (r PfctlResolver) ReadFromUDP(conn *net.UDPConn, b []byte) (int, *net.UDPAddr, net.IP, int, error) {
+	n, srcAddr, err := conn.ReadFromUDP(b)
+	if err != nil {
+		return 0, nil, nil, 0, err
+	}
+
+	dstAddr := conn.LocalAddr().(*net.UDPAddr)
+
+	opts := PfctlResolverOpts{
+		SrcIP:   srcAddr.IP,
+		SrcPort: int32(srcAddr.Port),
+		DstIP:   dstAddr.IP,
+		DstPort: int32(dstAddr.Port),
+		UDP:     true,
+	}
+
+	ip, port, err := r.GetOrigIPPortWithOpts(opts)
+	if err != nil {
+		return 0, nil, nil, 0, err
+	}
+
+	return n, srcAddr, ip, port, nil
+}
+
+// NewReplyConn returns listening connection since pf
+// rewrites source address of replies according to rdr state
+func (r PfctlResolver) NewReplyConn(lisConn *net.UDPConn, _ net.IP, _ int) (PacketReplyConn, error) {
+	return pfctlReplyConn{lisConn}, nil
+}
+
 type PfctlResolverOpts struct {
 	SrcIP   net.IP
 	SrcPort int32
 	DstIP   net.IP
 	DstPort int32
+	UDP     bool
 }
 
 func (r PfctlResolver) GetOrigIPPortWithOpts(opts PfctlResolverOpts) (net.IP, int, error) {
@@ -70,5 +106,13 @@ func (r PfctlResolver) GetOrigIPPortWithOpts(opts PfctlResolverOpts) (net.IP, in
 		SrcPort: opts.SrcPort,
 		DstIP:   opts.DstIP,
 		DstPort: opts.DstPort,
+		UDP:     opts.UDP,
 	})
 }
+
+type pfctlReplyConn struct {
+	*net.UDPConn
+}
+
+// Close does not close shared listening connection
+func (pfctlReplyConn) Close() error { return nil }
@@ -0,0 +1,193 @@
+package forwarder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultOwnersDir is only writable by root
+	// since forwarders always run under sudo
+	DefaultOwnersDir = "/var/run/kwt/forwarders"
+)
+
+// StaleCleaner removes rules left behind by kwt processes
+// that did not get a chance to reset their forwarder (eg killed with SIGKILL).
+// Rules are considered stale if process that added them is no longer running.
+type StaleCleaner interface {
+	// CleanUpStale returns descriptions of removed items
+	CleanUpStale() ([]string, error)
+}
+
+type MultiStaleCleaner []StaleCleaner
+
+var _ StaleCleaner = MultiStaleCleaner{}
+
+func (c MultiStaleCleaner) CleanUpStale() ([]string, error) {
+	var result []string
+
+	for _, cleaner := range c {
+		removed, err := cleaner.CleanUpStale()
+		result = append(result, removed...)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// ruleOwners records PID of the process that added rules (keyed by rules name,
+// eg kwt-tcp-43123) so that rules of running processes are kept
+// even if their proxy port is not listening (eg kwt net start-dns).
+// Recording is disabled if dir is empty.
+type ruleOwners struct {
+	dir string
+}
+
+const udpRulesNamePrefix = "kwt-udp-"
+
+func tcpRulesName(port int) string { return fmt.Sprintf("kwt-tcp-%d", port) }
+func udpRulesName(port int) string { return fmt.Sprintf("%s%d", udpRulesNamePrefix, port) }
+
+// rulesNames returns names of all rules added for given ports
+func rulesNames(tcpPort, udpPort int) []string {
+	names := []string{tcpRulesName(tcpPort)}
+	if udpPort > 0 {
+		names = append(names, udpRulesName(udpPort))
+	}
+	return names
+}
+
+func (o ruleOwners) Record(names []string) error {
+	if len(o.dir) == 0 {
+		return nil
+	}
+
+	err := os.MkdirAll(o.dir, 0700)
+	if err != nil {
+		return fmt.Errorf("Creating forwarder owners directory: %s", err)
+	}
+
+	for _, name := range names {
+		err := ioutil.WriteFile(o.path(name), []byte(strconv.Itoa(os.Getpid())), 0600)
+		if err != nil {
+			return fmt.Errorf("Recording forwarder owner: %s", err)
+		}
+	}
+
+	return nil
+}
+
+func (o ruleOwners) Forget(names []string) {
+	if len(o.dir) == 0 {
+		return
+	}
+	for _, name := range names {
+		os.Remove(o.path(name))
+	}
+}
+
+// Live falls back to checking if proxy port is listening
+// for rules without recorded owner (eg added by older versions)
+func (o ruleOwners) Live(name string, port int, listeningFunc func(int) bool) bool {
+	if len(o.dir) > 0 {
+		contents, err := ioutil.ReadFile(o.path(name))
+		if err == nil {
+			pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+			if err == nil {
+				return processRunning(pid)
+			}
+		}
+	}
+
+	return listeningFunc(port)
+}
+
+// Exited is true only if owner was recorded and is no longer running
+func (o ruleOwners) Exited(name string) bool {
+	if len(o.dir) == 0 {
+		return false
+	}
+
+	contents, err := ioutil.ReadFile(o.path(name))
+	if err != nil {
+		return false
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return false
+	}
+
+	return !processRunning(pid)
+}
+
+// Names returns sorted names of rules with recorded owners
+func (o ruleOwners) Names(prefix string) []string {
+	if len(o.dir) == 0 {
+		return nil
+	}
+
+	files, err := ioutil.ReadDir(o.dir)
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), prefix) {
+			names = append(names, file.Name())
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func (o ruleOwners) path(name string) string {
+	return filepath.Join(o.dir, name)
+}
+
+func processRunning(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, syscall.Signal(0))
+	// EPERM means that process exists but belongs to someone else
+	return err == nil || err == syscall.EPERM
+}
+
+func tcpPortListening(port int) bool {
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+
+	conn, err := net.DialTimeout("tcp", addr, 1*time.Second)
+	if err != nil {
+		return false
+	}
+
+	conn.Close()
+
+	return true
+}
+
+func udpPortListening(port int) bool {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		return true // port is taken
+	}
+
+	conn.Close()
+
+	return false
+}
@@ -0,0 +1,155 @@
+package forwarder_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/logtest"
+	. "github.com/carvel-dev/kwt/pkg/kwt/net/forwarder"
+)
+
+// unusedTCPPorts returns ports that nothing listens on
+func unusedTCPPorts(t *testing.T, num int) []string {
+	var ports []string
+
+	for i := 0; i < num; i++ {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Expected no err: %s", err)
+		}
+		defer lis.Close()
+
+		ports = append(ports, strconv.Itoa(lis.Addr().(*net.TCPAddr).Port))
+	}
+
+	return ports
+}
+
+func exitedProcessPID(t *testing.T) int {
+	cmd := osexec.Command("true")
+
+	err := cmd.Run()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	return cmd.Process.Pid
+}
+
+func TestNftablesCleanUpStaleKeepsRunningOwners(t *testing.T) {
+	ownersDir, err := ioutil.TempDir("", "kwt-forwarder-owners")
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer os.RemoveAll(ownersDir)
+
+	ports := unusedTCPPorts(t, 3)
+	livePort, exitedPort, unknownPort := ports[0], ports[1], ports[2]
+
+	livePortInt, _ := strconv.Atoi(livePort)
+
+	// Similar to 'kwt net start-dns' which does not listen on its TCP port
+	liveNftables := NewNftables(NftablesOpts{DstTCPPort: livePortInt, OwnersDir: ownersDir}, &FakeCmdExecutor{}, logtest.NoopLogger{})
+
+	err = liveNftables.Add(nil, []net.IP{net.ParseIP("1.1.1.1")})
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	exitedOwnerPath := filepath.Join(ownersDir, "kwt-tcp-"+exitedPort)
+
+	err = ioutil.WriteFile(exitedOwnerPath, []byte(strconv.Itoa(exitedProcessPID(t))), 0600)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	exec := &FakeCmdExecutor{
+		Outputs: map[string]string{
+			"nft list tables": strings.Join([]string{
+				"table inet kwt-tcp-" + livePort,
+				"table inet kwt-tcp-" + exitedPort,
+				"table inet kwt-tcp-" + unknownPort,
+			}, "\n"),
+		},
+	}
+
+	removed, err := NewNftables(NftablesOpts{OwnersDir: ownersDir}, exec, logtest.NoopLogger{}).CleanUpStale()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	expectedRemoved := []string{
+		"nftables table inet kwt-tcp-" + exitedPort,
+		// Not listening and without recorded owner (eg added by older version)
+		"nftables table inet kwt-tcp-" + unknownPort,
+	}
+
+	if !reflect.DeepEqual(removed, expectedRemoved) {
+		t.Fatalf("Expected removed items to match: actual %#v", removed)
+	}
+
+	if _, err := os.Stat(exitedOwnerPath); !os.IsNotExist(err) {
+		t.Fatalf("Expected exited owner to be forgotten: %s", err)
+	}
+
+	err = liveNftables.Reset()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(ownersDir, "kwt-tcp-"+livePort)); !os.IsNotExist(err) {
+		t.Fatalf("Expected owner to be forgotten after reset: %s", err)
+	}
+}
+
+func TestIptablesCleanUpStaleKeepsRunningOwners(t *testing.T) {
+	ownersDir, err := ioutil.TempDir("", "kwt-forwarder-owners")
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer os.RemoveAll(ownersDir)
+
+	ports := unusedTCPPorts(t, 2)
+	livePort, exitedPort := ports[0], ports[1]
+
+	livePortInt, _ := strconv.Atoi(livePort)
+
+	liveIptables := NewIptables(IptablesOpts{DstTCPPort: livePortInt, OwnersDir: ownersDir}, &FakeCmdExecutor{}, logtest.NoopLogger{})
+
+	err = liveIptables.Add(nil, []net.IP{net.ParseIP("1.1.1.1")})
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(ownersDir, "kwt-tcp-"+exitedPort), []byte(strconv.Itoa(exitedProcessPID(t))), 0600)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	exec := &FakeCmdExecutor{
+		Outputs: map[string]string{
+			"iptables -w -t nat -S": strings.Join([]string{
+				"-N kwt-tcp-" + livePort + "-output",
+				"-N kwt-tcp-" + exitedPort + "-output",
+			}, "\n"),
+		},
+	}
+
+	removed, err := NewIptables(IptablesOpts{OwnersDir: ownersDir}, exec, logtest.NoopLogger{}).CleanUpStale()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	if !reflect.DeepEqual(removed, []string{"iptables nat chain kwt-tcp-" + exitedPort + "-output"}) {
+		t.Fatalf("Expected removed items to match: actual %#v", removed)
+	}
+}
@@ -3,36 +3,110 @@ package net
 import (
 	"fmt"
 	"net"
+	"sort"
 	"strconv"
+	"sync"
+	"time"
 
+	"github.com/carvel-dev/kwt/pkg/kwt/net/accesslog"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/capture"
 	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/fault"
 	"github.com/carvel-dev/kwt/pkg/kwt/net/forwarder"
 )
 
 type ForwardingProxy struct {
 	forwarderFactory forwarder.Factory
 	dnsServerFactory DNSServerFactory
-	shutdownCh       chan struct{}
+	drainTimeout     time.Duration
+	capturer         *capture.Capturer
+	injector         *fault.Injector
+	accessLog        *accesslog.Log
+	metricsDsts      *MetricsDestinations
+
+	shutdownCh      chan struct{}
+	forceShutdownCh chan struct{}
+	forceOnce       sync.Once
+
+	// Available for control while serving
+	tcpProxy    *TCPProxy
+	servingLock sync.RWMutex
+
+	// Subnets received before forwarding is ready are kept as pending
+	subnetsUpdater *SubnetsUpdater
+	pendingSubnets []net.IPNet
+	subnetsLock    sync.Mutex
+
+	// Reported in status
+	forwardedSubnets  map[string]net.IPNet
+	interceptedDNSIPs []net.IP
+	statusLock        sync.RWMutex
 
 	logTag string
 	logger Logger
 }
 
-func NewForwardingProxy(forwarderFactory forwarder.Factory, dnsServerFactory DNSServerFactory, logger Logger) *ForwardingProxy {
-	return &ForwardingProxy{forwarderFactory, dnsServerFactory, make(chan struct{}), "ForwardingProxy", logger}
+func NewForwardingProxy(forwarderFactory forwarder.Factory, dnsServerFactory DNSServerFactory,
+	drainTimeout time.Duration, logger Logger) *ForwardingProxy {
+
+	return &ForwardingProxy{
+		forwarderFactory: forwarderFactory,
+		dnsServerFactory: dnsServerFactory,
+		drainTimeout:     drainTimeout,
+
+		shutdownCh:      make(chan struct{}),
+		forceShutdownCh: make(chan struct{}),
+
+		forwardedSubnets: map[string]net.IPNet{},
+
+		logTag: "ForwardingProxy",
+		logger: logger,
+	}
+}
+
+// WithCapturer records proxied TCP connections accepted by capturer
+func (o *ForwardingProxy) WithCapturer(capturer *capture.Capturer) *ForwardingProxy {
+	o.capturer = capturer
+	return o
+}
+
+// WithInjector applies fault rules to proxied TCP connections
+func (o *ForwardingProxy) WithInjector(injector *fault.Injector) *ForwardingProxy {
+	o.injector = injector
+	return o
+}
+
+// WithAccessLog records finished TCP connections into access log
+func (o *ForwardingProxy) WithAccessLog(accessLog *accesslog.Log) *ForwardingProxy {
+	o.accessLog = accessLog
+	return o
+}
+
+// WithMetricsDestinations labels TCP connection metrics with their destinations
+func (o *ForwardingProxy) WithMetricsDestinations(metricsDsts *MetricsDestinations) *ForwardingProxy {
+	o.metricsDsts = metricsDsts
+	return o
 }
 
+// Serve forwards given subnets; use UpdateSubnets to change them afterwards
 func (o *ForwardingProxy) Serve(dstConnFactory dstconn.Factory, subnets []net.IPNet, dnsIPs []net.IP) error {
+
 	origDstResolver, err := o.forwarderFactory.NewOriginalDstResolver()
 	if err != nil {
 		return err
 	}
 
-	tcpProxy := NewTCPProxy(origDstResolver, dstConnFactory, o.logger)
+	origDstPacketResolver, err := o.forwarderFactory.NewOriginalDstPacketResolver()
+	if err != nil {
+		return err
+	}
+
+	tcpProxy := NewTCPProxy(origDstResolver, dstConnFactory, o.logger).WithCapturer(o.capturer).
+		WithInjector(o.injector).WithAccessLog(o.accessLog).WithMetricsDestinations(o.metricsDsts)
 	tcpProxyErrCh := make(chan error)
 	tcpProxyStartedCh := make(chan struct{})
 
-	udpProxy := NewUDPProxy(dstConnFactory, o.logger)
+	udpProxy := NewUDPProxy(origDstPacketResolver, dstConnFactory, o.logger)
 	udpProxyErrCh := make(chan error)
 	udpProxyStartedCh := make(chan struct{})
 
@@ -64,7 +138,7 @@ func (o *ForwardingProxy) Serve(dstConnFactory dstconn.Factory, subnets []net.IP
 		<-udpProxyStartedCh
 		<-dnsServerStartedCh
 
-		actualForwarder, err := o.buildForwarder(tcpProxy, dnsServer)
+		actualForwarder, err := o.buildForwarder(tcpProxy, udpProxy, dnsServer)
 		if err != nil {
 			forwarderErrCh <- err
 			return
@@ -76,11 +150,16 @@ func (o *ForwardingProxy) Serve(dstConnFactory dstconn.Factory, subnets []net.IP
 			return
 		}
 
+		o.cleanUpStale()
+
 		forwarder.SetForwarder(actualForwarder)
 
 		o.logger.Info(o.logTag, "Forwarding subnets: %s", SubnetsAsString(subnets))
+		o.warnUDPSubnets6(subnets)
+
+		subnetsUpdater := NewSubnetsUpdater(forwarder, o.logger)
 
-		err = forwarder.Add(subnets, dnsIPs)
+		err = subnetsUpdater.Add(subnets, dnsIPs)
 		if err != nil {
 			forwarderErrCh <- err
 			return
@@ -88,6 +167,27 @@ func (o *ForwardingProxy) Serve(dstConnFactory dstconn.Factory, subnets []net.IP
 
 		o.dnsServerFactory.NewDNSOSCache().Flush()
 
+		o.statusLock.Lock()
+		o.interceptedDNSIPs = dnsIPs
+		o.statusLock.Unlock()
+
+		o.recordSubnets(subnetsUpdater.Applied(), nil)
+
+		o.servingLock.Lock()
+		o.tcpProxy = tcpProxy
+		o.servingLock.Unlock()
+
+		o.subnetsLock.Lock()
+		o.subnetsUpdater = subnetsUpdater
+		if o.pendingSubnets != nil {
+			err := o.updateSubnets(o.pendingSubnets)
+			if err != nil {
+				o.logger.Error(o.logTag, "Failed updating subnets: %s", err)
+			}
+			o.pendingSubnets = nil
+		}
+		o.subnetsLock.Unlock()
+
 		o.logger.Info(o.logTag, "Ready!")
 	}()
 
@@ -110,17 +210,37 @@ func (o *ForwardingProxy) Serve(dstConnFactory dstconn.Factory, subnets []net.IP
 
 	origErr := <-errCh
 
-	// Most import to reset since forwarder's resources are not reclaimed automatically
-	err = forwarder.Reset()
-	if err != nil {
-		o.logger.Error(o.logTag, "Failed resetting forwarder: %s", err)
-	}
+	o.servingLock.Lock()
+	o.tcpProxy = nil
+	o.servingLock.Unlock()
 
+	o.subnetsLock.Lock()
+	o.subnetsUpdater = nil
+	o.subnetsLock.Unlock()
+
+	o.statusLock.Lock()
+	o.forwardedSubnets = map[string]net.IPNet{}
+	o.interceptedDNSIPs = nil
+	o.statusLock.Unlock()
+
+	// Stop accepting new connections but let existing ones finish
 	err = tcpProxy.Shutdown()
 	if err != nil {
 		o.logger.Error(o.logTag, "Failed shutting down TCP proxy: %s", err)
 	}
 
+	if o.drainTimeout > 0 && tcpProxy.ActiveConns() > 0 {
+		o.logger.Info(o.logTag, "Draining connections for up to %s (press Ctrl-C again to skip)", o.drainTimeout)
+	}
+
+	tcpProxy.Drain(o.drainTimeout, o.forceShutdownCh)
+
+	// Most import to reset since forwarder's resources are not reclaimed automatically
+	err = forwarder.Reset()
+	if err != nil {
+		o.logger.Error(o.logTag, "Failed resetting forwarder: %s", err)
+	}
+
 	err = udpProxy.Shutdown()
 	if err != nil {
 		o.logger.Error(o.logTag, "Failed shutting down UDP proxy: %s", err)
@@ -134,17 +254,149 @@ func (o *ForwardingProxy) Serve(dstConnFactory dstconn.Factory, subnets []net.IP
 	return origErr
 }
 
+var _ ControlConns = &ForwardingProxy{}
+
+// UpdateSubnets makes forwarder forward given subnets (all desired subnets, not only changes);
+// failed changes are retried with the next update
+func (o *ForwardingProxy) UpdateSubnets(subnets []net.IPNet) error {
+	o.subnetsLock.Lock()
+	defer o.subnetsLock.Unlock()
+
+	if o.subnetsUpdater == nil {
+		o.pendingSubnets = subnets
+		return nil
+	}
+
+	return o.updateSubnets(subnets)
+}
+
+// ForwardedSubnets returns subnets currently redirected to proxies
+func (o *ForwardingProxy) ForwardedSubnets() []net.IPNet {
+	o.statusLock.RLock()
+	defer o.statusLock.RUnlock()
+
+	var result []net.IPNet
+
+	for _, subnet := range o.forwardedSubnets {
+		result = append(result, subnet)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].String() < result[j].String() })
+
+	return result
+}
+
+// InterceptedDNSIPs returns DNS servers whose traffic is redirected to DNS server
+func (o *ForwardingProxy) InterceptedDNSIPs() []net.IP {
+	o.statusLock.RLock()
+	defer o.statusLock.RUnlock()
+
+	return append([]net.IP{}, o.interceptedDNSIPs...)
+}
+
+func (o *ForwardingProxy) ActiveConns() int {
+	o.servingLock.RLock()
+	defer o.servingLock.RUnlock()
+
+	if o.tcpProxy == nil {
+		return 0
+	}
+
+	return o.tcpProxy.ActiveConns()
+}
+
+func (o *ForwardingProxy) Conns() []ProxiedConn {
+	o.servingLock.RLock()
+	defer o.servingLock.RUnlock()
+
+	if o.tcpProxy == nil {
+		return nil
+	}
+
+	return o.tcpProxy.Conns()
+}
+
+func (o *ForwardingProxy) CloseConn(id uint64) bool {
+	o.servingLock.RLock()
+	defer o.servingLock.RUnlock()
+
+	if o.tcpProxy == nil {
+		return false
+	}
+
+	return o.tcpProxy.CloseConn(id)
+}
+
 func (o *ForwardingProxy) Shutdown() error {
 	close(o.shutdownCh)
 	return nil
 }
 
-func (o *ForwardingProxy) buildForwarder(tcpProxy *TCPProxy, dnsServer DNSServer) (forwarder.Forwarder, error) {
+// ForceShutdown skips waiting for connections to drain
+func (o *ForwardingProxy) ForceShutdown() error {
+	o.forceOnce.Do(func() { close(o.forceShutdownCh) })
+	return nil
+}
+
+func (o *ForwardingProxy) cleanUpStale() {
+	cleaner, err := o.forwarderFactory.NewStaleCleaner()
+	if err != nil {
+		o.logger.Error(o.logTag, "Failed checking for stale forwarder state: %s", err)
+		return
+	}
+
+	removed, err := cleaner.CleanUpStale()
+
+	for _, desc := range removed {
+		o.logger.Info(o.logTag, "Removed stale %s left by previous run", desc)
+	}
+
+	if err != nil {
+		o.logger.Error(o.logTag, "Failed removing stale forwarder state: %s", err)
+	}
+}
+
+func (o *ForwardingProxy) updateSubnets(subnets []net.IPNet) error {
+	added, removed, err := o.subnetsUpdater.Update(subnets)
+
+	o.warnUDPSubnets6(added)
+	o.recordSubnets(added, removed)
+
+	return err
+}
+
+// warnUDPSubnets6 makes it explicit that IPv6 subnets are forwarded for TCP only
+// since UDP relay in net pod and forwarders' UDP rules are IPv4 only
+func (o *ForwardingProxy) warnUDPSubnets6(subnets []net.IPNet) {
+	_, subnets6 := forwarder.SplitSubnetsByFamily(subnets)
+	if len(subnets6) > 0 {
+		o.logger.Info(o.logTag, "Not forwarding UDP for IPv6 subnets (only TCP is forwarded): %s", SubnetsAsString(subnets6))
+	}
+}
+
+func (o *ForwardingProxy) recordSubnets(added, removed []net.IPNet) {
+	o.statusLock.Lock()
+	defer o.statusLock.Unlock()
+
+	for _, subnet := range added {
+		o.forwardedSubnets[subnet.String()] = subnet
+	}
+	for _, subnet := range removed {
+		delete(o.forwardedSubnets, subnet.String())
+	}
+}
+
+func (o *ForwardingProxy) buildForwarder(tcpProxy *TCPProxy, udpProxy *UDPProxy, dnsServer DNSServer) (forwarder.Forwarder, error) {
 	tcpPort, err := o.portFromAddr(tcpProxy.Addr())
 	if err != nil {
 		return nil, err
 	}
 
+	udpPort, err := o.portFromAddr(udpProxy.Addr())
+	if err != nil {
+		return nil, err
+	}
+
 	dnsTCPPort, err := o.portFromAddr(dnsServer.TCPAddr())
 	if err != nil {
 		return nil, err
@@ -157,6 +409,7 @@ func (o *ForwardingProxy) buildForwarder(tcpProxy *TCPProxy, dnsServer DNSServer
 
 	return o.forwarderFactory.NewForwarder(forwarder.ForwarderOpts{
 		DstTCPPort:    tcpPort,
+		DstUDPPort:    udpPort,
 		DstDNSTCPPort: dnsTCPPort,
 		DstDNSUDPPort: dnsUDPPort,
 	})
@@ -0,0 +1,90 @@
+package net
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
+)
+
+type HostResolver interface {
+	LookupIP(host string) ([]net.IP, error)
+}
+
+// DNSServerHostResolver resolves hosts against given DNS server
+// (eg kwt DNS server) instead of system configured resolvers
+type DNSServerHostResolver struct {
+	resolver *net.Resolver
+}
+
+var _ HostResolver = DNSServerHostResolver{}
+
+func NewDNSServerHostResolver(dnsServerAddr net.Addr) DNSServerHostResolver {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+
+	return DNSServerHostResolver{&net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, dnsServerAddr.String())
+		},
+	}}
+}
+
+func (r DNSServerHostResolver) LookupIP(host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	addrs, err := r.resolver.LookupIPAddr(context.Background(), host)
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("Expected host '%s' to resolve to at least one IP", host)
+	}
+
+	return ips, nil
+}
+
+// dialHost prefers IPv4 addresses since remote networks
+// typically do not have IPv6 connectivity
+func dialHost(resolver HostResolver, dstConnFactory dstconn.Factory, host string, port int) (net.Conn, error) {
+	ips, err := resolver.LookupIP(host)
+	if err != nil {
+		return nil, fmt.Errorf("Resolving host '%s': %s", host, err)
+	}
+
+	var sortedIPs []net.IP
+
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			sortedIPs = append(sortedIPs, ip)
+		}
+	}
+	for _, ip := range ips {
+		if ip.To4() == nil {
+			sortedIPs = append(sortedIPs, ip)
+		}
+	}
+
+	var lastErr error
+
+	for _, ip := range sortedIPs {
+		conn, err := dstConnFactory.NewConn(ip, port)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
@@ -0,0 +1,149 @@
+package net
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
+)
+
+// HTTPConnectProxy is an HTTP proxy that only supports CONNECT method,
+// which is what clients use for HTTPS (eg HTTPS_PROXY)
+type HTTPConnectProxy struct {
+	listenAddr     string
+	resolver       HostResolver
+	dstConnFactory dstconn.Factory
+
+	listener net.Listener
+
+	logTag string
+	logger Logger
+}
+
+func NewHTTPConnectProxy(listenAddr string, resolver HostResolver, dstConnFactory dstconn.Factory, logger Logger) *HTTPConnectProxy {
+	return &HTTPConnectProxy{
+		listenAddr:     listenAddr,
+		resolver:       resolver,
+		dstConnFactory: dstConnFactory,
+
+		logTag: "HTTPConnectProxy",
+		logger: logger,
+	}
+}
+
+func (p *HTTPConnectProxy) Serve(startedCh chan struct{}) error {
+	listener, err := net.Listen("tcp", p.listenAddr)
+	if err != nil {
+		return fmt.Errorf("Listening on '%s': %s", p.listenAddr, err)
+	}
+
+	p.listener = listener
+
+	defer p.listener.Close()
+
+	startedCh <- struct{}{}
+
+	p.logger.Info(p.logTag, "Started HTTP CONNECT proxy on %s", p.Addr())
+
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return nil // listener was closed
+		}
+		go p.serveConn(conn)
+	}
+}
+
+func (p *HTTPConnectProxy) Addr() net.Addr { return p.listener.Addr() }
+
+func (p *HTTPConnectProxy) Shutdown() error {
+	if p.listener != nil {
+		p.listener.Close()
+	}
+	return nil
+}
+
+func (p *HTTPConnectProxy) serveConn(srcConn net.Conn) {
+	srcDesc := srcConn.RemoteAddr()
+	reader := bufio.NewReader(srcConn)
+
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		p.logger.Error(p.logTag, "Failed reading request from '%s': %s", srcDesc, err)
+		srcConn.Close()
+		return
+	}
+
+	if req.Method != http.MethodConnect {
+		p.logger.Error(p.logTag, "Received unsupported method '%s' from '%s'", req.Method, srcDesc)
+		p.reply(srcConn, http.StatusMethodNotAllowed)
+		srcConn.Close()
+		return
+	}
+
+	host, portStr, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		p.logger.Error(p.logTag, "Failed parsing destination '%s': %s", req.Host, err)
+		p.reply(srcConn, http.StatusBadRequest)
+		srcConn.Close()
+		return
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		p.logger.Error(p.logTag, "Failed parsing destination port '%s': %s", portStr, err)
+		p.reply(srcConn, http.StatusBadRequest)
+		srcConn.Close()
+		return
+	}
+
+	dstConn, err := dialHost(p.resolver, p.dstConnFactory, host, port)
+	if err != nil {
+		p.logger.Error(p.logTag, "Could not establish remote connection to '%s': %s", req.Host, err)
+		p.reply(srcConn, http.StatusBadGateway)
+		srcConn.Close()
+		return
+	}
+
+	err = p.reply(srcConn, http.StatusOK)
+	if err != nil {
+		p.logger.Error(p.logTag, "Failed replying to '%s': %s", srcDesc, err)
+		srcConn.Close()
+		dstConn.Close()
+		return
+	}
+
+	proxyDesc := fmt.Sprintf("%s->%s", srcDesc, req.Host)
+	p.logger.Info(p.logTag, "Started %s", proxyDesc)
+
+	// Client may have sent data (eg TLS hello) right after request
+	bufSrcConn := &bufferedConn{Conn: srcConn, reader: reader}
+
+	p.dstConnFactory.NewConnCopier(proxyDesc).CopyAndClose(dstConn, bufSrcConn)
+
+	p.logger.Info(p.logTag, "Finished %s", proxyDesc)
+}
+
+func (p *HTTPConnectProxy) reply(conn net.Conn, status int) error {
+	_, err := fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n\r\n", status, http.StatusText(status))
+	return err
+}
+
+// bufferedConn reads through buffered reader
+// while preserving CloseWrite expected by conn copiers
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) { return c.reader.Read(b) }
+
+func (c *bufferedConn) CloseWrite() error {
+	if closer, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return closer.CloseWrite()
+	}
+	return nil
+}
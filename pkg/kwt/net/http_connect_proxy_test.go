@@ -0,0 +1,56 @@
+package net_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/logtest"
+	. "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
+)
+
+func TestHTTPConnectProxy(t *testing.T) {
+	backend, port := startEchoBackend(t)
+	defer backend.Close()
+
+	resolver := fakeHostResolver{map[string]net.IP{"svc.ns.svc.cluster.local": net.ParseIP("127.0.0.1")}}
+	proxy := NewHTTPConnectProxy("127.0.0.1:0", resolver, dstconn.NewLocal(logtest.NoopLogger{}), logtest.NoopLogger{})
+
+	defer proxy.Shutdown()
+
+	startedCh := make(chan struct{})
+	go proxy.Serve(startedCh)
+	<-startedCh
+
+	conn, err := net.Dial("tcp", proxy.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer conn.Close()
+
+	_, err = fmt.Fprintf(conn, "CONNECT svc.ns.svc.cluster.local:%d HTTP/1.1\r\nHost: svc.ns.svc.cluster.local\r\n\r\n", port)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	reader := bufio.NewReader(conn)
+
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected successful response: %d", resp.StatusCode)
+	}
+
+	expectEcho(t, struct {
+		io.Reader
+		io.Writer
+	}{reader, conn})
+}
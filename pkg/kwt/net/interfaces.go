@@ -8,7 +8,11 @@ import (
 
 type EntryPoint interface {
 	EntryPoint() (EntryPointSession, error)
+	Status() EntryPointStatus
 	Delete() error
+	// Release cleans up resources that only this process can use
+	// (eg net pod started with ephemeral keys) once it's done with entry point
+	Release() error
 }
 
 type EntryPointSession interface {
@@ -18,6 +22,8 @@ type EntryPointSession interface {
 
 type Subnets interface {
 	Subnets() ([]net.IPNet, error)
+	// Watch blocks until doneCh is closed, calling changedFunc with all subnets
+	Watch(changedFunc func([]net.IPNet), doneCh chan struct{}) error
 }
 
 type DNSIPs interface {
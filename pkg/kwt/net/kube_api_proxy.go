@@ -0,0 +1,228 @@
+package net
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"golang.org/x/net/websocket"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// KubeAPIProxy carries TCP connections over WebSocket connections
+// made through K8s API server's service proxy. It's used when
+// port forwarding is not allowed by RBAC.
+type KubeAPIProxy struct {
+	serviceName string
+	namespace   string
+	coreClient  kubernetes.Interface
+	restConfig  *rest.Config
+
+	listener     net.Listener
+	listenerLock sync.Mutex
+	stopCh       chan struct{}
+
+	logTag string
+	logger Logger
+}
+
+func NewKubeAPIProxy(
+	serviceName string,
+	namespace string,
+	coreClient kubernetes.Interface,
+	restConfig *rest.Config,
+	logger Logger,
+) *KubeAPIProxy {
+	return &KubeAPIProxy{
+		serviceName: serviceName,
+		namespace:   namespace,
+		coreClient:  coreClient,
+		restConfig:  restConfig,
+
+		stopCh: make(chan struct{}),
+
+		logTag: "KubeAPIProxy",
+		logger: logger,
+	}
+}
+
+func (f *KubeAPIProxy) Start(remotePort int, startedCh chan struct{}) error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("Listening locally: %s", err)
+	}
+
+	f.listenerLock.Lock()
+	f.listener = listener
+	f.listenerLock.Unlock()
+
+	f.logger.Debug(f.logTag, "Starting API proxying on %s", listener.Addr())
+
+	close(startedCh)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-f.stopCh:
+				f.logger.Debug(f.logTag, "Finished API proxying")
+				return nil
+			default:
+				return fmt.Errorf("Accepting connection: %s", err)
+			}
+		}
+
+		go f.proxy(conn, remotePort)
+	}
+}
+
+// LocalPort should only be called after API proxying is ready
+func (f *KubeAPIProxy) LocalPort() (int, error) {
+	f.listenerLock.Lock()
+	defer f.listenerLock.Unlock()
+
+	if f.listener == nil {
+		return 0, fmt.Errorf("Expected API proxying to be started")
+	}
+
+	_, portStr, err := net.SplitHostPort(f.listener.Addr().String())
+	if err != nil {
+		return 0, fmt.Errorf("Splitting local address: %s", err)
+	}
+
+	localPort, err := strconv.Atoi(portStr)
+	if err != nil {
+		return 0, fmt.Errorf("Parsing local port: %s", err)
+	}
+
+	return localPort, nil
+}
+
+func (f *KubeAPIProxy) Shutdown() error {
+	f.listenerLock.Lock()
+	defer f.listenerLock.Unlock()
+
+	select {
+	case <-f.stopCh:
+		return nil // already shut down
+	default:
+		close(f.stopCh)
+	}
+
+	if f.listener != nil {
+		return f.listener.Close()
+	}
+
+	return nil
+}
+
+func (f *KubeAPIProxy) proxy(conn net.Conn, remotePort int) {
+	defer conn.Close()
+
+	wsConn, err := f.dial(remotePort)
+	if err != nil {
+		f.logger.Error(f.logTag, "Failed dialing API proxy: %s", err)
+		return
+	}
+
+	defer wsConn.Close()
+
+	doneCh := make(chan struct{}, 2)
+
+	go func() {
+		io.Copy(wsConn, conn)
+		doneCh <- struct{}{}
+	}()
+
+	go func() {
+		io.Copy(conn, wsConn)
+		doneCh <- struct{}{}
+	}()
+
+	// Close both sides as soon as either side is done
+	<-doneCh
+}
+
+func (f *KubeAPIProxy) dial(remotePort int) (*websocket.Conn, error) {
+	proxyURL := f.coreClient.CoreV1().RESTClient().Get().
+		Resource("services").
+		Namespace(f.namespace).
+		Name(f.serviceName + ":" + strconv.Itoa(remotePort)).
+		SubResource("proxy").
+		URL()
+
+	wsURL := *proxyURL
+
+	switch proxyURL.Scheme {
+	case "https":
+		wsURL.Scheme = "wss"
+	case "http":
+		wsURL.Scheme = "ws"
+	default:
+		return nil, fmt.Errorf("Unknown API server scheme '%s'", proxyURL.Scheme)
+	}
+
+	config, err := websocket.NewConfig(wsURL.String(), (&url.URL{Scheme: proxyURL.Scheme, Host: proxyURL.Host}).String())
+	if err != nil {
+		return nil, fmt.Errorf("Building websocket config: %s", err)
+	}
+
+	config.TlsConfig, err = rest.TLSConfigFor(f.restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("Building TLS config: %s", err)
+	}
+
+	config.Header, err = f.authHeader(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	wsConn, err := websocket.DialConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("Dialing websocket: %s", err)
+	}
+
+	wsConn.PayloadType = websocket.BinaryFrame
+
+	return wsConn, nil
+}
+
+// authHeader collects headers set by configured auth round trippers
+// (bearer token, basic auth, auth providers) since websocket package
+// makes its own HTTP request
+func (f *KubeAPIProxy) authHeader(reqURL *url.URL) (http.Header, error) {
+	capturingRT := &headerCapturingRoundTripper{}
+
+	rt, err := rest.HTTPWrappersForConfig(f.restConfig, capturingRT)
+	if err != nil {
+		return nil, fmt.Errorf("Building auth round tripper: %s", err)
+	}
+
+	req, err := http.NewRequest("GET", reqURL.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Building request: %s", err)
+	}
+
+	_, err = rt.RoundTrip(req)
+	if err != nil && err != errHeaderCaptured {
+		return nil, fmt.Errorf("Obtaining auth headers: %s", err)
+	}
+
+	return capturingRT.header, nil
+}
+
+var errHeaderCaptured = fmt.Errorf("Header captured")
+
+type headerCapturingRoundTripper struct {
+	header http.Header
+}
+
+func (rt *headerCapturingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.header = req.Header
+	return nil, errHeaderCaptured
+}
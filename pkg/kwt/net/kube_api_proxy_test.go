@@ -0,0 +1,82 @@
+package net_test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/logtest"
+	. "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"golang.org/x/net/websocket"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func TestKubeAPIProxy(t *testing.T) {
+	var reqPath, reqAuth string
+
+	server := httptest.NewServer(websocket.Server{
+		Handshake: func(config *websocket.Config, req *http.Request) error {
+			reqPath = req.URL.Path
+			reqAuth = req.Header.Get("Authorization")
+			return nil
+		},
+		Handler: func(conn *websocket.Conn) { io.Copy(conn, conn) },
+	})
+
+	defer server.Close()
+
+	restConfig := &rest.Config{Host: server.URL, BearerToken: "token"}
+
+	coreClient, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	proxy := NewKubeAPIProxy("kwt-net", "ns", coreClient, restConfig, logtest.NoopLogger{})
+
+	defer proxy.Shutdown()
+
+	startedCh := make(chan struct{})
+	go proxy.Start(2049, startedCh)
+	<-startedCh
+
+	localPort, err := proxy.LocalPort()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(localPort))
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	buf := make([]byte, 4)
+
+	_, err = io.ReadFull(conn, buf)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	if string(buf) != "ping" {
+		t.Fatalf("Expected echoed bytes: %s", buf)
+	}
+
+	if reqPath != "/api/v1/namespaces/ns/services/kwt-net:2049/proxy" {
+		t.Fatalf("Expected service proxy path: %s", reqPath)
+	}
+
+	if reqAuth != "Bearer token" {
+		t.Fatalf("Expected bearer token: %s", reqAuth)
+	}
+}
@@ -19,36 +19,60 @@ const (
 	sshSecretPublicKey  = "ssh-publickey"
 	netPodSelectorKey   = "kwt.cppforlife.com/net"
 	netPodSelectorValue = "true"
+	netContainerName    = "kwt-net"
 	sshUser             = "tom"
+	netPodNoWebsocatMsg = "kwt-net: websocat is not installed"
 )
 
 type KubeEntryPoint struct {
 	coreClient kubernetes.Interface
 	restConfig *rest.Config
 
-	namespace string
-	imageURL  string
-	podName   string
-	podPort   int
+	namespace   string
+	imageURL    string
+	transport   string
+	podName     string
+	podPort     int
+	podWSPort   int
+	serviceName string
+
+	deploymentName      string
+	headlessServiceName string
+	replicaPicker       *kubeNetReplicaPicker
+	leaseKeeper         *kubeNetLeaseKeeper
 
 	secretClientSSHName string
 	secretHostSSHName   string
 
+	podOpts KubeNetPodOpts
+	owner   KubeNetOwner
+	keyType dstconn.SSHKeyType
+
+	ephemeralKeys *kubeNetEphemeralKeys
+
 	logTag string
 	logger Logger
 }
 
 var _ EntryPoint = KubeEntryPoint{}
 
-func NewKubeEntryPoint(coreClient kubernetes.Interface, restConfig *rest.Config, namespace string, imageURL string, logger Logger) KubeEntryPoint {
+func NewKubeEntryPoint(coreClient kubernetes.Interface, restConfig *rest.Config, namespace string, imageURL string, transport string, logger Logger) KubeEntryPoint {
 	return KubeEntryPoint{
 		coreClient: coreClient,
 		restConfig: restConfig,
 
-		namespace: namespace,
-		imageURL:  imageURL,
-		podName:   "kwt-net",
-		podPort:   2048,
+		namespace:   namespace,
+		imageURL:    imageURL,
+		transport:   transport,
+		podName:     "kwt-net",
+		podPort:     2048,
+		podWSPort:   2049,
+		serviceName: "kwt-net",
+
+		deploymentName:      "kwt-net",
+		headlessServiceName: "kwt-net-pods",
+		replicaPicker:       &kubeNetReplicaPicker{},
+		leaseKeeper:         &kubeNetLeaseKeeper{},
 
 		secretClientSSHName: "kwt-net-ssh-key",
 		secretHostSSHName:   "kwt-net-host-key",
@@ -58,57 +82,134 @@ func NewKubeEntryPoint(coreClient kubernetes.Interface, restConfig *rest.Config,
 	}
 }
 
-func (f KubeEntryPoint) EntryPoint() (EntryPointSession, error) {
-	var clientPrivateKeyPEM, hostPublicKeyAuf string
-	sshKeysErrCh := make(chan error)
-
-	f.logger.Info(f.logTag, "Creating networking client secret '%s' in namespace '%s'...", f.secretClientSSHName, f.namespace)
+// WithNetPodOpts customizes net pod when it's created
+func (f KubeEntryPoint) WithNetPodOpts(opts KubeNetPodOpts) KubeEntryPoint {
+	f.podOpts = opts
+	return f
+}
 
-	go func() {
-		var err error
-		clientPrivateKeyPEM, err = f.createNetPodClientSSHSecret()
-		sshKeysErrCh <- err
-	}()
+// WithOwner names and labels net resources per owner and
+// keeps owner's lease renewed while sessions are open
+func (f KubeEntryPoint) WithOwner(owner KubeNetOwner) KubeEntryPoint {
+	f.owner = owner
+	f.podName = owner.ResourceName()
+	f.serviceName = owner.ResourceName()
+	f.deploymentName = owner.ResourceName()
+	f.headlessServiceName = owner.ResourceName("pods")
+	f.secretClientSSHName = owner.ResourceName("ssh-key")
+	f.secretHostSSHName = owner.ResourceName("host-key")
+	return f
+}
 
-	f.logger.Info(f.logTag, "Creating networking host secret '%s' in namespace '%s'...", f.secretHostSSHName, f.namespace)
+func (f KubeEntryPoint) EntryPoint() (EntryPointSession, error) {
+	var leased bool
 
-	go func() {
-		var err error
-		hostPublicKeyAuf, err = f.createNetPodHostSSHSecret()
-		sshKeysErrCh <- err
-	}()
+	ownerLeaseExpired, err := f.ownerLeaseExpired()
+	if err != nil {
+		return nil, err
+	}
 
-	for i := 0; i < 2; i++ {
-		err := <-sshKeysErrCh
+	if len(f.owner.Name) > 0 {
+		// Lease is created before other resources so that they are never ownerless
+		err := f.lease().Renew()
 		if err != nil {
-			return nil, err
+			if !leasesUnavailable(err) {
+				return nil, err
+			}
+			// Resources of owners without a lease are left alone by garbage collection
+			f.logger.Error(f.logTag, "Continuing without networking lease in namespace '%s' "+
+				"(resources left behind will have to be deleted via 'kwt net clean-up'): %s", f.namespace, err)
+		} else {
+			leased = true
 		}
 	}
 
-	f.logger.Info(f.logTag, "Creating networking pod '%s' in namespace '%s'", f.podName, f.namespace)
-
-	pod, err := f.createNetPod()
+	clientPrivateKeyPEM, hostPublicKeyAuf, err := f.sshKeys()
 	if err != nil {
 		return nil, err
 	}
 
-	f.logger.Info(f.logTag, "Waiting for networking pod '%s' in namespace '%s' to start...", f.podName, f.namespace)
+	var pod *corev1.Pod
 
-	ready, err := f.waitForPod(pod)
+	if f.podOpts.Replicas > 0 {
+		f.logger.Info(f.logTag, "Creating networking deployment '%s' with %d replica(s) in namespace '%s'",
+			f.deploymentName, f.podOpts.Replicas, f.namespace)
+
+		err = f.createNetDeployment()
+	} else {
+		f.logger.Info(f.logTag, "Creating networking pod '%s' in namespace '%s'", f.podName, f.namespace)
+
+		pod, err = f.createNetPod(ownerLeaseExpired)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	// Replicas get their own services once they are picked (see createNetReplicaService)
+	if f.transport == KubeTransportAPIProxy && f.podOpts.Replicas == 0 {
+		f.logger.Info(f.logTag, "Creating networking service '%s' in namespace '%s'", f.serviceName, f.namespace)
+
+		err := f.createNetService()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var ready bool
+
+	if f.podOpts.Replicas > 0 {
+		f.logger.Info(f.logTag, "Waiting for networking deployment '%s' in namespace '%s' to have a ready replica...",
+			f.deploymentName, f.namespace)
+
+		// Each (re)connect picks next ready replica so that
+		// SSH clients fail over when their replica goes away
+		pod, err = f.waitForReadyReplica()
+		if err != nil {
+			return nil, err
+		}
+
+		ready = true
+	} else {
+		f.logger.Info(f.logTag, "Waiting for networking pod '%s' in namespace '%s' to start...", f.podName, f.namespace)
+
+		ready, err = f.waitForPod(pod)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if ready {
-		pf := NewKubePortForward(pod, f.coreClient, f.restConfig, f.logger)
+		var tunnel kubeTunnel
+		var tunnelPort int
+		var tunnelDesc string
+
+		switch f.transport {
+		case KubeTransportAPIProxy:
+			serviceName := f.serviceName
+
+			if f.podOpts.Replicas > 0 {
+				serviceName, err = f.createNetReplicaService(pod)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			tunnel = NewKubeAPIProxy(serviceName, f.namespace, f.coreClient, f.restConfig, f.logger)
+			tunnelPort = f.podWSPort
+			tunnelDesc = "kube API proxying"
+		default:
+			tunnel = NewKubePortForward(pod, f.coreClient, f.restConfig, f.logger)
+			tunnelPort = f.podPort
+			tunnelDesc = "kube port forwarding"
+		}
 
 		startedCh := make(chan struct{})
 		errCh := make(chan error, 1)
 
 		go func() {
-			err := pf.Start(f.podPort, startedCh)
+			err := tunnel.Start(tunnelPort, startedCh)
 			if err != nil {
-				f.logger.Error(f.logTag, "Failed starting kube port forwarding: %s", err)
+				f.logger.Error(f.logTag, "Failed starting %s: %s", tunnelDesc, err)
 			}
 			errCh <- err
 		}()
@@ -116,13 +217,13 @@ func (f KubeEntryPoint) EntryPoint() (EntryPointSession, error) {
 		select {
 		case <-startedCh:
 			// do nothing
-		case <-errCh:
-			return nil, fmt.Errorf("Starting kube port forwarding: %s", err)
+		case err := <-errCh:
+			return nil, fmt.Errorf("Starting %s: %s", tunnelDesc, err)
 		}
 
-		localPort, err := pf.LocalPort()
+		localPort, err := tunnel.LocalPort()
 		if err != nil {
-			return nil, fmt.Errorf("Obtaining kube port forwarding local port: %s", err)
+			return nil, fmt.Errorf("Obtaining %s local port: %s", tunnelDesc, err)
 		}
 
 		opts := dstconn.SSHClientConnOpts{
@@ -132,20 +233,78 @@ func (f KubeEntryPoint) EntryPoint() (EntryPointSession, error) {
 			HostPublicKeyAuf: hostPublicKeyAuf,
 		}
 
-		return KubeEntryPointSession{pf, opts}, nil
+		var releaseLeaseFunc func()
+
+		if leased {
+			releaseLeaseFunc = f.leaseKeeper.Acquire(f.lease())
+		}
+
+		return KubeEntryPointSession{tunnel, opts, releaseLeaseFunc}, nil
 	}
 
 	return nil, fmt.Errorf("Network pod failed to start")
 }
 
+func (f KubeEntryPoint) Status() EntryPointStatus {
+	status := EntryPointStatus{
+		Type:      "kube",
+		Transport: f.transport,
+		Namespace: f.namespace,
+		NetPod:    f.podName,
+	}
+
+	podName := f.podName
+
+	if f.podOpts.Replicas > 0 {
+		status.NetReplicas = f.podOpts.Replicas
+
+		podNames, err := f.readyReplicaNames()
+		if err != nil {
+			f.logger.Debug(f.logTag, "Failed fetching networking replicas for status: %s", err)
+		}
+
+		status.NetReadyReplicas = len(podNames)
+
+		podName = f.replicaPicker.Last()
+		if len(podName) == 0 {
+			return status
+		}
+
+		status.NetPod = podName
+	}
+
+	pod, err := f.coreClient.CoreV1().Pods(f.namespace).Get(podName, metav1.GetOptions{})
+	if err != nil {
+		f.logger.Debug(f.logTag, "Failed fetching networking pod for status: %s", err)
+		return status
+	}
+
+	status.NetPodNode = pod.Spec.NodeName
+
+	return status
+}
+
 func (f KubeEntryPoint) Delete() error {
-	err := f.coreClient.CoreV1().Pods(f.namespace).Delete(f.podName, &metav1.DeleteOptions{})
+	// Deployment may exist regardless of currently configured replicas
+	err := f.deleteNetDeployment()
+	if err != nil {
+		return err
+	}
+
+	err = f.coreClient.CoreV1().Pods(f.namespace).Delete(f.podName, &metav1.DeleteOptions{})
 	if err != nil {
 		if !errors.IsNotFound(err) {
 			return fmt.Errorf("Deleting net pod: %s", err)
 		}
 	}
 
+	err = f.coreClient.CoreV1().Services(f.namespace).Delete(f.serviceName, &metav1.DeleteOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("Deleting net service: %s", err)
+		}
+	}
+
 	err = f.coreClient.CoreV1().Secrets(f.namespace).Delete(f.secretClientSSHName, &metav1.DeleteOptions{})
 	if err != nil {
 		if !errors.IsNotFound(err) {
@@ -176,9 +335,88 @@ func (f KubeEntryPoint) Delete() error {
 		return err
 	}
 
+	if len(f.owner.Name) > 0 {
+		err := f.lease().Delete()
+		if err != nil && !leasesUnavailable(err) {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// netPodSelector does not match other owners' net pods
+func (f KubeEntryPoint) netPodSelector() map[string]string {
+	selector := f.owner.Labels()
+	selector[netPodSelectorKey] = netPodSelectorValue
+	return selector
+}
+
+func (f KubeEntryPoint) lease() KubeNetLease {
+	return NewKubeNetLease(f.coreClient, f.namespace, f.owner, f.logger)
+}
+
+// objectMeta includes owner labels and annotations
+func (f KubeEntryPoint) objectMeta(name string, labels map[string]string) metav1.ObjectMeta {
+	meta := metav1.ObjectMeta{
+		Name:        name,
+		Namespace:   f.namespace,
+		Labels:      f.owner.Labels(),
+		Annotations: f.owner.Annotations(),
+	}
+	for k, v := range labels {
+		meta.Labels[k] = v
+	}
+	return meta
+}
+
+// sshKeys returns client private key and host public key
+func (f KubeEntryPoint) sshKeys() (string, string, error) {
+	if f.ephemeralKeys != nil {
+		if f.podOpts.Replicas > 0 {
+			// Replicas started by deployment would wait for keys forever
+			return "", "", fmt.Errorf("Expected ephemeral SSH keys to not be used with net pod replicas")
+		}
+
+		clientKey, hostKey, _, err := f.ephemeralKeys.Keys(f.keyType)
+		if err != nil {
+			return "", "", err
+		}
+
+		return clientKey.PrivateKey, hostKey.PublicKey, nil
+	}
+
+	var clientPrivateKeyPEM, hostPublicKeyAuf string
+	sshKeysErrCh := make(chan error)
+
+	f.logger.Info(f.logTag, "Creating networking client secret '%s' in namespace '%s'...", f.secretClientSSHName, f.namespace)
+
+	go func() {
+		var err error
+		clientPrivateKeyPEM, err = f.createNetPodClientSSHSecret()
+		sshKeysErrCh <- err
+	}()
+
+	f.logger.Info(f.logTag, "Creating networking host secret '%s' in namespace '%s'...", f.secretHostSSHName, f.namespace)
+
+	go func() {
+		var err error
+		hostPublicKeyAuf, err = f.createNetPodHostSSHSecret()
+		sshKeysErrCh <- err
+	}()
+
+	var lastErr error
+
+	for i := 0; i < 2; i++ {
+		err := <-sshKeysErrCh
+		if err != nil {
+			lastErr = err
+		}
+	}
+
+	return clientPrivateKeyPEM, hostPublicKeyAuf, lastErr
+}
+
 func (f KubeEntryPoint) createNetPodClientSSHSecret() (string, error) {
 	foundSecret, err := f.coreClient.CoreV1().Secrets(f.namespace).Get(f.secretClientSSHName, metav1.GetOptions{})
 	if err != nil {
@@ -190,23 +428,11 @@ func (f KubeEntryPoint) createNetPodClientSSHSecret() (string, error) {
 		return string(foundSecret.Data[corev1.SSHAuthPrivateKey]), nil
 	}
 
-	key, err := dstconn.NewSSHKeyGenerator().Generate()
+	secret, key, err := f.netSSHSecret(f.secretClientSSHName)
 	if err != nil {
 		return "", err
 	}
 
-	secret := &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      f.secretClientSSHName,
-			Namespace: f.namespace,
-		},
-		Type: corev1.SecretTypeSSHAuth,
-		StringData: map[string]string{
-			corev1.SSHAuthPrivateKey: key.PrivateKey,
-			sshSecretPublicKey:       key.PublicKey,
-		},
-	}
-
 	_, err = f.coreClient.CoreV1().Secrets(f.namespace).Create(secret)
 	if err != nil {
 		return "", fmt.Errorf("Creating net pod client ssh secret: %s", err)
@@ -226,23 +452,11 @@ func (f KubeEntryPoint) createNetPodHostSSHSecret() (string, error) {
 		return string(foundSecret.Data[sshSecretPublicKey]), nil
 	}
 
-	key, err := dstconn.NewSSHKeyGenerator().Generate()
+	secret, key, err := f.netSSHSecret(f.secretHostSSHName)
 	if err != nil {
 		return "", err
 	}
 
-	secret := &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      f.secretHostSSHName,
-			Namespace: f.namespace,
-		},
-		Type: corev1.SecretTypeSSHAuth,
-		StringData: map[string]string{
-			corev1.SSHAuthPrivateKey: key.PrivateKey,
-			sshSecretPublicKey:       key.PublicKey,
-		},
-	}
-
 	_, err = f.coreClient.CoreV1().Secrets(f.namespace).Create(secret)
 	if err != nil {
 		return "", fmt.Errorf("Creating net pod host ssh secret: %s", err)
@@ -251,7 +465,8 @@ func (f KubeEntryPoint) createNetPodHostSSHSecret() (string, error) {
 	return key.PublicKey, nil
 }
 
-func (f KubeEntryPoint) createNetPod() (*corev1.Pod, error) {
+// createNetPod replaces net pod started with other keys only if owner's lease expired
+func (f KubeEntryPoint) createNetPod(ownerLeaseExpired bool) (*corev1.Pod, error) {
 	if len(f.imageURL) == 0 {
 		return nil, fmt.Errorf("Expected SSH image to be non-empty")
 	}
@@ -263,14 +478,79 @@ func (f KubeEntryPoint) createNetPod() (*corev1.Pod, error) {
 		}
 		// continue to create new pod
 	} else {
-		if foundPod.DeletionTimestamp != nil {
-			return nil, fmt.Errorf("Networking pod is currently being terminated")
+		finished := foundPod.Status.Phase == corev1.PodFailed || foundPod.Status.Phase == corev1.PodSucceeded
+
+		if foundPod.DeletionTimestamp == nil && !finished {
+			err := f.startedWithSameKeysErr(foundPod)
+			if err == nil {
+				return foundPod, nil
+			}
+			if !ownerLeaseExpired {
+				return nil, err
+			}
+		}
+
+		// Replace pod that was evicted or is being deleted (eg node was drained)
+		// so that reconnecting does not fail until someone cleans it up
+		if foundPod.DeletionTimestamp == nil {
+			if finished {
+				f.logger.Info(f.logTag, "Deleting finished networking pod '%s' in namespace '%s' (phase: %s, reason: %s)",
+					f.podName, f.namespace, foundPod.Status.Phase, foundPod.Status.Reason)
+			} else {
+				f.logger.Info(f.logTag, "Deleting networking pod '%s' in namespace '%s' started with other SSH keys "+
+					"since networking lease expired", f.podName, f.namespace)
+			}
+
+			err := f.coreClient.CoreV1().Pods(f.namespace).Delete(f.podName, &metav1.DeleteOptions{})
+			if err != nil && !errors.IsNotFound(err) {
+				return nil, fmt.Errorf("Deleting net pod: %s", err)
+			}
 		}
-		return foundPod, nil
+
+		err = f.waitForObjDeletion(fmt.Sprintf("pod '%s'", f.podName), func() error {
+			_, err := f.coreClient.CoreV1().Pods(f.namespace).Get(f.podName, metav1.GetOptions{})
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		f.logger.Info(f.logTag, "Recreating networking pod '%s' in namespace '%s'", f.podName, f.namespace)
+	}
+
+	pod, err := f.netPod()
+	if err != nil {
+		return nil, err
+	}
+
+	createdPod, err := f.coreClient.CoreV1().Pods(f.namespace).Create(pod)
+	if err != nil {
+		if errors.IsForbidden(err) || errors.IsInvalid(err) {
+			return nil, f.admissionErr(err)
+		}
+		return nil, fmt.Errorf("Creating net pod: %s", err)
+	}
+
+	return createdPod, nil
+}
+
+// netPod builds net pod spec with customizations applied
+func (f KubeEntryPoint) netPod() (*corev1.Pod, error) {
+	keysScript := []string{
+		// TODO move to init container
+		fmt.Sprintf(`echo "$KWT_CLIENT_PUB_KEY" > /home/%s/.ssh/authorized_keys`, sshUser),
+		// Host key may be rsa, ecdsa or ed25519; sshd refuses keys readable by others
+		fmt.Sprintf(`(umask 077 && echo "$KWT_HOST_PRIV_KEY" > %s)`, netHostKeyPath),
+		fmt.Sprintf(`echo "$KWT_HOST_PUB_KEY" > %s.pub`, netHostKeyPath),
+	}
+
+	if f.ephemeralKeys != nil {
+		// Keys are written via exec (see injectEphemeralKeys)
+		keysScript = []string{fmt.Sprintf("until [ -s %s ]; do sleep 1; done", netHostKeyPath)}
 	}
 
 	container := corev1.Container{
-		Name: f.podName,
+		Name: netContainerName,
 
 		Image:           f.imageURL,
 		ImagePullPolicy: corev1.PullIfNotPresent,
@@ -278,18 +558,16 @@ func (f KubeEntryPoint) createNetPod() (*corev1.Pod, error) {
 		Command: []string{"/bin/bash"},
 		Args: []string{
 			"-c",
-			strings.Join([]string{
-				// TODO move to init container
-				fmt.Sprintf(`echo "$KWT_CLIENT_PUB_KEY" > /home/%s/.ssh/authorized_keys`, sshUser),
-				`echo "$KWT_HOST_PRIV_KEY" > /etc/ssh/ssh_host_rsa_key`,
-				`echo "$KWT_HOST_PUB_KEY" > /etc/ssh/ssh_host_rsa_key.pub`,
+			strings.Join(append(keysScript,
 				`echo "GatewayPorts clientspecified" >> /etc/ssh/sshd_config`,
-				fmt.Sprintf("exec /usr/sbin/sshd -D -p %d", f.podPort),
-			}, " && "),
+				f.netPodWebsocatScript(),
+				fmt.Sprintf("exec /usr/sbin/sshd -D -p %d -h %s", f.podPort, netHostKeyPath),
+			), " && "),
 		},
 
 		Ports: []corev1.ContainerPort{
 			{Name: "ssh", ContainerPort: int32(f.podPort)},
+			{Name: "ws", ContainerPort: int32(f.podWSPort)},
 		},
 
 		ReadinessProbe: &corev1.Probe{
@@ -301,8 +579,10 @@ func (f KubeEntryPoint) createNetPod() (*corev1.Pod, error) {
 			InitialDelaySeconds: 1,
 			PeriodSeconds:       1,
 		},
+	}
 
-		Env: []corev1.EnvVar{
+	if f.ephemeralKeys == nil {
+		container.Env = []corev1.EnvVar{
 			{
 				Name: "KWT_CLIENT_PUB_KEY",
 				ValueFrom: &corev1.EnvVarSource{
@@ -319,7 +599,7 @@ func (f KubeEntryPoint) createNetPod() (*corev1.Pod, error) {
 				ValueFrom: &corev1.EnvVarSource{
 					SecretKeyRef: &corev1.SecretKeySelector{
 						LocalObjectReference: corev1.LocalObjectReference{
-							Name: "kwt-net-host-key",
+							Name: f.secretHostSSHName,
 						},
 						Key: corev1.SSHAuthPrivateKey,
 					},
@@ -330,44 +610,95 @@ func (f KubeEntryPoint) createNetPod() (*corev1.Pod, error) {
 				ValueFrom: &corev1.EnvVarSource{
 					SecretKeyRef: &corev1.SecretKeySelector{
 						LocalObjectReference: corev1.LocalObjectReference{
-							Name: "kwt-net-host-key",
+							Name: f.secretHostSSHName,
 						},
 						Key: sshSecretPublicKey,
 					},
 				},
 			},
-		},
+		}
 	}
 
 	pod := &corev1.Pod{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      f.podName,
-			Namespace: f.namespace,
-			Labels: map[string]string{
-				netPodSelectorKey: netPodSelectorValue,
-			},
-			Annotations: map[string]string{
-				"sidecar.istio.io/inject": "false", // just in case Istio is used
-				// TODO linkerd2?
-			},
-		},
+		ObjectMeta: f.objectMeta(f.podName, f.netPodSelector()),
 		Spec: corev1.PodSpec{
 			RestartPolicy: corev1.RestartPolicyAlways,
 			Containers:    []corev1.Container{container},
 		},
 	}
 
-	createdPod, err := f.coreClient.CoreV1().Pods(f.namespace).Create(pod)
+	pod.Annotations["sidecar.istio.io/inject"] = "false" // just in case Istio is used
+	// TODO linkerd2?
+
+	if f.ephemeralKeys != nil {
+		_, _, fingerprint, err := f.ephemeralKeys.Keys(f.keyType)
+		if err != nil {
+			return nil, err
+		}
+		pod.Annotations[netSSHHostKeyFingerprintAnnKey] = fingerprint
+	}
+
+	pod, err := f.podOpts.Apply(pod)
 	if err != nil {
-		return nil, fmt.Errorf("Creating net pod: %s", err)
+		return nil, fmt.Errorf("Customizing net pod: %s", err)
 	}
 
-	return createdPod, nil
+	return pod, nil
+}
+
+// admissionErr lists each violation on its own line since admission
+// messages (eg from PodSecurity) tend to be long single line lists
+func (f KubeEntryPoint) admissionErr(err error) error {
+	msg := err.Error()
+
+	if idx := strings.Index(msg, ": violates PodSecurity"); idx >= 0 {
+		msg = strings.Replace(msg[idx+2:], "), ", ")\n  - ", -1)
+		msg = strings.Replace(msg, ": ", ":\n  - ", 1)
+	}
+
+	return fmt.Errorf("Networking pod '%s' was rejected in namespace '%s' "+
+		"(customize it via --net-pod-template, --net-pod-toleration or --net-pod-node-selector): %s",
+		f.podName, f.namespace, msg)
+}
+
+func (f KubeEntryPoint) createNetService() error {
+	_, err := f.coreClient.CoreV1().Services(f.namespace).Get(f.serviceName, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("Getting net service: %s", err)
+		}
+		// continue to create new service
+	} else {
+		return nil
+	}
+
+	service := &corev1.Service{
+		ObjectMeta: f.objectMeta(f.serviceName, nil),
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeClusterIP,
+			Ports: []corev1.ServicePort{{
+				Name:       "ws",
+				Port:       int32(f.podWSPort),
+				TargetPort: intstr.FromInt(f.podWSPort),
+				Protocol:   corev1.ProtocolTCP,
+			}},
+			Selector: f.netPodSelector(),
+		},
+	}
+
+	_, err = f.coreClient.CoreV1().Services(f.namespace).Create(service)
+	if err != nil {
+		return fmt.Errorf("Creating net service: %s", err)
+	}
+
+	return nil
 }
 
 func (f KubeEntryPoint) waitForPod(pod *corev1.Pod) (bool, error) {
 	timeoutCh := time.After(2 * time.Minute)
 	notifiedOfRestarts := false
+	notifiedOfUnschedulable := false
+	injectedRestartCount := int32(-1)
 
 	for {
 		pod, err := f.coreClient.CoreV1().Pods(f.namespace).Get(pod.Name, metav1.GetOptions{})
@@ -382,9 +713,40 @@ func (f KubeEntryPoint) waitForPod(pod *corev1.Pod) (bool, error) {
 			return true, nil
 		}
 
+		err = f.netPodImageErr(pod)
+		if err != nil {
+			return false, err
+		}
+
+		// Container waits for ephemeral keys after each (re)start
+		if f.ephemeralKeys != nil && podRunning {
+			for _, contStatus := range pod.Status.ContainerStatuses {
+				if contStatus.Name == netContainerName && contStatus.State.Running != nil &&
+					contStatus.RestartCount != injectedRestartCount {
+					err := f.injectEphemeralKeys(pod)
+					if err != nil {
+						return false, err
+					}
+					injectedRestartCount = contStatus.RestartCount
+				}
+			}
+		}
+
+		if !notifiedOfUnschedulable {
+			for _, cond := range pod.Status.Conditions {
+				if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionFalse &&
+					cond.Reason == corev1.PodReasonUnschedulable {
+					notifiedOfUnschedulable = true
+					f.logger.Error(f.logTag, "Networking pod '%s' in namespace '%s' cannot be scheduled "+
+						"(consider --net-pod-toleration or --net-pod-node-selector): %s. Continuing to wait...",
+						pod.Name, f.namespace, cond.Message)
+				}
+			}
+		}
+
 		if !notifiedOfRestarts {
 			for _, contStatus := range pod.Status.ContainerStatuses {
-				if contStatus.Name == pod.Name {
+				if contStatus.Name == netContainerName {
 					if contStatus.RestartCount > 0 {
 						notifiedOfRestarts = true
 						f.logger.Error(f.logTag, "Networking pod '%s' in namespace '%s' is restarting which "+
@@ -406,6 +768,40 @@ func (f KubeEntryPoint) waitForPod(pod *corev1.Pod) (bool, error) {
 	}
 }
 
+// netPodWebsocatScript starts bridge for API proxy transport. Older images
+// do not include websocat hence container exits with a termination message
+// that is surfaced by netPodImageErr when API proxy transport needs it.
+func (f KubeEntryPoint) netPodWebsocatScript() string {
+	bridge := fmt.Sprintf("websocat --binary ws-l:0.0.0.0:%d tcp:127.0.0.1:%d", f.podWSPort, f.podPort)
+
+	if f.transport == KubeTransportAPIProxy {
+		return fmt.Sprintf(`{ command -v websocat >/dev/null || { echo "%s" | tee /dev/termination-log >&2; exit 1; }; } && { %s & }`,
+			netPodNoWebsocatMsg, bridge)
+	}
+
+	return fmt.Sprintf("{ command -v websocat >/dev/null && %s & }", bridge)
+}
+
+// netPodImageErr returns error if net container exited
+// because its image cannot be used with configured transport
+func (f KubeEntryPoint) netPodImageErr(pod *corev1.Pod) error {
+	for _, contStatus := range pod.Status.ContainerStatuses {
+		if contStatus.Name != netContainerName {
+			continue
+		}
+		for _, terminated := range []*corev1.ContainerStateTerminated{
+			contStatus.State.Terminated, contStatus.LastTerminationState.Terminated} {
+
+			if terminated != nil && strings.Contains(terminated.Message, netPodNoWebsocatMsg) {
+				return fmt.Errorf("Networking pod '%s' image '%s' does not include websocat "+
+					"required by '%s' transport (use newer image via --ssh-image or "+
+					"--transport %s)", pod.Name, f.imageURL, KubeTransportAPIProxy, KubeTransportPortForward)
+			}
+		}
+	}
+	return nil
+}
+
 func (f KubeEntryPoint) podReadyConditionStatus(pod *corev1.Pod) corev1.ConditionStatus {
 	for _, cond := range pod.Status.Conditions {
 		if cond.Type == corev1.PodReady {
@@ -437,12 +833,28 @@ func (f KubeEntryPoint) waitForObjDeletion(objDesc string, tryFunc func() error)
 	}
 }
 
+type kubeTunnel interface {
+	Start(remotePort int, startedCh chan struct{}) error
+	LocalPort() (int, error)
+	Shutdown() error
+}
+
+var _ kubeTunnel = KubePortForward{}
+var _ kubeTunnel = &KubeAPIProxy{}
+
 type KubeEntryPointSession struct {
-	pf   *KubePortForward
-	opts dstconn.SSHClientConnOpts
+	tunnel           kubeTunnel
+	opts             dstconn.SSHClientConnOpts
+	releaseLeaseFunc func()
 }
 
 var _ EntryPointSession = KubeEntryPointSession{}
 
 func (s KubeEntryPointSession) Opts() dstconn.SSHClientConnOpts { return s.opts }
-func (s KubeEntryPointSession) Close() error                    { return s.pf.Shutdown() }
+
+func (s KubeEntryPointSession) Close() error {
+	if s.releaseLeaseFunc != nil {
+		s.releaseLeaseFunc()
+	}
+	return s.tunnel.Shutdown()
+}
@@ -0,0 +1,495 @@
+package net
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	netDeploymentSelectorKey = "kwt.cppforlife.com/net-deployment"
+	netReplicaSelectorKey    = "kwt.cppforlife.com/net-replica"
+	netTemplateHashAnnKey    = "kwt.cppforlife.com/net-template-hash"
+)
+
+// kubeNetReplicaPicker rotates through ready replicas so that
+// reconnecting clients fail over to a different replica than
+// the one they were using and pooled clients are spread out
+type kubeNetReplicaPicker struct {
+	last     string
+	lastLock sync.Mutex
+}
+
+func (p *kubeNetReplicaPicker) Pick(podNames []string) string {
+	p.lastLock.Lock()
+	defer p.lastLock.Unlock()
+
+	sort.Strings(podNames)
+
+	result := podNames[0]
+
+	for _, name := range podNames {
+		if name > p.last {
+			result = name
+			break
+		}
+	}
+
+	p.last = result
+
+	return result
+}
+
+func (p *kubeNetReplicaPicker) Last() string {
+	p.lastLock.Lock()
+	defer p.lastLock.Unlock()
+
+	return p.last
+}
+
+// createNetDeployment makes sure net deployment matching current
+// configuration (replicas, image, customizations), its headless service
+// and its pod disruption budget exist
+func (f KubeEntryPoint) createNetDeployment() error {
+	if len(f.imageURL) == 0 {
+		return fmt.Errorf("Expected SSH image to be non-empty")
+	}
+
+	err := f.createNetHeadlessService()
+	if err != nil {
+		return err
+	}
+
+	deployment, err := f.netDeployment()
+	if err != nil {
+		return err
+	}
+
+	foundDeployment, err := f.coreClient.AppsV1().Deployments(f.namespace).Get(f.deploymentName, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("Getting net deployment: %s", err)
+		}
+
+		_, err = f.coreClient.AppsV1().Deployments(f.namespace).Create(deployment)
+		if err != nil {
+			if errors.IsForbidden(err) || errors.IsInvalid(err) {
+				return f.admissionErr(err)
+			}
+			return fmt.Errorf("Creating net deployment: %s", err)
+		}
+	} else {
+		err = f.updateNetDeployment(foundDeployment, deployment)
+		if err != nil {
+			return err
+		}
+	}
+
+	return f.reconcileNetPDB()
+}
+
+// updateNetDeployment only updates deployment if its configuration changed
+// since template changes result in replicas being replaced
+func (f KubeEntryPoint) updateNetDeployment(foundDeployment, deployment *appsv1.Deployment) error {
+	templateHash := deployment.Annotations[netTemplateHashAnnKey]
+	replicas := *deployment.Spec.Replicas
+
+	if foundDeployment.Annotations[netTemplateHashAnnKey] == templateHash &&
+		foundDeployment.Spec.Replicas != nil && *foundDeployment.Spec.Replicas == replicas {
+		return nil
+	}
+
+	f.logger.Info(f.logTag, "Updating networking deployment '%s' in namespace '%s' (%d replica(s))",
+		f.deploymentName, f.namespace, replicas)
+
+	// Keep annotations managed outside of this method (eg set by key rotation)
+	if val, found := foundDeployment.Spec.Template.Annotations[netKeysRotatedAtAnnKey]; found {
+		deployment.Spec.Template.Annotations[netKeysRotatedAtAnnKey] = val
+	}
+
+	if foundDeployment.Annotations == nil {
+		foundDeployment.Annotations = map[string]string{}
+	}
+
+	foundDeployment.Annotations[netTemplateHashAnnKey] = templateHash
+	foundDeployment.Spec.Replicas = deployment.Spec.Replicas
+	foundDeployment.Spec.Strategy = deployment.Spec.Strategy
+	foundDeployment.Spec.Template = deployment.Spec.Template
+
+	_, err := f.coreClient.AppsV1().Deployments(f.namespace).Update(foundDeployment)
+	if err != nil {
+		if errors.IsForbidden(err) || errors.IsInvalid(err) {
+			return f.admissionErr(err)
+		}
+		return fmt.Errorf("Updating net deployment: %s", err)
+	}
+
+	return nil
+}
+
+func (f KubeEntryPoint) netDeployment() (*appsv1.Deployment, error) {
+	pod, err := f.netPod()
+	if err != nil {
+		return nil, err
+	}
+
+	pod.Labels[netDeploymentSelectorKey] = f.deploymentName
+
+	replicas := int32(f.podOpts.Replicas)
+
+	// Rolling updates (eg after configuration change) do not go below desired
+	// number of replicas unless there are several of them. Node drains are
+	// limited by pod disruption budget instead (see reconcileNetPDB).
+	maxUnavailable := intstr.FromInt(0)
+
+	if replicas > 1 {
+		maxUnavailable = intstr.FromInt(1)
+
+		// Avoid losing all replicas together with a single node
+		if pod.Spec.Affinity == nil {
+			pod.Spec.Affinity = &corev1.Affinity{}
+		}
+		if pod.Spec.Affinity.PodAntiAffinity == nil {
+			pod.Spec.Affinity.PodAntiAffinity = &corev1.PodAntiAffinity{}
+		}
+
+		antiAffinity := pod.Spec.Affinity.PodAntiAffinity
+
+		antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(
+			antiAffinity.PreferredDuringSchedulingIgnoredDuringExecution, corev1.WeightedPodAffinityTerm{
+				Weight: 100,
+				PodAffinityTerm: corev1.PodAffinityTerm{
+					LabelSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{netDeploymentSelectorKey: f.deploymentName},
+					},
+					TopologyKey: "kubernetes.io/hostname",
+				},
+			})
+	}
+
+	template := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      pod.Labels,
+			Annotations: pod.Annotations,
+		},
+		Spec: pod.Spec,
+	}
+
+	templateBytes, err := json.Marshal(template)
+	if err != nil {
+		return nil, fmt.Errorf("Marshaling net deployment template: %s", err)
+	}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: f.objectMeta(f.deploymentName, map[string]string{netDeploymentSelectorKey: f.deploymentName}),
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{netDeploymentSelectorKey: f.deploymentName},
+			},
+			Strategy: appsv1.DeploymentStrategy{
+				Type: appsv1.RollingUpdateDeploymentStrategyType,
+				RollingUpdate: &appsv1.RollingUpdateDeployment{
+					MaxUnavailable: &maxUnavailable,
+				},
+			},
+			Template: template,
+		},
+	}
+
+	// Found template cannot be compared directly since API server fills in defaults
+	deployment.Annotations[netTemplateHashAnnKey] = fmt.Sprintf("%x", sha256.Sum256(templateBytes))[:16]
+
+	return deployment, nil
+}
+
+// createNetHeadlessService tracks ready replicas via its endpoints
+func (f KubeEntryPoint) createNetHeadlessService() error {
+	_, err := f.coreClient.CoreV1().Services(f.namespace).Get(f.headlessServiceName, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("Getting net headless service: %s", err)
+		}
+		// continue to create new service
+	} else {
+		return nil
+	}
+
+	service := &corev1.Service{
+		ObjectMeta: f.objectMeta(f.headlessServiceName, nil),
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Ports: []corev1.ServicePort{{
+				Name:       "ssh",
+				Port:       int32(f.podPort),
+				TargetPort: intstr.FromInt(f.podPort),
+				Protocol:   corev1.ProtocolTCP,
+			}},
+			Selector: map[string]string{netDeploymentSelectorKey: f.deploymentName},
+		},
+	}
+
+	_, err = f.coreClient.CoreV1().Services(f.namespace).Create(service)
+	if err != nil {
+		return fmt.Errorf("Creating net headless service: %s", err)
+	}
+
+	return nil
+}
+
+// waitForReadyReplica picks one of ready replicas listed by headless service endpoints
+func (f KubeEntryPoint) waitForReadyReplica() (*corev1.Pod, error) {
+	timeoutCh := time.After(2 * time.Minute)
+
+	for {
+		podNames, err := f.readyReplicaNames()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(podNames) > 0 {
+			podName := f.replicaPicker.Pick(podNames)
+
+			pod, err := f.coreClient.CoreV1().Pods(f.namespace).Get(podName, metav1.GetOptions{})
+			if err == nil && pod.DeletionTimestamp == nil {
+				f.logger.Info(f.logTag, "Using networking replica '%s' (%d of %d ready) in namespace '%s'",
+					podName, len(podNames), f.podOpts.Replicas, f.namespace)
+				return pod, nil
+			}
+		} else {
+			pods, err := f.coreClient.CoreV1().Pods(f.namespace).List(metav1.ListOptions{
+				LabelSelector: netDeploymentSelectorKey + "=" + f.deploymentName,
+			})
+			if err == nil {
+				for _, pod := range pods.Items {
+					err := f.netPodImageErr(&pod)
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+
+		select {
+		case <-timeoutCh:
+			return nil, fmt.Errorf("Timed out waiting for networking deployment '%s' to have a ready replica", f.deploymentName)
+		default:
+			// continue with waiting
+		}
+
+		time.Sleep(1 * time.Second)
+	}
+}
+
+func (f KubeEntryPoint) readyReplicaNames() ([]string, error) {
+	endpoints, err := f.coreClient.CoreV1().Endpoints(f.namespace).Get(f.headlessServiceName, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Getting net headless service endpoints: %s", err)
+	}
+
+	var result []string
+
+	for _, subset := range endpoints.Subsets {
+		for _, addr := range subset.Addresses { // only ready pods
+			if addr.TargetRef != nil && addr.TargetRef.Kind == "Pod" {
+				result = append(result, addr.TargetRef.Name)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func (f KubeEntryPoint) deleteNetDeployment() error {
+	propagation := metav1.DeletePropagationForeground
+
+	err := f.coreClient.AppsV1().Deployments(f.namespace).Delete(
+		f.deploymentName, &metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("Deleting net deployment: %s", err)
+		}
+	}
+
+	err = f.coreClient.CoreV1().Services(f.namespace).Delete(f.headlessServiceName, &metav1.DeleteOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("Deleting net headless service: %s", err)
+		}
+	}
+
+	err = f.deleteNetReplicaServices(nil)
+	if err != nil {
+		return err
+	}
+
+	err = f.deleteNetPDB()
+	if err != nil {
+		return err
+	}
+
+	return f.waitForObjDeletion(fmt.Sprintf("deployment '%s'", f.deploymentName), func() error {
+		_, err := f.coreClient.AppsV1().Deployments(f.namespace).Get(f.deploymentName, metav1.GetOptions{})
+		return err
+	})
+}
+
+// createNetReplicaService makes API server proxy transport reach given replica
+// (instead of any replica) by labeling it and creating a service selecting only it
+func (f KubeEntryPoint) createNetReplicaService(pod *corev1.Pod) (string, error) {
+	replicaID := netReplicaID(pod.Name)
+	serviceName := f.serviceName + "-" + replicaID
+
+	if pod.Labels[netReplicaSelectorKey] != replicaID {
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"labels": map[string]string{netReplicaSelectorKey: replicaID},
+			},
+		})
+		if err != nil {
+			return "", fmt.Errorf("Marshaling net replica label: %s", err)
+		}
+
+		_, err = f.coreClient.CoreV1().Pods(f.namespace).Patch(pod.Name, types.MergePatchType, patch)
+		if err != nil {
+			return "", fmt.Errorf("Labeling net replica '%s': %s", pod.Name, err)
+		}
+	}
+
+	_, err := f.coreClient.CoreV1().Services(f.namespace).Get(serviceName, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return "", fmt.Errorf("Getting net replica service: %s", err)
+		}
+
+		f.logger.Info(f.logTag, "Creating networking service '%s' for replica '%s' in namespace '%s'",
+			serviceName, pod.Name, f.namespace)
+
+		service := &corev1.Service{
+			ObjectMeta: f.objectMeta(serviceName, map[string]string{netDeploymentSelectorKey: f.deploymentName}),
+			Spec: corev1.ServiceSpec{
+				Type: corev1.ServiceTypeClusterIP,
+				Ports: []corev1.ServicePort{{
+					Name:       "ws",
+					Port:       int32(f.podWSPort),
+					TargetPort: intstr.FromInt(f.podWSPort),
+					Protocol:   corev1.ProtocolTCP,
+				}},
+				Selector: map[string]string{
+					netDeploymentSelectorKey: f.deploymentName,
+					netReplicaSelectorKey:    replicaID,
+				},
+			},
+		}
+
+		_, err = f.coreClient.CoreV1().Services(f.namespace).Create(service)
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return "", fmt.Errorf("Creating net replica service: %s", err)
+		}
+	}
+
+	// Services of replicas that went away are no longer useful
+	err = f.deleteNetReplicaServices(f.netReplicaIDs)
+	if err != nil {
+		f.logger.Debug(f.logTag, "Failed deleting stale networking replica services: %s", err)
+	}
+
+	timeoutCh := time.After(30 * time.Second)
+
+	for {
+		endpoints, err := f.coreClient.CoreV1().Endpoints(f.namespace).Get(serviceName, metav1.GetOptions{})
+		if err == nil {
+			for _, subset := range endpoints.Subsets {
+				if len(subset.Addresses) > 0 {
+					return serviceName, nil
+				}
+			}
+		}
+
+		select {
+		case <-timeoutCh:
+			return "", fmt.Errorf("Timed out waiting for networking service '%s' to select replica '%s'", serviceName, pod.Name)
+		default:
+			// continue with waiting
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// deleteNetReplicaServices deletes replica services except the ones
+// for replicas returned by keepFunc (all are deleted if keepFunc is nil)
+func (f KubeEntryPoint) deleteNetReplicaServices(keepFunc func() (map[string]bool, error)) error {
+	keep := map[string]bool{}
+
+	if keepFunc != nil {
+		var err error
+
+		keep, err = keepFunc()
+		if err != nil {
+			return err
+		}
+	}
+
+	services, err := f.coreClient.CoreV1().Services(f.namespace).List(metav1.ListOptions{
+		LabelSelector: netDeploymentSelectorKey + "=" + f.deploymentName,
+	})
+	if err != nil {
+		return fmt.Errorf("Listing net replica services: %s", err)
+	}
+
+	for _, service := range services.Items {
+		if keep[service.Spec.Selector[netReplicaSelectorKey]] {
+			continue
+		}
+
+		err := f.coreClient.CoreV1().Services(f.namespace).Delete(service.Name, &metav1.DeleteOptions{})
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				return fmt.Errorf("Deleting net replica service: %s", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// netReplicaIDs returns IDs of existing replicas
+func (f KubeEntryPoint) netReplicaIDs() (map[string]bool, error) {
+	pods, err := f.coreClient.CoreV1().Pods(f.namespace).List(metav1.ListOptions{
+		LabelSelector: netDeploymentSelectorKey + "=" + f.deploymentName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Listing net replicas: %s", err)
+	}
+
+	result := map[string]bool{}
+
+	for _, pod := range pods.Items {
+		result[netReplicaID(pod.Name)] = true
+	}
+
+	return result, nil
+}
+
+// netReplicaID is short enough to be used in service names and label values
+// regardless of replica's pod name length
+func netReplicaID(podName string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(podName))
+	return fmt.Sprintf("%08x", hash.Sum32())
+}
@@ -0,0 +1,281 @@
+package net_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/kubetest"
+	"github.com/carvel-dev/kwt/pkg/kwt/logtest"
+	. "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	netDeploymentPath = "/apis/apps/v1/namespaces/ns/deployments/kwt-net-dk"
+	netPDBPath        = "/apis/policy/v1/namespaces/ns/poddisruptionbudgets/kwt-net-dk"
+)
+
+func fakeNetReplica(name string) map[string]interface{} {
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "ns",
+			"labels":    map[string]interface{}{"kwt.cppforlife.com/net-deployment": "kwt-net-dk"},
+		},
+		"status": map[string]interface{}{"podIP": "10.1.0.1"},
+	}
+}
+
+func fakeEndpoints(name string, readyPods, notReadyPods []string) map[string]interface{} {
+	addrs := func(podNames []string) []interface{} {
+		result := []interface{}{}
+		for _, podName := range podNames {
+			result = append(result, map[string]interface{}{
+				"ip":        "10.1.0.1",
+				"targetRef": map[string]interface{}{"kind": "Pod", "name": podName},
+			})
+		}
+		return result
+	}
+
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{"name": name},
+		"subsets": []interface{}{map[string]interface{}{
+			"addresses":         addrs(readyPods),
+			"notReadyAddresses": addrs(notReadyPods),
+		}},
+	}
+}
+
+// fillNetReplicaEndpoints makes created replica services select their replicas
+func fillNetReplicaEndpoints(api *kubetest.FakeAPI) func() {
+	doneCh := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-doneCh:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+
+			for _, path := range api.Paths("/api/v1/namespaces/ns/services/") {
+				name := strings.TrimPrefix(path, "/api/v1/namespaces/ns/services/")
+				endpointsPath := "/api/v1/namespaces/ns/endpoints/" + name
+
+				spec, _ := api.Get(path)["spec"].(map[string]interface{})
+				selector, _ := spec["selector"].(map[string]interface{})
+
+				if _, found := selector["kwt.cppforlife.com/net-replica"]; found && !api.Has(endpointsPath) {
+					api.Set(endpointsPath, fakeEndpoints(name, []string{"replica"}, nil))
+				}
+			}
+		}
+	}()
+
+	return func() { close(doneCh) }
+}
+
+func netDeployment(t *testing.T, api *kubetest.FakeAPI) appsv1.Deployment {
+	var deployment appsv1.Deployment
+
+	api.Decode(t, netDeploymentPath, &deployment)
+
+	return deployment
+}
+
+func newNetDeploymentEntryPoint(t *testing.T, coreClient kubernetes.Interface, opts KubeNetPodOpts, image string) KubeEntryPoint {
+	owner, err := NewKubeNetOwner("dk", "dk@laptop")
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	return NewKubeEntryPoint(coreClient, &rest.Config{}, "ns", image, KubeTransportAPIProxy, logtest.NoopLogger{}).
+		WithOwner(owner).WithNetPodOpts(opts).WithSSHKeyType(dstconn.SSHKeyTypeED25519)
+}
+
+func TestKubeEntryPointNetDeployment(t *testing.T) {
+	api, coreClient, closeFunc := kubetest.NewFakeAPI(t)
+	defer closeFunc()
+
+	defer fillNetReplicaEndpoints(api)()
+
+	api.Set("/api/v1/namespaces/ns/pods/kwt-net-dk-1", fakeNetReplica("kwt-net-dk-1"))
+	api.Set("/api/v1/namespaces/ns/pods/kwt-net-dk-2", fakeNetReplica("kwt-net-dk-2"))
+	api.Set("/api/v1/namespaces/ns/pods/kwt-net-dk-3", fakeNetReplica("kwt-net-dk-3"))
+	api.Set("/api/v1/namespaces/ns/endpoints/kwt-net-dk-pods", fakeEndpoints(
+		"kwt-net-dk-pods", []string{"kwt-net-dk-2", "kwt-net-dk-1"}, []string{"kwt-net-dk-3"}))
+
+	opts := KubeNetPodOpts{
+		Replicas:    2,
+		Tolerations: []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpExists}},
+	}
+
+	entryPoint := newNetDeploymentEntryPoint(t, coreClient, opts, "sshd:1")
+
+	// Replicas are picked in turns so that pooled clients are spread out
+	for _, expectedPod := range []string{"kwt-net-dk-1", "kwt-net-dk-2", "kwt-net-dk-1"} {
+		sess, err := entryPoint.EntryPoint()
+		if err != nil {
+			t.Fatalf("Expected no err: %s", err)
+		}
+
+		sess.Close()
+
+		status := entryPoint.Status()
+
+		if status.NetPod != expectedPod || status.NetReplicas != 2 || status.NetReadyReplicas != 2 {
+			t.Fatalf("Expected replica '%s' to be picked out of 2 ready replicas: %#v", expectedPod, status)
+		}
+	}
+
+	deployment := netDeployment(t, api)
+	podSpec := deployment.Spec.Template.Spec
+
+	if *deployment.Spec.Replicas != 2 || podSpec.Containers[0].Image != "sshd:1" {
+		t.Fatalf("Expected deployment to match configuration: %#v", deployment.Spec)
+	}
+
+	if len(podSpec.Tolerations) != 1 || podSpec.Tolerations[0].Key != "dedicated" {
+		t.Fatalf("Expected deployment to include tolerations: %#v", podSpec.Tolerations)
+	}
+
+	if podSpec.Affinity == nil || podSpec.Affinity.PodAntiAffinity == nil ||
+		len(podSpec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution) != 1 {
+		t.Fatalf("Expected deployment to spread replicas across nodes: %#v", podSpec.Affinity)
+	}
+
+	if deployment.Spec.Strategy.RollingUpdate.MaxUnavailable.IntValue() != 1 {
+		t.Fatalf("Expected deployment to replace one replica at a time: %#v", deployment.Spec.Strategy)
+	}
+
+	pdb := api.Get(netPDBPath)
+
+	if pdb == nil || pdb["spec"].(map[string]interface{})["minAvailable"] != float64(1) {
+		t.Fatalf("Expected pod disruption budget to keep one replica: %#v", pdb)
+	}
+
+	if api.Has("/api/v1/namespaces/ns/services/kwt-net-dk") {
+		t.Fatalf("Expected API proxy service selecting all replicas to not be created")
+	}
+
+	// Each picked replica is labeled and selected by its own service
+	var replicaServices []corev1.Service
+
+	for _, podName := range []string{"kwt-net-dk-1", "kwt-net-dk-2"} {
+		var pod corev1.Pod
+
+		api.Decode(t, "/api/v1/namespaces/ns/pods/"+podName, &pod)
+		replicaID := pod.Labels["kwt.cppforlife.com/net-replica"]
+
+		var service corev1.Service
+
+		if len(replicaID) > 0 {
+			api.Decode(t, "/api/v1/namespaces/ns/services/kwt-net-dk-"+replicaID, &service)
+		}
+
+		if len(replicaID) == 0 || service.Spec.Selector["kwt.cppforlife.com/net-replica"] != replicaID {
+			t.Fatalf("Expected replica '%s' to have its own service: %#v", podName, service)
+		}
+
+		replicaServices = append(replicaServices, service)
+	}
+
+	if replicaServices[0].Name == replicaServices[1].Name {
+		t.Fatalf("Expected replica services to be different")
+	}
+
+	// Unchanged configuration does not result in deployment updates
+	api.Requests()
+
+	sess, err := entryPoint.EntryPoint()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	sess.Close()
+
+	for _, req := range api.Requests() {
+		if req == "PUT "+netDeploymentPath || strings.HasPrefix(req, "POST /apis/policy/") {
+			t.Fatalf("Expected deployment to not be updated: %s", req)
+		}
+	}
+
+	err = entryPoint.Delete()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	for _, path := range []string{netDeploymentPath, netPDBPath, "/api/v1/namespaces/ns/services/" + replicaServices[0].Name,
+		"/api/v1/namespaces/ns/services/" + replicaServices[1].Name, "/api/v1/namespaces/ns/services/kwt-net-dk-pods"} {
+		if api.Has(path) {
+			t.Fatalf("Expected '%s' to be deleted", path)
+		}
+	}
+}
+
+func TestKubeEntryPointNetDeploymentReconcile(t *testing.T) {
+	api, coreClient, closeFunc := kubetest.NewFakeAPI(t)
+	defer closeFunc()
+
+	defer fillNetReplicaEndpoints(api)()
+
+	api.Set("/api/v1/namespaces/ns/pods/kwt-net-dk-1", fakeNetReplica("kwt-net-dk-1"))
+	api.Set("/api/v1/namespaces/ns/endpoints/kwt-net-dk-pods", fakeEndpoints(
+		"kwt-net-dk-pods", []string{"kwt-net-dk-1"}, nil))
+
+	opts := KubeNetPodOpts{Replicas: 2}
+
+	sess, err := newNetDeploymentEntryPoint(t, coreClient, opts, "sshd:1").EntryPoint()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	sess.Close()
+
+	// Simulate key rotation which restarts replicas
+	api.Update(netDeploymentPath, func(obj map[string]interface{}) {
+		template := obj["spec"].(map[string]interface{})["template"].(map[string]interface{})
+		template["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})["kwt.cppforlife.com/net-keys-rotated-at"] = "rotated"
+	})
+
+	opts = KubeNetPodOpts{
+		Replicas:    1,
+		Tolerations: []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpExists}},
+	}
+
+	sess, err = newNetDeploymentEntryPoint(t, coreClient, opts, "sshd:2").EntryPoint()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	sess.Close()
+
+	deployment := netDeployment(t, api)
+	podSpec := deployment.Spec.Template.Spec
+
+	if *deployment.Spec.Replicas != 1 || podSpec.Containers[0].Image != "sshd:2" {
+		t.Fatalf("Expected deployment to be updated: %#v", deployment.Spec)
+	}
+
+	if len(podSpec.Tolerations) != 1 || podSpec.Tolerations[0].Key != "dedicated" {
+		t.Fatalf("Expected deployment tolerations to be updated: %#v", podSpec.Tolerations)
+	}
+
+	if podSpec.Affinity != nil {
+		t.Fatalf("Expected single replica to not have anti-affinity: %#v", podSpec.Affinity)
+	}
+
+	if deployment.Spec.Template.Annotations["kwt.cppforlife.com/net-keys-rotated-at"] != "rotated" {
+		t.Fatalf("Expected key rotation annotation to be kept: %#v", deployment.Spec.Template.Annotations)
+	}
+
+	if api.Has(netPDBPath) {
+		t.Fatalf("Expected pod disruption budget to be deleted for single replica")
+	}
+}
@@ -0,0 +1,178 @@
+package net
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	ctlkube "github.com/carvel-dev/kwt/pkg/kwt/kube"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
+	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	netSSHHostKeyFingerprintAnnKey = "kwt.cppforlife.com/net-ssh-host-key-fingerprint"
+	netHostKeyPath                 = "/etc/ssh/kwt_host_key"
+)
+
+// kubeNetEphemeralKeys are generated once per process and never stored in the cluster;
+// net pod receives client public key and host key via exec before starting sshd
+type kubeNetEphemeralKeys struct {
+	clientKey          dstconn.SSHKey
+	hostKey            dstconn.SSHKey
+	hostKeyFingerprint string
+	err                error
+	once               sync.Once
+}
+
+func (k *kubeNetEphemeralKeys) Keys(keyType dstconn.SSHKeyType) (dstconn.SSHKey, dstconn.SSHKey, string, error) {
+	k.once.Do(func() {
+		generator := dstconn.NewSSHKeyGenerator().WithKeyType(keyType)
+
+		k.clientKey, k.err = generator.Generate()
+		if k.err != nil {
+			return
+		}
+
+		k.hostKey, k.err = generator.Generate()
+		if k.err != nil {
+			return
+		}
+
+		var hostPubKey ssh.PublicKey
+
+		hostPubKey, k.err = dstconn.ParsePublicKey(k.hostKey.PublicKey)
+		if k.err != nil {
+			return
+		}
+
+		k.hostKeyFingerprint = ssh.FingerprintSHA256(hostPubKey)
+	})
+
+	return k.clientKey, k.hostKey, k.hostKeyFingerprint, k.err
+}
+
+// WithEphemeralKeys generates client and host keys in memory instead of keeping them in secrets
+func (f KubeEntryPoint) WithEphemeralKeys() KubeEntryPoint {
+	f.ephemeralKeys = &kubeNetEphemeralKeys{}
+	return f
+}
+
+// Release deletes net pod started with ephemeral keys of this process
+// since no other session can use it; other net resources are kept
+func (f KubeEntryPoint) Release() error {
+	if f.ephemeralKeys == nil {
+		return nil
+	}
+
+	pod, err := f.coreClient.CoreV1().Pods(f.namespace).Get(f.podName, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("Getting net pod: %s", err)
+	}
+
+	_, _, fingerprint, err := f.ephemeralKeys.Keys(f.keyType)
+	if err != nil {
+		return err
+	}
+
+	// Pod may have been replaced by another session (see ownerLeaseExpired)
+	if pod.Annotations[netSSHHostKeyFingerprintAnnKey] != fingerprint {
+		return nil
+	}
+
+	f.logger.Info(f.logTag, "Deleting networking pod '%s' in namespace '%s' started with ephemeral SSH keys", f.podName, f.namespace)
+
+	err = f.coreClient.CoreV1().Pods(f.namespace).Delete(f.podName, &metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("Deleting net pod: %s", err)
+	}
+
+	return nil
+}
+
+// ownerLeaseExpired indicates that no session of this owner is running (eg previous
+// session was killed before it could release its pod), hence pod started with other
+// keys is not used by anyone. Lease has to be checked before it's renewed.
+func (f KubeEntryPoint) ownerLeaseExpired() (bool, error) {
+	if len(f.owner.Name) == 0 || f.podOpts.Replicas > 0 {
+		return false, nil
+	}
+
+	expired, err := f.lease().Expired()
+	if err != nil {
+		if leasesUnavailable(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return expired, nil
+}
+
+// startedWithSameKeysErr fails if pod was started by a session using other keys. Since
+// pod is named per owner (not per session), deleting it would break other session
+// unless owner's lease expired (see ownerLeaseExpired).
+func (f KubeEntryPoint) startedWithSameKeysErr(pod *corev1.Pod) error {
+	const hint = "stop other 'kwt net' sessions and delete it via 'kwt net clean-up' or use different --net-owner"
+
+	podFingerprint, podEphemeral := pod.Annotations[netSSHHostKeyFingerprintAnnKey]
+
+	if f.ephemeralKeys == nil {
+		if podEphemeral {
+			return fmt.Errorf("Expected networking pod '%s' in namespace '%s' to use SSH keys stored in secrets, "+
+				"but it was started with ephemeral SSH keys (%s)", pod.Name, f.namespace, hint)
+		}
+		return nil
+	}
+
+	_, _, fingerprint, err := f.ephemeralKeys.Keys(f.keyType)
+	if err != nil {
+		return err
+	}
+
+	if !podEphemeral {
+		return fmt.Errorf("Expected networking pod '%s' in namespace '%s' to use ephemeral SSH keys, "+
+			"but it was started with SSH keys stored in secrets (%s)", pod.Name, f.namespace, hint)
+	}
+
+	if podFingerprint != fingerprint {
+		return fmt.Errorf("Expected networking pod '%s' in namespace '%s' to use ephemeral SSH keys of this session, "+
+			"but it was started with ephemeral SSH keys of another session (%s)", pod.Name, f.namespace, hint)
+	}
+
+	return nil
+}
+
+// injectEphemeralKeys writes keys into started container which waits for them before starting sshd
+func (f KubeEntryPoint) injectEphemeralKeys(pod *corev1.Pod) error {
+	clientKey, hostKey, _, err := f.ephemeralKeys.Keys(f.keyType)
+	if err != nil {
+		return err
+	}
+
+	f.logger.Info(f.logTag, "Injecting ephemeral SSH keys into networking pod '%s' in namespace '%s'", pod.Name, f.namespace)
+
+	script := strings.Join([]string{
+		"read -r pub_key",
+		fmt.Sprintf(`echo "$pub_key" > /home/%s/.ssh/authorized_keys`, sshUser),
+		// Host key is moved into place once complete since container is waiting for it
+		fmt.Sprintf("(umask 077 && cat > %s.tmp)", netHostKeyPath),
+		fmt.Sprintf("mv %s.tmp %s", netHostKeyPath, netHostKeyPath),
+	}, " && ")
+
+	stdin := strings.NewReader(strings.TrimSpace(clientKey.PublicKey) + "\n" + hostKey.PrivateKey)
+
+	err = ctlkube.NewExec(*pod, netContainerName, f.coreClient, f.restConfig).
+		Execute([]string{"/bin/bash", "-c", script}, ctlkube.ExecuteOpts{Stdin: stdin})
+	if err != nil {
+		return fmt.Errorf("Injecting ephemeral SSH keys into net pod (requires 'pods/exec' access): %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,248 @@
+package net_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/kubetest"
+	. "github.com/carvel-dev/kwt/pkg/kwt/net"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func fakeNetPod(annotations map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":        "kwt-net-dk",
+			"namespace":   "ns",
+			"annotations": annotations,
+		},
+		"status": map[string]interface{}{"phase": "Running"},
+	}
+}
+
+const netLeasePath = "/apis/coordination.k8s.io/v1/namespaces/ns/leases/kwt-net-dk"
+
+func fakeNetLease(renewedAt time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "kwt-net-dk", "namespace": "ns"},
+		"spec": map[string]interface{}{
+			"holderIdentity":       "dk@laptop",
+			"leaseDurationSeconds": 120,
+			"renewTime":            renewedAt.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		},
+	}
+}
+
+func TestKubeEntryPointEphemeralKeys(t *testing.T) {
+	api, coreClient, closeFunc := kubetest.NewFakeAPI(t)
+	defer closeFunc()
+
+	// Stop waiting for created pod since it never starts
+	api.ErrFunc = func(method, path string) int {
+		if method == "GET" && path == netPodPath && api.Has(netPodPath) {
+			return http.StatusForbidden
+		}
+		return 0
+	}
+
+	entryPoint := newNetDeploymentEntryPoint(t, coreClient, KubeNetPodOpts{}, "sshd:1").WithEphemeralKeys()
+
+	_, err := entryPoint.EntryPoint()
+	if err == nil {
+		t.Fatalf("Expected err waiting for pod")
+	}
+
+	for _, req := range api.Requests() {
+		if strings.HasPrefix(req, "POST /api/v1/namespaces/ns/secrets") {
+			t.Fatalf("Expected secrets to not be created: %s", req)
+		}
+	}
+
+	var pod corev1.Pod
+
+	api.Decode(t, netPodPath, &pod)
+
+	if len(pod.Spec.Containers) != 1 {
+		t.Fatalf("Expected pod to be created: %#v", pod)
+	}
+
+	for _, env := range pod.Spec.Containers[0].Env {
+		if strings.HasPrefix(env.Name, "KWT_") {
+			t.Fatalf("Expected container to not receive keys via env vars: %#v", env)
+		}
+	}
+
+	if !strings.HasPrefix(pod.Annotations["kwt.cppforlife.com/net-ssh-host-key-fingerprint"], "SHA256:") {
+		t.Fatalf("Expected pod to be annotated with host key fingerprint: %#v", pod.Annotations)
+	}
+}
+
+func TestKubeEntryPointEphemeralKeysWithReplicas(t *testing.T) {
+	api, coreClient, closeFunc := kubetest.NewFakeAPI(t)
+	defer closeFunc()
+
+	entryPoint := newNetDeploymentEntryPoint(t, coreClient, KubeNetPodOpts{Replicas: 2}, "sshd:1").WithEphemeralKeys()
+
+	_, err := entryPoint.EntryPoint()
+	if err == nil || err.Error() != "Expected ephemeral SSH keys to not be used with net pod replicas" {
+		t.Fatalf("Expected replicas to be rejected, but was: %v", err)
+	}
+
+	if api.Has(netDeploymentPath) {
+		t.Fatalf("Expected deployment to not be created")
+	}
+}
+
+func TestKubeEntryPointEphemeralKeysPodMismatch(t *testing.T) {
+	examples := []struct {
+		desc        string
+		annotations map[string]interface{}
+		ephemeral   bool
+		err         string
+	}{
+		{
+			desc:        "pod started with keys stored in secrets",
+			annotations: map[string]interface{}{},
+			ephemeral:   true,
+			err:         "but it was started with SSH keys stored in secrets",
+		},
+		{
+			desc:        "pod started by another session with ephemeral keys",
+			annotations: map[string]interface{}{"kwt.cppforlife.com/net-ssh-host-key-fingerprint": "SHA256:other"},
+			ephemeral:   true,
+			err:         "but it was started with ephemeral SSH keys of another session",
+		},
+		{
+			desc:        "pod started with ephemeral keys used with keys stored in secrets",
+			annotations: map[string]interface{}{"kwt.cppforlife.com/net-ssh-host-key-fingerprint": "SHA256:other"},
+			err:         "but it was started with ephemeral SSH keys",
+		},
+	}
+
+	for _, ex := range examples {
+		api, coreClient, closeFunc := kubetest.NewFakeAPI(t)
+
+		api.Set(netPodPath, fakeNetPod(ex.annotations))
+		api.Set(netLeasePath, fakeNetLease(time.Now())) // another session is running
+
+		entryPoint := newNetDeploymentEntryPoint(t, coreClient, KubeNetPodOpts{}, "sshd:1")
+		if ex.ephemeral {
+			entryPoint = entryPoint.WithEphemeralKeys()
+		}
+
+		_, err := entryPoint.EntryPoint()
+
+		closeFunc()
+
+		if err == nil || !strings.Contains(err.Error(), ex.err) || !strings.Contains(err.Error(), "kwt net clean-up") {
+			t.Fatalf("[%s] Expected err '%s', but was: %v", ex.desc, ex.err, err)
+		}
+
+		// Pod may be used by another session
+		for _, req := range api.Requests() {
+			if strings.HasPrefix(req, "DELETE ") {
+				t.Fatalf("[%s] Expected pod to not be deleted: %s", ex.desc, req)
+			}
+		}
+	}
+}
+
+func TestKubeEntryPointEphemeralKeysExpiredLease(t *testing.T) {
+	api, coreClient, closeFunc := kubetest.NewFakeAPI(t)
+	defer closeFunc()
+
+	api.Set(netPodPath, fakeNetPod(map[string]interface{}{"kwt.cppforlife.com/net-ssh-host-key-fingerprint": "SHA256:other"}))
+	api.Set(netLeasePath, fakeNetLease(time.Now().Add(-10*time.Minute))) // session was killed
+
+	// Stop waiting for recreated pod since it never starts
+	api.ErrFunc = func(method, path string) int {
+		if method == "GET" && path == netPodPath && api.Has(netPodPath) {
+			annotations := api.Get(netPodPath)["metadata"].(map[string]interface{})["annotations"]
+			if annotations.(map[string]interface{})["kwt.cppforlife.com/net-ssh-host-key-fingerprint"] != "SHA256:other" {
+				return http.StatusForbidden
+			}
+		}
+		return 0
+	}
+
+	entryPoint := newNetDeploymentEntryPoint(t, coreClient, KubeNetPodOpts{}, "sshd:1").WithEphemeralKeys()
+
+	_, err := entryPoint.EntryPoint()
+	if err == nil || strings.Contains(err.Error(), "another session") {
+		t.Fatalf("Expected pod of expired session to be replaced, but was: %v", err)
+	}
+
+	var pod corev1.Pod
+
+	api.Decode(t, netPodPath, &pod)
+
+	fingerprint := pod.Annotations["kwt.cppforlife.com/net-ssh-host-key-fingerprint"]
+	if fingerprint == "SHA256:other" || !strings.HasPrefix(fingerprint, "SHA256:") {
+		t.Fatalf("Expected pod to be recreated with keys of this session: %#v", pod.Annotations)
+	}
+
+	var deleted bool
+
+	for _, req := range api.Requests() {
+		if req == "DELETE "+netPodPath {
+			deleted = true
+		}
+	}
+
+	if !deleted {
+		t.Fatalf("Expected pod of expired session to be deleted")
+	}
+}
+
+func TestKubeEntryPointEphemeralKeysRelease(t *testing.T) {
+	api, coreClient, closeFunc := kubetest.NewFakeAPI(t)
+	defer closeFunc()
+
+	// Stop waiting for created pod since it never starts
+	api.ErrFunc = func(method, path string) int {
+		if method == "GET" && path == netPodPath && api.Has(netPodPath) {
+			return http.StatusForbidden
+		}
+		return 0
+	}
+
+	entryPoint := newNetDeploymentEntryPoint(t, coreClient, KubeNetPodOpts{}, "sshd:1").WithEphemeralKeys()
+
+	_, err := entryPoint.EntryPoint()
+	if err == nil {
+		t.Fatalf("Expected err waiting for pod")
+	}
+
+	api.ErrFunc = nil
+
+	var pod corev1.Pod
+
+	api.Decode(t, netPodPath, &pod)
+
+	fingerprint := pod.Annotations["kwt.cppforlife.com/net-ssh-host-key-fingerprint"]
+
+	setFingerprint := func(fingerprint string) {
+		api.Update(netPodPath, func(pod map[string]interface{}) {
+			pod["metadata"].(map[string]interface{})["annotations"] = map[string]interface{}{
+				"kwt.cppforlife.com/net-ssh-host-key-fingerprint": fingerprint,
+			}
+		})
+	}
+
+	// Pod replaced by another session is left alone
+	setFingerprint("SHA256:other")
+
+	err = entryPoint.Release()
+	if err != nil || !api.Has(netPodPath) {
+		t.Fatalf("Expected pod of another session to be kept: %v", err)
+	}
+
+	setFingerprint(fingerprint)
+
+	err = entryPoint.Release()
+	if err != nil || api.Has(netPodPath) {
+		t.Fatalf("Expected pod started with keys of this session to be deleted: %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+package net
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// KubeNetGC deletes net resources of owners whose lease expired.
+// Resources of owners without any lease (eg created by older versions) are left alone
+// unless they are explicitly deleted as legacy resources (see DeleteLegacy).
+type KubeNetGC struct {
+	coreClient kubernetes.Interface
+	namespace  string
+
+	logTag string
+	logger Logger
+}
+
+func NewKubeNetGC(coreClient kubernetes.Interface, namespace string, logger Logger) KubeNetGC {
+	return KubeNetGC{coreClient, namespace, "KubeNetGC", logger}
+}
+
+// DeleteStale returns descriptions of deleted resources
+func (g KubeNetGC) DeleteStale() ([]string, error) {
+	leases, err := listKubeNetLeases(g.coreClient, g.namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []string
+	now := time.Now()
+
+	for _, lease := range leases {
+		if !lease.Expired(now) {
+			continue
+		}
+
+		owner := lease.Labels[netOwnerLabelKey]
+
+		g.logger.Info(g.logTag, "Deleting networking resources of '%s' (lease '%s' expired)",
+			lease.Annotations[netOwnerIdentityAnnKey], lease.Name)
+
+		ownerDeleted, err := g.deleteOwned(owner)
+		deleted = append(deleted, ownerDeleted...)
+		if err != nil {
+			return deleted, err
+		}
+
+		err = NewKubeNetLease(g.coreClient, g.namespace, KubeNetOwner{Name: owner}, g.logger).Delete()
+		if err != nil {
+			return deleted, err
+		}
+
+		deleted = append(deleted, fmt.Sprintf("lease '%s'", lease.Name))
+	}
+
+	return deleted, nil
+}
+
+func (g KubeNetGC) deleteOwned(owner string) ([]string, error) {
+	var deleted []string
+
+	listOpts := metav1.ListOptions{LabelSelector: netOwnerLabelKey + "=" + owner}
+	propagation := metav1.DeletePropagationForeground
+	deleteOpts := &metav1.DeleteOptions{PropagationPolicy: &propagation}
+
+	deployments, err := g.coreClient.AppsV1().Deployments(g.namespace).List(listOpts)
+	if err != nil {
+		return deleted, fmt.Errorf("Listing net deployments: %s", err)
+	}
+
+	for _, deployment := range deployments.Items {
+		err := g.coreClient.AppsV1().Deployments(g.namespace).Delete(deployment.Name, deleteOpts)
+		if err != nil && !errors.IsNotFound(err) {
+			return deleted, fmt.Errorf("Deleting net deployment: %s", err)
+		}
+		deleted = append(deleted, fmt.Sprintf("deployment '%s'", deployment.Name))
+	}
+
+	pdbs, err := listKubeNetPDBs(g.coreClient, g.namespace, listOpts)
+	if err != nil && !errors.IsNotFound(err) && !errors.IsForbidden(err) { // budgets are optional
+		return deleted, fmt.Errorf("Listing net pod disruption budgets: %s", err)
+	}
+
+	for _, pdb := range pdbs {
+		err := g.coreClient.CoreV1().RESTClient().Delete().
+			AbsPath(pdbAPIPath, "namespaces", g.namespace, "poddisruptionbudgets", pdb.Name).Do().Error()
+		if err != nil && !errors.IsNotFound(err) {
+			return deleted, fmt.Errorf("Deleting net pod disruption budget: %s", err)
+		}
+		deleted = append(deleted, fmt.Sprintf("pod disruption budget '%s'", pdb.Name))
+	}
+
+	pods, err := g.coreClient.CoreV1().Pods(g.namespace).List(listOpts)
+	if err != nil {
+		return deleted, fmt.Errorf("Listing net pods: %s", err)
+	}
+
+	for _, pod := range pods.Items {
+		if len(pod.OwnerReferences) > 0 {
+			continue // deleted together with its deployment
+		}
+		err := g.coreClient.CoreV1().Pods(g.namespace).Delete(pod.Name, deleteOpts)
+		if err != nil && !errors.IsNotFound(err) {
+			return deleted, fmt.Errorf("Deleting net pod: %s", err)
+		}
+		deleted = append(deleted, fmt.Sprintf("pod '%s'", pod.Name))
+	}
+
+	services, err := g.coreClient.CoreV1().Services(g.namespace).List(listOpts)
+	if err != nil {
+		return deleted, fmt.Errorf("Listing net services: %s", err)
+	}
+
+	for _, service := range services.Items {
+		err := g.coreClient.CoreV1().Services(g.namespace).Delete(service.Name, deleteOpts)
+		if err != nil && !errors.IsNotFound(err) {
+			return deleted, fmt.Errorf("Deleting net service: %s", err)
+		}
+		deleted = append(deleted, fmt.Sprintf("service '%s'", service.Name))
+	}
+
+	secrets, err := g.coreClient.CoreV1().Secrets(g.namespace).List(listOpts)
+	if err != nil {
+		return deleted, fmt.Errorf("Listing net secrets: %s", err)
+	}
+
+	for _, secret := range secrets.Items {
+		err := g.coreClient.CoreV1().Secrets(g.namespace).Delete(secret.Name, deleteOpts)
+		if err != nil && !errors.IsNotFound(err) {
+			return deleted, fmt.Errorf("Deleting net secret: %s", err)
+		}
+		deleted = append(deleted, fmt.Sprintf("secret '%s'", secret.Name))
+	}
+
+	return deleted, nil
+}
+
+// DeleteLegacy deletes shared net resources (eg 'kwt-net' pod) created by versions
+// that did not name resources per owner. Resources labeled with an owner are left alone
+// since their names may match legacy ones (eg service of owner named 'pods').
+func (g KubeNetGC) DeleteLegacy() ([]string, error) {
+	var deleted []string
+
+	legacy := KubeNetOwner{}
+	propagation := metav1.DeletePropagationForeground
+	deleteOpts := &metav1.DeleteOptions{PropagationPolicy: &propagation}
+
+	deployments := g.coreClient.AppsV1().Deployments(g.namespace)
+	pods := g.coreClient.CoreV1().Pods(g.namespace)
+	services := g.coreClient.CoreV1().Services(g.namespace)
+	secrets := g.coreClient.CoreV1().Secrets(g.namespace)
+
+	type legacyAccessor struct {
+		kind       string
+		getFunc    func(string) (metav1.Object, error)
+		deleteFunc func(string) error
+	}
+
+	deploymentAccessor := legacyAccessor{
+		"deployment",
+		func(name string) (metav1.Object, error) { return deployments.Get(name, metav1.GetOptions{}) },
+		func(name string) error { return deployments.Delete(name, deleteOpts) },
+	}
+	podAccessor := legacyAccessor{
+		"pod",
+		func(name string) (metav1.Object, error) { return pods.Get(name, metav1.GetOptions{}) },
+		func(name string) error { return pods.Delete(name, deleteOpts) },
+	}
+	serviceAccessor := legacyAccessor{
+		"service",
+		func(name string) (metav1.Object, error) { return services.Get(name, metav1.GetOptions{}) },
+		func(name string) error { return services.Delete(name, deleteOpts) },
+	}
+	secretAccessor := legacyAccessor{
+		"secret",
+		func(name string) (metav1.Object, error) { return secrets.Get(name, metav1.GetOptions{}) },
+		func(name string) error { return secrets.Delete(name, deleteOpts) },
+	}
+
+	resources := []struct {
+		accessor legacyAccessor
+		name     string
+	}{
+		{deploymentAccessor, legacy.ResourceName()},
+		{podAccessor, legacy.ResourceName()},
+		{serviceAccessor, legacy.ResourceName()},
+		{serviceAccessor, legacy.ResourceName("pods")},
+		{secretAccessor, legacy.ResourceName("ssh-key")},
+		{secretAccessor, legacy.ResourceName("host-key")},
+	}
+
+	for _, res := range resources {
+		desc := fmt.Sprintf("%s '%s'", res.accessor.kind, res.name)
+
+		obj, err := res.accessor.getFunc(res.name)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return deleted, fmt.Errorf("Getting legacy net %s: %s", desc, err)
+		}
+
+		if _, found := obj.GetLabels()[netOwnerLabelKey]; found {
+			continue
+		}
+
+		g.logger.Info(g.logTag, "Deleting legacy networking %s", desc)
+
+		err = res.accessor.deleteFunc(res.name)
+		if err != nil && !errors.IsNotFound(err) {
+			return deleted, fmt.Errorf("Deleting legacy net %s: %s", desc, err)
+		}
+
+		deleted = append(deleted, desc)
+	}
+
+	return deleted, nil
+}
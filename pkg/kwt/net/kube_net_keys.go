@@ -0,0 +1,169 @@
+package net
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	netSSHKeyTypeAnnKey      = "kwt.cppforlife.com/net-ssh-key-type"
+	netSSHKeyCreatedAtAnnKey = "kwt.cppforlife.com/net-ssh-key-created-at"
+	netKeysRotatedAtAnnKey   = "kwt.cppforlife.com/net-keys-rotated-at"
+)
+
+// WithSSHKeyType configures type of newly generated client and host keys
+func (f KubeEntryPoint) WithSSHKeyType(keyType dstconn.SSHKeyType) KubeEntryPoint {
+	f.keyType = keyType
+	return f
+}
+
+func (f KubeEntryPoint) netSSHSecret(name string) (*corev1.Secret, dstconn.SSHKey, error) {
+	key, err := dstconn.NewSSHKeyGenerator().WithKeyType(f.keyType).Generate()
+	if err != nil {
+		return nil, dstconn.SSHKey{}, err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: f.objectMeta(name, nil),
+		Type:       corev1.SecretTypeSSHAuth,
+		StringData: map[string]string{
+			corev1.SSHAuthPrivateKey: key.PrivateKey,
+			sshSecretPublicKey:       key.PublicKey,
+		},
+	}
+
+	// Secrets are updated in place during rotation hence creation timestamp is not enough
+	secret.Annotations[netSSHKeyTypeAnnKey] = string(key.Type)
+	secret.Annotations[netSSHKeyCreatedAtAnnKey] = time.Now().UTC().Format(time.RFC3339)
+
+	return secret, key, nil
+}
+
+// RotateKeys regenerates client and host keys and restarts
+// net pods so that they pick up new keys. Connected clients
+// reconnect with new keys once their connections break.
+func (f KubeEntryPoint) RotateKeys() error {
+	for _, name := range []string{f.secretClientSSHName, f.secretHostSSHName} {
+		f.logger.Info(f.logTag, "Rotating networking secret '%s' in namespace '%s'", name, f.namespace)
+
+		err := f.rotateNetSSHSecret(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return f.rollNetPods()
+}
+
+// RotateKeysIfOlder rotates keys if either of them is older than maxAge
+func (f KubeEntryPoint) RotateKeysIfOlder(maxAge time.Duration) (bool, error) {
+	for _, name := range []string{f.secretClientSSHName, f.secretHostSSHName} {
+		secret, err := f.coreClient.CoreV1().Secrets(f.namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue // will be created with new keys
+			}
+			return false, fmt.Errorf("Getting net pod ssh secret: %s", err)
+		}
+
+		createdAt := secret.CreationTimestamp.Time
+
+		if val, found := secret.Annotations[netSSHKeyCreatedAtAnnKey]; found {
+			parsedCreatedAt, err := time.Parse(time.RFC3339, val)
+			if err == nil {
+				createdAt = parsedCreatedAt
+			}
+		}
+
+		if age := time.Since(createdAt); age > maxAge {
+			f.logger.Info(f.logTag, "Networking secret '%s' in namespace '%s' is older than %s (%s)",
+				name, f.namespace, maxAge, age.Round(time.Second))
+			return true, f.RotateKeys()
+		}
+	}
+
+	return false, nil
+}
+
+func (f KubeEntryPoint) rotateNetSSHSecret(name string) error {
+	secret, _, err := f.netSSHSecret(name)
+	if err != nil {
+		return err
+	}
+
+	foundSecret, err := f.coreClient.CoreV1().Secrets(f.namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("Getting net pod ssh secret: %s", err)
+		}
+
+		_, err = f.coreClient.CoreV1().Secrets(f.namespace).Create(secret)
+		if err != nil {
+			return fmt.Errorf("Creating net pod ssh secret: %s", err)
+		}
+
+		return nil
+	}
+
+	// Update in place so that concurrently reconnecting clients do not race to recreate it
+	foundSecret.Type = secret.Type
+	foundSecret.Data = nil
+	foundSecret.StringData = secret.StringData
+
+	if foundSecret.Annotations == nil {
+		foundSecret.Annotations = map[string]string{}
+	}
+	for k, v := range secret.Annotations {
+		foundSecret.Annotations[k] = v
+	}
+
+	_, err = f.coreClient.CoreV1().Secrets(f.namespace).Update(foundSecret)
+	if err != nil {
+		return fmt.Errorf("Updating net pod ssh secret: %s", err)
+	}
+
+	return nil
+}
+
+// rollNetPods restarts net pods since keys are only read on pod start
+func (f KubeEntryPoint) rollNetPods() error {
+	deployment, err := f.coreClient.AppsV1().Deployments(f.namespace).Get(f.deploymentName, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("Getting net deployment: %s", err)
+		}
+	} else {
+		f.logger.Info(f.logTag, "Rolling networking deployment '%s' in namespace '%s'", f.deploymentName, f.namespace)
+
+		if deployment.Spec.Template.Annotations == nil {
+			deployment.Spec.Template.Annotations = map[string]string{}
+		}
+		deployment.Spec.Template.Annotations[netKeysRotatedAtAnnKey] = time.Now().UTC().Format(time.RFC3339)
+
+		_, err := f.coreClient.AppsV1().Deployments(f.namespace).Update(deployment)
+		if err != nil {
+			return fmt.Errorf("Rolling net deployment: %s", err)
+		}
+	}
+
+	err = f.coreClient.CoreV1().Pods(f.namespace).Delete(f.podName, &metav1.DeleteOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("Deleting net pod: %s", err)
+	}
+
+	f.logger.Info(f.logTag, "Deleted networking pod '%s' in namespace '%s' (it will be recreated on next connect)",
+		f.podName, f.namespace)
+
+	return f.waitForObjDeletion(fmt.Sprintf("pod '%s'", f.podName), func() error {
+		_, err := f.coreClient.CoreV1().Pods(f.namespace).Get(f.podName, metav1.GetOptions{})
+		return err
+	})
+}
@@ -0,0 +1,148 @@
+package net_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/kubetest"
+	. "github.com/carvel-dev/kwt/pkg/kwt/net"
+)
+
+const (
+	netClientSecretPath = "/api/v1/namespaces/ns/secrets/kwt-net-dk-ssh-key"
+	netHostSecretPath   = "/api/v1/namespaces/ns/secrets/kwt-net-dk-host-key"
+	netPodPath          = "/api/v1/namespaces/ns/pods/kwt-net-dk"
+)
+
+func fakeNetSSHSecret(name string, createdAt, annCreatedAt time.Time) map[string]interface{} {
+	annotations := map[string]interface{}{"other": "kept"}
+	if !annCreatedAt.IsZero() {
+		annotations["kwt.cppforlife.com/net-ssh-key-created-at"] = annCreatedAt.UTC().Format(time.RFC3339)
+	}
+
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":              name,
+			"namespace":         "ns",
+			"uid":               "uid-" + name,
+			"creationTimestamp": createdAt.UTC().Format(time.RFC3339),
+			"labels":            map[string]interface{}{"kwt.cppforlife.com/net-owner": "dk"},
+			"annotations":       annotations,
+		},
+		"type": "kubernetes.io/ssh-auth",
+		"data": map[string]interface{}{"ssh-publickey": "b2xk"}, // 'old'
+	}
+}
+
+func TestKubeEntryPointRotateKeys(t *testing.T) {
+	api, coreClient, closeFunc := kubetest.NewFakeAPI(t)
+	defer closeFunc()
+
+	api.Set(netClientSecretPath, fakeNetSSHSecret("kwt-net-dk-ssh-key", time.Now(), time.Time{}))
+	api.Set(netHostSecretPath, fakeNetSSHSecret("kwt-net-dk-host-key", time.Now(), time.Time{}))
+	api.Set(netPodPath, fakeNetReplica("kwt-net-dk"))
+	api.Set(netDeploymentPath, map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "kwt-net-dk"},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{"annotations": map[string]interface{}{"other": "kept"}},
+			},
+		},
+	})
+
+	entryPoint := newNetDeploymentEntryPoint(t, coreClient, KubeNetPodOpts{}, "sshd:1")
+
+	rotatedAt := time.Now().UTC().Truncate(time.Second)
+
+	err := entryPoint.RotateKeys()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	// Secrets are updated in place so that concurrent clients do not race to recreate them
+	for _, req := range api.Requests() {
+		if strings.HasPrefix(req, "POST /api/v1/namespaces/ns/secrets") || strings.HasPrefix(req, "DELETE /api/v1/namespaces/ns/secrets") {
+			t.Fatalf("Expected secrets to be updated in place: %s", req)
+		}
+	}
+
+	for _, path := range []string{netClientSecretPath, netHostSecretPath} {
+		secret := api.Get(path)
+		meta := secret["metadata"].(map[string]interface{})
+		annotations := meta["annotations"].(map[string]interface{})
+		stringData, _ := secret["stringData"].(map[string]interface{})
+
+		if meta["uid"] != "uid-"+meta["name"].(string) {
+			t.Fatalf("Expected secret '%s' to be kept: %#v", path, meta)
+		}
+
+		if pubKey, _ := stringData["ssh-publickey"].(string); !strings.HasPrefix(pubKey, "ssh-ed25519 ") {
+			t.Fatalf("Expected secret '%s' to have new ed25519 key: %#v", path, secret)
+		}
+
+		if annotations["kwt.cppforlife.com/net-ssh-key-type"] != "ed25519" || annotations["other"] != "kept" {
+			t.Fatalf("Expected secret '%s' to be annotated with key type: %#v", path, annotations)
+		}
+
+		createdAt, err := time.Parse(time.RFC3339, annotations["kwt.cppforlife.com/net-ssh-key-created-at"].(string))
+		if err != nil || createdAt.Before(rotatedAt) {
+			t.Fatalf("Expected secret '%s' to be annotated with rotation time: %#v", path, annotations)
+		}
+	}
+
+	templateAnnotations := netDeployment(t, api).Spec.Template.Annotations
+
+	if _, found := templateAnnotations["kwt.cppforlife.com/net-keys-rotated-at"]; !found || templateAnnotations["other"] != "kept" {
+		t.Fatalf("Expected deployment to be rolled: %#v", templateAnnotations)
+	}
+
+	if api.Has(netPodPath) {
+		t.Fatalf("Expected bare net pod to be deleted")
+	}
+}
+
+func TestKubeEntryPointRotateKeysIfOlder(t *testing.T) {
+	api, coreClient, closeFunc := kubetest.NewFakeAPI(t)
+	defer closeFunc()
+
+	entryPoint := newNetDeploymentEntryPoint(t, coreClient, KubeNetPodOpts{}, "sshd:1")
+
+	rotated, err := entryPoint.RotateKeysIfOlder(24 * time.Hour)
+	if err != nil || rotated {
+		t.Fatalf("Expected missing secrets to not be rotated: %t, %v", rotated, err)
+	}
+
+	monthAgo := time.Now().Add(-30 * 24 * time.Hour)
+
+	// Secrets rotated in place keep their creation timestamp
+	api.Set(netClientSecretPath, fakeNetSSHSecret("kwt-net-dk-ssh-key", monthAgo, time.Now()))
+	api.Set(netHostSecretPath, fakeNetSSHSecret("kwt-net-dk-host-key", monthAgo, time.Now()))
+
+	rotated, err = entryPoint.RotateKeysIfOlder(24 * time.Hour)
+	if err != nil || rotated {
+		t.Fatalf("Expected recently rotated secrets to not be rotated: %t, %v", rotated, err)
+	}
+
+	api.Set(netHostSecretPath, fakeNetSSHSecret("kwt-net-dk-host-key", time.Now(), time.Now().Add(-48*time.Hour)))
+
+	api.Requests()
+
+	rotated, err = entryPoint.RotateKeysIfOlder(24 * time.Hour)
+	if err != nil || !rotated {
+		t.Fatalf("Expected secrets with old keys to be rotated: %t, %v", rotated, err)
+	}
+
+	var updates []string
+
+	for _, req := range api.Requests() {
+		if strings.HasPrefix(req, "PUT ") {
+			updates = append(updates, req)
+		}
+	}
+
+	expected := "PUT " + netClientSecretPath + ",PUT " + netHostSecretPath
+	if strings.Join(updates, ",") != expected {
+		t.Fatalf("Expected both secrets to be rotated, but was: %#v", updates)
+	}
+}
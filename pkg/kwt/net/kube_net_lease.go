@@ -0,0 +1,287 @@
+package net
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// Vendored client-go predates coordination.k8s.io API group
+	// hence leases are accessed via core REST client's absolute paths
+	leaseAPIPath = "/apis/coordination.k8s.io/v1"
+
+	netLeaseDuration      = 2 * time.Minute
+	netLeaseRenewInterval = 30 * time.Second
+)
+
+type kubeLease struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec kubeLeaseSpec `json:"spec,omitempty"`
+}
+
+type kubeLeaseSpec struct {
+	HolderIdentity       *string           `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds *int32            `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          *metav1.MicroTime `json:"acquireTime,omitempty"`
+	RenewTime            *metav1.MicroTime `json:"renewTime,omitempty"`
+}
+
+type kubeLeaseList struct {
+	Items []kubeLease `json:"items"`
+}
+
+func (l kubeLease) Expired(now time.Time) bool {
+	if l.Spec.RenewTime == nil || l.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	expiresAt := l.Spec.RenewTime.Add(time.Duration(*l.Spec.LeaseDurationSeconds) * time.Second)
+	return now.After(expiresAt)
+}
+
+// KubeNetLease is renewed while net resources are in use
+// so that abandoned ones can be garbage collected
+type KubeNetLease struct {
+	coreClient kubernetes.Interface
+	namespace  string
+	name       string
+	owner      KubeNetOwner
+
+	logTag string
+	logger Logger
+}
+
+func NewKubeNetLease(coreClient kubernetes.Interface, namespace string, owner KubeNetOwner, logger Logger) KubeNetLease {
+	return KubeNetLease{
+		coreClient: coreClient,
+		namespace:  namespace,
+		name:       owner.ResourceName(),
+		owner:      owner,
+
+		logTag: "KubeNetLease",
+		logger: logger,
+	}
+}
+
+// Renew creates lease if it does not exist. It retries if lease
+// was concurrently created or renewed (eg by another session).
+func (l KubeNetLease) Renew() error {
+	var err error
+
+	for i := 0; i < 3; i++ {
+		err = l.renew()
+
+		leaseErr, ok := err.(kubeNetLeaseErr)
+		if !ok || !(errors.IsConflict(leaseErr.err) || errors.IsAlreadyExists(leaseErr.err)) {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (l KubeNetLease) renew() error {
+	lease, err := l.get()
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return kubeNetLeaseErr{"Getting net lease", err}
+		}
+		return l.create()
+	}
+
+	now := metav1.NowMicro()
+	durationSecs := int32(netLeaseDuration.Seconds())
+
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != l.owner.Identity || lease.Expired(now.Time) {
+		lease.Spec.AcquireTime = &now
+	}
+
+	lease.Spec.HolderIdentity = &l.owner.Identity
+	lease.Spec.LeaseDurationSeconds = &durationSecs
+	lease.Spec.RenewTime = &now
+
+	body, err := json.Marshal(lease)
+	if err != nil {
+		return fmt.Errorf("Marshaling net lease: %s", err)
+	}
+
+	err = l.coreClient.CoreV1().RESTClient().Put().
+		AbsPath(leaseAPIPath, "namespaces", l.namespace, "leases", l.name).
+		Body(body).Do().Error()
+	if err != nil {
+		return kubeNetLeaseErr{"Renewing net lease", err}
+	}
+
+	return nil
+}
+
+// Expired indicates that lease is not renewed by anyone (missing lease is considered expired)
+func (l KubeNetLease) Expired() (bool, error) {
+	lease, err := l.get()
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, kubeNetLeaseErr{"Getting net lease", err}
+	}
+
+	return lease.Expired(time.Now()), nil
+}
+
+// KeepRenewed renews lease periodically until doneCh is closed
+func (l KubeNetLease) KeepRenewed(doneCh chan struct{}) {
+	for {
+		select {
+		case <-doneCh:
+			return
+		case <-time.After(netLeaseRenewInterval):
+			err := l.Renew()
+			if err != nil {
+				l.logger.Error(l.logTag, "Failed renewing networking lease '%s' in namespace '%s': %s", l.name, l.namespace, err)
+			}
+		}
+	}
+}
+
+func (l KubeNetLease) Delete() error {
+	err := l.coreClient.CoreV1().RESTClient().Delete().
+		AbsPath(leaseAPIPath, "namespaces", l.namespace, "leases", l.name).Do().Error()
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return kubeNetLeaseErr{"Deleting net lease", err}
+		}
+	}
+	return nil
+}
+
+func (l KubeNetLease) create() error {
+	now := metav1.NowMicro()
+	durationSecs := int32(netLeaseDuration.Seconds())
+
+	lease := kubeLease{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        l.name,
+			Namespace:   l.namespace,
+			Labels:      l.owner.Labels(),
+			Annotations: l.owner.Annotations(),
+		},
+		Spec: kubeLeaseSpec{
+			HolderIdentity:       &l.owner.Identity,
+			LeaseDurationSeconds: &durationSecs,
+			AcquireTime:          &now,
+			RenewTime:            &now,
+		},
+	}
+
+	body, err := json.Marshal(lease)
+	if err != nil {
+		return fmt.Errorf("Marshaling net lease: %s", err)
+	}
+
+	err = l.coreClient.CoreV1().RESTClient().Post().
+		AbsPath(leaseAPIPath, "namespaces", l.namespace, "leases").
+		Body(body).Do().Error()
+	if err != nil {
+		return kubeNetLeaseErr{"Creating net lease", err}
+	}
+
+	return nil
+}
+
+func (l KubeNetLease) get() (kubeLease, error) {
+	body, err := l.coreClient.CoreV1().RESTClient().Get().
+		AbsPath(leaseAPIPath, "namespaces", l.namespace, "leases", l.name).Do().Raw()
+	if err != nil {
+		return kubeLease{}, err
+	}
+
+	var lease kubeLease
+
+	err = json.Unmarshal(body, &lease)
+	if err != nil {
+		return kubeLease{}, fmt.Errorf("Unmarshaling net lease: %s", err)
+	}
+
+	return lease, nil
+}
+
+func listKubeNetLeases(coreClient kubernetes.Interface, namespace string) ([]kubeLease, error) {
+	body, err := coreClient.CoreV1().RESTClient().Get().
+		AbsPath(leaseAPIPath, "namespaces", namespace, "leases").
+		Param("labelSelector", netOwnerLabelKey).Do().Raw()
+	if err != nil {
+		return nil, fmt.Errorf("Listing net leases: %s", err)
+	}
+
+	var list kubeLeaseList
+
+	err = json.Unmarshal(body, &list)
+	if err != nil {
+		return nil, fmt.Errorf("Unmarshaling net leases: %s", err)
+	}
+
+	return list.Items, nil
+}
+
+// kubeNetLeaseErr keeps API error so that callers can tell
+// if leases cannot be used at all (see leasesUnavailable)
+type kubeNetLeaseErr struct {
+	desc string
+	err  error
+}
+
+func (e kubeNetLeaseErr) Error() string { return e.desc + ": " + e.err.Error() }
+
+// leasesUnavailable indicates that leases are forbidden by RBAC
+// or coordination.k8s.io API is not served by the cluster
+func leasesUnavailable(err error) bool {
+	leaseErr, ok := err.(kubeNetLeaseErr)
+	return ok && (errors.IsForbidden(leaseErr.err) || errors.IsNotFound(leaseErr.err))
+}
+
+// kubeNetLeaseKeeper keeps lease renewed once per entry point
+// while at least one of its sessions is open
+type kubeNetLeaseKeeper struct {
+	sessions int
+	doneCh   chan struct{}
+	lock     sync.Mutex
+}
+
+// Acquire returns function that should be called once session is closed
+func (k *kubeNetLeaseKeeper) Acquire(lease KubeNetLease) func() {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if k.sessions == 0 {
+		k.doneCh = make(chan struct{})
+		go lease.KeepRenewed(k.doneCh)
+	}
+
+	k.sessions++
+
+	var releaseOnce sync.Once
+
+	return func() { releaseOnce.Do(k.release) }
+}
+
+func (k *kubeNetLeaseKeeper) release() {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	k.sessions--
+
+	if k.sessions == 0 {
+		close(k.doneCh)
+	}
+}
@@ -0,0 +1,292 @@
+package net_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/kubetest"
+	"github.com/carvel-dev/kwt/pkg/kwt/logtest"
+	. "github.com/carvel-dev/kwt/pkg/kwt/net"
+)
+
+func TestKubeNetLease(t *testing.T) {
+	api, coreClient, closeFunc := kubetest.NewFakeAPI(t)
+	defer closeFunc()
+
+	owner, err := NewKubeNetOwner("dk", "dk@laptop")
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	lease := NewKubeNetLease(coreClient, "ns", owner, logtest.NoopLogger{})
+
+	const leasePath = "/apis/coordination.k8s.io/v1/namespaces/ns/leases/kwt-net-dk"
+
+	err = lease.Renew()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	created := api.Get(leasePath)
+	if created == nil {
+		t.Fatalf("Expected lease to be created: %#v", api.Paths(""))
+	}
+
+	spec := created["spec"].(map[string]interface{})
+	labels := created["metadata"].(map[string]interface{})["labels"].(map[string]interface{})
+
+	if spec["holderIdentity"] != "dk@laptop" || spec["leaseDurationSeconds"] != float64(120) || labels["kwt.cppforlife.com/net-owner"] != "dk" {
+		t.Fatalf("Expected lease to be owned: %#v", created)
+	}
+
+	firstRenewTime := spec["renewTime"]
+
+	time.Sleep(10 * time.Millisecond)
+
+	err = lease.Renew()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	spec = api.Get(leasePath)["spec"].(map[string]interface{})
+
+	if spec["renewTime"] == firstRenewTime || spec["acquireTime"] != firstRenewTime {
+		t.Fatalf("Expected lease to be renewed without being reacquired: %#v", spec)
+	}
+
+	err = lease.Delete()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	err = lease.Delete()
+	if err != nil {
+		t.Fatalf("Expected deleting missing lease to succeed: %s", err)
+	}
+}
+
+func TestKubeNetLeaseRetriesConflicts(t *testing.T) {
+	api, coreClient, closeFunc := kubetest.NewFakeAPI(t)
+	defer closeFunc()
+
+	owner, err := NewKubeNetOwner("dk", "dk@laptop")
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	lease := NewKubeNetLease(coreClient, "ns", owner, logtest.NoopLogger{})
+
+	err = lease.Renew()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	conflicts := 1
+
+	// Lease is concurrently renewed by another session
+	api.ErrFunc = func(method, path string) int {
+		if method == "PUT" && conflicts > 0 {
+			conflicts--
+			return http.StatusConflict
+		}
+		return 0
+	}
+
+	api.Requests()
+
+	err = lease.Renew()
+	if err != nil {
+		t.Fatalf("Expected conflict to be retried: %s", err)
+	}
+
+	var puts int
+
+	for _, req := range api.Requests() {
+		if strings.HasPrefix(req, "PUT ") {
+			puts++
+		}
+	}
+
+	if puts != 2 {
+		t.Fatalf("Expected lease to be renewed after conflict, but was %d renewals", puts)
+	}
+
+	api.ErrFunc = func(method, path string) int {
+		if method == "PUT" {
+			return http.StatusConflict
+		}
+		return 0
+	}
+
+	err = lease.Renew()
+	if err == nil || !strings.Contains(err.Error(), "Renewing net lease") {
+		t.Fatalf("Expected continuous conflicts to fail renewal: %v", err)
+	}
+}
+
+func TestKubeEntryPointWithoutLease(t *testing.T) {
+	api, coreClient, closeFunc := kubetest.NewFakeAPI(t)
+	defer closeFunc()
+
+	defer fillNetReplicaEndpoints(api)()
+
+	api.Set("/api/v1/namespaces/ns/pods/kwt-net-dk-1", fakeNetReplica("kwt-net-dk-1"))
+	api.Set("/api/v1/namespaces/ns/endpoints/kwt-net-dk-pods", fakeEndpoints(
+		"kwt-net-dk-pods", []string{"kwt-net-dk-1"}, nil))
+
+	for _, code := range []int{http.StatusForbidden, http.StatusNotFound} {
+		// Leases are forbidden by RBAC or not served by older clusters
+		api.ErrFunc = func(method, path string) int {
+			if strings.HasPrefix(path, "/apis/coordination.k8s.io/") {
+				return code
+			}
+			return 0
+		}
+
+		entryPoint := newNetDeploymentEntryPoint(t, coreClient, KubeNetPodOpts{Replicas: 1}, "sshd:1")
+
+		sess, err := entryPoint.EntryPoint()
+		if err != nil {
+			t.Fatalf("Expected to continue without lease (%d): %s", code, err)
+		}
+
+		sess.Close()
+
+		err = entryPoint.Delete()
+		if err != nil {
+			t.Fatalf("Expected to delete without lease (%d): %s", code, err)
+		}
+	}
+
+	api.ErrFunc = func(method, path string) int {
+		if strings.HasPrefix(path, "/apis/coordination.k8s.io/") {
+			return http.StatusConflict
+		}
+		return 0
+	}
+
+	_, err := newNetDeploymentEntryPoint(t, coreClient, KubeNetPodOpts{Replicas: 1}, "sshd:1").EntryPoint()
+	if err == nil || !strings.Contains(err.Error(), "Getting net lease") {
+		t.Fatalf("Expected other lease errors to fail: %v", err)
+	}
+}
+
+func TestKubeNetGC(t *testing.T) {
+	api, coreClient, closeFunc := kubetest.NewFakeAPI(t)
+	defer closeFunc()
+
+	lease := func(name, owner string, renewTime time.Time) map[string]interface{} {
+		return map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"name":   name,
+				"labels": map[string]interface{}{"kwt.cppforlife.com/net-owner": owner},
+			},
+			"spec": map[string]interface{}{
+				"leaseDurationSeconds": 120,
+				"renewTime":            renewTime.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+			},
+		}
+	}
+
+	secret := func(name, owner string) map[string]interface{} {
+		return map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"name":   name,
+				"labels": map[string]interface{}{"kwt.cppforlife.com/net-owner": owner},
+			},
+		}
+	}
+
+	api.Set("/apis/coordination.k8s.io/v1/namespaces/ns/leases/kwt-net-gone", lease("kwt-net-gone", "gone", time.Now().Add(-time.Hour)))
+	api.Set("/apis/coordination.k8s.io/v1/namespaces/ns/leases/kwt-net-live", lease("kwt-net-live", "live", time.Now()))
+	api.Set("/api/v1/namespaces/ns/secrets/kwt-net-gone-ssh-key", secret("kwt-net-gone-ssh-key", "gone"))
+	api.Set("/api/v1/namespaces/ns/secrets/kwt-net-live-ssh-key", secret("kwt-net-live-ssh-key", "live"))
+	api.Set("/apis/policy/v1/namespaces/ns/poddisruptionbudgets/kwt-net-gone", secret("kwt-net-gone", "gone"))
+	api.Set("/apis/policy/v1/namespaces/ns/poddisruptionbudgets/kwt-net-live", secret("kwt-net-live", "live"))
+
+	deleted, err := NewKubeNetGC(coreClient, "ns", logtest.NoopLogger{}).DeleteStale()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	expected := "pod disruption budget 'kwt-net-gone',secret 'kwt-net-gone-ssh-key',lease 'kwt-net-gone'"
+	if strings.Join(deleted, ",") != expected {
+		t.Fatalf("Expected deleted to be '%s', but was '%s'", expected, strings.Join(deleted, ","))
+	}
+
+	if !api.Has("/apis/coordination.k8s.io/v1/namespaces/ns/leases/kwt-net-live") {
+		t.Fatalf("Expected live lease to be kept")
+	}
+
+	if !api.Has("/api/v1/namespaces/ns/secrets/kwt-net-live-ssh-key") {
+		t.Fatalf("Expected live owner's secret to be kept")
+	}
+
+	if !api.Has("/apis/policy/v1/namespaces/ns/poddisruptionbudgets/kwt-net-live") {
+		t.Fatalf("Expected live owner's pod disruption budget to be kept")
+	}
+}
+
+func TestKubeNetGCDeleteLegacy(t *testing.T) {
+	api, coreClient, closeFunc := kubetest.NewFakeAPI(t)
+	defer closeFunc()
+
+	obj := func(name string, labels map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"metadata": map[string]interface{}{"name": name, "labels": labels}}
+	}
+
+	api.Set("/api/v1/namespaces/ns/pods/kwt-net", obj("kwt-net", nil))
+	api.Set("/api/v1/namespaces/ns/secrets/kwt-net-ssh-key", obj("kwt-net-ssh-key", nil))
+	api.Set("/api/v1/namespaces/ns/secrets/kwt-net-host-key", obj("kwt-net-host-key", nil))
+	// Owner named 'pods' uses same service name as legacy headless service
+	api.Set("/api/v1/namespaces/ns/services/kwt-net-pods", obj(
+		"kwt-net-pods", map[string]interface{}{"kwt.cppforlife.com/net-owner": "pods"}))
+	api.Set("/api/v1/namespaces/ns/secrets/kwt-net-dk-ssh-key", obj(
+		"kwt-net-dk-ssh-key", map[string]interface{}{"kwt.cppforlife.com/net-owner": "dk"}))
+
+	deleted, err := NewKubeNetGC(coreClient, "ns", logtest.NoopLogger{}).DeleteLegacy()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	expected := "pod 'kwt-net',secret 'kwt-net-ssh-key',secret 'kwt-net-host-key'"
+	if strings.Join(deleted, ",") != expected {
+		t.Fatalf("Expected deleted to be '%s', but was '%s'", expected, strings.Join(deleted, ","))
+	}
+
+	for _, path := range []string{"/api/v1/namespaces/ns/services/kwt-net-pods", "/api/v1/namespaces/ns/secrets/kwt-net-dk-ssh-key"} {
+		if !api.Has(path) {
+			t.Fatalf("Expected owned resource '%s' to be kept", path)
+		}
+	}
+}
+
+func TestNewKubeNetOwner(t *testing.T) {
+	valid := map[string]string{
+		"dk":                    "dk",
+		"John.Smith":            "john-smith",
+		`CORP\jsmith`:           "corp-jsmith",
+		"_svc_":                 "svc",
+		strings.Repeat("a", 50): strings.Repeat("a", 40),
+	}
+
+	for in, out := range valid {
+		owner, err := NewKubeNetOwner(in, "")
+		if err != nil {
+			t.Fatalf("Expected owner '%s' to be valid: %s", in, err)
+		}
+		if owner.Name != out || owner.Identity != in {
+			t.Fatalf("Expected owner '%s' name to be '%s', but was %#v", in, out, owner)
+		}
+		if owner.ResourceName("pods") != "kwt-net-"+out+"-pods" {
+			t.Fatalf("Expected owner '%s' resource name to include owner name: %s", in, owner.ResourceName("pods"))
+		}
+	}
+
+	_, err := NewKubeNetOwner("...", "")
+	if err == nil {
+		t.Fatalf("Expected owner without letters or digits to be invalid")
+	}
+}
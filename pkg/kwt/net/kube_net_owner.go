@@ -0,0 +1,69 @@
+package net
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	netOwnerLabelKey       = "kwt.cppforlife.com/net-owner"
+	netOwnerIdentityAnnKey = "kwt.cppforlife.com/net-owner-identity"
+	netOwnerNameMaxLen     = 40 // leaves room for prefix and suffixes within 63 chars
+)
+
+var (
+	netOwnerNameInvalidChars = regexp.MustCompile("[^a-z0-9-]+")
+)
+
+// KubeNetOwner identifies who net resources belong to so that
+// multiple users can share a namespace without affecting each other
+type KubeNetOwner struct {
+	// Name is used in resource names and labels
+	Name string
+	// Identity is a free form description (eg user@host)
+	Identity string
+}
+
+// NewKubeNetOwner makes name safe to be used in resource names and labels
+func NewKubeNetOwner(name, identity string) (KubeNetOwner, error) {
+	safeName := netOwnerNameInvalidChars.ReplaceAllString(strings.ToLower(name), "-")
+	safeName = strings.Trim(safeName, "-")
+
+	if len(safeName) > netOwnerNameMaxLen {
+		safeName = strings.Trim(safeName[:netOwnerNameMaxLen], "-")
+	}
+
+	if len(safeName) == 0 {
+		return KubeNetOwner{}, fmt.Errorf("Expected net owner name '%s' to include at least one letter or digit", name)
+	}
+
+	if len(identity) == 0 {
+		identity = name
+	}
+
+	return KubeNetOwner{Name: safeName, Identity: identity}, nil
+}
+
+// ResourceName returns name of net resource owned by this owner
+func (o KubeNetOwner) ResourceName(suffixes ...string) string {
+	pieces := []string{"kwt-net"}
+	if len(o.Name) > 0 {
+		pieces = append(pieces, o.Name)
+	}
+	return strings.Join(append(pieces, suffixes...), "-")
+}
+
+func (o KubeNetOwner) Labels() map[string]string {
+	if len(o.Name) == 0 {
+		return map[string]string{}
+	}
+	return map[string]string{netOwnerLabelKey: o.Name}
+}
+
+func (o KubeNetOwner) Annotations() map[string]string {
+	if len(o.Identity) == 0 {
+		return map[string]string{}
+	}
+	return map[string]string{netOwnerIdentityAnnKey: o.Identity}
+}
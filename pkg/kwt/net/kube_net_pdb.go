@@ -0,0 +1,123 @@
+package net
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// Vendored client-go only includes policy/v1beta1 API
+	// which is no longer served by recent clusters
+	pdbAPIPath = "/apis/policy/v1"
+)
+
+type kubePDB struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec kubePDBSpec `json:"spec,omitempty"`
+}
+
+type kubePDBSpec struct {
+	MinAvailable *intstr.IntOrString   `json:"minAvailable,omitempty"`
+	Selector     *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+type kubePDBList struct {
+	Items []kubePDB `json:"items"`
+}
+
+// reconcileNetPDB keeps at least one replica running during voluntary
+// disruptions (eg node drains) if there are several replicas. Budget is
+// optional hence it's skipped if it cannot be managed (eg due to RBAC).
+func (f KubeEntryPoint) reconcileNetPDB() error {
+	if f.podOpts.Replicas < 2 {
+		// Budget would block node drains with a single replica
+		return f.deleteNetPDB()
+	}
+
+	err := f.coreClient.CoreV1().RESTClient().Get().
+		AbsPath(pdbAPIPath, "namespaces", f.namespace, "poddisruptionbudgets", f.deploymentName).Do().Error()
+	if err == nil {
+		return nil
+	}
+
+	if !errors.IsNotFound(err) {
+		return f.skipNetPDB("Getting", err)
+	}
+
+	minAvailable := intstr.FromInt(1)
+
+	pdb := kubePDB{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "policy/v1",
+			Kind:       "PodDisruptionBudget",
+		},
+		ObjectMeta: f.objectMeta(f.deploymentName, map[string]string{netDeploymentSelectorKey: f.deploymentName}),
+		Spec: kubePDBSpec{
+			MinAvailable: &minAvailable,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{netDeploymentSelectorKey: f.deploymentName},
+			},
+		},
+	}
+
+	body, err := json.Marshal(pdb)
+	if err != nil {
+		return fmt.Errorf("Marshaling net pod disruption budget: %s", err)
+	}
+
+	err = f.coreClient.CoreV1().RESTClient().Post().
+		AbsPath(pdbAPIPath, "namespaces", f.namespace, "poddisruptionbudgets").
+		Body(body).Do().Error()
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return f.skipNetPDB("Creating", err)
+	}
+
+	return nil
+}
+
+func (f KubeEntryPoint) skipNetPDB(action string, err error) error {
+	if errors.IsForbidden(err) || errors.IsNotFound(err) {
+		f.logger.Error(f.logTag, "Continuing without pod disruption budget for networking deployment '%s' "+
+			"(%s it failed: %s)", f.deploymentName, strings.ToLower(action), err)
+		return nil
+	}
+	return fmt.Errorf("%s net pod disruption budget: %s", action, err)
+}
+
+func (f KubeEntryPoint) deleteNetPDB() error {
+	err := f.coreClient.CoreV1().RESTClient().Delete().
+		AbsPath(pdbAPIPath, "namespaces", f.namespace, "poddisruptionbudgets", f.deploymentName).Do().Error()
+	if err != nil {
+		// Budget may have not been created due to lack of permissions
+		if !errors.IsNotFound(err) && !errors.IsForbidden(err) {
+			return fmt.Errorf("Deleting net pod disruption budget: %s", err)
+		}
+	}
+	return nil
+}
+
+func listKubeNetPDBs(coreClient kubernetes.Interface, namespace string, listOpts metav1.ListOptions) ([]kubePDB, error) {
+	body, err := coreClient.CoreV1().RESTClient().Get().
+		AbsPath(pdbAPIPath, "namespaces", namespace, "poddisruptionbudgets").
+		Param("labelSelector", listOpts.LabelSelector).Do().Raw()
+	if err != nil {
+		return nil, err
+	}
+
+	var list kubePDBList
+
+	err = json.Unmarshal(body, &list)
+	if err != nil {
+		return nil, fmt.Errorf("Unmarshaling net pod disruption budgets: %s", err)
+	}
+
+	return list.Items, nil
+}
@@ -0,0 +1,254 @@
+package net
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// KubeNetPodOpts customizes generated net pod, eg to satisfy
+// admission policies or to schedule it onto tainted nodes
+type KubeNetPodOpts struct {
+	Tolerations      []corev1.Toleration
+	NodeSelector     map[string]string
+	ServiceAccount   string
+	ImagePullSecrets []string
+
+	// Template is a pod merged over generated pod (see MergePodTemplate)
+	Template map[string]interface{}
+
+	// Replicas of net pod managed by a deployment; bare pod is used if 0
+	Replicas int
+}
+
+// Apply returns modified copy of given pod
+func (o KubeNetPodOpts) Apply(pod *corev1.Pod) (*corev1.Pod, error) {
+	pod = pod.DeepCopy()
+
+	pod.Spec.Tolerations = append(pod.Spec.Tolerations, o.Tolerations...)
+
+	if len(o.NodeSelector) > 0 && pod.Spec.NodeSelector == nil {
+		pod.Spec.NodeSelector = map[string]string{}
+	}
+	for k, v := range o.NodeSelector {
+		pod.Spec.NodeSelector[k] = v
+	}
+
+	if len(o.ServiceAccount) > 0 {
+		pod.Spec.ServiceAccountName = o.ServiceAccount
+	}
+
+	for _, name := range o.ImagePullSecrets {
+		pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: name})
+	}
+
+	if o.Template == nil {
+		return pod, nil
+	}
+
+	mergedPod, err := MergePodTemplate(pod, o.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	// Net pod is looked up by its name and selected by its label
+	mergedPod.Name = pod.Name
+	mergedPod.Namespace = pod.Namespace
+
+	if mergedPod.Labels == nil {
+		mergedPod.Labels = map[string]string{}
+	}
+	for k, v := range pod.Labels {
+		mergedPod.Labels[k] = v
+	}
+
+	return mergedPod, nil
+}
+
+// NewToleration parses 'key[=value][:effect]' similarly to 'kubectl taint';
+// value-less toleration matches any value and '*' tolerates all taints
+func NewToleration(val string) (corev1.Toleration, error) {
+	var result corev1.Toleration
+
+	keyVal := val
+
+	if idx := strings.LastIndex(val, ":"); idx >= 0 {
+		keyVal = val[:idx]
+		result.Effect = corev1.TaintEffect(val[idx+1:])
+
+		switch result.Effect {
+		case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		default:
+			return corev1.Toleration{}, fmt.Errorf("Expected toleration '%s' effect to be "+
+				"NoSchedule, PreferNoSchedule or NoExecute", val)
+		}
+	}
+
+	pieces := strings.SplitN(keyVal, "=", 2)
+
+	result.Key = pieces[0]
+	result.Operator = corev1.TolerationOpExists
+
+	if len(pieces) == 2 {
+		result.Value = pieces[1]
+		result.Operator = corev1.TolerationOpEqual
+	}
+
+	if result.Key == "*" && len(pieces) == 1 {
+		result.Key = ""
+	} else if len(result.Key) == 0 {
+		return corev1.Toleration{}, fmt.Errorf("Expected toleration '%s' to have format 'key[=value][:effect]'", val)
+	}
+
+	return result, nil
+}
+
+func NewTolerations(vals []string) ([]corev1.Toleration, error) {
+	var result []corev1.Toleration
+
+	for _, val := range vals {
+		toleration, err := NewToleration(val)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, toleration)
+	}
+
+	return result, nil
+}
+
+// NewNodeSelector parses 'key=value' pieces
+func NewNodeSelector(vals []string) (map[string]string, error) {
+	result := map[string]string{}
+
+	for _, val := range vals {
+		pieces := strings.SplitN(val, "=", 2)
+		if len(pieces) != 2 || len(pieces[0]) == 0 {
+			return nil, fmt.Errorf("Expected node selector '%s' to have format 'key=value'", val)
+		}
+		result[pieces[0]] = pieces[1]
+	}
+
+	return result, nil
+}
+
+// NewPodTemplate parses pod YAML and makes sure it can be merged
+func NewPodTemplate(templateYAML []byte) (map[string]interface{}, error) {
+	var result map[string]interface{}
+
+	err := yaml.Unmarshal(templateYAML, &result)
+	if err != nil {
+		return nil, fmt.Errorf("Parsing pod template: %s", err)
+	}
+
+	if kind, found := result["kind"]; found && kind != "Pod" {
+		return nil, fmt.Errorf("Expected pod template kind to be 'Pod', but was '%v'", kind)
+	}
+
+	_, err = MergePodTemplate(&corev1.Pod{}, result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// MergePodTemplate merges template over pod: objects are merged recursively and
+// other values replaced, except that containers, init containers, volumes,
+// volume mounts and env variables are merged by name (similar to strategic merge)
+func MergePodTemplate(pod *corev1.Pod, template map[string]interface{}) (*corev1.Pod, error) {
+	podBytes, err := json.Marshal(pod)
+	if err != nil {
+		return nil, fmt.Errorf("Marshaling pod: %s", err)
+	}
+
+	var podMap map[string]interface{}
+
+	err = json.Unmarshal(podBytes, &podMap)
+	if err != nil {
+		return nil, fmt.Errorf("Unmarshaling pod: %s", err)
+	}
+
+	mergedBytes, err := json.Marshal(mergePodTemplateValue(podMap, template, ""))
+	if err != nil {
+		return nil, fmt.Errorf("Marshaling merged pod: %s", err)
+	}
+
+	var result corev1.Pod
+
+	err = json.Unmarshal(mergedBytes, &result)
+	if err != nil {
+		return nil, fmt.Errorf("Merging pod template: %s", err)
+	}
+
+	return &result, nil
+}
+
+var podTemplateNamedLists = map[string]bool{
+	"containers":     true,
+	"initContainers": true,
+	"volumes":        true,
+	"volumeMounts":   true,
+	"env":            true,
+}
+
+func mergePodTemplateValue(dst, src interface{}, key string) interface{} {
+	switch typedSrc := src.(type) {
+	case map[string]interface{}:
+		typedDst, ok := dst.(map[string]interface{})
+		if !ok {
+			return src
+		}
+		for k, v := range typedSrc {
+			typedDst[k] = mergePodTemplateValue(typedDst[k], v, k)
+		}
+		return typedDst
+
+	case []interface{}:
+		typedDst, ok := dst.([]interface{})
+		if !ok || !podTemplateNamedLists[key] {
+			return src
+		}
+		for _, srcItem := range typedSrc {
+			idx := podTemplateNamedIndex(typedDst, srcItem)
+			if idx >= 0 {
+				typedDst[idx] = mergePodTemplateValue(typedDst[idx], srcItem, "")
+			} else {
+				typedDst = append(typedDst, srcItem)
+			}
+		}
+		return typedDst
+
+	default:
+		return src
+	}
+}
+
+func podTemplateNamedIndex(items []interface{}, item interface{}) int {
+	name, ok := podTemplateItemName(item)
+	if !ok {
+		return -1
+	}
+	for i, existing := range items {
+		if existingName, ok := podTemplateItemName(existing); ok && existingName == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func podTemplateItemName(item interface{}) (string, bool) {
+	typedItem, ok := item.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	// Volume mounts are identified by path
+	if path, ok := typedItem["mountPath"].(string); ok {
+		return path, true
+	}
+	name, ok := typedItem["name"].(string)
+	return name, ok
+}
@@ -0,0 +1,121 @@
+package net_test
+
+import (
+	"testing"
+
+	. "github.com/carvel-dev/kwt/pkg/kwt/net"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewToleration(t *testing.T) {
+	valid := map[string]corev1.Toleration{
+		"dedicated=kwt:NoSchedule": {Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "kwt", Effect: corev1.TaintEffectNoSchedule},
+		"dedicated:NoExecute":      {Key: "dedicated", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute},
+		"dedicated=kwt":            {Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "kwt"},
+		"*":                        {Operator: corev1.TolerationOpExists},
+	}
+
+	for in, out := range valid {
+		toleration, err := NewToleration(in)
+		if err != nil {
+			t.Fatalf("Expected toleration '%s' to be valid: %s", in, err)
+		}
+		if toleration != out {
+			t.Fatalf("Expected toleration '%s' to be %#v, but was %#v", in, out, toleration)
+		}
+	}
+
+	for _, in := range []string{"dedicated=kwt:Never", "=kwt", ":NoSchedule"} {
+		_, err := NewToleration(in)
+		if err == nil {
+			t.Fatalf("Expected toleration '%s' to be invalid", in)
+		}
+	}
+}
+
+func TestKubeNetPodOptsApply(t *testing.T) {
+	template, err := NewPodTemplate([]byte(`
+metadata:
+  name: other-name
+  labels:
+    team: infra
+spec:
+  securityContext:
+    runAsNonRoot: true
+  containers:
+  - name: kwt-net
+    resources:
+      limits:
+        memory: 64Mi
+    env:
+    - name: EXTRA
+      value: "1"
+  volumes:
+  - name: tmp
+    emptyDir: {}
+`))
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	opts := KubeNetPodOpts{
+		Tolerations:      []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpExists}},
+		NodeSelector:     map[string]string{"pool": "tools"},
+		ServiceAccount:   "kwt",
+		ImagePullSecrets: []string{"regcred"},
+		Template:         template,
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kwt-net",
+			Namespace: "default",
+			Labels:    map[string]string{"kwt.cppforlife.com/net": "true"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:  "kwt-net",
+				Image: "kwt-image",
+				Env:   []corev1.EnvVar{{Name: "KWT_CLIENT_PUB_KEY", Value: "key"}},
+			}},
+		},
+	}
+
+	result, err := opts.Apply(pod)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	if result.Name != "kwt-net" || result.Labels["kwt.cppforlife.com/net"] != "true" || result.Labels["team"] != "infra" {
+		t.Fatalf("Expected pod metadata to be preserved and merged: %#v", result.ObjectMeta)
+	}
+
+	if len(result.Spec.Tolerations) != 1 || result.Spec.NodeSelector["pool"] != "tools" ||
+		result.Spec.ServiceAccountName != "kwt" || result.Spec.ImagePullSecrets[0].Name != "regcred" {
+		t.Fatalf("Expected pod spec to include overrides: %#v", result.Spec)
+	}
+
+	if result.Spec.SecurityContext == nil || result.Spec.SecurityContext.RunAsNonRoot == nil || len(result.Spec.Volumes) != 1 {
+		t.Fatalf("Expected pod spec to include template: %#v", result.Spec)
+	}
+
+	if len(result.Spec.Containers) != 1 {
+		t.Fatalf("Expected containers to be merged by name: %#v", result.Spec.Containers)
+	}
+
+	container := result.Spec.Containers[0]
+
+	if container.Image != "kwt-image" || len(container.Env) != 2 || container.Resources.Limits.Memory().String() != "64Mi" {
+		t.Fatalf("Expected container to be merged with template: %#v", container)
+	}
+
+	if len(pod.Spec.Tolerations) != 0 {
+		t.Fatalf("Expected original pod to not be modified")
+	}
+
+	_, err = NewPodTemplate([]byte("kind: Deployment"))
+	if err == nil {
+		t.Fatalf("Expected non-pod template to be invalid")
+	}
+}
@@ -0,0 +1,94 @@
+package net
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/kubedns"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/kubeips"
+)
+
+const (
+	kubeServiceHostResolverTTL = 10 * time.Second
+)
+
+// KubeServiceHostResolver resolves service names (eg 'svc.ns', 'svc.ns.svc.cluster.local')
+// to cluster IP and IPs of backing pods; other hosts are resolved via system resolver.
+// Results are cached briefly since it's consulted for each new connection.
+type KubeServiceHostResolver struct {
+	kubeIPs *kubeips.Resolver
+
+	cache     map[string]kubeServiceHostIPs
+	cacheLock sync.Mutex
+}
+
+var _ HostResolver = &KubeServiceHostResolver{}
+
+type kubeServiceHostIPs struct {
+	ips        []net.IP
+	resolvedAt time.Time
+}
+
+func NewKubeServiceHostResolver(kubeIPs *kubeips.Resolver) *KubeServiceHostResolver {
+	return &KubeServiceHostResolver{kubeIPs: kubeIPs, cache: map[string]kubeServiceHostIPs{}}
+}
+
+func (r *KubeServiceHostResolver) LookupIP(host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	if namespace, name, ok := r.serviceName(host); ok {
+		return r.kubeIPs.ServiceIPs(namespace, name) // cached by shared resolver
+	}
+
+	r.cacheLock.Lock()
+	cached, found := r.cache[host]
+	r.cacheLock.Unlock()
+
+	if found && time.Since(cached.resolvedAt) < kubeServiceHostResolverTTL {
+		return cached.ips, nil
+	}
+
+	ips, err := r.systemIPs(host)
+	if err != nil {
+		return nil, err
+	}
+
+	r.cacheLock.Lock()
+	r.cache[host] = kubeServiceHostIPs{ips, time.Now()}
+	r.cacheLock.Unlock()
+
+	return ips, nil
+}
+
+func (*KubeServiceHostResolver) serviceName(host string) (string, string, bool) {
+	host = strings.TrimSuffix(host, ".")
+	host = strings.TrimSuffix(host, "."+kubedns.DefaultClusterDomain)
+	host = strings.TrimSuffix(host, ".svc")
+
+	pieces := strings.Split(host, ".")
+	if len(pieces) != 2 {
+		return "", "", false
+	}
+
+	return pieces[1], pieces[0], true
+}
+
+func (*KubeServiceHostResolver) systemIPs(host string) ([]net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(context.Background(), host)
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+
+	return ips, nil
+}
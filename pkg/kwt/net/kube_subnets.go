@@ -4,95 +4,210 @@ import (
 	"net"
 	"time"
 
+	"github.com/carvel-dev/kwt/pkg/kwt/net/kubeips"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
 )
 
+var (
+	podsResource     = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	servicesResource = schema.GroupVersionResource{Version: "v1", Resource: "services"}
+)
+
+// KubeSubnets uses unstructured objects since vendored API types
+// predate dual-stack pods and services (see kubeips.PodStatusIPs)
 type KubeSubnets struct {
-	coreClient          kubernetes.Interface
-	additionalRemoteIPs []string
+	dynamicClient dynamic.Interface
+	opts          KubeSubnetsOpts
+
+	// Avoid recomputing subnets for every pod in a rollout
+	watchDebounce time.Duration
 
 	logTag string
 	logger Logger
 }
 
-func NewKubeSubnets(coreClient kubernetes.Interface, additionalRemoteIPs []string, logger Logger) KubeSubnets {
-	return KubeSubnets{coreClient, additionalRemoteIPs, "KubeSubnets", logger}
+var _ Subnets = KubeSubnets{}
+
+type KubeSubnetsOpts struct {
+	AdditionalRemoteIPs []string
+	ExcludedSubnets     []net.IPNet
+
+	// Precise returns exact pod and service IPs instead of guessed subnets
+	Precise    bool
+	Namespaces []string // all namespaces if empty
 }
 
-func (s KubeSubnets) Subnets() ([]net.IPNet, error) {
-	localIPs, err := LocalIPs()
-	if err != nil {
-		return nil, err
+func NewKubeSubnets(dynamicClient dynamic.Interface, opts KubeSubnetsOpts, logger Logger) KubeSubnets {
+	watchDebounce := 5 * time.Second
+	if opts.Precise {
+		// Each new IP needs to be forwarded as soon as possible
+		watchDebounce = 1 * time.Second
 	}
 
+	return KubeSubnets{dynamicClient, opts, watchDebounce, "KubeSubnets", logger}
+}
+
+func (s KubeSubnets) Subnets() ([]net.IPNet, error) {
 	t1 := time.Now()
 
-	podIPs, err := s.podIPs()
-	if err != nil {
-		return nil, err
-	}
+	var pods, svcs []interface{}
 
-	svcIPs, err := s.serviceIPs()
-	if err != nil {
-		return nil, err
+	for _, ns := range s.namespaces() {
+		podList, err := s.dynamicClient.Resource(podsResource).Namespace(ns).List(metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		svcList, err := s.dynamicClient.Resource(servicesResource).Namespace(ns).List(metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range podList.Items {
+			pods = append(pods, &podList.Items[i])
+		}
+
+		for i := range svcList.Items {
+			svcs = append(svcs, &svcList.Items[i])
+		}
 	}
 
 	t2 := time.Now()
 
-	s.logger.Debug(s.logTag, "Finished fetching pods (%d) and services (%d) in %s", len(podIPs), len(svcIPs), t2.Sub(t1))
+	s.logger.Debug(s.logTag, "Finished fetching pods (%d) and services (%d) in %s", len(pods), len(svcs), t2.Sub(t1))
 
-	var remoteIPs []net.IP
+	return s.guessSubnets(pods, svcs)
+}
 
-	remoteIPs = append(remoteIPs, podIPs...)
-	remoteIPs = append(remoteIPs, svcIPs...)
+func (s KubeSubnets) Watch(changedFunc func([]net.IPNet), doneCh chan struct{}) error {
+	changedCh := make(chan struct{}, 1)
 
-	for _, ipStr := range s.additionalRemoteIPs {
-		ip := net.ParseIP(ipStr)
-		if ip != nil {
-			remoteIPs = append(remoteIPs, ip)
-		}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { s.notify(changedCh) },
+		UpdateFunc: func(interface{}, interface{}) { s.notify(changedCh) },
+		DeleteFunc: func(interface{}) { s.notify(changedCh) },
 	}
 
-	return GuessSubnets(remoteIPs, localIPs), nil
-}
+	var podStores, svcStores []cache.Store
+	var hasSyncedFuncs []cache.InformerSynced
 
-func (s KubeSubnets) podIPs() ([]net.IP, error) {
-	podList, err := s.coreClient.CoreV1().Pods("").List(metav1.ListOptions{})
-	if err != nil {
-		return nil, err
+	for _, ns := range s.namespaces() {
+		podStore, podController := cache.NewInformer(
+			s.listWatch(podsResource, ns), &unstructured.Unstructured{}, 0, handler)
+
+		svcStore, svcController := cache.NewInformer(
+			s.listWatch(servicesResource, ns), &unstructured.Unstructured{}, 0, handler)
+
+		go podController.Run(doneCh)
+		go svcController.Run(doneCh)
+
+		podStores = append(podStores, podStore)
+		svcStores = append(svcStores, svcStore)
+		hasSyncedFuncs = append(hasSyncedFuncs, podController.HasSynced, svcController.HasSynced)
 	}
 
-	var result []net.IP
+	if !cache.WaitForCacheSync(doneCh, hasSyncedFuncs...) {
+		return nil // stopped before sync
+	}
+
+	var lastSubnets []net.IPNet
+
+	for {
+		select {
+		case <-changedCh:
+		case <-doneCh:
+			return nil
+		}
+
+		select {
+		case <-time.After(s.watchDebounce):
+		case <-doneCh:
+			return nil
+		}
+
+		subnets, err := s.guessSubnets(s.listStores(podStores), s.listStores(svcStores))
+		if err != nil {
+			s.logger.Error(s.logTag, "Failed recomputing subnets: %s", err)
+			continue
+		}
+
+		added, removed := DiffSubnets(lastSubnets, subnets)
 
-	for _, pod := range podList.Items {
-		if len(pod.Status.PodIP) > 0 {
-			ip := net.ParseIP(pod.Status.PodIP)
-			if ip != nil {
-				result = append(result, ip)
-			}
+		if lastSubnets == nil || len(added) > 0 || len(removed) > 0 {
+			lastSubnets = subnets
+			changedFunc(subnets)
 		}
 	}
+}
 
-	return result, nil
+func (s KubeSubnets) namespaces() []string {
+	if len(s.opts.Namespaces) == 0 {
+		return []string{metav1.NamespaceAll}
+	}
+	return s.opts.Namespaces
 }
 
-func (s KubeSubnets) serviceIPs() ([]net.IP, error) {
-	svcList, err := s.coreClient.CoreV1().Services("").List(metav1.ListOptions{})
-	if err != nil {
-		return nil, err
+func (s KubeSubnets) listWatch(resource schema.GroupVersionResource, ns string) *cache.ListWatch {
+	client := s.dynamicClient.Resource(resource).Namespace(ns)
+
+	return &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			return client.List(opts)
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			return client.Watch(opts)
+		},
+	}
+}
+
+func (KubeSubnets) listStores(stores []cache.Store) []interface{} {
+	var result []interface{}
+	for _, store := range stores {
+		result = append(result, store.List()...)
+	}
+	return result
+}
+
+func (KubeSubnets) notify(changedCh chan struct{}) {
+	select {
+	case changedCh <- struct{}{}:
+	default: // already notified
 	}
+}
 
-	var result []net.IP
+func (s KubeSubnets) guessSubnets(pods, svcs []interface{}) ([]net.IPNet, error) {
+	var remoteIPs []net.IP
 
-	for _, svc := range svcList.Items {
-		if len(svc.Spec.ClusterIP) > 0 {
-			ip := net.ParseIP(svc.Spec.ClusterIP) // ClusterIP can be "None"
-			if ip != nil {
-				result = append(result, ip)
-			}
+	// Secondary IPs of dual-stack pods and services are in the other family subnets
+	for _, obj := range pods {
+		remoteIPs = append(remoteIPs, kubeips.PodStatusIPs(obj.(*unstructured.Unstructured).Object)...)
+	}
+
+	for _, obj := range svcs {
+		remoteIPs = append(remoteIPs, kubeips.ServiceClusterIPs(obj.(*unstructured.Unstructured).Object)...)
+	}
+
+	for _, ipStr := range s.opts.AdditionalRemoteIPs {
+		ip := net.ParseIP(ipStr)
+		if ip != nil {
+			remoteIPs = append(remoteIPs, ip)
 		}
 	}
 
-	return result, nil
+	if s.opts.Precise {
+		return HostSubnets(remoteIPs), nil
+	}
+
+	localIPs, err := LocalIPs()
+	if err != nil {
+		return nil, err
+	}
+
+	return GuessSubnets(remoteIPs, localIPs, s.opts.ExcludedSubnets), nil
 }
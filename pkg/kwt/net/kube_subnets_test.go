@@ -0,0 +1,90 @@
+package net_test
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/kubetest"
+	"github.com/carvel-dev/kwt/pkg/kwt/logtest"
+	. "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"k8s.io/client-go/dynamic"
+)
+
+func newPreciseKubeSubnets(t *testing.T, api *kubetest.FakeAPI) KubeSubnets {
+	dynamicClient, err := dynamic.NewForConfig(api.Config())
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	return NewKubeSubnets(dynamicClient, KubeSubnetsOpts{Precise: true}, logtest.NoopLogger{})
+}
+
+func TestKubeSubnetsDualStack(t *testing.T) {
+	api, _, closeFunc := kubetest.NewFakeAPI(t)
+	defer closeFunc()
+
+	api.Set("/api/v1/namespaces/ns/pods/ds", `{"metadata":{"name":"ds","namespace":"ns"},`+
+		`"status":{"podIP":"10.1.0.1","podIPs":[{"ip":"10.1.0.1"},{"ip":"fd00:1::1"}]}}`)
+	api.Set("/api/v1/namespaces/ns/pods/v4", `{"metadata":{"name":"v4","namespace":"ns"},"status":{"podIP":"10.1.0.2"}}`)
+	api.Set("/api/v1/namespaces/ns/services/ds", `{"metadata":{"name":"ds","namespace":"ns"},`+
+		`"spec":{"clusterIP":"10.0.0.1","clusterIPs":["10.0.0.1","fd00::1"]}}`)
+	api.Set("/api/v1/namespaces/ns/services/hl", `{"metadata":{"name":"hl","namespace":"ns"},`+
+		`"spec":{"clusterIP":"None","clusterIPs":["None"]}}`)
+
+	subnets, err := newPreciseKubeSubnets(t, api).Subnets()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	// Secondary IPs of dual-stack pods and services are included
+	expected := "10.0.0.1/32, 10.1.0.1/32, 10.1.0.2/32, fd00:1::1/128, fd00::1/128"
+
+	if sortedSubnetsAsString(subnets) != expected {
+		t.Fatalf("Expected subnets '%s', but was '%s'", expected, SubnetsAsString(subnets))
+	}
+}
+
+func TestKubeSubnetsWatchDualStack(t *testing.T) {
+	api, _, closeFunc := kubetest.NewFakeAPI(t)
+	defer closeFunc()
+
+	api.Set("/api/v1/namespaces/ns/pods/v4", `{"metadata":{"name":"v4","namespace":"ns"},"status":{"podIP":"10.1.0.2"}}`)
+
+	changedCh := make(chan []net.IPNet, 10)
+	doneCh := make(chan struct{})
+
+	defer close(doneCh)
+
+	go newPreciseKubeSubnets(t, api).Watch(func(subnets []net.IPNet) { changedCh <- subnets }, doneCh)
+
+	expectSubnets := func(expected string) {
+		select {
+		case subnets := <-changedCh:
+			if sortedSubnetsAsString(subnets) != expected {
+				t.Fatalf("Expected subnets '%s', but was '%s'", expected, SubnetsAsString(subnets))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected subnets '%s' to be watched", expected)
+		}
+	}
+
+	expectSubnets("10.1.0.2/32")
+
+	api.Set("/api/v1/namespaces/ns/pods/ds", `{"metadata":{"name":"ds","namespace":"ns"},`+
+		`"status":{"podIP":"10.1.0.1","podIPs":[{"ip":"10.1.0.1"},{"ip":"fd00:1::1"}]}}`)
+
+	expectSubnets("10.1.0.1/32, 10.1.0.2/32, fd00:1::1/128")
+}
+
+// sortedSubnetsAsString ignores order since informer stores do not keep it
+func sortedSubnetsAsString(subnets []net.IPNet) string {
+	var result []string
+	for _, subnet := range subnets {
+		result = append(result, subnet.String())
+	}
+	sort.Strings(result)
+	return strings.Join(result, ", ")
+}
@@ -0,0 +1,76 @@
+package net
+
+import (
+	"fmt"
+	"strings"
+
+	authv1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	KubeTransportPortForward = "portforward"
+	KubeTransportAPIProxy    = "apiproxy"
+)
+
+var (
+	kubeTransports = []string{KubeTransportPortForward, KubeTransportAPIProxy}
+)
+
+// SelectKubeTransport returns requested transport if one is specified;
+// otherwise, it picks port forwarding unless RBAC forbids it. API server proxy
+// transport is only picked if RBAC allows creating services and proxying to them.
+func SelectKubeTransport(coreClient kubernetes.Interface, namespace string, transport string, logger Logger) (string, error) {
+	const logTag = "KubeTransport"
+
+	if len(transport) > 0 {
+		for _, knownTransport := range kubeTransports {
+			if transport == knownTransport {
+				return transport, nil
+			}
+		}
+		return "", fmt.Errorf("Expected transport to be one of: %s", strings.Join(kubeTransports, ", "))
+	}
+
+	if kubeAccessAllowed(coreClient, namespace, "create", "pods", "portforward", logger) {
+		return KubeTransportPortForward, nil
+	}
+
+	apiProxyAllowed := kubeAccessAllowed(coreClient, namespace, "create", "services", "", logger) &&
+		kubeAccessAllowed(coreClient, namespace, "get", "services", "proxy", logger)
+
+	if !apiProxyAllowed {
+		return "", fmt.Errorf("Port forwarding is forbidden in namespace '%s' and API server proxy transport "+
+			"requires permissions to create services and to get services/proxy", namespace)
+	}
+
+	logger.Info(logTag, "Port forwarding is forbidden in namespace '%s', "+
+		"falling back to API server proxy transport", namespace)
+
+	return KubeTransportAPIProxy, nil
+}
+
+// kubeAccessAllowed assumes access is allowed if it cannot be checked
+func kubeAccessAllowed(coreClient kubernetes.Interface, namespace, verb, resource, subresource string, logger Logger) bool {
+	const logTag = "KubeTransport"
+
+	review := &authv1.SelfSubjectAccessReview{
+		Spec: authv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authv1.ResourceAttributes{
+				Namespace:   namespace,
+				Verb:        verb,
+				Resource:    resource,
+				Subresource: subresource,
+			},
+		},
+	}
+
+	review, err := coreClient.AuthorizationV1().SelfSubjectAccessReviews().Create(review)
+	if err != nil {
+		logger.Error(logTag, "Failed checking permission to %s %s (assuming allowed): %s",
+			verb, strings.TrimSuffix(resource+"/"+subresource, "/"), err)
+		return true
+	}
+
+	return review.Status.Allowed
+}
@@ -0,0 +1,108 @@
+package net_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/logtest"
+	. "github.com/carvel-dev/kwt/pkg/kwt/net"
+	authv1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// fakeAccessReviewAPI allows access reviews for 'verb resource/subresource' keys
+type fakeAccessReviewAPI struct {
+	allowed map[string]bool
+}
+
+func (a fakeAccessReviewAPI) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var review authv1.SelfSubjectAccessReview
+
+	json.NewDecoder(req.Body).Decode(&review)
+
+	attrs := review.Spec.ResourceAttributes
+	review.Status.Allowed = a.allowed[attrs.Verb+" "+attrs.Resource+"/"+attrs.Subresource]
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review)
+}
+
+func TestSelectKubeTransport(t *testing.T) {
+	apiProxyPerms := map[string]bool{"create services/": true, "get services/proxy": true}
+
+	examples := []struct {
+		desc      string
+		allowed   map[string]bool
+		transport string
+		err       string
+	}{
+		{
+			desc:      "port forwarding allowed",
+			allowed:   map[string]bool{"create pods/portforward": true},
+			transport: KubeTransportPortForward,
+		},
+		{
+			desc:      "port forwarding forbidden",
+			allowed:   apiProxyPerms,
+			transport: KubeTransportAPIProxy,
+		},
+		{
+			desc:    "port forwarding and service proxying forbidden",
+			allowed: map[string]bool{"create services/": true},
+			err: "Port forwarding is forbidden in namespace 'ns' and API server proxy transport " +
+				"requires permissions to create services and to get services/proxy",
+		},
+		{
+			desc:    "port forwarding and service creation forbidden",
+			allowed: map[string]bool{"get services/proxy": true},
+			err: "Port forwarding is forbidden in namespace 'ns' and API server proxy transport " +
+				"requires permissions to create services and to get services/proxy",
+		},
+	}
+
+	for _, ex := range examples {
+		server := httptest.NewServer(fakeAccessReviewAPI{ex.allowed})
+
+		coreClient, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+		if err != nil {
+			t.Fatalf("Expected no err: %s", err)
+		}
+
+		transport, err := SelectKubeTransport(coreClient, "ns", "", logtest.NoopLogger{})
+
+		server.Close()
+
+		if len(ex.err) > 0 {
+			if err == nil || err.Error() != ex.err {
+				t.Fatalf("[%s] Expected err '%s', but was: %v", ex.desc, ex.err, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("[%s] Expected no err: %s", ex.desc, err)
+		}
+
+		if transport != ex.transport {
+			t.Fatalf("[%s] Expected transport '%s', but was '%s'", ex.desc, ex.transport, transport)
+		}
+	}
+}
+
+func TestSelectKubeTransportRequested(t *testing.T) {
+	transport, err := SelectKubeTransport(nil, "ns", KubeTransportAPIProxy, logtest.NoopLogger{})
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+	if transport != KubeTransportAPIProxy {
+		t.Fatalf("Expected requested transport, but was '%s'", transport)
+	}
+
+	_, err = SelectKubeTransport(nil, "ns", "ssh", logtest.NoopLogger{})
+	if err == nil {
+		t.Fatalf("Expected unknown transport to be rejected")
+	}
+}
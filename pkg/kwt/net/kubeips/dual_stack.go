@@ -0,0 +1,78 @@
+package kubeips
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Vendored API types predate dual-stack services and pods (Spec.ClusterIPs,
+// Status.PodIPs) so IPs of both families are read from raw objects.
+
+// GetObject returns raw core object (eg service or pod);
+// errors are Kubernetes API errors (eg checked via errors.IsNotFound)
+func GetObject(coreClient kubernetes.Interface, resource, namespace, name string) (map[string]interface{}, error) {
+	bs, err := coreClient.CoreV1().RESTClient().Get().
+		Namespace(namespace).Resource(resource).Name(name).Do().Raw()
+	if err != nil {
+		return nil, err
+	}
+
+	var obj map[string]interface{}
+
+	err = json.Unmarshal(bs, &obj)
+	if err != nil {
+		return nil, fmt.Errorf("Unmarshaling %s '%s/%s': %s", resource, namespace, name, err)
+	}
+
+	return obj, nil
+}
+
+// ServiceClusterIPs returns cluster IPs of all IP families
+// (none for headless services); primary IP is first
+func ServiceClusterIPs(svc map[string]interface{}) []net.IP {
+	clusterIPs, _, _ := unstructured.NestedStringSlice(svc, "spec", "clusterIPs")
+	clusterIP, _, _ := unstructured.NestedString(svc, "spec", "clusterIP")
+
+	// Older clusters only set clusterIP
+	return parseIPs(append(clusterIPs, clusterIP)) // ClusterIP can be "None"
+}
+
+// PodStatusIPs returns pod IPs of all IP families; primary IP is first
+func PodStatusIPs(pod map[string]interface{}) []net.IP {
+	var ipStrs []string
+
+	podIPs, _, _ := unstructured.NestedSlice(pod, "status", "podIPs")
+	for _, podIP := range podIPs {
+		if podIPMap, ok := podIP.(map[string]interface{}); ok {
+			ipStr, _ := podIPMap["ip"].(string)
+			ipStrs = append(ipStrs, ipStr)
+		}
+	}
+
+	podIP, _, _ := unstructured.NestedString(pod, "status", "podIP")
+
+	return parseIPs(append(ipStrs, podIP))
+}
+
+func parseIPs(strs []string) []net.IP {
+	var result []net.IP
+	seen := map[string]struct{}{}
+
+	for _, str := range strs {
+		ip := net.ParseIP(str)
+		if ip == nil {
+			continue
+		}
+		if _, found := seen[ip.String()]; found {
+			continue
+		}
+		seen[ip.String()] = struct{}{}
+		result = append(result, ip)
+	}
+
+	return result
+}
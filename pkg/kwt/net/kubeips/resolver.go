@@ -0,0 +1,113 @@
+package kubeips
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	resolverTTL = 10 * time.Second
+)
+
+// Resolver finds IPs of services (including IPs of their endpoints) and pods;
+// results are cached briefly since it's consulted for each new connection.
+// It's shared by fault injection and capture so that both agree on service IPs.
+type Resolver struct {
+	coreClient kubernetes.Interface
+
+	cache     map[string]cachedIPs
+	cacheLock sync.Mutex
+}
+
+type cachedIPs struct {
+	ips        []net.IP
+	resolvedAt time.Time
+}
+
+func NewResolver(coreClient kubernetes.Interface) *Resolver {
+	return &Resolver{coreClient: coreClient, cache: map[string]cachedIPs{}}
+}
+
+// ServiceIPs returns no IPs if service does not exist
+func (r *Resolver) ServiceIPs(namespace, name string) ([]net.IP, error) {
+	return r.cached("svc/"+namespace+"/"+name, func() ([]net.IP, error) {
+		return r.serviceIPs(namespace, name)
+	})
+}
+
+// PodIPs returns no IPs if pod does not exist
+func (r *Resolver) PodIPs(namespace, name string) ([]net.IP, error) {
+	return r.cached("pod/"+namespace+"/"+name, func() ([]net.IP, error) {
+		return r.podIPs(namespace, name)
+	})
+}
+
+func (r *Resolver) cached(key string, resolveFunc func() ([]net.IP, error)) ([]net.IP, error) {
+	r.cacheLock.Lock()
+	cached, found := r.cache[key]
+	r.cacheLock.Unlock()
+
+	if found && time.Since(cached.resolvedAt) < resolverTTL {
+		return cached.ips, nil
+	}
+
+	ips, err := resolveFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	r.cacheLock.Lock()
+	r.cache[key] = cachedIPs{ips, time.Now()}
+	r.cacheLock.Unlock()
+
+	return ips, nil
+}
+
+func (r *Resolver) serviceIPs(namespace, name string) ([]net.IP, error) {
+	svc, err := GetObject(r.coreClient, "services", namespace, name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Getting service '%s/%s': %s", namespace, name, err)
+	}
+
+	ips := ServiceClusterIPs(svc)
+
+	// Include backing pods since clients may connect to them directly (eg headless services)
+	endpoints, err := r.coreClient.CoreV1().Endpoints(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ips, nil
+		}
+		return nil, fmt.Errorf("Getting endpoints '%s/%s': %s", namespace, name, err)
+	}
+
+	for _, subset := range endpoints.Subsets {
+		for _, addr := range subset.Addresses {
+			if ip := net.ParseIP(addr.IP); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+	}
+
+	return ips, nil
+}
+
+func (r *Resolver) podIPs(namespace, name string) ([]net.IP, error) {
+	pod, err := GetObject(r.coreClient, "pods", namespace, name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Getting pod '%s/%s': %s", namespace, name, err)
+	}
+
+	return PodStatusIPs(pod), nil
+}
@@ -0,0 +1,91 @@
+package kubeips_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/kubetest"
+	. "github.com/carvel-dev/kwt/pkg/kwt/net/kubeips"
+)
+
+func newResolver(t *testing.T, objs map[string]string) (*Resolver, *kubetest.FakeAPI, func()) {
+	api, coreClient, closeFunc := kubetest.NewFakeAPI(t)
+
+	for path, obj := range objs {
+		api.Set(path, obj)
+	}
+
+	return NewResolver(coreClient), api, closeFunc
+}
+
+func ipsAsString(ips []net.IP) string {
+	return fmt.Sprintf("%s", ips)
+}
+
+func TestResolverServiceIPs(t *testing.T) {
+	resolver, api, closeFunc := newResolver(t, map[string]string{
+		"/api/v1/namespaces/ns/services/svc":   `{"spec":{"clusterIP":"10.0.0.1"}}`,
+		"/api/v1/namespaces/ns/endpoints/svc":  `{"subsets":[{"addresses":[{"ip":"10.1.0.1"},{"ip":"10.1.0.2"}]}]}`,
+		"/api/v1/namespaces/ns/services/hl":    `{"spec":{"clusterIP":"None"}}`,
+		"/api/v1/namespaces/ns/endpoints/hl":   `{"subsets":[{"addresses":[{"ip":"10.1.0.3"}]}]}`,
+		"/api/v1/namespaces/ns/services/no-ep": `{"spec":{"clusterIP":"10.0.0.2"}}`,
+		"/api/v1/namespaces/ns/services/ds":    `{"spec":{"clusterIP":"10.0.0.3","clusterIPs":["10.0.0.3","fd00::3"]}}`,
+	})
+	defer closeFunc()
+
+	examples := map[string]string{
+		"svc":     "[10.0.0.1 10.1.0.1 10.1.0.2]",
+		"hl":      "[10.1.0.3]",
+		"no-ep":   "[10.0.0.2]",
+		"ds":      "[10.0.0.3 fd00::3]",
+		"missing": "[]",
+	}
+
+	for name, expectedIPs := range examples {
+		ips, err := resolver.ServiceIPs("ns", name)
+		if err != nil {
+			t.Fatalf("Expected no err: %s", err)
+		}
+		if ipsAsString(ips) != expectedIPs {
+			t.Fatalf("Expected service '%s' IPs to be %s, but was %s", name, expectedIPs, ipsAsString(ips))
+		}
+	}
+
+	api.Requests()
+
+	ips, err := resolver.ServiceIPs("ns", "svc")
+	if err != nil || ipsAsString(ips) != "[10.0.0.1 10.1.0.1 10.1.0.2]" {
+		t.Fatalf("Expected cached service IPs: %s (err: %v)", ipsAsString(ips), err)
+	}
+
+	if len(api.Requests()) != 0 {
+		t.Fatalf("Expected service IPs to be cached")
+	}
+}
+
+func TestResolverPodIPs(t *testing.T) {
+	resolver, _, closeFunc := newResolver(t, map[string]string{
+		"/api/v1/namespaces/ns/pods/pod":     `{"status":{"podIP":"10.1.0.1"}}`,
+		"/api/v1/namespaces/ns/pods/ds":      `{"status":{"podIP":"10.1.0.2","podIPs":[{"ip":"10.1.0.2"},{"ip":"fd00:1::2"}]}}`,
+		"/api/v1/namespaces/ns/pods/pending": `{"status":{}}`,
+	})
+	defer closeFunc()
+
+	examples := map[string]string{
+		"pod":     "[10.1.0.1]",
+		"ds":      "[10.1.0.2 fd00:1::2]",
+		"pending": "[]",
+		"missing": "[]",
+	}
+
+	for name, expectedIPs := range examples {
+		ips, err := resolver.PodIPs("ns", name)
+		if err != nil {
+			t.Fatalf("Expected no err: %s", err)
+		}
+		if ipsAsString(ips) != expectedIPs {
+			t.Fatalf("Expected pod '%s' IPs to be %s, but was %s", name, expectedIPs, ipsAsString(ips))
+		}
+	}
+}
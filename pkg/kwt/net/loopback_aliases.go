@@ -0,0 +1,50 @@
+package net
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"runtime"
+)
+
+// LoopbackAliases makes loopback addresses other than 127.0.0.1 usable.
+// On Linux whole 127.0.0.0/8 is already routed to lo; OS X needs aliases on lo0.
+type LoopbackAliases struct {
+	logTag string
+	logger Logger
+}
+
+func NewLoopbackAliases(logger Logger) LoopbackAliases {
+	return LoopbackAliases{"LoopbackAliases", logger}
+}
+
+func (a LoopbackAliases) Add(ip net.IP) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return a.ifconfig("alias", ip.String(), "up")
+	default:
+		a.logger.Debug(a.logTag, "Skipping adding loopback alias %s", ip)
+		return nil
+	}
+}
+
+func (a LoopbackAliases) Remove(ip net.IP) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return a.ifconfig("-alias", ip.String())
+	default:
+		a.logger.Debug(a.logTag, "Skipping removing loopback alias %s", ip)
+		return nil
+	}
+}
+
+func (a LoopbackAliases) ifconfig(args ...string) error {
+	args = append([]string{"lo0"}, args...)
+
+	out, err := exec.Command("ifconfig", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Running ifconfig %s: %s (output: %s)", args, err, out)
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package net
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// LoopbackIPs hands out 127.x.y.z addresses (starting with 127.1.0.1)
+// keeping the same address for a key until it's released
+type LoopbackIPs struct {
+	next     uint32
+	ips      map[string]net.IP
+	released []net.IP
+	lock     sync.Mutex
+}
+
+func NewLoopbackIPs() *LoopbackIPs {
+	return &LoopbackIPs{next: 127<<24 | 1<<16 | 1, ips: map[string]net.IP{}}
+}
+
+func (l *LoopbackIPs) Allocate(key string) (net.IP, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if ip, found := l.ips[key]; found {
+		return ip, nil
+	}
+
+	var ip net.IP
+
+	if len(l.released) > 0 {
+		ip = l.released[0]
+		l.released = l.released[1:]
+	} else {
+		// Skip network and broadcast looking addresses
+		for l.next&0xff == 0 || l.next&0xff == 0xff {
+			l.next++
+		}
+
+		if l.next>>24 != 127 {
+			return nil, fmt.Errorf("Expected to have available loopback addresses")
+		}
+
+		ip = net.IPv4(byte(l.next>>24), byte(l.next>>16), byte(l.next>>8), byte(l.next)).To4()
+		l.next++
+	}
+
+	l.ips[key] = ip
+
+	return ip, nil
+}
+
+func (l *LoopbackIPs) Release(key string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if ip, found := l.ips[key]; found {
+		delete(l.ips, key)
+		l.released = append(l.released, ip)
+	}
+}
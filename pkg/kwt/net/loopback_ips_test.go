@@ -0,0 +1,44 @@
+package net_test
+
+import (
+	"strconv"
+	"testing"
+
+	. "github.com/carvel-dev/kwt/pkg/kwt/net"
+)
+
+func TestLoopbackIPs(t *testing.T) {
+	ips := NewLoopbackIPs()
+
+	ip1, err := ips.Allocate("ns/svc1")
+	if err != nil || ip1.String() != "127.1.0.1" {
+		t.Fatalf("Expected first address: %s (err: %s)", ip1, err)
+	}
+
+	ip2, err := ips.Allocate("ns/svc2")
+	if err != nil || ip2.String() != "127.1.0.2" {
+		t.Fatalf("Expected second address: %s (err: %s)", ip2, err)
+	}
+
+	ip1Again, err := ips.Allocate("ns/svc1")
+	if err != nil || !ip1Again.Equal(ip1) {
+		t.Fatalf("Expected same address for same key: %s (err: %s)", ip1Again, err)
+	}
+
+	ips.Release("ns/svc1")
+
+	ip3, err := ips.Allocate("ns/svc3")
+	if err != nil || !ip3.Equal(ip1) {
+		t.Fatalf("Expected released address to be reused: %s (err: %s)", ip3, err)
+	}
+
+	for i := 4; i < 300; i++ {
+		ip, err := ips.Allocate("ns/svc" + strconv.Itoa(i))
+		if err != nil {
+			t.Fatalf("Expected no err: %s", err)
+		}
+		if ip[3] == 0 || ip[3] == 255 {
+			t.Fatalf("Expected to skip network and broadcast looking addresses: %s", ip)
+		}
+	}
+}
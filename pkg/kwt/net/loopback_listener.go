@@ -0,0 +1,104 @@
+package net
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// LoopbackListener listens on IPv4 and, if available, IPv6 loopback
+// addresses using the same port so that firewall rules
+// for both address families can redirect to a single port
+type LoopbackListener struct {
+	listeners []net.Listener
+
+	connCh    chan net.Conn
+	errCh     chan error
+	closedCh  chan struct{}
+	closeOnce sync.Once
+}
+
+var _ net.Listener = &LoopbackListener{}
+
+func NewLoopbackListener(logger Logger) (*LoopbackListener, error) {
+	lis4, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	listeners := []net.Listener{lis4}
+
+	port := lis4.Addr().(*net.TCPAddr).Port
+
+	lis6, err := net.Listen("tcp", net.JoinHostPort("::1", strconv.Itoa(port)))
+	if err != nil {
+		logger.Debug("LoopbackListener", "Skipping IPv6 loopback listener: %s", err)
+	} else {
+		listeners = append(listeners, lis6)
+	}
+
+	lis := &LoopbackListener{
+		listeners: listeners,
+		connCh:    make(chan net.Conn),
+		errCh:     make(chan error, len(listeners)),
+		closedCh:  make(chan struct{}),
+	}
+
+	for _, l := range listeners {
+		go lis.accept(l)
+	}
+
+	return lis, nil
+}
+
+func (lis *LoopbackListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-lis.connCh:
+		return conn, nil
+	case err := <-lis.errCh:
+		return nil, err
+	case <-lis.closedCh:
+		return nil, io.EOF
+	}
+}
+
+func (lis *LoopbackListener) Close() error {
+	var lastErr error
+
+	lis.closeOnce.Do(func() {
+		close(lis.closedCh)
+
+		for _, l := range lis.listeners {
+			err := l.Close()
+			if err != nil {
+				lastErr = err
+			}
+		}
+	})
+
+	return lastErr
+}
+
+// Addr returns IPv4 address; IPv6 address uses the same port
+func (lis *LoopbackListener) Addr() net.Addr { return lis.listeners[0].Addr() }
+
+func (lis *LoopbackListener) accept(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-lis.closedCh:
+			case lis.errCh <- err:
+			}
+			return
+		}
+
+		select {
+		case lis.connCh <- conn:
+		case <-lis.closedCh:
+			conn.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package net
+
+import (
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/net/accesslog"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/capture"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/fault"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/forwarder"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// LoopbackProxy gives each service its own loopback address and
+// forwards connections made to service ports on that address.
+// Unlike ForwardingProxy it does not need firewall changes.
+type LoopbackProxy struct {
+	coreClient kubernetes.Interface
+	opts       LoopbackProxyOpts
+
+	ips      *LoopbackIPs
+	aliases  LoopbackAliases
+	etcHosts EtcHostsBlock
+
+	services     map[string]*loopbackService
+	servicesLock sync.RWMutex
+
+	shutdownCh chan struct{}
+
+	logTag string
+	logger Logger
+}
+
+type LoopbackProxyOpts struct {
+	Namespaces    []string
+	ClusterDomain string // eg cluster.local
+	EtcHostsPath  string // hosts file is not updated if empty
+	Capturer      *capture.Capturer
+	Injector      *fault.Injector
+	AccessLog     *accesslog.Log
+}
+
+type loopbackService struct {
+	namespace string
+	name      string
+	ip        net.IP
+	clusterIP net.IP
+	ports     []int
+	proxies   []*TCPProxy
+}
+
+func NewLoopbackProxy(coreClient kubernetes.Interface, opts LoopbackProxyOpts, logger Logger) *LoopbackProxy {
+	return &LoopbackProxy{
+		coreClient: coreClient,
+		opts:       opts,
+
+		ips:      NewLoopbackIPs(),
+		aliases:  NewLoopbackAliases(logger),
+		etcHosts: NewEtcHostsBlock(opts.EtcHostsPath),
+
+		services:   map[string]*loopbackService{},
+		shutdownCh: make(chan struct{}),
+
+		logTag: "LoopbackProxy",
+		logger: logger,
+	}
+}
+
+// ServiceIP returns loopback address assigned to a service
+func (p *LoopbackProxy) ServiceIP(namespace, name string) (net.IP, bool) {
+	p.servicesLock.RLock()
+	defer p.servicesLock.RUnlock()
+
+	svc, found := p.services[namespace+"/"+name]
+	if !found {
+		return nil, false
+	}
+
+	return svc.ip, true
+}
+
+func (p *LoopbackProxy) Serve(dstConnFactory dstconn.Factory) error {
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { p.update(obj, dstConnFactory) },
+		UpdateFunc: func(_, obj interface{}) { p.update(obj, dstConnFactory) },
+		DeleteFunc: func(obj interface{}) { p.delete(obj) },
+	}
+
+	restClient := p.coreClient.CoreV1().RESTClient()
+
+	var hasSyncedFuncs []cache.InformerSynced
+
+	for _, ns := range p.opts.Namespaces {
+		_, svcController := cache.NewInformer(
+			cache.NewListWatchFromClient(restClient, "services", ns, fields.Everything()),
+			&corev1.Service{}, 0, handler)
+
+		go svcController.Run(p.shutdownCh)
+
+		hasSyncedFuncs = append(hasSyncedFuncs, svcController.HasSynced)
+	}
+
+	if cache.WaitForCacheSync(p.shutdownCh, hasSyncedFuncs...) {
+		p.logger.Info(p.logTag, "Ready!")
+	}
+
+	<-p.shutdownCh
+
+	p.servicesLock.Lock()
+	defer p.servicesLock.Unlock()
+
+	for key, svc := range p.services {
+		p.stopService(svc)
+		p.removeService(key, svc)
+	}
+
+	p.updateEtcHosts()
+
+	return nil
+}
+
+func (p *LoopbackProxy) Shutdown() error {
+	close(p.shutdownCh)
+	return nil
+}
+
+func (p *LoopbackProxy) update(obj interface{}, dstConnFactory dstconn.Factory) {
+	svc, ok := obj.(*corev1.Service)
+	if !ok {
+		return
+	}
+
+	key := svc.Namespace + "/" + svc.Name
+
+	clusterIP := net.ParseIP(svc.Spec.ClusterIP) // ClusterIP can be "None"
+
+	var ports []int
+
+	for _, port := range svc.Spec.Ports {
+		if port.Protocol == corev1.ProtocolTCP || len(port.Protocol) == 0 {
+			ports = append(ports, int(port.Port))
+		}
+	}
+
+	p.servicesLock.Lock()
+	defer p.servicesLock.Unlock()
+
+	existingSvc, found := p.services[key]
+
+	if clusterIP == nil || len(ports) == 0 {
+		if found {
+			p.stopService(existingSvc)
+			p.removeService(key, existingSvc)
+			p.updateEtcHosts()
+		}
+		p.logger.Debug(p.logTag, "Skipping service '%s' without cluster IP or TCP ports", key)
+		return
+	}
+
+	if found {
+		if existingSvc.clusterIP.Equal(clusterIP) && p.samePorts(existingSvc.ports, ports) {
+			return
+		}
+		p.stopService(existingSvc)
+	} else {
+		ip, err := p.ips.Allocate(key)
+		if err != nil {
+			p.logger.Error(p.logTag, "Failed allocating loopback address for service '%s': %s", key, err)
+			return
+		}
+
+		err = p.aliases.Add(ip)
+		if err != nil {
+			p.logger.Error(p.logTag, "Failed adding loopback alias for service '%s': %s", key, err)
+			p.ips.Release(key)
+			return
+		}
+
+		existingSvc = &loopbackService{namespace: svc.Namespace, name: svc.Name, ip: ip}
+		p.services[key] = existingSvc
+	}
+
+	existingSvc.clusterIP = clusterIP
+	existingSvc.ports = ports
+
+	p.startService(existingSvc, dstConnFactory)
+	p.updateEtcHosts()
+}
+
+func (p *LoopbackProxy) delete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	svc, ok := obj.(*corev1.Service)
+	if !ok {
+		return
+	}
+
+	key := svc.Namespace + "/" + svc.Name
+
+	p.servicesLock.Lock()
+	defer p.servicesLock.Unlock()
+
+	if existingSvc, found := p.services[key]; found {
+		p.stopService(existingSvc)
+		p.removeService(key, existingSvc)
+		p.updateEtcHosts()
+	}
+}
+
+func (p *LoopbackProxy) startService(svc *loopbackService, dstConnFactory dstconn.Factory) {
+	for _, port := range svc.ports {
+		listenAddr := net.JoinHostPort(svc.ip.String(), strconv.Itoa(port))
+
+		listener, err := net.Listen("tcp", listenAddr)
+		if err != nil {
+			p.logger.Error(p.logTag, "Failed listening on %s for service '%s/%s': %s",
+				listenAddr, svc.namespace, svc.name, err)
+			continue
+		}
+
+		resolver := forwarder.NewStaticResolver(svc.clusterIP, port)
+		proxy := NewTCPProxy(resolver, dstConnFactory, p.logger).WithCapturer(p.opts.Capturer).
+			WithInjector(p.opts.Injector).WithAccessLog(p.opts.AccessLog)
+
+		go func() {
+			err := proxy.ServeListener(listener, make(chan struct{}, 1))
+			if err != nil {
+				p.logger.Debug(p.logTag, "Stopped serving %s: %s", listenAddr, err)
+			}
+		}()
+
+		svc.proxies = append(svc.proxies, proxy)
+
+		p.logger.Info(p.logTag, "Forwarding %s to service '%s/%s'", listenAddr, svc.namespace, svc.name)
+	}
+}
+
+func (p *LoopbackProxy) stopService(svc *loopbackService) {
+	for _, proxy := range svc.proxies {
+		proxy.Shutdown()
+	}
+	svc.proxies = nil
+}
+
+func (p *LoopbackProxy) removeService(key string, svc *loopbackService) {
+	err := p.aliases.Remove(svc.ip)
+	if err != nil {
+		p.logger.Error(p.logTag, "Failed removing loopback alias for service '%s': %s", key, err)
+	}
+
+	p.ips.Release(key)
+	delete(p.services, key)
+}
+
+func (p *LoopbackProxy) updateEtcHosts() {
+	if len(p.opts.EtcHostsPath) == 0 {
+		return
+	}
+
+	var entries []EtcHostsEntry
+
+	for _, svc := range p.services {
+		hostnames := []string{
+			svc.name + "." + svc.namespace + ".svc." + p.opts.ClusterDomain,
+			svc.name + "." + svc.namespace + ".svc",
+			svc.name + "." + svc.namespace,
+		}
+
+		// Short names are only unambiguous within single namespace
+		if len(p.opts.Namespaces) == 1 {
+			hostnames = append(hostnames, svc.name)
+		}
+
+		entries = append(entries, EtcHostsEntry{IP: svc.ip.String(), Hostnames: hostnames})
+	}
+
+	err := p.etcHosts.Write(entries)
+	if err != nil {
+		p.logger.Error(p.logTag, "Failed updating hosts file: %s", err)
+	}
+}
+
+func (*LoopbackProxy) samePorts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
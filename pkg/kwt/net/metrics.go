@@ -0,0 +1,124 @@
+package net
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/metrics"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/accesslog"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Destination IPs and ports are not used as labels since each of them
+// would create a new series; see MetricsDestinations for bounded destination
+// labels and access log for per destination details
+var (
+	tcpProxyConnsActive = metrics.Default.NewGauge("kwt_tcp_proxy_connections_active",
+		"Number of currently proxied TCP connections", "destination")
+	tcpProxyConnsTotal = metrics.Default.NewCounter("kwt_tcp_proxy_connections_total",
+		"Number of proxied TCP connections", "destination")
+	tcpProxyBytesTotal = metrics.Default.NewCounter("kwt_tcp_proxy_bytes_total",
+		"Number of bytes proxied (in: from destination, out: to destination)", "destination", "direction")
+	tcpProxyDialSeconds = metrics.Default.NewHistogram("kwt_tcp_proxy_dial_duration_seconds",
+		"Time taken to establish connection to destination", metrics.DurationBuckets)
+	tcpProxyDialFailuresTotal = metrics.Default.NewCounter("kwt_tcp_proxy_dial_failures_total",
+		"Number of connections that could not be proxied", "reason")
+
+	sshReconnectsTotal = metrics.Default.NewCounter("kwt_ssh_reconnects_total",
+		"Number of SSH client reconnects after initial connection", "result")
+)
+
+const (
+	MetricsDestinationOther = "other"
+)
+
+// MetricsDestinations turns destination IPs into a bounded set of label values:
+// Kubernetes service ("svc/<ns>/<name>") or pod namespace ("pod/<ns>", since pod
+// names change with each rollout). Unknown destinations and destinations seen
+// after maxLabels distinct values are already used are labeled as "other".
+type MetricsDestinations struct {
+	objects   accesslog.ObjectResolver
+	maxLabels int
+
+	labels     map[string]struct{}
+	labelsLock sync.Mutex
+}
+
+func NewMetricsDestinations(objects accesslog.ObjectResolver, maxLabels int) *MetricsDestinations {
+	return &MetricsDestinations{
+		objects:   objects,
+		maxLabels: maxLabels,
+		labels:    map[string]struct{}{},
+	}
+}
+
+func (d *MetricsDestinations) Label(ip net.IP) string {
+	obj, found := d.objects.Object(ip)
+	if !found {
+		return MetricsDestinationOther
+	}
+
+	label := obj.Kind + "/" + obj.Namespace
+	if obj.Kind == "svc" {
+		label += "/" + obj.Name
+	}
+
+	d.labelsLock.Lock()
+	defer d.labelsLock.Unlock()
+
+	if _, found := d.labels[label]; found {
+		return label
+	}
+
+	if len(d.labels) >= d.maxLabels {
+		return MetricsDestinationOther
+	}
+
+	d.labels[label] = struct{}{}
+
+	return label
+}
+
+func dialFailureReason(err error) string {
+	switch typedErr := err.(type) {
+	case dstconn.ConnectionBrokenErr:
+		return "connection_broken"
+	case *gossh.OpenChannelError:
+		// eg 'connect failed' when destination refused connection
+		return strings.Replace(typedErr.Reason.String(), " ", "_", -1)
+	case net.Error:
+		if typedErr.Timeout() {
+			return "timeout"
+		}
+	}
+	return "other"
+}
+
+// countingConn records number of bytes read and written
+// while preserving CloseWrite expected by conn copiers
+type countingConn struct {
+	net.Conn
+	read, written uint64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddUint64(&c.read, uint64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddUint64(&c.written, uint64(n))
+	return n, err
+}
+
+func (c *countingConn) CloseWrite() error {
+	if closer, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return closer.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package net_test
+
+import (
+	"net"
+	"testing"
+
+	. "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/accesslog"
+)
+
+type fakeObjectResolver map[string]accesslog.Object
+
+func (r fakeObjectResolver) Object(ip net.IP) (accesslog.Object, bool) {
+	obj, found := r[ip.String()]
+	return obj, found
+}
+
+func TestMetricsDestinations(t *testing.T) {
+	objects := fakeObjectResolver{
+		"10.0.0.1": accesslog.Object{Kind: "svc", Namespace: "app1", Name: "redis"},
+		"10.0.0.2": accesslog.Object{Kind: "svc", Namespace: "app1", Name: "web"},
+		"10.1.0.1": accesslog.Object{Kind: "pod", Namespace: "app1", Name: "web-5d8f7-abcde"},
+		"10.1.0.2": accesslog.Object{Kind: "pod", Namespace: "app1", Name: "web-5d8f7-fghij"},
+		"10.1.0.3": accesslog.Object{Kind: "pod", Namespace: "app2", Name: "db-0"},
+	}
+
+	dsts := NewMetricsDestinations(objects, 2)
+
+	examples := []struct {
+		ip    string
+		label string
+	}{
+		{"10.0.0.1", "svc/app1/redis"},
+		{"10.1.0.1", "pod/app1"},
+		{"10.1.0.2", "pod/app1"}, // pod names are not used
+		{"10.0.0.9", "other"},    // unknown destination
+		{"10.0.0.2", "other"},    // over max labels
+		{"10.1.0.3", "other"},
+		{"10.0.0.1", "svc/app1/redis"}, // already used labels are kept
+	}
+
+	for _, ex := range examples {
+		label := dsts.Label(net.ParseIP(ex.ip))
+		if label != ex.label {
+			t.Fatalf("Expected '%s' to be labeled '%s', but was '%s'", ex.ip, ex.label, label)
+		}
+	}
+}
@@ -133,6 +133,7 @@ type LookUpNATOpts struct {
 	SrcPort int32
 	DstIP   net.IP
 	DstPort int32
+	UDP     bool // TCP by default
 }
 
 func (p *Pfctl) LookUpNAT(opts LookUpNATOpts) (net.IP, int, error) {
@@ -144,6 +145,9 @@ func (p *Pfctl) LookUpNAT(opts LookUpNATOpts) (net.IP, int, error) {
 	natlook.SetDstPort(opts.DstPort)
 	natlook.af = syscall.AF_INET
 	natlook.proto = syscall.IPPROTO_TCP
+	if opts.UDP {
+		natlook.proto = syscall.IPPROTO_UDP
+	}
 	natlook.direction = PF_OUT
 
 	err := p.ioctl.Read(DIOCNATLOOK, unsafe.Pointer(&natlook))
@@ -0,0 +1,43 @@
+package net
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// ReconnBackoff spaces out reconnect attempts exponentially;
+// jitter keeps pooled clients from reconnecting in lockstep
+type ReconnBackoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64 // fraction of delay randomly added or subtracted
+}
+
+func DefaultReconnBackoff() ReconnBackoff {
+	return ReconnBackoff{
+		Initial:    1 * time.Second,
+		Max:        30 * time.Second,
+		Multiplier: 2,
+		Jitter:     0.2,
+	}
+}
+
+// Delay returns delay before given attempt (starting with 0)
+func (b ReconnBackoff) Delay(attempt int) time.Duration {
+	delay := float64(b.Initial) * math.Pow(b.Multiplier, float64(attempt))
+	if delay > float64(b.Max) || math.IsInf(delay, 0) {
+		delay = float64(b.Max)
+	}
+
+	if b.Jitter > 0 {
+		delay += delay * b.Jitter * (2*rand.Float64() - 1)
+	}
+
+	if delay < 0 {
+		return 0
+	}
+
+	return time.Duration(delay)
+}
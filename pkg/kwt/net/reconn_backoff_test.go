@@ -0,0 +1,43 @@
+package net_test
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/carvel-dev/kwt/pkg/kwt/net"
+)
+
+func TestReconnBackoff(t *testing.T) {
+	backoff := ReconnBackoff{Initial: time.Second, Max: 10 * time.Second, Multiplier: 2}
+
+	expected := []time.Duration{1, 2, 4, 8, 10, 10}
+
+	for attempt, exp := range expected {
+		delay := backoff.Delay(attempt)
+		if delay != exp*time.Second {
+			t.Fatalf("Expected attempt %d delay to be %s, but was %s", attempt, exp*time.Second, delay)
+		}
+	}
+
+	if delay := backoff.Delay(10000); delay != 10*time.Second {
+		t.Fatalf("Expected delay to be capped, but was %s", delay)
+	}
+
+	backoff.Jitter = 0.5
+
+	var varied bool
+
+	for i := 0; i < 100; i++ {
+		delay := backoff.Delay(2)
+		if delay < 2*time.Second || delay > 6*time.Second {
+			t.Fatalf("Expected delay with jitter to be within 2s and 6s, but was %s", delay)
+		}
+		if delay != 4*time.Second {
+			varied = true
+		}
+	}
+
+	if !varied {
+		t.Fatalf("Expected jitter to vary delays")
+	}
+}
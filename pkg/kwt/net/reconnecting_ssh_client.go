@@ -1,26 +1,75 @@
 package net
 
 import (
+	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
 )
 
-type ReconnSSHClient struct {
-	entryPoint     EntryPoint
-	entryPointSess EntryPointSession
+const (
+	SSHClientStateReady        = "Ready"
+	SSHClientStateDegraded     = "Degraded"     // keepalives are failing
+	SSHClientStateReconnecting = "Reconnecting" // client is broken and is being replaced
+)
 
-	sshClient     *dstconn.SSHClient
-	sshClientLock sync.RWMutex
+// SSHHealthOpts controls how unresponsive SSH clients are detected and replaced
+type SSHHealthOpts struct {
+	KeepAlive            dstconn.SSHKeepAliveOpts
+	MaxKeepAliveFailures int // consecutive failures before client is replaced
+	Backoff              ReconnBackoff
+}
+
+func DefaultSSHHealthOpts() SSHHealthOpts {
+	return SSHHealthOpts{
+		KeepAlive:            dstconn.DefaultSSHKeepAliveOpts(),
+		MaxKeepAliveFailures: 3,
+		Backoff:              DefaultReconnBackoff(),
+	}
+}
+
+// ReconnSSHClient spreads new channels across a pool of SSH clients,
+// picking least loaded one. Broken clients are replaced individually
+// while other clients keep serving.
+type ReconnSSHClient struct {
+	entryPoint EntryPoint
+	clients    []*pooledSSHClient
 
 	logger Logger
 	logTag string
 }
 
 func NewReconnSSHClient(entryPoint EntryPoint, logger Logger) *ReconnSSHClient {
+	return NewReconnSSHClientPool(entryPoint, 1, logger)
+}
+
+func NewReconnSSHClientPool(entryPoint EntryPoint, poolSize int, logger Logger) *ReconnSSHClient {
+	if poolSize < 1 {
+		poolSize = 1
+	}
+
+	var clients []*pooledSSHClient
+
+	for i := 0; i < poolSize; i++ {
+		logTag := "ReconnSSHClient"
+		if poolSize > 1 {
+			logTag = fmt.Sprintf("ReconnSSHClient[%d]", i)
+		}
+
+		clients = append(clients, &pooledSSHClient{
+			entryPoint: entryPoint,
+			healthOpts: DefaultSSHHealthOpts(),
+			logger:     logger,
+			logTag:     logTag,
+		})
+	}
+
 	return &ReconnSSHClient{
 		entryPoint: entryPoint,
+		clients:    clients,
 		logger:     logger,
 		logTag:     "ReconnSSHClient",
 	}
@@ -28,26 +77,22 @@ func NewReconnSSHClient(entryPoint EntryPoint, logger Logger) *ReconnSSHClient {
 
 var _ dstconn.Factory = &ReconnSSHClient{}
 
-func (f *ReconnSSHClient) NewConn(ip net.IP, port int) (net.Conn, error) {
-	client, err := f.getSSHClient()
-	if err != nil {
-		return nil, err
+// WithHealthOpts configures keepalives and reconnect backoff of all pooled clients
+func (f *ReconnSSHClient) WithHealthOpts(opts SSHHealthOpts) *ReconnSSHClient {
+	for _, pooled := range f.clients {
+		pooled.healthOpts = opts
 	}
+	return f
+}
 
-	conn, err := client.NewConn(ip, port)
-	if err != nil {
-		client, err := f.reconnectIfNecessary(err)
-		if err != nil {
-			return nil, err
-		}
+func (f *ReconnSSHClient) NewConn(ip net.IP, port int) (net.Conn, error) {
+	return f.newChannel(func(client *dstconn.SSHClient) (net.Conn, error) {
 		return client.NewConn(ip, port)
-	}
-
-	return conn, nil
+	})
 }
 
 func (f *ReconnSSHClient) NewConnCopier(proxyDesc string) dstconn.ConnCopier {
-	client, err := f.getSSHClient()
+	client, err := f.leastLoaded().getSSHClient()
 	if err != nil {
 		return dstconn.ClosingConnCopier{}
 	}
@@ -55,15 +100,23 @@ func (f *ReconnSSHClient) NewConnCopier(proxyDesc string) dstconn.ConnCopier {
 	return client.NewConnCopier(proxyDesc)
 }
 
+func (f *ReconnSSHClient) NewPacketConn(ip net.IP, port int) (net.Conn, error) {
+	return f.newChannel(func(client *dstconn.SSHClient) (net.Conn, error) {
+		return client.NewPacketConn(ip, port)
+	})
+}
+
 func (f *ReconnSSHClient) NewListener() (net.Listener, error) {
-	client, err := f.getSSHClient()
+	pooled := f.leastLoaded()
+
+	client, err := pooled.getSSHClient()
 	if err != nil {
 		return nil, err
 	}
 
 	lis, err := client.NewListener()
 	if err != nil {
-		client, err := f.reconnectIfNecessary(err)
+		client, err := pooled.reconnectIfNecessary(err)
 		if err != nil {
 			return nil, err
 		}
@@ -73,17 +126,200 @@ func (f *ReconnSSHClient) NewListener() (net.Listener, error) {
 	return lis, nil
 }
 
+// Connect connects first client before others since entry point
+// may need to create shared resources (eg net pod) on first use
 func (f *ReconnSSHClient) Connect() error {
+	err := f.clients[0].Connect()
+	if err != nil {
+		return err
+	}
+
+	errCh := make(chan error, len(f.clients))
+
+	for _, pooled := range f.clients[1:] {
+		go func(pooled *pooledSSHClient) { errCh <- pooled.Connect() }(pooled)
+	}
+
+	var firstErr error
+
+	for range f.clients[1:] {
+		err := <-errCh
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// Disconnect also releases entry point since clients are not reconnected afterwards
+func (f *ReconnSSHClient) Disconnect() error {
+	var firstErr error
+
+	for _, pooled := range f.clients {
+		err := pooled.Disconnect()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	err := f.entryPoint.Release()
+	if err != nil {
+		f.logger.Error(f.logTag, "Failed releasing entry point: %s", err)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+func (f *ReconnSSHClient) Status() []SSHClientStatus {
+	var result []SSHClientStatus
+
+	for _, pooled := range f.clients {
+		result = append(result, pooled.status())
+	}
+
+	return result
+}
+
+func (f *ReconnSSHClient) newChannel(newFunc func(*dstconn.SSHClient) (net.Conn, error)) (net.Conn, error) {
+	pooled := f.leastLoaded()
+
+	client, err := pooled.getSSHClient()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := newFunc(client)
+	if err != nil {
+		_, needsReconnect := err.(dstconn.ConnectionBrokenErr)
+
+		f.logger.Debug(pooled.logTag, "Received err: %s (needsReconnect: %t)", err, needsReconnect)
+
+		if !needsReconnect {
+			return nil, err
+		}
+
+		pooled.setState(SSHClientStateReconnecting, err.Error())
+		pooled.disconnect()
+
+		if len(f.clients) > 1 {
+			// Let other clients serve while broken one is being replaced
+			pooled.reconnectInBackground()
+			pooled = f.leastLoaded()
+		}
+
+		client, err := pooled.getSSHClient()
+		if err != nil {
+			return nil, err
+		}
+
+		conn, err = newFunc(client)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return pooled.track(conn), nil
+}
+
+// leastLoaded prefers connected clients so that requests
+// do not wait for broken clients to be replaced
+func (f *ReconnSSHClient) leastLoaded() *pooledSSHClient {
+	var result *pooledSSHClient
+
+	for _, pooled := range f.clients {
+		if !pooled.isUp() {
+			continue
+		}
+		if result == nil || pooled.activeChannels() < result.activeChannels() {
+			result = pooled
+		}
+	}
+
+	if result != nil {
+		return result
+	}
+
+	for _, pooled := range f.clients {
+		if result == nil || pooled.activeChannels() < result.activeChannels() {
+			result = pooled
+		}
+	}
+
+	return result
+}
+
+type pooledSSHClient struct {
+	entryPoint     EntryPoint
+	entryPointSess EntryPointSession
+	healthOpts     SSHHealthOpts
+
+	sshClient     *dstconn.SSHClient
+	sshClientLock sync.RWMutex
+	connected     bool // at least once
+
+	up           int32 // accessed atomically
+	active       int64 // accessed atomically
+	reconnecting int32 // accessed atomically
+	closed       int32 // accessed atomically
+
+	state     string
+	stateLock sync.Mutex
+
+	logger Logger
+	logTag string
+}
+
+func (f *pooledSSHClient) Connect() error {
+	atomic.StoreInt32(&f.closed, 0)
 	_ = f.disconnect()
 	_, err := f.connect()
 	return err
 }
 
-func (f *ReconnSSHClient) Disconnect() error {
+func (f *pooledSSHClient) Disconnect() error {
+	atomic.StoreInt32(&f.closed, 1)
 	return f.disconnect()
 }
 
-func (f *ReconnSSHClient) getSSHClient() (*dstconn.SSHClient, error) {
+func (f *pooledSSHClient) isUp() bool            { return atomic.LoadInt32(&f.up) == 1 }
+func (f *pooledSSHClient) activeChannels() int64 { return atomic.LoadInt64(&f.active) }
+
+func (f *pooledSSHClient) status() SSHClientStatus {
+	status := SSHClientStatus{
+		Up:             f.isUp(),
+		State:          f.currentState(),
+		ActiveChannels: f.activeChannels(),
+	}
+
+	f.sshClientLock.RLock()
+	defer f.sshClientLock.RUnlock()
+
+	if f.sshClient != nil {
+		keepAlive := f.sshClient.LastKeepAlive()
+
+		if !keepAlive.At.IsZero() {
+			status.LastKeepAliveAt = keepAlive.At
+			status.LastKeepAliveRTT = keepAlive.RTT.String()
+
+			if keepAlive.Err != nil {
+				status.LastKeepAliveErr = keepAlive.Err.Error()
+			}
+		}
+	}
+
+	return status
+}
+
+func (f *pooledSSHClient) track(conn net.Conn) net.Conn {
+	atomic.AddInt64(&f.active, 1)
+	return &pooledConn{Conn: conn, closedFunc: func() { atomic.AddInt64(&f.active, -1) }}
+}
+
+func (f *pooledSSHClient) getSSHClient() (*dstconn.SSHClient, error) {
 	f.sshClientLock.RLock()
 
 	if f.sshClient != nil {
@@ -96,7 +332,7 @@ func (f *ReconnSSHClient) getSSHClient() (*dstconn.SSHClient, error) {
 	return f.connect()
 }
 
-func (f *ReconnSSHClient) connect() (*dstconn.SSHClient, error) {
+func (f *pooledSSHClient) connect() (*dstconn.SSHClient, error) {
 	f.sshClientLock.Lock()
 	defer f.sshClientLock.Unlock()
 
@@ -109,27 +345,70 @@ func (f *ReconnSSHClient) connect() (*dstconn.SSHClient, error) {
 
 	sess, err := f.entryPoint.EntryPoint()
 	if err != nil {
+		f.recordReconnect(false)
 		return nil, err
 	}
 
-	sshClient := dstconn.NewSSHClient(sess.Opts(), f.logger)
+	var sshClient *dstconn.SSHClient
+
+	sshClient = dstconn.NewSSHClient(sess.Opts(), f.logger).WithKeepAlive(f.healthOpts.KeepAlive,
+		func(keepAlive dstconn.SSHKeepAlive) { f.observeKeepAlive(sshClient, keepAlive) })
 
 	err = sshClient.Connect()
 	if err != nil {
+		sess.Close()
+		f.recordReconnect(false)
 		return nil, err
 	}
 
 	f.logger.Debug(f.logTag, "Reconnected SSH client")
+	f.recordReconnect(true)
 	f.sshClient = sshClient
 	f.entryPointSess = sess
+	f.connected = true
+
+	atomic.StoreInt32(&f.up, 1)
+
+	f.setState(SSHClientStateReady, "connected")
 
 	return f.sshClient, nil
 }
 
-func (f *ReconnSSHClient) disconnect() error {
+func (f *pooledSSHClient) recordReconnect(succeeded bool) {
+	if !f.connected {
+		return // initial connection is not a reconnect
+	}
+	if succeeded {
+		sshReconnectsTotal.Inc("success")
+	} else {
+		sshReconnectsTotal.Inc("failure")
+	}
+}
+
+func (f *pooledSSHClient) disconnect() error {
+	f.sshClientLock.Lock()
+	defer f.sshClientLock.Unlock()
+
+	return f.disconnectLocked()
+}
+
+// disconnectIfCurrent ignores clients that were already replaced
+func (f *pooledSSHClient) disconnectIfCurrent(sshClient *dstconn.SSHClient) bool {
 	f.sshClientLock.Lock()
 	defer f.sshClientLock.Unlock()
 
+	if f.sshClient != sshClient {
+		return false
+	}
+
+	f.disconnectLocked()
+
+	return true
+}
+
+func (f *pooledSSHClient) disconnectLocked() error {
+	atomic.StoreInt32(&f.up, 0)
+
 	var err error
 
 	if f.sshClient != nil {
@@ -139,20 +418,113 @@ func (f *ReconnSSHClient) disconnect() error {
 
 	if f.entryPointSess != nil {
 		f.entryPointSess.Close()
+		f.entryPointSess = nil
 	}
 
 	return err
 }
 
-func (f *ReconnSSHClient) reconnectIfNecessary(err error) (*dstconn.SSHClient, error) {
+func (f *pooledSSHClient) reconnectIfNecessary(err error) (*dstconn.SSHClient, error) {
 	_, needsReconnect := err.(dstconn.ConnectionBrokenErr)
 
 	f.logger.Debug(f.logTag, "Received err: %s (needsReconnect: %t)", err, needsReconnect)
 
 	if needsReconnect {
+		f.setState(SSHClientStateReconnecting, err.Error())
 		f.disconnect()
 		return f.connect()
 	}
 
 	return nil, err
 }
+
+func (f *pooledSSHClient) reconnectInBackground() {
+	if !atomic.CompareAndSwapInt32(&f.reconnecting, 0, 1) {
+		return // already reconnecting
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&f.reconnecting, 0)
+
+		for attempt := 0; atomic.LoadInt32(&f.closed) == 0; attempt++ {
+			_, err := f.connect()
+			if err == nil {
+				return
+			}
+
+			delay := f.healthOpts.Backoff.Delay(attempt)
+
+			f.logger.Error(f.logTag, "Failed replacing SSH client (will retry in %s): %s", delay.Round(time.Millisecond), err)
+
+			time.Sleep(delay)
+		}
+	}()
+}
+
+// observeKeepAlive replaces client in the background once keepalives
+// keep failing instead of waiting for new channels to fail
+func (f *pooledSSHClient) observeKeepAlive(sshClient *dstconn.SSHClient, keepAlive dstconn.SSHKeepAlive) {
+	switch {
+	case keepAlive.Err == nil:
+		f.setState(SSHClientStateReady, "keepalive succeeded")
+
+	case keepAlive.Failures < f.healthOpts.MaxKeepAliveFailures:
+		f.setState(SSHClientStateDegraded, fmt.Sprintf("keepalive failed: %s", keepAlive.Err))
+
+	default:
+		if atomic.LoadInt32(&f.closed) == 1 || !f.disconnectIfCurrent(sshClient) {
+			return
+		}
+
+		f.setState(SSHClientStateReconnecting, fmt.Sprintf(
+			"%d consecutive keepalives failed: %s", keepAlive.Failures, keepAlive.Err))
+
+		f.reconnectInBackground()
+	}
+}
+
+func (f *pooledSSHClient) currentState() string {
+	f.stateLock.Lock()
+	defer f.stateLock.Unlock()
+
+	return f.state
+}
+
+// setState logs only state transitions to avoid logging every keepalive
+func (f *pooledSSHClient) setState(state, reason string) {
+	f.stateLock.Lock()
+	defer f.stateLock.Unlock()
+
+	if f.state == state {
+		return
+	}
+
+	f.state = state
+
+	if state == SSHClientStateReady {
+		f.logger.Info(f.logTag, "SSH client is %s (%s)", state, reason)
+	} else {
+		f.logger.Error(f.logTag, "SSH client is %s (%s)", state, reason)
+	}
+}
+
+// pooledConn notifies its pooled client when it's closed
+// while preserving CloseWrite expected by conn copiers
+type pooledConn struct {
+	net.Conn
+	closedFunc func()
+	closedOnce sync.Once
+}
+
+func (c *pooledConn) Close() error {
+	err := c.Conn.Close()
+	c.closedOnce.Do(c.closedFunc)
+	return err
+}
+
+func (c *pooledConn) CloseWrite() error {
+	if closer, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return closer.CloseWrite()
+	}
+	return nil
+}
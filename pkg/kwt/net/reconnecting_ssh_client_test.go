@@ -0,0 +1,496 @@
+package net_test
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/logtest"
+	. "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeEntryPoint starts new in-process SSH server for each session
+// so that tests can break individual pooled clients
+type fakeEntryPoint struct {
+	clientKey dstconn.SSHKey
+	hostKey   dstconn.SSHKey
+
+	sessions     []*fakeEntryPointSession
+	sessionsLock sync.Mutex
+}
+
+var _ EntryPoint = &fakeEntryPoint{}
+
+func newFakeEntryPoint(t *testing.T) *fakeEntryPoint {
+	clientKey, err := dstconn.NewSSHKeyGenerator().WithKeyType(dstconn.SSHKeyTypeED25519).Generate()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	hostKey, err := dstconn.NewSSHKeyGenerator().WithKeyType(dstconn.SSHKeyTypeED25519).Generate()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	entryPoint := &fakeEntryPoint{clientKey: clientKey, hostKey: hostKey}
+
+	t.Cleanup(func() {
+		for _, sess := range entryPoint.Sessions() {
+			sess.Close()
+		}
+	})
+
+	return entryPoint
+}
+
+func (e *fakeEntryPoint) EntryPoint() (EntryPointSession, error) {
+	sess := &fakeEntryPointSession{}
+
+	host, err := sess.startServer(e.hostKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sess.opts = dstconn.SSHClientConnOpts{
+		User:             "tom",
+		Host:             host,
+		PrivateKeyPEM:    e.clientKey.PrivateKey,
+		HostPublicKeyAuf: e.hostKey.PublicKey,
+	}
+
+	e.sessionsLock.Lock()
+	e.sessions = append(e.sessions, sess)
+	e.sessionsLock.Unlock()
+
+	return sess, nil
+}
+
+func (e *fakeEntryPoint) Status() EntryPointStatus { return EntryPointStatus{Type: "fake"} }
+func (e *fakeEntryPoint) Delete() error            { return nil }
+func (e *fakeEntryPoint) Release() error           { return nil }
+
+func (e *fakeEntryPoint) Sessions() []*fakeEntryPointSession {
+	e.sessionsLock.Lock()
+	defer e.sessionsLock.Unlock()
+
+	return append([]*fakeEntryPointSession{}, e.sessions...)
+}
+
+type fakeEntryPointSession struct {
+	opts dstconn.SSHClientConnOpts
+
+	listener  net.Listener
+	conns     []net.Conn
+	connsLock sync.Mutex
+}
+
+var _ EntryPointSession = &fakeEntryPointSession{}
+
+func (s *fakeEntryPointSession) Opts() dstconn.SSHClientConnOpts { return s.opts }
+
+func (s *fakeEntryPointSession) Close() error {
+	s.listener.Close()
+
+	s.connsLock.Lock()
+	defer s.connsLock.Unlock()
+
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+
+	return nil
+}
+
+// Break stops SSH server from sending anything similar to a net pod going away.
+// Connections are only half closed so that clients deterministically see EOF.
+func (s *fakeEntryPointSession) Break() {
+	s.listener.Close()
+
+	s.connsLock.Lock()
+	defer s.connsLock.Unlock()
+
+	for _, conn := range s.conns {
+		conn.(*net.TCPConn).CloseWrite()
+	}
+}
+
+// startServer accepts any client and serves direct-tcpip channels
+func (s *fakeEntryPointSession) startServer(hostKey dstconn.SSHKey) (string, error) {
+	hostSigner, err := ssh.ParsePrivateKey([]byte(hostKey.PrivateKey))
+	if err != nil {
+		return "", err
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) { return nil, nil },
+	}
+	config.AddHostKey(hostSigner)
+
+	s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+
+	go func() {
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+
+			s.connsLock.Lock()
+			s.conns = append(s.conns, conn)
+			s.connsLock.Unlock()
+
+			go s.serveConn(conn, config)
+		}
+	}()
+
+	return s.listener.Addr().String(), nil
+}
+
+func (s *fakeEntryPointSession) serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	go ssh.DiscardRequests(reqs)
+
+	for newChan := range chans {
+		if newChan.ChannelType() != "direct-tcpip" {
+			newChan.Reject(ssh.UnknownChannelType, "")
+			continue
+		}
+
+		var payload struct {
+			Host     string
+			Port     uint32
+			OrigHost string
+			OrigPort uint32
+		}
+
+		err := ssh.Unmarshal(newChan.ExtraData(), &payload)
+		if err != nil {
+			newChan.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+
+		dstConn, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+		if err != nil {
+			newChan.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+
+		channel, chanReqs, err := newChan.Accept()
+		if err != nil {
+			dstConn.Close()
+			continue
+		}
+
+		go ssh.DiscardRequests(chanReqs)
+
+		go func() {
+			io.Copy(channel, dstConn)
+			channel.Close()
+		}()
+		go func() {
+			io.Copy(dstConn, channel)
+			dstConn.Close()
+		}()
+	}
+}
+
+func testSSHHealthOpts() SSHHealthOpts {
+	opts := DefaultSSHHealthOpts()
+	opts.KeepAlive = dstconn.SSHKeepAliveOpts{Interval: 20 * time.Millisecond, Timeout: 1 * time.Second}
+	opts.MaxKeepAliveFailures = 1000 // only replace clients due to broken channels
+	opts.Backoff = ReconnBackoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond, Multiplier: 2}
+	return opts
+}
+
+func expectActiveChannels(t *testing.T, client *ReconnSSHClient, expected []int64) {
+	statuses := client.Status()
+
+	if len(statuses) != len(expected) {
+		t.Fatalf("Expected %d statuses, but was %d", len(expected), len(statuses))
+	}
+
+	for i, status := range statuses {
+		if status.ActiveChannels != expected[i] {
+			t.Fatalf("Expected client %d to have %d active channels, but was %d (all: %#v)",
+				i, expected[i], status.ActiveChannels, statuses)
+		}
+	}
+}
+
+func waitForStatus(t *testing.T, client *ReconnSSHClient, desc string, condFunc func([]SSHClientStatus) bool) {
+	for i := 0; i < 500; i++ {
+		if condFunc(client.Status()) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %s (statuses: %#v)", desc, client.Status())
+}
+
+func TestReconnSSHClientPoolPicksLeastLoaded(t *testing.T) {
+	backend, port := startEchoBackend(t)
+	defer backend.Close()
+
+	entryPoint := newFakeEntryPoint(t)
+
+	client := NewReconnSSHClientPool(entryPoint, 3, logtest.NoopLogger{}).WithHealthOpts(testSSHHealthOpts())
+
+	err := client.Connect()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer client.Disconnect()
+
+	if len(entryPoint.Sessions()) != 3 {
+		t.Fatalf("Expected one entry point session per pooled client")
+	}
+
+	var conns []net.Conn
+
+	for i := 0; i < 3; i++ {
+		conn, err := client.NewConn(net.ParseIP("127.0.0.1"), port)
+		if err != nil {
+			t.Fatalf("Expected no err: %s", err)
+		}
+
+		expectEcho(t, conn)
+		conns = append(conns, conn)
+	}
+
+	expectActiveChannels(t, client, []int64{1, 1, 1})
+
+	conns[1].Close()
+
+	expectActiveChannels(t, client, []int64{1, 0, 1})
+
+	conn, err := client.NewConn(net.ParseIP("127.0.0.1"), port)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	expectEcho(t, conn)
+	expectActiveChannels(t, client, []int64{1, 1, 1})
+
+	conn.Close()
+	conn.Close() // closing again must not affect counts
+
+	expectActiveChannels(t, client, []int64{1, 0, 1})
+
+	conns[0].Close()
+	conns[2].Close()
+
+	expectActiveChannels(t, client, []int64{0, 0, 0})
+}
+
+func TestReconnSSHClientPoolReplacesBrokenClient(t *testing.T) {
+	backend, port := startEchoBackend(t)
+	defer backend.Close()
+
+	entryPoint := newFakeEntryPoint(t)
+
+	client := NewReconnSSHClientPool(entryPoint, 2, logtest.NoopLogger{}).WithHealthOpts(testSSHHealthOpts())
+
+	err := client.Connect()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer client.Disconnect()
+
+	// First client is connected before others hence it owns first session
+	firstConn, err := client.NewConn(net.ParseIP("127.0.0.1"), port)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	servingConn, err := client.NewConn(net.ParseIP("127.0.0.1"), port)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer servingConn.Close()
+
+	expectActiveChannels(t, client, []int64{1, 1})
+
+	// Make first client least loaded before breaking it
+	firstConn.Close()
+
+	entryPoint.Sessions()[0].Break()
+
+	// Failed keepalive indicates that client noticed broken connection
+	waitForStatus(t, client, "first client to be degraded", func(statuses []SSHClientStatus) bool {
+		return len(statuses[0].LastKeepAliveErr) > 0 && statuses[0].State == SSHClientStateDegraded
+	})
+
+	conn, err := client.NewConn(net.ParseIP("127.0.0.1"), port)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer conn.Close()
+
+	expectEcho(t, conn)
+	expectEcho(t, servingConn)
+
+	statuses := client.Status()
+	if statuses[1].ActiveChannels != 2 {
+		t.Fatalf("Expected second client to serve new channel: %#v", statuses)
+	}
+	if !statuses[1].Up || statuses[1].State != SSHClientStateReady {
+		t.Fatalf("Expected second client to be unaffected: %#v", statuses)
+	}
+
+	waitForStatus(t, client, "first client to be replaced", func(statuses []SSHClientStatus) bool {
+		return statuses[0].Up && statuses[0].State == SSHClientStateReady
+	})
+
+	if len(entryPoint.Sessions()) != 3 {
+		t.Fatalf("Expected only first client to start new session, but was %d sessions",
+			len(entryPoint.Sessions()))
+	}
+
+	replacedConn, err := client.NewConn(net.ParseIP("127.0.0.1"), port)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer replacedConn.Close()
+
+	expectEcho(t, replacedConn)
+	expectActiveChannels(t, client, []int64{1, 2})
+}
+
+func TestReconnSSHClientKeepAliveStateTransitions(t *testing.T) {
+	entryPoint := newFakeEntryPoint(t)
+
+	var transitions []string
+	var transitionsLock sync.Mutex
+
+	logger := logtest.HookLogger{HookFunc: func(msg string) {
+		if strings.HasPrefix(msg, "SSH client is ") {
+			transitionsLock.Lock()
+			transitions = append(transitions, msg)
+			transitionsLock.Unlock()
+		}
+	}}
+
+	opts := testSSHHealthOpts()
+	opts.MaxKeepAliveFailures = 3
+
+	client := NewReconnSSHClient(entryPoint, logger).WithHealthOpts(opts)
+
+	err := client.Connect()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer client.Disconnect()
+
+	entryPoint.Sessions()[0].Break()
+
+	waitForStatus(t, client, "client to be replaced", func(statuses []SSHClientStatus) bool {
+		return len(entryPoint.Sessions()) == 2 && statuses[0].Up && statuses[0].State == SSHClientStateReady
+	})
+
+	expectedTransitions := []string{
+		"SSH client is Ready (connected)",
+		"SSH client is Degraded (keepalive failed: ",
+		"SSH client is Reconnecting (3 consecutive keepalives failed: ",
+		"SSH client is Ready (connected)",
+	}
+
+	transitionsLock.Lock()
+	defer transitionsLock.Unlock()
+
+	if len(transitions) != len(expectedTransitions) {
+		t.Fatalf("Expected transitions %#v, but was %#v", expectedTransitions, transitions)
+	}
+
+	for i, expected := range expectedTransitions {
+		if !strings.HasPrefix(transitions[i], expected) {
+			t.Fatalf("Expected transitions %#v, but was %#v", expectedTransitions, transitions)
+		}
+	}
+}
+
+func TestReconnSSHClientIgnoresKeepAliveOfReplacedClient(t *testing.T) {
+	backend, port := startEchoBackend(t)
+	defer backend.Close()
+
+	entryPoint := newFakeEntryPoint(t)
+
+	blockedCh := make(chan struct{})
+	unblockCh := make(chan struct{})
+	var blockOnce sync.Once
+
+	// Pause keepalive goroutine of the first client after it decided to report
+	// its last failure but before client is notified about it
+	logger := logtest.HookLogger{HookFunc: func(msg string) {
+		if strings.HasPrefix(msg, "Sending keepalive: ") && strings.HasSuffix(msg, "failures: 2)") {
+			blockOnce.Do(func() {
+				close(blockedCh)
+				<-unblockCh
+			})
+		}
+	}}
+
+	opts := testSSHHealthOpts()
+	opts.MaxKeepAliveFailures = 2
+
+	client := NewReconnSSHClient(entryPoint, logger).WithHealthOpts(opts)
+
+	err := client.Connect()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer client.Disconnect()
+
+	entryPoint.Sessions()[0].Break()
+
+	select {
+	case <-blockedCh:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for keepalive failures")
+	}
+
+	// Broken channel replaces client while its keepalive failure is in flight
+	conn, err := client.NewConn(net.ParseIP("127.0.0.1"), port)
+	if err != nil {
+		close(unblockCh)
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer conn.Close()
+
+	close(unblockCh)
+
+	// Give stale keepalive failure a chance to (incorrectly) disconnect replacement
+	time.Sleep(10 * opts.KeepAlive.Interval)
+
+	statuses := client.Status()
+	if !statuses[0].Up || statuses[0].State != SSHClientStateReady {
+		t.Fatalf("Expected replacement client to be unaffected: %#v", statuses)
+	}
+
+	if len(entryPoint.Sessions()) != 2 {
+		t.Fatalf("Expected only one replacement session, but was %d sessions", len(entryPoint.Sessions()))
+	}
+
+	expectEcho(t, conn)
+}
@@ -2,50 +2,204 @@ package net
 
 import (
 	"fmt"
+	"net"
+	"sync"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
 )
 
 type RemotingProxy struct {
-	entryPoint      EntryPoint
-	subnets         Subnets
+	remotes         []Remote
 	dnsIPs          DNSIPs
 	forwardingProxy *ForwardingProxy
 
+	// Available for control while serving
+	desiredSubnets     *DesiredSubnets
+	desiredSubnetsLock sync.RWMutex
+
+	// Available for status while serving
+	reconnSSHClients     []*ReconnSSHClient
+	reconnSSHClientsLock sync.RWMutex
+
 	logTag string
 	logger Logger
 }
 
-func NewRemotingProxy(entryPoint EntryPoint, subnets Subnets, dnsIPs DNSIPs, forwardingProxy *ForwardingProxy, logger Logger) *RemotingProxy {
-	return &RemotingProxy{entryPoint, subnets, dnsIPs, forwardingProxy, "RemotingProxy", logger}
+// Remote is a cluster reachable via its entry point;
+// connections are routed to remote based on its subnets
+type Remote struct {
+	Name       string // may be empty if there is only one remote
+	Context    string // kubeconfig context reported in status
+	EntryPoint EntryPoint
+	Subnets    Subnets
+
+	// SSHPoolSize is a number of parallel SSH clients to entry point
+	SSHPoolSize int
+	SSHHealth   SSHHealthOpts // defaults are used if empty
+}
+
+func NewRemotingProxy(remotes []Remote, dnsIPs DNSIPs, forwardingProxy *ForwardingProxy, logger Logger) *RemotingProxy {
+	return &RemotingProxy{
+		remotes:         remotes,
+		dnsIPs:          dnsIPs,
+		forwardingProxy: forwardingProxy,
+
+		logTag: "RemotingProxy",
+		logger: logger,
+	}
 }
 
 func (f *RemotingProxy) Serve() error {
-	subnets, err := f.subnets.Subnets()
+	var routes []RoutingDstConnRoute
+	var reconnSSHClients []*ReconnSSHClient
+
+	for _, remote := range f.remotes {
+		subnets, err := remote.Subnets.Subnets()
+		if err != nil {
+			return err
+		}
+
+		if len(subnets) == 0 {
+			if len(remote.Name) > 0 {
+				return fmt.Errorf("Expected at least one subnet to be guessed or specified for context '%s'", remote.Name)
+			}
+			return fmt.Errorf("Expected at least one subnet to be guessed or specified")
+		}
+
+		reconnSSHClient := NewReconnSSHClientPool(remote.EntryPoint, remote.SSHPoolSize, f.logger)
+		if remote.SSHHealth != (SSHHealthOpts{}) {
+			reconnSSHClient.WithHealthOpts(remote.SSHHealth)
+		}
+		reconnSSHClients = append(reconnSSHClients, reconnSSHClient)
+
+		routes = append(routes, RoutingDstConnRoute{
+			Name:    remote.Name,
+			Subnets: subnets,
+			Factory: reconnSSHClient,
+		})
+	}
+
+	// Check for overlapping subnets before connecting to any remote
+	routingFactory, err := NewRoutingDstConnFactory(routes)
 	if err != nil {
 		return err
 	}
 
-	if len(subnets) == 0 {
-		return fmt.Errorf("Expected at least one subnet to be guessed or specified")
+	dnsIPs, err := f.dnsIPs.DNSIPs()
+	if err != nil {
+		return err
 	}
 
-	dnsIPs, err := f.dnsIPs.DNSIPs()
+	for _, reconnSSHClient := range reconnSSHClients {
+		// Connect immediately starting proxies to catch any early failures
+		err = reconnSSHClient.Connect()
+		if err != nil {
+			return err
+		}
+
+		defer reconnSSHClient.Disconnect()
+	}
+
+	f.reconnSSHClientsLock.Lock()
+	f.reconnSSHClients = reconnSSHClients
+	f.reconnSSHClientsLock.Unlock()
+
+	desiredSubnets := NewDesiredSubnets(routingFactory, f.forwardingProxy.UpdateSubnets)
+
+	f.desiredSubnetsLock.Lock()
+	f.desiredSubnets = desiredSubnets
+	f.desiredSubnetsLock.Unlock()
+
+	doneCh := make(chan struct{})
+
+	defer close(doneCh)
+
+	for _, remote := range f.remotes {
+		go f.watchSubnets(remote, desiredSubnets, doneCh)
+	}
+
+	var dstConnFactory dstconn.Factory = routingFactory
+
+	if len(reconnSSHClients) == 1 {
+		dstConnFactory = reconnSSHClients[0] // no need to route
+	}
+
+	return f.forwardingProxy.Serve(dstConnFactory, routingFactory.Subnets(), dnsIPs)
+}
+
+func (f *RemotingProxy) watchSubnets(remote Remote, desiredSubnets *DesiredSubnets, doneCh chan struct{}) {
+	err := remote.Subnets.Watch(func(subnets []net.IPNet) {
+		err := desiredSubnets.UpdateWatched(remote.Name, subnets)
+		if err != nil {
+			f.logger.Error(f.logTag, "Failed updating changed subnets: %s", err)
+		}
+	}, doneCh)
+	if err != nil {
+		f.logger.Error(f.logTag, "Failed watching subnets: %s", err)
+	}
+}
+
+var _ ControlSubnets = &RemotingProxy{}
+
+// AddSubnets changes subnets via the same desired subnets as watched subnets
+// so that later changes of watched subnets do not undo them
+func (f *RemotingProxy) AddSubnets(subnets []net.IPNet) error {
+	desiredSubnets, err := f.servingDesiredSubnets()
 	if err != nil {
 		return err
 	}
 
-	reconnSSHClient := NewReconnSSHClient(f.entryPoint, f.logger)
+	return desiredSubnets.AddSubnets(subnets)
+}
 
-	// Connect immediately starting proxies to catch any early failures
-	err = reconnSSHClient.Connect()
+func (f *RemotingProxy) RemoveSubnets(subnets []net.IPNet) error {
+	desiredSubnets, err := f.servingDesiredSubnets()
 	if err != nil {
 		return err
 	}
 
-	defer reconnSSHClient.Disconnect()
+	return desiredSubnets.RemoveSubnets(subnets)
+}
+
+func (f *RemotingProxy) servingDesiredSubnets() (*DesiredSubnets, error) {
+	f.desiredSubnetsLock.RLock()
+	defer f.desiredSubnetsLock.RUnlock()
 
-	return f.forwardingProxy.Serve(reconnSSHClient, subnets, dnsIPs)
+	if f.desiredSubnets == nil {
+		return nil, fmt.Errorf("Expected forwarding to be ready")
+	}
+
+	return f.desiredSubnets, nil
+}
+
+var _ StatusRemotes = &RemotingProxy{}
+
+func (f *RemotingProxy) RemoteStatuses() []RemoteStatus {
+	f.reconnSSHClientsLock.RLock()
+	defer f.reconnSSHClientsLock.RUnlock()
+
+	var result []RemoteStatus
+
+	for i, remote := range f.remotes {
+		status := RemoteStatus{
+			Context:    remote.Context,
+			EntryPoint: remote.EntryPoint.Status(),
+		}
+
+		if i < len(f.reconnSSHClients) {
+			status.SSHClients = f.reconnSSHClients[i].Status()
+		}
+
+		result = append(result, status)
+	}
+
+	return result
 }
 
 func (f *RemotingProxy) Shutdown() error {
 	return f.forwardingProxy.Shutdown()
 }
+
+func (f *RemotingProxy) ForceShutdown() error {
+	return f.forwardingProxy.ForceShutdown()
+}
@@ -0,0 +1,202 @@
+package net
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
+)
+
+// RoutingDstConnFactory picks destination connection factory
+// based on which route's subnets contain destination IP
+type RoutingDstConnFactory struct {
+	routes     []RoutingDstConnRoute
+	routesLock sync.RWMutex
+}
+
+var _ dstconn.Factory = &RoutingDstConnFactory{}
+
+type RoutingDstConnRoute struct {
+	Name    string
+	Subnets []net.IPNet
+	Factory dstconn.Factory
+}
+
+func NewRoutingDstConnFactory(routes []RoutingDstConnRoute) (*RoutingDstConnFactory, error) {
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("Expected at least one route")
+	}
+
+	for i, route := range routes {
+		err := checkRouteOverlap(route, routes[i+1:])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &RoutingDstConnFactory{routes: routes}, nil
+}
+
+// UpdateSubnets keeps previous subnets if new subnets overlap other routes
+func (f *RoutingDstConnFactory) UpdateSubnets(name string, subnets []net.IPNet) error {
+	f.routesLock.Lock()
+	defer f.routesLock.Unlock()
+
+	for i, route := range f.routes {
+		if route.Name != name {
+			continue
+		}
+
+		var otherRoutes []RoutingDstConnRoute
+		otherRoutes = append(otherRoutes, f.routes[:i]...)
+		otherRoutes = append(otherRoutes, f.routes[i+1:]...)
+
+		route.Subnets = subnets
+
+		err := checkRouteOverlap(route, otherRoutes)
+		if err != nil {
+			return err
+		}
+
+		f.routes[i] = route
+
+		return nil
+	}
+
+	return fmt.Errorf("Expected to find route '%s'", name)
+}
+
+// UpdateAllSubnets changes subnets of multiple routes at once (keyed by route name);
+// all previous subnets are kept if any of new subnets overlap other routes
+func (f *RoutingDstConnFactory) UpdateAllSubnets(subnets map[string][]net.IPNet) error {
+	f.routesLock.Lock()
+	defer f.routesLock.Unlock()
+
+	routes := append([]RoutingDstConnRoute{}, f.routes...)
+	found := 0
+
+	for i, route := range routes {
+		if routeSubnets, ok := subnets[route.Name]; ok {
+			routes[i].Subnets = routeSubnets
+			found++
+		}
+	}
+
+	if found != len(subnets) {
+		return fmt.Errorf("Expected to find all routes")
+	}
+
+	for i, route := range routes {
+		err := checkRouteOverlap(route, routes[i+1:])
+		if err != nil {
+			return err
+		}
+	}
+
+	f.routes = routes
+
+	return nil
+}
+
+// RouteSubnets returns subnets keyed by route name
+func (f *RoutingDstConnFactory) RouteSubnets() map[string][]net.IPNet {
+	f.routesLock.RLock()
+	defer f.routesLock.RUnlock()
+
+	result := map[string][]net.IPNet{}
+	for _, route := range f.routes {
+		result[route.Name] = route.Subnets
+	}
+	return result
+}
+
+// RouteNames returns route names in order of routes
+func (f *RoutingDstConnFactory) RouteNames() []string {
+	f.routesLock.RLock()
+	defer f.routesLock.RUnlock()
+
+	var result []string
+	for _, route := range f.routes {
+		result = append(result, route.Name)
+	}
+	return result
+}
+
+func (f *RoutingDstConnFactory) Subnets() []net.IPNet {
+	f.routesLock.RLock()
+	defer f.routesLock.RUnlock()
+
+	var result []net.IPNet
+	for _, route := range f.routes {
+		result = append(result, route.Subnets...)
+	}
+	return result
+}
+
+func (f *RoutingDstConnFactory) NewConn(ip net.IP, port int) (net.Conn, error) {
+	factory, err := f.factory(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	return factory.NewConn(ip, port)
+}
+
+func (f *RoutingDstConnFactory) NewConnCopier(proxyDesc string) dstconn.ConnCopier {
+	f.routesLock.RLock()
+	defer f.routesLock.RUnlock()
+
+	// Copying does not depend on particular route, though
+	// disconnected routes only provide closing copier
+	for _, route := range f.routes {
+		copier := route.Factory.NewConnCopier(proxyDesc)
+		if _, closing := copier.(dstconn.ClosingConnCopier); !closing {
+			return copier
+		}
+	}
+
+	return dstconn.ClosingConnCopier{}
+}
+
+func (f *RoutingDstConnFactory) NewPacketConn(ip net.IP, port int) (net.Conn, error) {
+	factory, err := f.factory(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	return factory.NewPacketConn(ip, port)
+}
+
+func (f *RoutingDstConnFactory) NewListener() (net.Listener, error) {
+	return nil, fmt.Errorf("Listening is not supported when routing to multiple destinations")
+}
+
+func (f *RoutingDstConnFactory) factory(ip net.IP) (dstconn.Factory, error) {
+	f.routesLock.RLock()
+	defer f.routesLock.RUnlock()
+
+	for _, route := range f.routes {
+		for _, subnet := range route.Subnets {
+			if subnet.Contains(ip) {
+				return route.Factory, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("Expected IP '%s' to be within subnets of one of the routes", ip)
+}
+
+func checkRouteOverlap(route RoutingDstConnRoute, otherRoutes []RoutingDstConnRoute) error {
+	for _, otherRoute := range otherRoutes {
+		for _, subnet := range route.Subnets {
+			for _, otherSubnet := range otherRoute.Subnets {
+				if SubnetsOverlap(subnet, otherSubnet) {
+					return fmt.Errorf("Expected subnets to not overlap: subnet %s (%s) overlaps subnet %s (%s)",
+						subnet.String(), route.Name, otherSubnet.String(), otherRoute.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
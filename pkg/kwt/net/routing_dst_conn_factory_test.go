@@ -0,0 +1,76 @@
+package net_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	. "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
+)
+
+type fakeDstConnFactory struct {
+	name string
+}
+
+var _ dstconn.Factory = fakeDstConnFactory{}
+
+func (f fakeDstConnFactory) NewConn(net.IP, int) (net.Conn, error) {
+	return nil, fmt.Errorf("dialed %s", f.name)
+}
+
+func (f fakeDstConnFactory) NewConnCopier(string) dstconn.ConnCopier {
+	return dstconn.ClosingConnCopier{}
+}
+
+func (f fakeDstConnFactory) NewPacketConn(net.IP, int) (net.Conn, error) {
+	return nil, fmt.Errorf("dialed %s", f.name)
+}
+
+func (f fakeDstConnFactory) NewListener() (net.Listener, error) { return nil, nil }
+
+func TestRoutingDstConnFactory(t *testing.T) {
+	_, ipNet1, _ := net.ParseCIDR("10.0.0.0/16")
+	_, ipNet2, _ := net.ParseCIDR("10.1.0.0/16")
+	_, ipNet3, _ := net.ParseCIDR("10.0.5.0/24")
+
+	factory, err := NewRoutingDstConnFactory([]RoutingDstConnRoute{
+		{Name: "staging", Subnets: []net.IPNet{*ipNet1}, Factory: fakeDstConnFactory{"staging"}},
+		{Name: "dev", Subnets: []net.IPNet{*ipNet2}, Factory: fakeDstConnFactory{"dev"}},
+	})
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	_, err = factory.NewConn(net.ParseIP("10.1.2.3"), 80)
+	if err == nil || err.Error() != "dialed dev" {
+		t.Fatalf("Expected to dial dev: %s", err)
+	}
+
+	_, err = factory.NewPacketConn(net.ParseIP("10.0.2.3"), 53)
+	if err == nil || err.Error() != "dialed staging" {
+		t.Fatalf("Expected to dial staging: %s", err)
+	}
+
+	_, err = factory.NewConn(net.ParseIP("10.2.0.1"), 80)
+	if err == nil || err.Error() != "Expected IP '10.2.0.1' to be within subnets of one of the routes" {
+		t.Fatalf("Expected routing err: %s", err)
+	}
+
+	err = factory.UpdateSubnets("dev", []net.IPNet{*ipNet2, *ipNet3})
+	if err == nil || err.Error() != "Expected subnets to not overlap: subnet 10.0.5.0/24 (dev) overlaps subnet 10.0.0.0/16 (staging)" {
+		t.Fatalf("Expected overlap err: %s", err)
+	}
+
+	if SubnetsAsString(factory.Subnets()) != "10.0.0.0/16, 10.1.0.0/16" {
+		t.Fatalf("Expected subnets to be unchanged: %s", SubnetsAsString(factory.Subnets()))
+	}
+
+	_, err = NewRoutingDstConnFactory([]RoutingDstConnRoute{
+		{Name: "staging", Subnets: []net.IPNet{*ipNet1}},
+		{Name: "dev", Subnets: []net.IPNet{*ipNet3}},
+	})
+	if err == nil || err.Error() != "Expected subnets to not overlap: subnet 10.0.0.0/16 (staging) overlaps subnet 10.0.5.0/24 (dev)" {
+		t.Fatalf("Expected overlap err: %s", err)
+	}
+}
@@ -0,0 +1,142 @@
+package net
+
+import (
+	"net"
+	"os"
+	"sort"
+	"time"
+)
+
+// SessionStatus describes running 'kwt net start' or 'kwt net listen'
+type SessionStatus struct {
+	Command     string              `json:"command"`
+	PID         int                 `json:"pid"`
+	StartedAt   time.Time           `json:"started_at"`
+	Remotes     []RemoteStatus      `json:"remotes"`
+	Subnets     []string            `json:"subnets,omitempty"`
+	DNSIPs      []string            `json:"dns_ips,omitempty"`
+	DNSMappings []ControlDNSMapping `json:"dns_mappings,omitempty"`
+	ActiveConns int                 `json:"active_conns"`
+}
+
+type RemoteStatus struct {
+	Context    string            `json:"context"`
+	EntryPoint EntryPointStatus  `json:"entry_point"`
+	SSHClients []SSHClientStatus `json:"ssh_clients"`
+}
+
+type EntryPointStatus struct {
+	Type       string `json:"type"`
+	Transport  string `json:"transport,omitempty"`
+	Host       string `json:"host,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	NetPod     string `json:"net_pod,omitempty"`
+	NetPodNode string `json:"net_pod_node,omitempty"`
+
+	NetReplicas      int `json:"net_replicas,omitempty"`
+	NetReadyReplicas int `json:"net_ready_replicas,omitempty"`
+}
+
+type SSHClientStatus struct {
+	Up               bool      `json:"up"`
+	State            string    `json:"state,omitempty"` // Ready, Degraded or Reconnecting
+	ActiveChannels   int64     `json:"active_channels"`
+	LastKeepAliveAt  time.Time `json:"last_keepalive_at,omitempty"`
+	LastKeepAliveRTT string    `json:"last_keepalive_rtt,omitempty"`
+	LastKeepAliveErr string    `json:"last_keepalive_err,omitempty"`
+}
+
+type StatusRemotes interface {
+	RemoteStatuses() []RemoteStatus
+}
+
+type StatusForwarding interface {
+	ForwardedSubnets() []net.IPNet
+	InterceptedDNSIPs() []net.IP
+}
+
+type StatusConns interface {
+	ActiveConns() int
+}
+
+type SessionStatusReporterOpts struct {
+	Command string
+	Remotes StatusRemotes
+
+	// Optional depending on command
+	Forwarding StatusForwarding
+	DNS        ControlDNS
+	Conns      StatusConns
+}
+
+// SessionStatusReporter collects status from components of running session
+type SessionStatusReporter struct {
+	opts      SessionStatusReporterOpts
+	startedAt time.Time
+}
+
+var _ ControlStatus = SessionStatusReporter{}
+
+func NewSessionStatusReporter(opts SessionStatusReporterOpts) SessionStatusReporter {
+	return SessionStatusReporter{opts: opts, startedAt: time.Now()}
+}
+
+func (r SessionStatusReporter) Status() (SessionStatus, error) {
+	status := SessionStatus{
+		Command:   r.opts.Command,
+		PID:       os.Getpid(),
+		StartedAt: r.startedAt,
+	}
+
+	if r.opts.Remotes != nil {
+		status.Remotes = r.opts.Remotes.RemoteStatuses()
+	}
+
+	if r.opts.Forwarding != nil {
+		for _, subnet := range r.opts.Forwarding.ForwardedSubnets() {
+			status.Subnets = append(status.Subnets, subnet.String())
+		}
+		for _, ip := range r.opts.Forwarding.InterceptedDNSIPs() {
+			status.DNSIPs = append(status.DNSIPs, ip.String())
+		}
+	}
+
+	if r.opts.DNS != nil {
+		mappings, err := r.opts.DNS.DNSMappings()
+		if err != nil {
+			return SessionStatus{}, err
+		}
+
+		for domain, target := range mappings {
+			status.DNSMappings = append(status.DNSMappings, ControlDNSMapping{Domain: domain, Target: target})
+		}
+
+		sort.Slice(status.DNSMappings, func(i, j int) bool {
+			return status.DNSMappings[i].Domain < status.DNSMappings[j].Domain
+		})
+	}
+
+	if r.opts.Conns != nil {
+		status.ActiveConns = r.opts.Conns.ActiveConns()
+	}
+
+	return status, nil
+}
+
+// SingleRemoteStatuses reports status of a remote
+// that is connected to without RemotingProxy
+type SingleRemoteStatuses struct {
+	Context    string
+	EntryPoint EntryPoint
+	SSHClient  *ReconnSSHClient
+}
+
+var _ StatusRemotes = SingleRemoteStatuses{}
+
+func (s SingleRemoteStatuses) RemoteStatuses() []RemoteStatus {
+	return []RemoteStatus{{
+		Context:    s.Context,
+		EntryPoint: s.EntryPoint.Status(),
+		SSHClients: s.SSHClient.Status(),
+	}}
+}
@@ -0,0 +1,224 @@
+package net
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
+)
+
+const (
+	socksVersion5 = 0x05
+
+	socksMethodNoAuth       = 0x00
+	socksMethodNoAcceptable = 0xff
+
+	socksCmdConnect = 0x01
+
+	socksAddrTypeIPv4   = 0x01
+	socksAddrTypeDomain = 0x03
+	socksAddrTypeIPv6   = 0x04
+
+	socksReplySucceeded           = 0x00
+	socksReplyGeneralFailure      = 0x01
+	socksReplyHostUnreachable     = 0x04
+	socksReplyCmdNotSupported     = 0x07
+	socksReplyAddrTypeUnsupported = 0x08
+)
+
+// SOCKSProxy is a SOCKS5 server (CONNECT only, no auth) that
+// resolves destination hosts remotely (ie socks5h) via given resolver
+type SOCKSProxy struct {
+	listenAddr     string
+	resolver       HostResolver
+	dstConnFactory dstconn.Factory
+
+	listener net.Listener
+
+	logTag string
+	logger Logger
+}
+
+func NewSOCKSProxy(listenAddr string, resolver HostResolver, dstConnFactory dstconn.Factory, logger Logger) *SOCKSProxy {
+	return &SOCKSProxy{
+		listenAddr:     listenAddr,
+		resolver:       resolver,
+		dstConnFactory: dstConnFactory,
+
+		logTag: "SOCKSProxy",
+		logger: logger,
+	}
+}
+
+func (p *SOCKSProxy) Serve(startedCh chan struct{}) error {
+	listener, err := net.Listen("tcp", p.listenAddr)
+	if err != nil {
+		return fmt.Errorf("Listening on '%s': %s", p.listenAddr, err)
+	}
+
+	p.listener = listener
+
+	defer p.listener.Close()
+
+	startedCh <- struct{}{}
+
+	p.logger.Info(p.logTag, "Started SOCKS5 proxy on %s", p.Addr())
+
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return nil // listener was closed
+		}
+		go p.serveConn(conn)
+	}
+}
+
+func (p *SOCKSProxy) Addr() net.Addr { return p.listener.Addr() }
+
+func (p *SOCKSProxy) Shutdown() error {
+	if p.listener != nil {
+		p.listener.Close()
+	}
+	return nil
+}
+
+func (p *SOCKSProxy) serveConn(srcConn net.Conn) {
+	srcDesc := srcConn.RemoteAddr()
+
+	host, port, err := p.handshake(srcConn)
+	if err != nil {
+		p.logger.Error(p.logTag, "Failed SOCKS handshake with '%s': %s", srcDesc, err)
+		srcConn.Close()
+		return
+	}
+
+	dstDesc := net.JoinHostPort(host, strconv.Itoa(port))
+
+	dstConn, err := dialHost(p.resolver, p.dstConnFactory, host, port)
+	if err != nil {
+		p.logger.Error(p.logTag, "Could not establish remote connection to '%s': %s", dstDesc, err)
+		p.reply(srcConn, socksReplyHostUnreachable)
+		srcConn.Close()
+		return
+	}
+
+	err = p.reply(srcConn, socksReplySucceeded)
+	if err != nil {
+		p.logger.Error(p.logTag, "Failed replying to '%s': %s", srcDesc, err)
+		srcConn.Close()
+		dstConn.Close()
+		return
+	}
+
+	proxyDesc := fmt.Sprintf("%s->%s", srcDesc, dstDesc)
+	p.logger.Info(p.logTag, "Started %s", proxyDesc)
+
+	p.dstConnFactory.NewConnCopier(proxyDesc).CopyAndClose(dstConn, srcConn)
+
+	p.logger.Info(p.logTag, "Finished %s", proxyDesc)
+}
+
+func (p *SOCKSProxy) handshake(conn net.Conn) (string, int, error) {
+	header := make([]byte, 2)
+
+	_, err := io.ReadFull(conn, header)
+	if err != nil {
+		return "", 0, fmt.Errorf("Reading greeting: %s", err)
+	}
+
+	if header[0] != socksVersion5 {
+		return "", 0, fmt.Errorf("Expected SOCKS version 5 but was %d", header[0])
+	}
+
+	methods := make([]byte, header[1])
+
+	_, err = io.ReadFull(conn, methods)
+	if err != nil {
+		return "", 0, fmt.Errorf("Reading auth methods: %s", err)
+	}
+
+	method := byte(socksMethodNoAcceptable)
+
+	for _, m := range methods {
+		if m == socksMethodNoAuth {
+			method = socksMethodNoAuth
+		}
+	}
+
+	_, err = conn.Write([]byte{socksVersion5, method})
+	if err != nil {
+		return "", 0, fmt.Errorf("Writing auth method: %s", err)
+	}
+
+	if method == socksMethodNoAcceptable {
+		return "", 0, fmt.Errorf("Expected client to support no auth method")
+	}
+
+	req := make([]byte, 4)
+
+	_, err = io.ReadFull(conn, req)
+	if err != nil {
+		return "", 0, fmt.Errorf("Reading request: %s", err)
+	}
+
+	if req[1] != socksCmdConnect {
+		p.reply(conn, socksReplyCmdNotSupported)
+		return "", 0, fmt.Errorf("Expected CONNECT command but was %d", req[1])
+	}
+
+	var host string
+
+	switch req[3] {
+	case socksAddrTypeIPv4, socksAddrTypeIPv6:
+		ip := make([]byte, net.IPv4len)
+		if req[3] == socksAddrTypeIPv6 {
+			ip = make([]byte, net.IPv6len)
+		}
+
+		_, err = io.ReadFull(conn, ip)
+		if err != nil {
+			return "", 0, fmt.Errorf("Reading IP: %s", err)
+		}
+
+		host = net.IP(ip).String()
+
+	case socksAddrTypeDomain:
+		length := make([]byte, 1)
+
+		_, err = io.ReadFull(conn, length)
+		if err != nil {
+			return "", 0, fmt.Errorf("Reading domain length: %s", err)
+		}
+
+		domain := make([]byte, length[0])
+
+		_, err = io.ReadFull(conn, domain)
+		if err != nil {
+			return "", 0, fmt.Errorf("Reading domain: %s", err)
+		}
+
+		host = string(domain)
+
+	default:
+		p.reply(conn, socksReplyAddrTypeUnsupported)
+		return "", 0, fmt.Errorf("Unknown address type %d", req[3])
+	}
+
+	portBytes := make([]byte, 2)
+
+	_, err = io.ReadFull(conn, portBytes)
+	if err != nil {
+		return "", 0, fmt.Errorf("Reading port: %s", err)
+	}
+
+	return host, int(binary.BigEndian.Uint16(portBytes)), nil
+}
+
+// reply does not include bound address since clients do not use it for CONNECT
+func (p *SOCKSProxy) reply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socksVersion5, code, 0x00, socksAddrTypeIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,110 @@
+package net_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/logtest"
+	. "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
+)
+
+type fakeHostResolver struct {
+	hosts map[string]net.IP
+}
+
+func (r fakeHostResolver) LookupIP(host string) ([]net.IP, error) {
+	if ip, found := r.hosts[host]; found {
+		return []net.IP{ip}, nil
+	}
+	return nil, fmt.Errorf("not found")
+}
+
+func startEchoBackend(t *testing.T) (net.Listener, int) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	return backend, backend.Addr().(*net.TCPAddr).Port
+}
+
+func expectEcho(t *testing.T, conn io.ReadWriter) {
+	_, err := conn.Write([]byte("ping"))
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	buf := make([]byte, 4)
+
+	_, err = io.ReadFull(conn, buf)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	if string(buf) != "ping" {
+		t.Fatalf("Expected echoed bytes: %s", buf)
+	}
+}
+
+func TestSOCKSProxy(t *testing.T) {
+	backend, port := startEchoBackend(t)
+	defer backend.Close()
+
+	resolver := fakeHostResolver{map[string]net.IP{"svc.ns.svc.cluster.local": net.ParseIP("127.0.0.1")}}
+	proxy := NewSOCKSProxy("127.0.0.1:0", resolver, dstconn.NewLocal(logtest.NoopLogger{}), logtest.NoopLogger{})
+
+	defer proxy.Shutdown()
+
+	startedCh := make(chan struct{})
+	go proxy.Serve(startedCh)
+	<-startedCh
+
+	conn, err := net.Dial("tcp", proxy.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer conn.Close()
+
+	host := "svc.ns.svc.cluster.local"
+
+	req := []byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00, 0x03, byte(len(host))}
+	req = append(req, []byte(host)...)
+	req = append(req, 0, 0)
+	binary.BigEndian.PutUint16(req[len(req)-2:], uint16(port))
+
+	_, err = conn.Write(req)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	resp := make([]byte, 2+10)
+
+	_, err = io.ReadFull(conn, resp)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	if resp[1] != 0x00 || resp[3] != 0x00 {
+		t.Fatalf("Expected successful replies: %v", resp)
+	}
+
+	expectEcho(t, conn)
+}
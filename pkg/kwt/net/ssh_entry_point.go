@@ -18,7 +18,12 @@ func (f SSHEntryPoint) EntryPoint() (EntryPointSession, error) {
 	return SSHEntryPointSession{f.opts}, nil
 }
 
-func (f SSHEntryPoint) Delete() error { return nil }
+func (f SSHEntryPoint) Status() EntryPointStatus {
+	return EntryPointStatus{Type: "ssh", Host: f.opts.Host}
+}
+
+func (f SSHEntryPoint) Delete() error  { return nil }
+func (f SSHEntryPoint) Release() error { return nil }
 
 type SSHEntryPointSession struct {
 	opts dstconn.SSHClientConnOpts
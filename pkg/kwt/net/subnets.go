@@ -13,7 +13,7 @@ func LocalIPs() ([]net.IP, error) {
 		return nil, fmt.Errorf("Fetching local interface addrs: %s", err)
 	}
 
-	var localIPv4s []net.IP
+	var localIPs []net.IP
 
 	for _, addr := range addrs {
 		ip, _, err := net.ParseCIDR(addr.String())
@@ -21,38 +21,60 @@ func LocalIPs() ([]net.IP, error) {
 			return nil, err
 		}
 
+		if ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			continue
+		}
+
 		ipv4 := ip.To4()
 		if ipv4 != nil {
-			if !ipv4.IsLoopback() {
-				localIPv4s = append(localIPv4s, ipv4)
-			}
+			localIPs = append(localIPs, ipv4)
+		} else {
+			localIPs = append(localIPs, ip)
 		}
 	}
 
-	return localIPv4s, nil
+	return localIPs, nil
 }
 
-func GuessSubnets(remoteIPs []net.IP, excludedIPs []net.IP) []net.IPNet {
+// GuessSubnets never returns subnets that contain excluded IPs or overlap excluded subnets
+func GuessSubnets(remoteIPs []net.IP, excludedIPs []net.IP, excludedSubnets []net.IPNet) []net.IPNet {
 	largestToSmallestMasks := []net.IPMask{
 		net.CIDRMask(14, 32), // default on GKE
 		net.CIDRMask(16, 32),
 		net.CIDRMask(24, 32),
 	}
+	largestToSmallestIPv6Masks := []net.IPMask{
+		net.CIDRMask(56, 128),
+		net.CIDRMask(64, 128),
+		net.CIDRMask(112, 128), // common service range size
+	}
 	var possibleNets []net.IPNet
 
 	// Guess ranges that would satsify remote IPs but not overlap with local IPs
 	for _, remoteIP := range remoteIPs {
-		for _, mask := range largestToSmallestMasks {
-			possibleNet := net.IPNet{remoteIP, mask}
+		masks := largestToSmallestMasks
+		if remoteIP.To4() == nil {
+			masks = largestToSmallestIPv6Masks
+		}
+
+		for _, mask := range masks {
+			// Host bits are cleared so that the same network is always
+			// described the same way regardless of which remote IP was seen first
+			possibleNet := net.IPNet{IP: remoteIP.Mask(mask), Mask: mask}
 
-			overlapsLocal := false
+			overlapsExcluded := false
 			for _, excludedIP := range excludedIPs {
 				if possibleNet.Contains(excludedIP) {
-					overlapsLocal = true
+					overlapsExcluded = true
+				}
+			}
+			for _, excludedSubnet := range excludedSubnets {
+				if SubnetsOverlap(possibleNet, excludedSubnet) {
+					overlapsExcluded = true
 				}
 			}
 
-			if !overlapsLocal {
+			if !overlapsExcluded {
 				possibleNets = append(possibleNets, possibleNet)
 				break // continue with the next remote IP
 			}
@@ -84,6 +106,98 @@ func GuessSubnets(remoteIPs []net.IP, excludedIPs []net.IP) []net.IPNet {
 	return selectedNets
 }
 
+func SubnetsOverlap(a, b net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// HostSubnets returns a single host subnet (/32 or /128) per unique IP
+func HostSubnets(ips []net.IP) []net.IPNet {
+	var result []net.IPNet
+	seen := map[string]struct{}{}
+
+	for _, ip := range ips {
+		mask := net.CIDRMask(128, 128)
+		if ipv4 := ip.To4(); ipv4 != nil {
+			ip = ipv4
+			mask = net.CIDRMask(32, 32)
+		}
+
+		if _, found := seen[ip.String()]; found {
+			continue
+		}
+
+		seen[ip.String()] = struct{}{}
+		result = append(result, net.IPNet{IP: ip, Mask: mask})
+	}
+
+	return result
+}
+
+// DiffSubnets returns subnets that are only in newSubnets and only in oldSubnets.
+// Subnets are compared without host bits (eg 10.0.3.7/16 equals 10.0.1.2/16)
+// since forwarders treat them as the same network.
+func DiffSubnets(oldSubnets, newSubnets []net.IPNet) ([]net.IPNet, []net.IPNet) {
+	oldStrs := map[string]struct{}{}
+	for _, subnet := range oldSubnets {
+		oldStrs[maskedSubnetStr(subnet)] = struct{}{}
+	}
+
+	newStrs := map[string]struct{}{}
+	for _, subnet := range newSubnets {
+		newStrs[maskedSubnetStr(subnet)] = struct{}{}
+	}
+
+	var added, removed []net.IPNet
+
+	for _, subnet := range newSubnets {
+		if _, found := oldStrs[maskedSubnetStr(subnet)]; !found {
+			added = append(added, subnet)
+		}
+	}
+
+	for _, subnet := range oldSubnets {
+		if _, found := newStrs[maskedSubnetStr(subnet)]; !found {
+			removed = append(removed, subnet)
+		}
+	}
+
+	return added, removed
+}
+
+// CollapseSubnets drops duplicate subnets and subnets within other subnets
+// since forwarders (eg nftables interval sets) reject overlapping subnets
+func CollapseSubnets(subnets []net.IPNet) []net.IPNet {
+	var result []net.IPNet
+	seen := map[string]struct{}{}
+
+	for _, subnet := range subnets {
+		ones, bits := subnet.Mask.Size()
+		contained := false
+
+		for _, otherSubnet := range subnets {
+			otherOnes, otherBits := otherSubnet.Mask.Size()
+			if otherBits == bits && otherOnes < ones && otherSubnet.Contains(subnet.IP) {
+				contained = true
+				break
+			}
+		}
+
+		if _, found := seen[maskedSubnetStr(subnet)]; found || contained {
+			continue
+		}
+
+		seen[maskedSubnetStr(subnet)] = struct{}{}
+		result = append(result, subnet)
+	}
+
+	return result
+}
+
+func maskedSubnetStr(subnet net.IPNet) string {
+	masked := net.IPNet{IP: subnet.IP.Mask(subnet.Mask), Mask: subnet.Mask}
+	return masked.String()
+}
+
 func SubnetsAsString(subnets []net.IPNet) string {
 	var result []string
 	for _, n := range subnets {
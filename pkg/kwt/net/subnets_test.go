@@ -11,14 +11,104 @@ func TestGuessSubnets(t *testing.T) {
 	excludedIPs := []net.IP{net.ParseIP("10.80.130.76")}
 
 	inputIPs := []net.IP{net.ParseIP("10.200.37.33"), net.ParseIP("10.100.200.141")}
-	subnets := GuessSubnets(inputIPs, excludedIPs)
-	if SubnetsAsString(subnets) != "10.200.37.33/14, 10.100.200.141/14" {
+	subnets := GuessSubnets(inputIPs, excludedIPs, nil)
+	if SubnetsAsString(subnets) != "10.200.0.0/14, 10.100.0.0/14" {
 		t.Fatalf("did not guess subnets correctly: %s", SubnetsAsString(subnets))
 	}
 
 	inputIPs = []net.IP{net.ParseIP("10.200.37.33"), net.ParseIP("10.200.100.141")}
-	subnets = GuessSubnets(inputIPs, excludedIPs)
-	if SubnetsAsString(subnets) != "10.200.37.33/14" {
+	subnets = GuessSubnets(inputIPs, excludedIPs, nil)
+	if SubnetsAsString(subnets) != "10.200.0.0/14" {
 		t.Fatalf("did not guess subnets correctly: %s", SubnetsAsString(subnets))
 	}
 }
+
+func TestGuessSubnetsExcludedSubnets(t *testing.T) {
+	_, excludedSubnet, _ := net.ParseCIDR("10.201.5.0/24")
+
+	inputIPs := []net.IP{net.ParseIP("10.200.37.33"), net.ParseIP("10.201.5.10")}
+	subnets := GuessSubnets(inputIPs, nil, []net.IPNet{*excludedSubnet})
+	if SubnetsAsString(subnets) != "10.200.0.0/16" {
+		t.Fatalf("did not guess subnets correctly: %s", SubnetsAsString(subnets))
+	}
+}
+
+func TestGuessSubnetsIPv6(t *testing.T) {
+	excludedIPs := []net.IP{net.ParseIP("10.80.130.76"), net.ParseIP("fd00:10:80::5")}
+
+	inputIPs := []net.IP{
+		net.ParseIP("10.200.37.33"),
+		net.ParseIP("fd00:10:96::a"),
+		net.ParseIP("fd00:10:96::b"),
+		net.ParseIP("fd00:10:80:1::5"),
+	}
+	subnets := GuessSubnets(inputIPs, excludedIPs, nil)
+	if SubnetsAsString(subnets) != "10.200.0.0/14, fd00:10:96::/56, fd00:10:80:1::/64" {
+		t.Fatalf("did not guess subnets correctly: %s", SubnetsAsString(subnets))
+	}
+}
+
+func TestDiffSubnets(t *testing.T) {
+	_, ipNet1, _ := net.ParseCIDR("10.0.0.0/24")
+	_, ipNet2, _ := net.ParseCIDR("10.1.0.0/24")
+	_, ipNet3, _ := net.ParseCIDR("10.2.0.0/24")
+
+	added, removed := DiffSubnets([]net.IPNet{*ipNet1, *ipNet2}, []net.IPNet{*ipNet3, *ipNet2})
+	if SubnetsAsString(added) != "10.2.0.0/24" {
+		t.Fatalf("did not find added subnets correctly: %s", SubnetsAsString(added))
+	}
+	if SubnetsAsString(removed) != "10.0.0.0/24" {
+		t.Fatalf("did not find removed subnets correctly: %s", SubnetsAsString(removed))
+	}
+
+	added, removed = DiffSubnets([]net.IPNet{*ipNet1, *ipNet2}, []net.IPNet{*ipNet2, *ipNet1})
+	if len(added) != 0 || len(removed) != 0 {
+		t.Fatalf("expected no changes: %s / %s", SubnetsAsString(added), SubnetsAsString(removed))
+	}
+}
+
+func TestGuessSubnetsDiffIgnoresRemoteIPsOrder(t *testing.T) {
+	oldSubnets := GuessSubnets([]net.IP{net.ParseIP("10.0.3.7"), net.ParseIP("10.0.1.2")}, nil, nil)
+	newSubnets := GuessSubnets([]net.IP{net.ParseIP("10.0.1.2"), net.ParseIP("10.0.3.7")}, nil, nil)
+
+	added, removed := DiffSubnets(oldSubnets, newSubnets)
+	if len(added) != 0 || len(removed) != 0 {
+		t.Fatalf("expected no changes: %s / %s", SubnetsAsString(added), SubnetsAsString(removed))
+	}
+}
+
+func TestDiffSubnetsIgnoresHostBits(t *testing.T) {
+	ip1, ipNet1, _ := net.ParseCIDR("10.0.3.7/16")
+	ip2, ipNet2, _ := net.ParseCIDR("10.0.1.2/16")
+	_, ipNet3, _ := net.ParseCIDR("fd00:10:96::a/112")
+
+	withHostBits1 := net.IPNet{IP: ip1, Mask: ipNet1.Mask}
+	withHostBits2 := net.IPNet{IP: ip2, Mask: ipNet2.Mask}
+
+	added, removed := DiffSubnets([]net.IPNet{withHostBits1, *ipNet3}, []net.IPNet{*ipNet3, withHostBits2})
+	if len(added) != 0 || len(removed) != 0 {
+		t.Fatalf("expected no changes: %s / %s", SubnetsAsString(added), SubnetsAsString(removed))
+	}
+}
+
+func TestHostSubnets(t *testing.T) {
+	inputIPs := []net.IP{
+		net.ParseIP("10.200.37.33"),
+		net.ParseIP("fd00:10:96::a"),
+		net.ParseIP("10.200.37.33"),
+	}
+	subnets := HostSubnets(inputIPs)
+	if SubnetsAsString(subnets) != "10.200.37.33/32, fd00:10:96::a/128" {
+		t.Fatalf("did not produce host subnets correctly: %s", SubnetsAsString(subnets))
+	}
+}
+
+func TestCollapseSubnets(t *testing.T) {
+	subnets, _ := NewConfiguredSubnets([]string{"10.1.0.0/16", "10.0.0.0/14", "10.0.0.0/14",
+		"10.8.0.0/16", "fd00::/64", "fd00::/56", "fd01::/64"}).Subnets()
+
+	collapsed := CollapseSubnets(subnets)
+	if SubnetsAsString(collapsed) != "10.0.0.0/14, 10.8.0.0/16, fd00::/56, fd01::/64" {
+		t.Fatalf("did not collapse subnets correctly: %s", SubnetsAsString(collapsed))
+	}
+}
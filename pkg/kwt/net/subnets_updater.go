@@ -0,0 +1,85 @@
+package net
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/net/forwarder"
+)
+
+// SubnetsUpdater keeps forwarder's subnets in sync with desired subnets.
+// Nested subnets are collapsed and subnets are removed before new ones are added
+// so that forwarders never see overlapping subnets (eg /14 narrowed down to /16).
+type SubnetsUpdater struct {
+	forwarder forwarder.Forwarder
+	applied   []net.IPNet
+
+	logTag string
+	logger Logger
+}
+
+func NewSubnetsUpdater(forwarder forwarder.Forwarder, logger Logger) *SubnetsUpdater {
+	return &SubnetsUpdater{forwarder: forwarder, logTag: "SubnetsUpdater", logger: logger}
+}
+
+// Add forwards initial subnets (and DNS IPs) via forwarder's Add
+func (u *SubnetsUpdater) Add(subnets []net.IPNet, dnsIPs []net.IP) error {
+	collapsedSubnets := CollapseSubnets(subnets)
+
+	err := u.forwarder.Add(collapsedSubnets, dnsIPs)
+	if err != nil {
+		return err
+	}
+
+	u.applied = collapsedSubnets
+
+	return nil
+}
+
+// Update returns subnets that were actually added and removed
+// even if it fails to apply all changes; failed changes
+// are retried with the next update
+func (u *SubnetsUpdater) Update(subnets []net.IPNet) ([]net.IPNet, []net.IPNet, error) {
+	added, removed := DiffSubnets(u.applied, CollapseSubnets(subnets))
+
+	var errs []string
+
+	if len(removed) > 0 {
+		u.logger.Info(u.logTag, "Removing subnets: %s", SubnetsAsString(removed))
+
+		err := u.forwarder.RemoveSubnets(removed)
+		if err != nil {
+			// Forwarders fail to remove subnets that are already gone;
+			// keeping them would fail every later update
+			errs = append(errs, fmt.Sprintf("Removing subnets: %s", err))
+		}
+
+		u.applied, _ = DiffSubnets(removed, u.applied)
+	}
+
+	var addedApplied []net.IPNet
+
+	if len(added) > 0 {
+		u.logger.Info(u.logTag, "Adding subnets: %s", SubnetsAsString(added))
+
+		err := u.forwarder.AddSubnets(added)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("Adding subnets: %s", err))
+		} else {
+			addedApplied = added
+			u.applied = append(u.applied, added...)
+		}
+	}
+
+	if len(errs) > 0 {
+		return addedApplied, removed, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+
+	return addedApplied, removed, nil
+}
+
+// Applied returns subnets that forwarder currently forwards
+func (u *SubnetsUpdater) Applied() []net.IPNet {
+	return append([]net.IPNet{}, u.applied...)
+}
@@ -0,0 +1,162 @@
+package net_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/logtest"
+	. "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/forwarder"
+)
+
+// fakeIntervalForwarder rejects overlapping subnets and
+// removal of missing subnets like nftables interval sets
+type fakeIntervalForwarder struct {
+	subnets []net.IPNet
+
+	// failAdd fails adding of subnet once
+	failAdd string
+}
+
+var _ forwarder.Forwarder = &fakeIntervalForwarder{}
+
+func (f *fakeIntervalForwarder) CheckPrereqs() error { return nil }
+
+func (f *fakeIntervalForwarder) Add(subnets []net.IPNet, _ []net.IP) error {
+	f.subnets = nil
+	return f.AddSubnets(subnets)
+}
+
+func (f *fakeIntervalForwarder) AddSubnets(subnets []net.IPNet) error {
+	for _, subnet := range subnets {
+		if subnet.String() == f.failAdd {
+			f.failAdd = ""
+			return fmt.Errorf("Failed adding %s", subnet.String())
+		}
+		for _, existingSubnet := range f.subnets {
+			if SubnetsOverlap(subnet, existingSubnet) {
+				return fmt.Errorf("Conflicting intervals %s and %s", subnet.String(), existingSubnet.String())
+			}
+		}
+		f.subnets = append(f.subnets, subnet)
+	}
+	return nil
+}
+
+func (f *fakeIntervalForwarder) RemoveSubnets(subnets []net.IPNet) error {
+	for _, subnet := range subnets {
+		remaining, _ := DiffSubnets([]net.IPNet{subnet}, f.subnets)
+		if len(remaining) == len(f.subnets) {
+			return fmt.Errorf("Missing interval %s", subnet.String())
+		}
+		f.subnets = remaining
+	}
+	return nil
+}
+
+func (f *fakeIntervalForwarder) Reset() error { return nil }
+
+func parseSubnets(t *testing.T, subnetStrs ...string) []net.IPNet {
+	subnets, err := NewConfiguredSubnets(subnetStrs).Subnets()
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+	return subnets
+}
+
+func TestSubnetsUpdaterOverlappingSubnets(t *testing.T) {
+	fwd := &fakeIntervalForwarder{}
+	updater := NewSubnetsUpdater(fwd, logtest.NoopLogger{})
+
+	err := updater.Add(parseSubnets(t, "10.0.0.0/14", "10.1.0.0/16", "10.8.0.0/16"), nil)
+	if err != nil {
+		t.Fatalf("Expected nested subnet to be collapsed: %s", err)
+	}
+
+	examples := []struct {
+		desc      string
+		subnets   []string
+		forwarded string
+	}{
+		{
+			desc:      "guessed subnet narrowed down",
+			subnets:   []string{"10.0.0.0/16", "10.8.0.0/16"},
+			forwarded: "10.8.0.0/16, 10.0.0.0/16",
+		},
+		{
+			desc:      "subnet added within forwarded subnet",
+			subnets:   []string{"10.0.0.0/16", "10.8.0.0/16", "10.0.5.0/24"},
+			forwarded: "10.8.0.0/16, 10.0.0.0/16",
+		},
+		{
+			desc:      "enclosing subnet removed",
+			subnets:   []string{"10.8.0.0/16", "10.0.5.0/24"},
+			forwarded: "10.8.0.0/16, 10.0.5.0/24",
+		},
+		{
+			desc:      "subnet widened",
+			subnets:   []string{"10.8.0.0/14", "10.0.5.0/24"},
+			forwarded: "10.0.5.0/24, 10.8.0.0/14",
+		},
+	}
+
+	for _, ex := range examples {
+		_, _, err := updater.Update(parseSubnets(t, ex.subnets...))
+		if err != nil {
+			t.Fatalf("[%s] Expected no err: %s", ex.desc, err)
+		}
+
+		if SubnetsAsString(fwd.subnets) != ex.forwarded || SubnetsAsString(updater.Applied()) != ex.forwarded {
+			t.Fatalf("[%s] Expected forwarded subnets '%s', but was '%s' (applied '%s')",
+				ex.desc, ex.forwarded, SubnetsAsString(fwd.subnets), SubnetsAsString(updater.Applied()))
+		}
+	}
+}
+
+func TestSubnetsUpdaterFailedUpdates(t *testing.T) {
+	fwd := &fakeIntervalForwarder{}
+	updater := NewSubnetsUpdater(fwd, logtest.NoopLogger{})
+
+	err := updater.Add(parseSubnets(t, "10.0.0.0/16", "10.1.0.0/16"), nil)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	fwd.failAdd = "10.2.0.0/16"
+
+	added, removed, err := updater.Update(parseSubnets(t, "10.0.0.0/16", "10.2.0.0/16"))
+	if err == nil {
+		t.Fatalf("Expected err adding subnet")
+	}
+
+	// Removal is applied even though addition failed
+	if len(added) != 0 || SubnetsAsString(removed) != "10.1.0.0/16" || SubnetsAsString(fwd.subnets) != "10.0.0.0/16" {
+		t.Fatalf("Expected only removal to be applied: added '%s', removed '%s', forwarded '%s'",
+			SubnetsAsString(added), SubnetsAsString(removed), SubnetsAsString(fwd.subnets))
+	}
+
+	// Later changes are applied along with retried failed ones
+	added, _, err = updater.Update(parseSubnets(t, "10.0.0.0/16", "10.2.0.0/16", "10.3.0.0/16"))
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	if SubnetsAsString(added) != "10.2.0.0/16, 10.3.0.0/16" || SubnetsAsString(fwd.subnets) != "10.0.0.0/16, 10.2.0.0/16, 10.3.0.0/16" {
+		t.Fatalf("Expected failed subnet to be retried: added '%s', forwarded '%s'",
+			SubnetsAsString(added), SubnetsAsString(fwd.subnets))
+	}
+
+	// Subnets already gone from forwarder do not block later updates
+	fwd.subnets = parseSubnets(t, "10.2.0.0/16", "10.3.0.0/16")
+
+	_, _, err = updater.Update(parseSubnets(t, "10.2.0.0/16", "10.3.0.0/16"))
+	if err == nil {
+		t.Fatalf("Expected err removing missing subnet")
+	}
+
+	_, _, err = updater.Update(parseSubnets(t, "10.2.0.0/16", "10.3.0.0/16", "10.4.0.0/16"))
+	if err != nil || SubnetsAsString(fwd.subnets) != "10.2.0.0/16, 10.3.0.0/16, 10.4.0.0/16" {
+		t.Fatalf("Expected subnets to be added: %v (forwarded '%s')", err, SubnetsAsString(fwd.subnets))
+	}
+}
@@ -4,18 +4,32 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/carvel-dev/kwt/pkg/kwt/net/accesslog"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/capture"
 	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/fault"
 	"github.com/carvel-dev/kwt/pkg/kwt/net/forwarder"
 )
 
 type TCPProxy struct {
 	origDstResolver forwarder.OriginalDstResolver
 	dstConnFactory  dstconn.Factory
+	capturer        *capture.Capturer
+	injector        *fault.Injector
+	accessLog       *accesslog.Log
+	metricsDsts     *MetricsDestinations
 
 	listener net.Listener
 
+	// Connections that are being proxied keyed by source connection
+	conns     map[net.Conn]*proxiedConn
+	connsLock sync.Mutex
+
 	logTag string
 	logger Logger
 }
@@ -28,14 +42,39 @@ func NewTCPProxy(
 	return &TCPProxy{
 		origDstResolver: origDstResolver,
 		dstConnFactory:  dstConnFactory,
+		conns:           map[net.Conn]*proxiedConn{},
 
 		logTag: "TCPProxy",
 		logger: logger,
 	}
 }
 
+// WithCapturer records proxied connections accepted by capturer
+func (c *TCPProxy) WithCapturer(capturer *capture.Capturer) *TCPProxy {
+	c.capturer = capturer
+	return c
+}
+
+// WithInjector applies fault rules to proxied connections
+func (c *TCPProxy) WithInjector(injector *fault.Injector) *TCPProxy {
+	c.injector = injector
+	return c
+}
+
+// WithAccessLog records finished connections into access log
+func (c *TCPProxy) WithAccessLog(accessLog *accesslog.Log) *TCPProxy {
+	c.accessLog = accessLog
+	return c
+}
+
+// WithMetricsDestinations labels connection metrics with their destinations
+func (c *TCPProxy) WithMetricsDestinations(metricsDsts *MetricsDestinations) *TCPProxy {
+	c.metricsDsts = metricsDsts
+	return c
+}
+
 func (c *TCPProxy) Serve(startedCh chan struct{}) error {
-	listener, err := net.Listen("tcp", "localhost:0")
+	listener, err := NewLoopbackListener(c.logger)
 	if err != nil {
 		return err
 	}
@@ -67,14 +106,106 @@ func (c *TCPProxy) ServeListener(listener net.Listener, startedCh chan struct{})
 
 func (c *TCPProxy) Addr() net.Addr { return c.listener.Addr() }
 
+// Shutdown stops accepting new connections; use Drain to wait for existing ones
 func (c *TCPProxy) Shutdown() error {
-	// TODO drain connections?
 	if c.listener != nil {
 		c.listener.Close()
 	}
 	return nil
 }
 
+// Drain waits for proxied connections to finish until timeout
+// or until forceCh is closed, then closes remaining connections
+func (c *TCPProxy) Drain(timeout time.Duration, forceCh chan struct{}) {
+	timeoutCh := time.After(timeout)
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		count := c.ActiveConns()
+		if count == 0 {
+			return
+		}
+
+		c.logger.Info(c.logTag, "Waiting for %d connection(s) to finish", count)
+
+		select {
+		case <-ticker.C:
+		case <-timeoutCh:
+			c.closeConns("drain timeout reached", accesslog.CloseReasonDrainTimeout)
+			return
+		case <-forceCh:
+			c.closeConns("forced", accesslog.CloseReasonForced)
+			return
+		}
+	}
+}
+
+func (c *TCPProxy) ActiveConns() int {
+	c.connsLock.Lock()
+	defer c.connsLock.Unlock()
+
+	return len(c.conns)
+}
+
+// Conns returns currently proxied connections
+func (c *TCPProxy) Conns() []ProxiedConn {
+	c.connsLock.Lock()
+	defer c.connsLock.Unlock()
+
+	var result []ProxiedConn
+
+	for _, conn := range c.conns {
+		result = append(result, conn.Info())
+	}
+
+	return result
+}
+
+// CloseConn closes proxied connection with given ID
+func (c *TCPProxy) CloseConn(id uint64) bool {
+	c.connsLock.Lock()
+	defer c.connsLock.Unlock()
+
+	for _, conn := range c.conns {
+		if conn.id == id {
+			c.logger.Info(c.logTag, "Closing connection %d (%s->%s)", id, conn.src, conn.dst)
+			conn.CloseWithReason(accesslog.CloseReasonClosed)
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *TCPProxy) closeConns(desc, reason string) {
+	c.connsLock.Lock()
+	defer c.connsLock.Unlock()
+
+	if len(c.conns) > 0 {
+		c.logger.Info(c.logTag, "Closing %d remaining connection(s) (%s)", len(c.conns), desc)
+	}
+
+	for _, conn := range c.conns {
+		conn.CloseWithReason(reason)
+	}
+}
+
+func (c *TCPProxy) trackConn(srcConn net.Conn, conn *proxiedConn) {
+	c.connsLock.Lock()
+	defer c.connsLock.Unlock()
+
+	c.conns[srcConn] = conn
+}
+
+func (c *TCPProxy) untrackConn(srcConn net.Conn) {
+	c.connsLock.Lock()
+	defer c.connsLock.Unlock()
+
+	delete(c.conns, srcConn)
+}
+
 func (c *TCPProxy) serveConn(srcConn net.Conn, t1 time.Time) {
 	srcDesc := srcConn.RemoteAddr()
 	c.logger.Info(c.logTag, "Received %s", srcDesc)
@@ -82,21 +213,83 @@ func (c *TCPProxy) serveConn(srcConn net.Conn, t1 time.Time) {
 	origDstIP, origDstPort, err := c.origDstResolver.GetOrigIPPort(srcConn)
 	if err != nil {
 		c.logger.Error(c.logTag, "Could not retrieve original destination from '%s': %s", srcDesc, err)
+		tcpProxyDialFailuresTotal.Inc("original_destination")
 		srcConn.Close()
 		return
 	}
 
-	dstDesc := fmt.Sprintf("%s:%d", origDstIP, origDstPort)
+	dstDesc := net.JoinHostPort(origDstIP.String(), strconv.Itoa(origDstPort))
+	dstAddr := &net.TCPAddr{IP: origDstIP, Port: origDstPort}
+	connID := atomic.AddUint64(&proxiedConnLastID, 1)
+
+	var accessLogConn *accesslog.Conn
+
+	if c.accessLog != nil {
+		accessLogConn = c.accessLog.Start(connID, c.tcpAddr(srcDesc), dstAddr, t1)
+	}
+
+	var faultRule fault.Rule
+	var hasFaultRule bool
+
+	if c.injector != nil {
+		faultRule, hasFaultRule = c.injector.Match(origDstIP, origDstPort)
+
+		if hasFaultRule && c.injector.Refuse(faultRule) {
+			c.logger.Info(c.logTag, "Refusing connection to '%s' (fault: %s)", dstDesc, faultRule)
+			fault.Reset(srcConn)
+			c.finishAccessLog(accessLogConn, accesslog.ConnResult{CloseReason: accesslog.CloseReasonRefused})
+			return
+		}
+	}
 
 	dstConn, err := c.dstConnFactory.NewConn(origDstIP, origDstPort)
 	if err != nil {
 		c.logger.Error(c.logTag, "Could not establish remote connection to '%s': %s", dstDesc, err)
+		tcpProxyDialFailuresTotal.Inc(dialFailureReason(err))
 		srcConn.Close()
+		c.finishAccessLog(accessLogConn, accesslog.ConnResult{CloseReason: accesslog.CloseReasonDialFailed, Err: err})
 		return
 	}
 
 	t2 := time.Now()
 
+	tcpProxyDialSeconds.Observe(t2.Sub(t1).Seconds())
+	dstLabel := MetricsDestinationOther
+
+	if c.metricsDsts != nil {
+		dstLabel = c.metricsDsts.Label(origDstIP)
+	}
+
+	tcpProxyConnsTotal.Inc(dstLabel)
+	tcpProxyConnsActive.Inc(dstLabel)
+
+	countingSrcConn := &countingConn{Conn: srcConn}
+
+	conn := &proxiedConn{
+		id:        connID,
+		src:       srcDesc.String(),
+		dst:       dstDesc,
+		startedAt: t2,
+		srcConn:   countingSrcConn,
+		dstConn:   dstConn,
+	}
+
+	c.trackConn(srcConn, conn)
+	defer c.untrackConn(srcConn)
+
+	defer func() {
+		tcpProxyConnsActive.Dec(dstLabel)
+		tcpProxyBytesTotal.Add(float64(countingSrcConn.read), dstLabel, "out")
+		tcpProxyBytesTotal.Add(float64(countingSrcConn.written), dstLabel, "in")
+
+		c.finishAccessLog(accessLogConn, accesslog.ConnResult{
+			DialedAt:    t2,
+			BytesOut:    atomic.LoadUint64(&countingSrcConn.read),
+			BytesIn:     atomic.LoadUint64(&countingSrcConn.written),
+			CloseReason: conn.CloseReason(),
+		})
+	}()
+
 	proxyDesc := fmt.Sprintf("%s->%s", srcDesc, dstDesc)
 	c.logger.Info(c.logTag, "Started %s", proxyDesc)
 
@@ -105,5 +298,116 @@ func (c *TCPProxy) serveConn(srcConn net.Conn, t1 time.Time) {
 		c.logger.Info(c.logTag, "Finished %s (%s/%s)", proxyDesc, t2.Sub(t1), t3.Sub(t2))
 	}()
 
-	c.dstConnFactory.NewConnCopier(proxyDesc).CopyAndClose(dstConn, srcConn)
+	var copySrcConn net.Conn = countingSrcConn
+
+	if hasFaultRule {
+		c.logger.Info(c.logTag, "Injecting faults into %s: %s", proxyDesc, faultRule)
+
+		copySrcConn = fault.NewConn(countingSrcConn, faultRule, c.injector)
+
+		if faultRule.ResetAfter > 0 {
+			resetTimer := time.AfterFunc(faultRule.ResetAfter, func() {
+				c.logger.Info(c.logTag, "Resetting %s (fault: %s)", proxyDesc, faultRule)
+				conn.setCloseReason(accesslog.CloseReasonReset)
+				fault.Reset(srcConn)
+				dstConn.Close()
+			})
+			defer resetTimer.Stop()
+		}
+	}
+
+	copier := c.dstConnFactory.NewConnCopier(proxyDesc)
+
+	if c.capturer != nil {
+		connCapture := c.capturer.Start(connID, c.tcpAddr(srcDesc), dstAddr)
+		if connCapture != nil {
+			copier = capture.NewConnCopier(copier, connCapture, c.logger)
+		}
+	}
+
+	copier.CopyAndClose(dstConn, copySrcConn)
+}
+
+func (c *TCPProxy) finishAccessLog(conn *accesslog.Conn, result accesslog.ConnResult) {
+	if c.accessLog != nil {
+		c.accessLog.Finish(conn, result)
+	}
+}
+
+// tcpAddr falls back to unspecified address for listeners
+// that do not report source as TCP address
+func (*TCPProxy) tcpAddr(addr net.Addr) *net.TCPAddr {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr
+	}
+
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr.String())
+	if err != nil {
+		return &net.TCPAddr{IP: net.IPv4zero}
+	}
+
+	return tcpAddr
+}
+
+// ProxiedConn describes connection being proxied;
+// bytes are counted from the perspective of destination
+type ProxiedConn struct {
+	ID        uint64    `json:"id"`
+	Src       string    `json:"src"`
+	Dst       string    `json:"dst"`
+	StartedAt time.Time `json:"started_at"`
+	BytesOut  uint64    `json:"bytes_out"`
+	BytesIn   uint64    `json:"bytes_in"`
+}
+
+var proxiedConnLastID uint64 // accessed atomically
+
+type proxiedConn struct {
+	id        uint64
+	src, dst  string
+	startedAt time.Time
+
+	srcConn *countingConn
+	dstConn net.Conn
+
+	closeReason     string
+	closeReasonLock sync.Mutex
+}
+
+func (c *proxiedConn) Info() ProxiedConn {
+	return ProxiedConn{
+		ID:        c.id,
+		Src:       c.src,
+		Dst:       c.dst,
+		StartedAt: c.startedAt,
+		BytesOut:  atomic.LoadUint64(&c.srcConn.read),
+		BytesIn:   atomic.LoadUint64(&c.srcConn.written),
+	}
+}
+
+func (c *proxiedConn) CloseWithReason(reason string) {
+	c.setCloseReason(reason)
+	c.srcConn.Close()
+	c.dstConn.Close()
+}
+
+// setCloseReason keeps the first reason since closing
+// connection also makes copying finish with EOF
+func (c *proxiedConn) setCloseReason(reason string) {
+	c.closeReasonLock.Lock()
+	defer c.closeReasonLock.Unlock()
+
+	if len(c.closeReason) == 0 {
+		c.closeReason = reason
+	}
+}
+
+func (c *proxiedConn) CloseReason() string {
+	c.closeReasonLock.Lock()
+	defer c.closeReasonLock.Unlock()
+
+	if len(c.closeReason) == 0 {
+		return accesslog.CloseReasonEOF
+	}
+	return c.closeReason
 }
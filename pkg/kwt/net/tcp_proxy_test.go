@@ -0,0 +1,230 @@
+package net_test
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/logtest"
+	. "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/accesslog"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/fault"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/forwarder"
+)
+
+type noopObjectResolver struct{}
+
+func (noopObjectResolver) Object(net.IP) (accesslog.Object, bool) { return accesslog.Object{}, false }
+
+func TestTCPProxyDrain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kwt-tcp-proxy")
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	accessLogPath := filepath.Join(dir, "access.jsonl")
+
+	accessLog, err := accesslog.NewLog(accesslog.LogOpts{Path: accessLogPath},
+		noopObjectResolver{}, accesslog.NewProcessResolver(), logtest.NoopLogger{})
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer backend.Close()
+
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+
+	backendAddr := backend.Addr().(*net.TCPAddr)
+	resolver := forwarder.NewStaticResolver(backendAddr.IP, backendAddr.Port)
+	proxy := NewTCPProxy(resolver, dstconn.NewLocal(logtest.NoopLogger{}), logtest.NoopLogger{}).WithAccessLog(accessLog)
+
+	startedCh := make(chan struct{})
+	go proxy.Serve(startedCh)
+	<-startedCh
+
+	conn, err := net.Dial("tcp", proxy.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	buf := make([]byte, 4)
+
+	_, err = io.ReadFull(conn, buf)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	proxy.Shutdown()
+
+	if proxy.ActiveConns() != 1 {
+		t.Fatalf("Expected one active conn: %d", proxy.ActiveConns())
+	}
+
+	forceCh := make(chan struct{})
+	drainedCh := make(chan struct{})
+
+	go func() {
+		proxy.Drain(1*time.Minute, forceCh)
+		close(drainedCh)
+	}()
+
+	select {
+	case <-drainedCh:
+		t.Fatalf("Expected drain to wait for active conn")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(forceCh)
+
+	select {
+	case <-drainedCh:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected drain to finish after being forced")
+	}
+
+	_, err = conn.Read(buf)
+	if err != io.EOF {
+		t.Fatalf("Expected conn to be closed: %v", err)
+	}
+
+	// Access log entry is written once copying finishes
+	time.Sleep(100 * time.Millisecond)
+
+	accessLog.Close()
+
+	accessLogBytes, err := ioutil.ReadFile(accessLogPath)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	var entry accesslog.Entry
+
+	err = json.Unmarshal(accessLogBytes, &entry)
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	if entry.Dst != backendAddr.String() || entry.BytesOut != 4 || entry.BytesIn != 4 || entry.CloseReason != "forced" {
+		t.Fatalf("Expected access log entry to match, but was %#v", entry)
+	}
+}
+
+func TestTCPProxyFaults(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer backend.Close()
+
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+
+	backendAddr := backend.Addr().(*net.TCPAddr)
+	resolver := forwarder.NewStaticResolver(backendAddr.IP, backendAddr.Port)
+	injector := fault.NewInjector(nil, nil, logtest.NoopLogger{})
+	proxy := NewTCPProxy(resolver, dstconn.NewLocal(logtest.NoopLogger{}), logtest.NoopLogger{}).WithInjector(injector)
+
+	startedCh := make(chan struct{})
+	go proxy.Serve(startedCh)
+	<-startedCh
+
+	defer proxy.Shutdown()
+
+	echo := func() (time.Duration, error) {
+		conn, err := net.Dial("tcp", proxy.Addr().String())
+		if err != nil {
+			return 0, err
+		}
+
+		defer conn.Close()
+
+		startedAt := time.Now()
+
+		_, err = conn.Write([]byte("ping"))
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = io.ReadFull(conn, make([]byte, 4))
+
+		return time.Since(startedAt), err
+	}
+
+	err = injector.SetFaultRules([]string{"dst=127.0.0.1 latency=100ms"})
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	elapsed, err := echo()
+	if err != nil || elapsed < 200*time.Millisecond {
+		t.Fatalf("Expected latency in both directions: %s (err: %v)", elapsed, err)
+	}
+
+	err = injector.SetFaultRules([]string{"refuse=100%"})
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	_, err = echo()
+	if err == nil {
+		t.Fatalf("Expected connection to be refused")
+	}
+
+	err = injector.SetFaultRules([]string{"reset-after=100ms"})
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	conn, err := net.Dial("tcp", proxy.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = conn.Read(make([]byte, 1))
+	if err == nil {
+		t.Fatalf("Expected connection to be reset")
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		t.Fatalf("Expected connection to be reset before timeout")
+	}
+}
@@ -1,44 +1,66 @@
 package net
 
 import (
+	"fmt"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
-	"github.com/miekg/dns"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/forwarder"
 )
 
-// https://github.com/LiamHaworth/go-tproxy/blob/master/tproxy_udp.go
+const (
+	udpProxyMaxDatagramSize = 65535
+	udpProxyFlowQueueSize   = 64
+)
 
 type UDPProxy struct {
-	dstConnFactory dstconn.Factory
+	origDstResolver forwarder.OriginalDstPacketResolver
+	dstConnFactory  dstconn.Factory
+	idleTimeout     time.Duration
+
+	conn       *net.UDPConn
+	shutdownCh chan struct{}
 
-	conn *net.UDPConn
+	flows     map[string]*udpFlow
+	flowsLock sync.Mutex
 
 	logTag string
 	logger Logger
 }
 
 func NewUDPProxy(
+	origDstResolver forwarder.OriginalDstPacketResolver,
 	dstConnFactory dstconn.Factory,
 	logger Logger,
 ) *UDPProxy {
 	return &UDPProxy{
-		dstConnFactory: dstConnFactory,
+		origDstResolver: origDstResolver,
+		dstConnFactory:  dstConnFactory,
+		idleTimeout:     1 * time.Minute,
+
+		shutdownCh: make(chan struct{}),
+		flows:      map[string]*udpFlow{},
 
 		logTag: "UDPProxy",
 		logger: logger,
 	}
 }
 
-func (c *UDPProxy) Serve(startedCh chan struct{}) error {
-	var err error
+// WithIdleTimeout changes how long flows without datagrams in either direction are kept
+func (c *UDPProxy) WithIdleTimeout(idleTimeout time.Duration) *UDPProxy {
+	c.idleTimeout = idleTimeout
+	return c
+}
 
+func (c *UDPProxy) Serve(startedCh chan struct{}) error {
 	addr, err := net.ResolveUDPAddr("udp", "localhost:0")
 	if err != nil {
 		return err
 	}
 
-	c.conn, err = net.ListenUDP("udp", addr)
+	c.conn, err = c.origDstResolver.ListenUDP(addr)
 	if err != nil {
 		return err
 	}
@@ -49,23 +71,209 @@ func (c *UDPProxy) Serve(startedCh chan struct{}) error {
 
 	c.logger.Info(c.logTag, "Started proxy on %s", c.conn.LocalAddr())
 
+	buf := make([]byte, udpProxyMaxDatagramSize)
+
 	for {
-		var b []byte
-		_, sess, err := dns.ReadFromSessionUDP(c.conn, b)
+		n, srcAddr, origDstIP, origDstPort, err := c.origDstResolver.ReadFromUDP(c.conn, buf)
 		if err != nil {
-			c.logger.Error(c.logTag, "Receiving UDP", err)
-		} else {
-			go c.serveSess(sess)
+			select {
+			case <-c.shutdownCh:
+				return nil
+			default:
+				c.logger.Error(c.logTag, "Receiving UDP: %s", err)
+				continue
+			}
 		}
+
+		datagram := make([]byte, n)
+		copy(datagram, buf[:n])
+
+		c.flow(srcAddr, origDstIP, origDstPort).Enqueue(datagram)
 	}
 }
 
 func (c *UDPProxy) Addr() net.Addr { return c.conn.LocalAddr() }
 
 func (c *UDPProxy) Shutdown() error {
+	select {
+	case <-c.shutdownCh:
+		return nil // already shut down
+	default:
+		close(c.shutdownCh)
+	}
+
+	if c.conn != nil {
+		c.conn.Close()
+	}
+
+	c.flowsLock.Lock()
+	defer c.flowsLock.Unlock()
+
+	for _, flow := range c.flows {
+		flow.Close()
+	}
+
 	return nil
 }
 
-func (c *UDPProxy) serveSess(sess *dns.SessionUDP) {
-	c.logger.Info(c.logTag, "Started %s", sess.RemoteAddr())
+func (c *UDPProxy) flow(srcAddr *net.UDPAddr, origDstIP net.IP, origDstPort int) *udpFlow {
+	dstDesc := net.JoinHostPort(origDstIP.String(), fmt.Sprintf("%d", origDstPort))
+	proxyDesc := fmt.Sprintf("%s->%s", srcAddr, dstDesc)
+
+	c.flowsLock.Lock()
+	defer c.flowsLock.Unlock()
+
+	if flow, found := c.flows[proxyDesc]; found {
+		return flow
+	}
+
+	flow := &udpFlow{
+		proxy:     c,
+		srcAddr:   srcAddr,
+		dstIP:     origDstIP,
+		dstPort:   origDstPort,
+		proxyDesc: proxyDesc,
+		queueCh:   make(chan []byte, udpProxyFlowQueueSize),
+		closedCh:  make(chan struct{}),
+	}
+
+	c.flows[proxyDesc] = flow
+
+	go flow.Serve()
+
+	return flow
+}
+
+func (c *UDPProxy) removeFlow(flow *udpFlow) {
+	c.flowsLock.Lock()
+	defer c.flowsLock.Unlock()
+
+	if c.flows[flow.proxyDesc] == flow {
+		delete(c.flows, flow.proxyDesc)
+	}
+}
+
+// udpFlow represents datagrams exchanged between single
+// local source address and single original destination
+type udpFlow struct {
+	proxy *UDPProxy
+
+	srcAddr   *net.UDPAddr
+	dstIP     net.IP
+	dstPort   int
+	proxyDesc string
+
+	queueCh   chan []byte
+	closedCh  chan struct{}
+	closeOnce sync.Once
+
+	idleTimer *time.Timer
+	timerLock sync.Mutex
+}
+
+// Enqueue does not block the caller; datagrams are dropped if flow cannot keep up
+func (f *udpFlow) Enqueue(datagram []byte) {
+	select {
+	case f.queueCh <- datagram:
+	default:
+		f.proxy.logger.Debug(f.proxy.logTag, "Dropping datagram for %s", f.proxyDesc)
+	}
+}
+
+func (f *udpFlow) Serve() {
+	t1 := time.Now()
+
+	defer f.proxy.removeFlow(f)
+	defer f.Close()
+
+	dstConn, err := f.proxy.dstConnFactory.NewPacketConn(f.dstIP, f.dstPort)
+	if err != nil {
+		f.proxy.logger.Error(f.proxy.logTag, "Could not establish remote connection for '%s': %s", f.proxyDesc, err)
+		return
+	}
+
+	defer dstConn.Close()
+
+	replyConn, err := f.proxy.origDstResolver.NewReplyConn(f.proxy.conn, f.dstIP, f.dstPort)
+	if err != nil {
+		f.proxy.logger.Error(f.proxy.logTag, "Could not prepare reply connection for '%s': %s", f.proxyDesc, err)
+		return
+	}
+
+	defer replyConn.Close()
+
+	t2 := time.Now()
+
+	f.proxy.logger.Info(f.proxy.logTag, "Started %s", f.proxyDesc)
+
+	defer func() {
+		t3 := time.Now()
+		f.proxy.logger.Info(f.proxy.logTag, "Finished %s (%s/%s)", f.proxyDesc, t2.Sub(t1), t3.Sub(t2))
+	}()
+
+	f.resetIdleTimer()
+
+	go f.copyReplies(dstConn, replyConn)
+
+	for {
+		select {
+		case datagram := <-f.queueCh:
+			_, err := dstConn.Write(datagram)
+			if err != nil {
+				f.proxy.logger.Error(f.proxy.logTag, "Failed to write datagram for '%s': %s", f.proxyDesc, err)
+				return
+			}
+			f.resetIdleTimer()
+
+		case <-f.closedCh:
+			return
+		}
+	}
+}
+
+func (f *udpFlow) copyReplies(dstConn net.Conn, replyConn forwarder.PacketReplyConn) {
+	buf := make([]byte, udpProxyMaxDatagramSize)
+
+	for {
+		n, err := dstConn.Read(buf)
+		if err != nil {
+			f.Close()
+			return
+		}
+
+		_, err = replyConn.WriteToUDP(buf[:n], f.srcAddr)
+		if err != nil {
+			f.proxy.logger.Error(f.proxy.logTag, "Failed to write reply datagram for '%s': %s", f.proxyDesc, err)
+			f.Close()
+			return
+		}
+
+		f.resetIdleTimer()
+	}
+}
+
+func (f *udpFlow) resetIdleTimer() {
+	f.timerLock.Lock()
+	defer f.timerLock.Unlock()
+
+	if f.idleTimer == nil {
+		f.idleTimer = time.AfterFunc(f.proxy.idleTimeout, func() {
+			f.proxy.logger.Debug(f.proxy.logTag, "Timing out idle %s", f.proxyDesc)
+			f.Close()
+		})
+	} else {
+		f.idleTimer.Reset(f.proxy.idleTimeout)
+	}
+}
+
+func (f *udpFlow) Close() {
+	f.closeOnce.Do(func() {
+		close(f.closedCh)
+
+		f.timerLock.Lock()
+		if f.idleTimer != nil {
+			f.idleTimer.Stop()
+		}
+		f.timerLock.Unlock()
+	})
 }
@@ -0,0 +1,273 @@
+package net_test
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/carvel-dev/kwt/pkg/kwt/logtest"
+	. "github.com/carvel-dev/kwt/pkg/kwt/net"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/dstconn"
+	"github.com/carvel-dev/kwt/pkg/kwt/net/forwarder"
+)
+
+// fakeOrigDstPacketResolver treats all received datagrams as sent to origDst
+type fakeOrigDstPacketResolver struct {
+	origDst *net.UDPAddr
+}
+
+var _ forwarder.OriginalDstPacketResolver = fakeOrigDstPacketResolver{}
+
+func (r fakeOrigDstPacketResolver) ListenUDP(addr *net.UDPAddr) (*net.UDPConn, error) {
+	return net.ListenUDP("udp", addr)
+}
+
+func (r fakeOrigDstPacketResolver) ReadFromUDP(conn *net.UDPConn, b []byte) (int, *net.UDPAddr, net.IP, int, error) {
+	n, srcAddr, err := conn.ReadFromUDP(b)
+	return n, srcAddr, r.origDst.IP, r.origDst.Port, err
+}
+
+func (r fakeOrigDstPacketResolver) NewReplyConn(lisConn *net.UDPConn, _ net.IP, _ int) (forwarder.PacketReplyConn, error) {
+	return fakePacketReplyConn{lisConn}, nil
+}
+
+type fakePacketReplyConn struct {
+	*net.UDPConn
+}
+
+func (fakePacketReplyConn) Close() error { return nil } // listener is owned by proxy
+
+// fakePacketConnFactory hands out packet conns whose relays echo datagrams back
+type fakePacketConnFactory struct {
+	fakeDstConnFactory
+
+	relays       int
+	closedRelays int
+	lock         sync.Mutex
+
+	// readyCh blocks packet conns from being returned until it's closed
+	readyCh chan struct{}
+}
+
+func (f *fakePacketConnFactory) NewPacketConn(ip net.IP, port int) (net.Conn, error) {
+	if f.readyCh != nil {
+		<-f.readyCh
+	}
+
+	connSide, relaySide := net.Pipe()
+
+	f.lock.Lock()
+	f.relays++
+	f.lock.Unlock()
+
+	go func() {
+		// Echoed frames are valid frames
+		buf := make([]byte, 65537)
+		for {
+			n, err := relaySide.Read(buf)
+			if err != nil {
+				f.lock.Lock()
+				f.closedRelays++
+				f.lock.Unlock()
+				return
+			}
+			relaySide.Write(buf[:n])
+		}
+	}()
+
+	return dstconn.NewSSHPacketConnWithRelay(connSide, &net.UDPAddr{IP: ip, Port: port}), nil
+}
+
+func (f *fakePacketConnFactory) Relays() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.relays
+}
+
+func (f *fakePacketConnFactory) ExpectClosedRelays(t *testing.T, expected int) {
+	for i := 0; i < 100; i++ {
+		f.lock.Lock()
+		closedRelays := f.closedRelays
+		f.lock.Unlock()
+
+		if closedRelays == expected {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Fatalf("Expected %d remote connections to be closed", expected)
+}
+
+func startUDPProxy(t *testing.T, factory dstconn.Factory, idleTimeout time.Duration, logger Logger) (*UDPProxy, func()) {
+	resolver := fakeOrigDstPacketResolver{&net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 53}}
+
+	proxy := NewUDPProxy(resolver, factory, logger).WithIdleTimeout(idleTimeout)
+
+	startedCh := make(chan struct{})
+	errCh := make(chan error, 1)
+
+	go func() { errCh <- proxy.Serve(startedCh) }()
+
+	select {
+	case <-startedCh:
+	case err := <-errCh:
+		t.Fatalf("Expected no err: %s", err)
+	}
+
+	var shutdownOnce sync.Once
+
+	return proxy, func() {
+		shutdownOnce.Do(func() {
+			proxy.Shutdown()
+
+			err := <-errCh
+			if err != nil {
+				t.Fatalf("Expected no err: %s", err)
+			}
+		})
+	}
+}
+
+func dialUDPProxy(t *testing.T, proxy *UDPProxy) *net.UDPConn {
+	conn, err := net.DialUDP("udp", nil, proxy.Addr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("Expected no err: %s", err)
+	}
+	return conn
+}
+
+func expectUDPReply(t *testing.T, conn *net.UDPConn, expected string) {
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	buf := make([]byte, 100)
+
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Expected reply '%s': %s", expected, err)
+	}
+
+	if string(buf[:n]) != expected {
+		t.Fatalf("Expected reply '%s', but was '%s'", expected, buf[:n])
+	}
+}
+
+func TestUDPProxyFlows(t *testing.T) {
+	factory := &fakePacketConnFactory{}
+
+	proxy, shutdownFunc := startUDPProxy(t, factory, time.Minute, logtest.NoopLogger{})
+	defer shutdownFunc()
+
+	client1 := dialUDPProxy(t, proxy)
+	defer client1.Close()
+
+	client2 := dialUDPProxy(t, proxy)
+	defer client2.Close()
+
+	// Replies are sent back only to the source of the flow
+	for _, msg := range []string{"a1", "a2"} {
+		client1.Write([]byte(msg))
+		expectUDPReply(t, client1, msg)
+	}
+
+	client2.Write([]byte("b1"))
+	expectUDPReply(t, client2, "b1")
+
+	client1.Write([]byte("a3"))
+	expectUDPReply(t, client1, "a3")
+
+	if factory.Relays() != 2 {
+		t.Fatalf("Expected one remote connection per source address, but was %d", factory.Relays())
+	}
+
+	shutdownFunc()
+
+	// Shutting down closes remote connections of all flows
+	factory.ExpectClosedRelays(t, 2)
+}
+
+func TestUDPProxyIdleFlows(t *testing.T) {
+	factory := &fakePacketConnFactory{}
+
+	proxy, shutdownFunc := startUDPProxy(t, factory, 300*time.Millisecond, logtest.NoopLogger{})
+	defer shutdownFunc()
+
+	client := dialUDPProxy(t, proxy)
+	defer client.Close()
+
+	// Flow with traffic does not expire
+	for i := 0; i < 6; i++ {
+		client.Write([]byte("ping"))
+		expectUDPReply(t, client, "ping")
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if factory.Relays() != 1 {
+		t.Fatalf("Expected active flow to be kept, but was %d remote connections", factory.Relays())
+	}
+
+	factory.ExpectClosedRelays(t, 0)
+
+	// Idle flow is closed along with its remote connection
+	factory.ExpectClosedRelays(t, 1)
+
+	client.Write([]byte("again"))
+	expectUDPReply(t, client, "again")
+
+	if factory.Relays() != 2 {
+		t.Fatalf("Expected expired flow to be recreated, but was %d remote connections", factory.Relays())
+	}
+}
+
+func TestUDPProxyFlowQueue(t *testing.T) {
+	factory := &fakePacketConnFactory{readyCh: make(chan struct{})}
+
+	droppedCh := make(chan struct{}, 100)
+
+	logger := logtest.HookLogger{HookFunc: func(msg string) {
+		if strings.HasPrefix(msg, "Dropping datagram") {
+			droppedCh <- struct{}{}
+		}
+	}}
+
+	proxy, shutdownFunc := startUDPProxy(t, factory, time.Minute, logger)
+	defer shutdownFunc()
+
+	client := dialUDPProxy(t, proxy)
+	defer client.Close()
+
+	// Datagrams are queued while remote connection is established
+	for i := 0; i < 100; i++ {
+		client.Write([]byte("queued"))
+	}
+
+	for i := 0; i < 100-64; i++ {
+		select {
+		case <-droppedCh:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected datagrams over queue size to be dropped, but was %d", i)
+		}
+	}
+
+	close(factory.readyCh)
+
+	for i := 0; i < 64; i++ {
+		expectUDPReply(t, client, "queued")
+	}
+
+	client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+
+	_, err := client.Read(make([]byte, 100))
+	if err == nil {
+		t.Fatalf("Expected only queued datagrams to be sent")
+	}
+
+	select {
+	case <-droppedCh:
+		t.Fatalf("Expected no more datagrams to be dropped")
+	default:
+	}
+}